@@ -0,0 +1,233 @@
+package product
+
+import (
+	"bytes"
+	"encoding/json"
+	"goERP/controllers/base"
+	md "goERP/models"
+	"strconv"
+	"strings"
+)
+
+// ProductMetalRateController 贵金属牌价
+type ProductMetalRateController struct {
+	base.BaseController
+}
+
+// Post post请求
+func (ctl *ProductMetalRateController) Post() {
+	ctl.URL = "/product/metalrate/"
+	action := ctl.Input().Get("action")
+	switch action {
+	case "table": //bootstrap table的post请求
+		ctl.PostList()
+	case "create":
+		ctl.PostCreate()
+	case "reprice":
+		ctl.PostReprice()
+	case "delete":
+		ctl.PostDelete()
+	default:
+		ctl.PostList()
+	}
+}
+
+// Get 牌价get请求，牌价保留历史记录，只能新建不能修改
+func (ctl *ProductMetalRateController) Get() {
+	ctl.PageName = "贵金属牌价"
+	action := ctl.Input().Get("action")
+	switch action {
+	case "create":
+		ctl.Create()
+	case "detail":
+		ctl.Detail()
+	default:
+		ctl.GetList()
+
+	}
+	// 标题合成
+	b := bytes.Buffer{}
+	b.WriteString(ctl.PageName)
+	b.WriteString("\\")
+	b.WriteString(ctl.PageAction)
+	ctl.Data["PageName"] = b.String()
+	ctl.URL = "/product/metalrate/"
+	ctl.Data["URL"] = ctl.URL
+
+	ctl.Data["MenuProductMetalRateActive"] = "active"
+}
+
+// Create 牌价创建get请求页面
+func (ctl *ProductMetalRateController) Create() {
+	ctl.Data["Action"] = "create"
+	ctl.Data["Readonly"] = false
+	ctl.Data["FormField"] = "form-create"
+	ctl.PageAction = "创建"
+	ctl.Layout = "base/base.html"
+	ctl.TplName = "product/product_metal_rate_form.html"
+}
+
+// Detail 牌价信息显示get请求，信息不可修改
+func (ctl *ProductMetalRateController) Detail() {
+	id := ctl.Ctx.Input.Param(":id")
+	if id != "" {
+		if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
+			if rate, err := md.GetProductMetalRateByID(idInt64); err == nil {
+				ctl.PageAction = rate.Metal + " " + rate.Purity
+				ctl.Data["Rate"] = rate
+			}
+		}
+	}
+	ctl.Data["Readonly"] = true
+	ctl.Data["Action"] = "detail"
+	ctl.Data["RecordID"] = id
+	ctl.Layout = "base/base.html"
+	ctl.TplName = "product/product_metal_rate_form.html"
+}
+
+// PostCreate 牌价post请求创建新牌价，已生效的牌价重新计算未确认的销售明细
+func (ctl *ProductMetalRateController) PostCreate() {
+	result := make(map[string]interface{})
+	postData := ctl.GetString("postData")
+	rate := new(md.ProductMetalRate)
+	var (
+		err error
+		id  int64
+	)
+	if err = json.Unmarshal([]byte(postData), rate); err == nil {
+		if id, err = md.AddProductMetalRate(rate, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = ctl.URL + strconv.FormatInt(id, 10) + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "数据创建失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// PostReprice 按当前生效的牌价重新计算所有未确认的按克重计价销售明细
+func (ctl *ProductMetalRateController) PostReprice() {
+	result := make(map[string]interface{})
+	if num, err := md.RepriceSaleOrderLines(&ctl.User); err == nil {
+		result["code"] = "success"
+		result["num"] = num
+	} else {
+		result["code"] = "failed"
+		result["message"] = "重新计价失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// PostDelete 删除选中的牌价，删除后按当前生效的牌价重新计算草稿订单明细
+func (ctl *ProductMetalRateController) PostDelete() {
+	result := make(map[string]interface{})
+	var (
+		num int64
+		err error
+	)
+	for _, idStr := range ctl.GetStrings("ids[]") {
+		var id int64
+		if id, err = strconv.ParseInt(idStr, 10, 64); err != nil {
+			break
+		}
+		if err = md.DeleteProductMetalRate(id, &ctl.User); err != nil {
+			break
+		}
+		num++
+	}
+	if err == nil {
+		result["code"] = "success"
+		result["num"] = num
+	} else {
+		result["code"] = "failed"
+		result["message"] = "牌价删除失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// 获得符合要求的数据
+func (ctl *ProductMetalRateController) productMetalRateList(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (map[string]interface{}, error) {
+
+	var arrs []md.ProductMetalRate
+	paginator, arrs, err := md.GetAllProductMetalRate(query, exclude, condMap, fields, sortby, order, offset, limit)
+	result := make(map[string]interface{})
+	if err == nil {
+
+		tableLines := make([]interface{}, 0, 4)
+		for _, line := range arrs {
+			oneLine := make(map[string]interface{})
+			oneLine["Metal"] = line.Metal
+			oneLine["Purity"] = line.Purity
+			oneLine["PricePerGram"] = line.PricePerGram
+			oneLine["DateEffective"] = line.DateEffective.Format("2006-01-02 15:04")
+			oneLine["ID"] = line.ID
+			oneLine["id"] = line.ID
+			tableLines = append(tableLines, oneLine)
+		}
+		result["data"] = tableLines
+		if jsonResult, er := json.Marshal(&paginator); er == nil {
+			result["paginator"] = string(jsonResult)
+			result["total"] = paginator.TotalCount
+		}
+	}
+	return result, err
+}
+
+// PostList 牌价post请求，用于获得多条牌价
+func (ctl *ProductMetalRateController) PostList() {
+	query := make(map[string]interface{})
+	exclude := make(map[string]interface{})
+	fields := make([]string, 0, 0)
+	sortby := make([]string, 0, 1)
+	order := make([]string, 0, 1)
+	cond := make(map[string]map[string]interface{})
+	condAnd := make(map[string]interface{})
+	if metal := strings.TrimSpace(ctl.GetString("Metal")); metal != "" {
+		condAnd["Metal"] = metal
+	}
+	if purity := strings.TrimSpace(ctl.GetString("Purity")); purity != "" {
+		condAnd["Purity"] = purity
+	}
+	offset, _ := ctl.GetInt64("offset")
+	limit, _ := ctl.GetInt64("limit")
+	orderStr := ctl.GetString("order")
+	sortStr := ctl.GetString("sort")
+	if orderStr != "" && sortStr != "" {
+		sortby = append(sortby, sortStr)
+		order = append(order, orderStr)
+	} else {
+		sortby = append(sortby, "DateEffective")
+		order = append(order, "desc")
+	}
+	if len(condAnd) > 0 {
+		cond["and"] = condAnd
+	}
+	if result, err := ctl.productMetalRateList(query, exclude, cond, fields, sortby, order, offset, limit); err == nil {
+		ctl.Data["json"] = result
+	}
+	ctl.ServeJSON()
+
+}
+
+// GetList 牌价get请求，列出牌价
+func (ctl *ProductMetalRateController) GetList() {
+	viewType := ctl.Input().Get("view")
+	if viewType == "" || viewType == "table" {
+		ctl.Data["ViewType"] = "table"
+	}
+	ctl.PageAction = "列表"
+	ctl.Data["tableId"] = "table-product-metal-rate"
+	ctl.Layout = "base/base_list_view.html"
+	ctl.TplName = "product/product_metal_rate_list_search.html"
+}
@@ -0,0 +1,327 @@
+package product
+
+import (
+	"bytes"
+	"encoding/json"
+	"goERP/controllers/base"
+	md "goERP/models"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProductPricelistController 价格表
+type ProductPricelistController struct {
+	base.BaseController
+}
+
+// Post post请求
+func (ctl *ProductPricelistController) Post() {
+	ctl.URL = "/product/pricelist/"
+	action := ctl.Input().Get("action")
+	switch action {
+	case "table": //bootstrap table的post请求
+		ctl.PostList()
+	case "create":
+		ctl.PostCreate()
+	case "item":
+		ctl.PostItem()
+	case "deleteItem":
+		ctl.PostDeleteItem()
+	case "price":
+		ctl.PostPrice()
+	default:
+		ctl.PostList()
+	}
+}
+
+// Put 价格表put请求，修改价格表
+func (ctl *ProductPricelistController) Put() {
+	id := ctl.Ctx.Input.Param(":id")
+	ctl.URL = "/product/pricelist/"
+	if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
+		if pricelist, err := md.GetProductPriceListByID(idInt64); err == nil {
+			if err := ctl.ParseForm(&pricelist); err == nil {
+
+				if err := md.UpdateProductPriceListByID(pricelist); err == nil {
+					ctl.Redirect(ctl.URL+id+"?action=detail", 302)
+				}
+			}
+		}
+	}
+	ctl.Redirect(ctl.URL+id+"?action=edit", 302)
+
+}
+
+// Get 价格表get请求
+func (ctl *ProductPricelistController) Get() {
+	ctl.PageName = "价格表管理"
+	action := ctl.Input().Get("action")
+	switch action {
+	case "create":
+		ctl.Create()
+	case "edit":
+		ctl.Edit()
+	case "detail":
+		ctl.Detail()
+	default:
+		ctl.GetList()
+
+	}
+	// 标题合成
+	b := bytes.Buffer{}
+	b.WriteString(ctl.PageName)
+	b.WriteString("\\")
+	b.WriteString(ctl.PageAction)
+	ctl.Data["PageName"] = b.String()
+	ctl.URL = "/product/pricelist/"
+	ctl.Data["URL"] = ctl.URL
+
+	ctl.Data["MenuProductPricelistActive"] = "active"
+}
+
+// Edit 价格表编辑get请求
+func (ctl *ProductPricelistController) Edit() {
+	id := ctl.Ctx.Input.Param(":id")
+	if id != "" {
+		if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
+			if pricelist, err := md.GetProductPriceListByID(idInt64); err == nil {
+				ctl.PageAction = pricelist.Name
+				ctl.Data["Pricelist"] = pricelist
+			}
+		}
+	}
+	ctl.Data["PricelistError"] = ctl.GetString("pricelistError")
+	ctl.Data["FormField"] = "form-edit"
+	ctl.Data["Action"] = "edit"
+	ctl.Data["RecordID"] = id
+	ctl.Layout = "base/base.html"
+	ctl.TplName = "product/product_pricelist_form.html"
+}
+
+// Create 价格表创建get请求页面
+func (ctl *ProductPricelistController) Create() {
+	ctl.Data["Action"] = "create"
+	ctl.Data["Readonly"] = false
+	ctl.Data["FormField"] = "form-create"
+	ctl.PageAction = "创建"
+	ctl.Layout = "base/base.html"
+	ctl.TplName = "product/product_pricelist_form.html"
+}
+
+// Detail 价格表信息显示get请求，信息不可修改
+func (ctl *ProductPricelistController) Detail() {
+	//获取信息一样，直接调用Edit
+	ctl.Edit()
+	ctl.Data["Readonly"] = true
+	ctl.Data["Action"] = "detail"
+}
+
+// redirectDetail 处理完成后跳转到价格表详情，出错时在详情页显示错误
+func (ctl *ProductPricelistController) redirectDetail(id string, err error) {
+	location := ctl.URL + id + "?action=detail"
+	if err != nil {
+		location += "&pricelistError=" + url.QueryEscape(err.Error())
+	}
+	ctl.Redirect(location, 302)
+}
+
+// formDate 获得表单中的日期，未填写时为零值
+func (ctl *ProductPricelistController) formDate(key string) (time.Time, error) {
+	value := strings.TrimSpace(ctl.GetString(key))
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+// PostItem 为价格表添加价格规则
+func (ctl *ProductPricelistController) PostItem() {
+	id := ctl.Ctx.Input.Param(":id")
+	idInt64, err := strconv.ParseInt(id, 10, 64)
+	if err == nil {
+		item := &md.ProductPricelistItem{PricelistID: idInt64}
+		item.AppliedOn = ctl.GetString("AppliedOn")
+		item.ProductID, _ = ctl.GetInt64("Product")
+		item.ProductTemplateID, _ = ctl.GetInt64("ProductTemplate")
+		item.CategoryID, _ = ctl.GetInt64("Category")
+		item.Sequence, _ = ctl.GetInt64("Sequence")
+		item.MinQuantity, _ = ctl.GetFloat("MinQuantity")
+		item.ComputePrice = ctl.GetString("ComputePrice")
+		item.FixedPrice, _ = ctl.GetFloat("FixedPrice")
+		item.PercentPrice, _ = ctl.GetFloat("PercentPrice")
+		item.Base = ctl.GetString("Base")
+		item.BasePricelistID, _ = ctl.GetInt64("BasePricelist")
+		item.PriceDiscount, _ = ctl.GetFloat("PriceDiscount")
+		item.PriceSurcharge, _ = ctl.GetFloat("PriceSurcharge")
+		item.PriceRound, _ = ctl.GetFloat("PriceRound")
+		item.PriceMinMargin, _ = ctl.GetFloat("PriceMinMargin")
+		item.PriceMaxMargin, _ = ctl.GetFloat("PriceMaxMargin")
+		if item.DateStart, err = ctl.formDate("DateStart"); err == nil {
+			if item.DateEnd, err = ctl.formDate("DateEnd"); err == nil {
+				_, err = md.AddProductPricelistItem(item, &ctl.User)
+			}
+		}
+	}
+	ctl.redirectDetail(id, err)
+}
+
+// PostDeleteItem 删除价格表的价格规则
+func (ctl *ProductPricelistController) PostDeleteItem() {
+	id := ctl.Ctx.Input.Param(":id")
+	itemID, err := ctl.GetInt64("ItemID")
+	if err == nil {
+		err = md.DeleteProductPricelistItem(itemID)
+	}
+	ctl.redirectDetail(id, err)
+}
+
+// PostPrice 按价格表计算产品规格的价格
+func (ctl *ProductPricelistController) PostPrice() {
+	result := make(map[string]interface{})
+	var pricelist *md.ProductPriceList
+	if id, err := strconv.ParseInt(ctl.Ctx.Input.Param(":id"), 10, 64); err == nil {
+		pricelist = &md.ProductPriceList{ID: id}
+	}
+	productID, err := ctl.GetInt64("Product")
+	qty, _ := ctl.GetFloat("Qty")
+	date := time.Now()
+	if err == nil {
+		if value := strings.TrimSpace(ctl.GetString("Date")); value != "" {
+			date, err = time.ParseInLocation("2006-01-02", value, time.Local)
+		}
+	}
+	if err == nil {
+		var price float64
+		if price, err = md.GetProductPrice(pricelist, &md.ProductProduct{ID: productID}, qty, date); err == nil {
+			result["code"] = "success"
+			result["price"] = price
+		} else {
+			result["code"] = "failed"
+			result["message"] = "价格计算失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// PostCreate 价格表post请求创建新价格表
+func (ctl *ProductPricelistController) PostCreate() {
+	result := make(map[string]interface{})
+	postData := ctl.GetString("postData")
+	pricelist := new(md.ProductPriceList)
+	var (
+		err error
+		id  int64
+	)
+	if err = json.Unmarshal([]byte(postData), pricelist); err == nil {
+		if id, err = md.AddProductPriceList(pricelist, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = ctl.URL + strconv.FormatInt(id, 10) + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "数据创建失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// 获得符合要求的数据
+func (ctl *ProductPricelistController) productPricelistList(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (map[string]interface{}, error) {
+
+	var arrs []md.ProductPriceList
+	paginator, arrs, err := md.GetAllProductPriceList(query, exclude, condMap, fields, sortby, order, offset, limit)
+	result := make(map[string]interface{})
+	if err == nil {
+
+		tableLines := make([]interface{}, 0, 4)
+		for _, line := range arrs {
+			oneLine := make(map[string]interface{})
+			oneLine["name"] = line.Name
+			oneLine["Name"] = line.Name
+			oneLine["Active"] = line.Active
+			oneLine["ID"] = line.ID
+			oneLine["id"] = line.ID
+			if line.Company != nil {
+				company := make(map[string]interface{})
+				company["id"] = line.Company.ID
+				company["name"] = line.Company.Name
+				oneLine["Company"] = company
+			}
+			tableLines = append(tableLines, oneLine)
+		}
+		result["data"] = tableLines
+		if jsonResult, er := json.Marshal(&paginator); er == nil {
+			result["paginator"] = string(jsonResult)
+			result["total"] = paginator.TotalCount
+		}
+	}
+	return result, err
+}
+
+// PostList 价格表post请求，用于获得多条价格表
+func (ctl *ProductPricelistController) PostList() {
+	query := make(map[string]interface{})
+	exclude := make(map[string]interface{})
+	fields := make([]string, 0, 0)
+	sortby := make([]string, 0, 1)
+	order := make([]string, 0, 1)
+	cond := make(map[string]map[string]interface{})
+	condAnd := make(map[string]interface{})
+	excludeIdsStr := ctl.GetStrings("exclude[]")
+	var excludeIds []int64
+	for _, v := range excludeIdsStr {
+		if val, err := strconv.ParseInt(v, 10, 64); err == nil {
+			excludeIds = append(excludeIds, val)
+		}
+	}
+	if len(excludeIds) > 0 {
+		exclude["Id.in"] = excludeIds
+	}
+	if name := strings.TrimSpace(ctl.GetString("name")); name != "" {
+		condAnd["Name.icontains"] = name
+	}
+	offset, _ := ctl.GetInt64("offset")
+	limit, _ := ctl.GetInt64("limit")
+	orderStr := ctl.GetString("order")
+	sortStr := ctl.GetString("sort")
+	if orderStr != "" && sortStr != "" {
+		sortby = append(sortby, sortStr)
+		order = append(order, orderStr)
+	} else {
+		sortby = append(sortby, "Id")
+		order = append(order, "desc")
+	}
+	if len(condAnd) > 0 {
+		cond["and"] = condAnd
+	}
+	if result, err := ctl.productPricelistList(query, exclude, cond, fields, sortby, order, offset, limit); err == nil {
+		ctl.Data["json"] = result
+	}
+	ctl.ServeJSON()
+
+}
+
+// GetList 价格表get请求，列出价格表
+func (ctl *ProductPricelistController) GetList() {
+	viewType := ctl.Input().Get("view")
+	if viewType == "" || viewType == "table" {
+		ctl.Data["ViewType"] = "table"
+	}
+	ctl.PageAction = "列表"
+	ctl.Data["tableId"] = "table-product-pricelist"
+	ctl.Layout = "base/base_list_view.html"
+	ctl.TplName = "product/product_pricelist_list_search.html"
+}
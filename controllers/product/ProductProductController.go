@@ -8,6 +8,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type ProductProductController struct {
@@ -25,6 +26,8 @@ func (ctl *ProductProductController) Post() {
 		ctl.PostCreate()
 	case "batchUpdate":
 		ctl.PostBatchUpdate()
+	case "availability":
+		ctl.PostAvailability()
 	default:
 		ctl.PostList()
 	}
@@ -56,6 +59,62 @@ func (ctl *ProductProductController) Get() {
 
 }
 
+// PostAvailability 产品库存可用情况，按ProductID查询产品规格，按TemplateID查询款式汇总及各规格，
+// 按CounterID查询柜台产品；可用WareHouseID、LocationID、Date(2006-01-02)过滤
+func (ctl *ProductProductController) PostAvailability() {
+	result := make(map[string]interface{})
+	warehouseID, _ := ctl.GetInt64("WareHouseID")
+	locationID, _ := ctl.GetInt64("LocationID")
+	var (
+		date time.Time
+		err  error
+	)
+	if dateStr := ctl.GetString("Date"); dateStr != "" {
+		if date, err = time.ParseInLocation("2006-01-02", dateStr, time.Local); err == nil {
+			// 包含当天的移动
+			date = date.AddDate(0, 0, 1).Add(-time.Second)
+		}
+	}
+	if err == nil {
+		productID, _ := ctl.GetInt64("ProductID")
+		templateID, _ := ctl.GetInt64("TemplateID")
+		counterID, _ := ctl.GetInt64("CounterID")
+		switch {
+		case productID > 0:
+			var line *md.ProductAvailability
+			if line, err = md.GetProductAvailability(productID, warehouseID, locationID, date); err == nil {
+				result["data"] = line
+			}
+		case templateID > 0:
+			var (
+				total *md.ProductAvailability
+				lines []*md.ProductAvailability
+			)
+			if total, lines, err = md.GetProductTemplateAvailability(templateID, warehouseID, locationID, date); err == nil {
+				result["data"] = total
+				result["products"] = lines
+			}
+		case counterID > 0:
+			var lines []*md.ProductAvailability
+			if lines, err = md.GetSaleCounterAvailability(counterID, warehouseID, locationID, date); err == nil {
+				result["data"] = lines
+			}
+		default:
+			result["code"] = "failed"
+			result["message"] = "请指定产品规格、产品款式或柜台"
+		}
+	}
+	if err != nil {
+		result["code"] = "failed"
+		result["message"] = "获取库存可用情况失败"
+		result["debug"] = err.Error()
+	} else if _, ok := result["code"]; !ok {
+		result["code"] = "success"
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
 // PostBatchUpdate 批量操作
 func (ctl *ProductProductController) PostBatchUpdate() {
 	result := make(map[string]interface{})
@@ -24,6 +24,8 @@ func (ctl *PurchaseOrderController) Post() {
 		ctl.PostList()
 	case "create":
 		ctl.PostCreate()
+	case "confirm":
+		ctl.PostConfirm()
 	default:
 		ctl.PostList()
 	}
@@ -31,20 +33,27 @@ func (ctl *PurchaseOrderController) Post() {
 
 // Put request
 func (ctl *PurchaseOrderController) Put() {
-	id := ctl.Ctx.Input.Param(":id")
+	result := make(map[string]interface{})
+	postData := ctl.GetString("postData")
 	ctl.URL = "/purchase/order/"
-	if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
-		if order, err := md.GetPurchaseOrderByID(idInt64); err == nil {
-			if err := ctl.ParseForm(&order); err == nil {
-
-				if err := md.UpdatePurchaseOrderByID(order); err == nil {
-					ctl.Redirect(ctl.URL+id+"?action=detail", 302)
-				}
-			}
+	order := new(md.PurchaseOrder)
+	var err error
+	if err = json.Unmarshal([]byte(postData), order); err == nil {
+		if err = md.UpdatePurchaseOrderByID(order, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = ctl.URL + strconv.FormatInt(order.ID, 10) + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "数据更新失败"
+			result["debug"] = err.Error()
 		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
 	}
-	ctl.Redirect(ctl.URL+id+"?action=edit", 302)
-
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
 }
 
 // Get request
@@ -77,19 +86,17 @@ func (ctl *PurchaseOrderController) Get() {
 // Edit edit purchase order
 func (ctl *PurchaseOrderController) Edit() {
 	id := ctl.Ctx.Input.Param(":id")
-	orderInfo := make(map[string]interface{})
 	if id != "" {
 		if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
 			if order, err := md.GetPurchaseOrderByID(idInt64); err == nil {
 				ctl.PageAction = order.Name
-				orderInfo["name"] = order.Name
-
+				ctl.Data["Order"] = order
 			}
 		}
 	}
+	ctl.Data["FormField"] = "form-edit"
 	ctl.Data["Action"] = "edit"
 	ctl.Data["RecordID"] = id
-	ctl.Data["order"] = orderInfo
 	ctl.Layout = "base/base.html"
 	ctl.TplName = "purchase/purchase_order_form.html"
 }
@@ -98,6 +105,7 @@ func (ctl *PurchaseOrderController) Edit() {
 func (ctl *PurchaseOrderController) Create() {
 	ctl.Data["Action"] = "create"
 	ctl.Data["Readonly"] = false
+	ctl.Data["FormField"] = "form-create"
 	ctl.PageAction = "创建"
 	ctl.Layout = "base/base.html"
 	ctl.TplName = "purchase/purchase_order_form.html"
@@ -113,17 +121,52 @@ func (ctl *PurchaseOrderController) Detail() {
 
 // PostCreate post request create purchase order
 func (ctl *PurchaseOrderController) PostCreate() {
+	result := make(map[string]interface{})
+	postData := ctl.GetString("postData")
 	order := new(md.PurchaseOrder)
-	if err := ctl.ParseForm(order); err == nil {
+	var (
+		err error
+		id  int64
+	)
+	if err = json.Unmarshal([]byte(postData), order); err == nil {
+		if id, err = md.AddPurchaseOrder(order, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = "/purchase/order/" + strconv.FormatInt(id, 10) + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "数据创建失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
 
-		if id, err := md.AddPurchaseOrder(order); err == nil {
-			ctl.Redirect("/purchase/order/"+strconv.FormatInt(id, 10)+"?action=detail", 302)
+// PostConfirm 确认采购订单，生成入库单
+func (ctl *PurchaseOrderController) PostConfirm() {
+	result := make(map[string]interface{})
+	id := ctl.Ctx.Input.Param(":id")
+	if idInt64, err := strconv.ParseInt(id, 10, 64); err == nil {
+		var pickingID int64
+		if pickingID, err = md.ConfirmPurchaseOrder(idInt64, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = "/stock/picking/" + strconv.FormatInt(pickingID, 10) + "?action=detail&direction=incoming"
 		} else {
-			ctl.Get()
+			result["code"] = "failed"
+			result["message"] = "订单确认失败"
+			result["debug"] = err.Error()
 		}
 	} else {
-		ctl.Get()
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
 	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
 }
 
 // Validator js valid
@@ -164,9 +207,19 @@ func (ctl *PurchaseOrderController) PurchaseOrderList(query map[string]interface
 		tableLines := make([]interface{}, 0, 4)
 		for _, line := range arrs {
 			oneLine := make(map[string]interface{})
-			oneLine["name"] = line.Name
+			oneLine["Name"] = line.Name
 			oneLine["ID"] = line.ID
 			oneLine["id"] = line.ID
+			oneLine["State"] = line.ReceiptState
+			if line.Partner != nil {
+				oneLine["Partner"] = line.Partner.Name
+			}
+			if line.Company != nil {
+				oneLine["Company"] = line.Company.Name
+			}
+			if line.StockWarehouse != nil {
+				oneLine["StockWarehouse"] = line.StockWarehouse.Name
+			}
 
 			tableLines = append(tableLines, oneLine)
 		}
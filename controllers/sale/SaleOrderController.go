@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"goERP/controllers/base"
 	md "goERP/models"
+	"net/url"
 	"strconv"
 	"strings"
 )
@@ -24,6 +25,10 @@ func (ctl *SaleOrderController) Post() {
 		ctl.PostList()
 	case "create":
 		ctl.PostCreate()
+	case "advance":
+		ctl.PostAdvance()
+	case "revert":
+		ctl.PostRevert()
 	default:
 		ctl.PostList()
 	}
@@ -82,10 +87,18 @@ func (ctl *SaleOrderController) Edit() {
 			if order, err := md.GetSaleOrderByID(idInt64); err == nil {
 				ctl.PageAction = order.Name
 				ctl.Data["Order"] = order
-
+				if order.State != nil {
+					if state, err := md.GetSaleOrderStateByID(order.State.ID); err == nil {
+						ctl.Data["OrderState"] = state
+					}
+				}
+				if logs, err := md.GetSaleOrderStateLogs(order.ID); err == nil {
+					ctl.Data["StateLogs"] = logs
+				}
 			}
 		}
 	}
+	ctl.Data["StateError"] = ctl.GetString("stateError")
 	ctl.Data["Action"] = "edit"
 	ctl.Data["FormField"] = "form-edit"
 	ctl.Data["RecordID"] = id
@@ -140,6 +153,35 @@ func (ctl *SaleOrderController) PostCreate() {
 	ctl.ServeJSON()
 }
 
+// redirectDetail 状态变更后跳转到订单详情，出错时在详情页显示错误
+func (ctl *SaleOrderController) redirectDetail(id string, err error) {
+	location := "/sale/order/" + id + "?action=detail"
+	if err != nil {
+		location += "&stateError=" + url.QueryEscape(err.Error())
+	}
+	ctl.Redirect(location, 302)
+}
+
+// PostAdvance 销售订单推进到下一步状态
+func (ctl *SaleOrderController) PostAdvance() {
+	id := ctl.Ctx.Input.Param(":id")
+	idInt64, err := strconv.ParseInt(id, 10, 64)
+	if err == nil {
+		err = md.AdvanceSaleOrder(idInt64, &ctl.User, strings.TrimSpace(ctl.GetString("Note")))
+	}
+	ctl.redirectDetail(id, err)
+}
+
+// PostRevert 销售订单退回到上一步状态
+func (ctl *SaleOrderController) PostRevert() {
+	id := ctl.Ctx.Input.Param(":id")
+	idInt64, err := strconv.ParseInt(id, 10, 64)
+	if err == nil {
+		err = md.RevertSaleOrder(idInt64, &ctl.User, strings.TrimSpace(ctl.GetString("Note")))
+	}
+	ctl.redirectDetail(id, err)
+}
+
 // Validator js valid
 func (ctl *SaleOrderController) Validator() {
 	name := ctl.GetString("name")
@@ -30,25 +30,35 @@ func (ctl *SaleOrderStateController) Post() {
 
 // Put request
 func (ctl *SaleOrderStateController) Put() {
-	id := ctl.Ctx.Input.Param(":id")
+	result := make(map[string]interface{})
+	postData := ctl.GetString("postData")
 	ctl.URL = "/sale/order/state/"
-	if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
-		if state, err := md.GetSaleOrderStateByID(idInt64); err == nil {
-			if err := ctl.ParseForm(&state); err == nil {
-
-				if err := md.UpdateSaleOrderStateByID(state); err == nil {
-					ctl.Redirect(ctl.URL+id+"?action=detail", 302)
-				}
-			}
+	state := new(md.SaleOrderState)
+	var (
+		err error
+		id  int64
+	)
+	if err = json.Unmarshal([]byte(postData), state); err == nil {
+		if id, err = md.UpdateSaleOrderState(state, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = ctl.URL + strconv.FormatInt(id, 10) + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "数据更新失败"
+			result["debug"] = err.Error()
 		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
 	}
-	ctl.Redirect(ctl.URL+id+"?action=edit", 302)
-
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
 }
 
 // Get request
 func (ctl *SaleOrderStateController) Get() {
-	ctl.PageName = "产品属性管理"
+	ctl.PageName = "订单状态管理"
 	action := ctl.Input().Get("action")
 	switch action {
 	case "create":
@@ -76,18 +86,18 @@ func (ctl *SaleOrderStateController) Get() {
 // Edit edite sale order state
 func (ctl *SaleOrderStateController) Edit() {
 	id := ctl.Ctx.Input.Param(":id")
-	stateInfo := make(map[string]interface{})
 	if id != "" {
 		if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
 			if state, err := md.GetSaleOrderStateByID(idInt64); err == nil {
 				ctl.PageAction = state.Name
-				stateInfo["name"] = state.Name
+				ctl.Data["State"] = state
 			}
 		}
 	}
+	ctl.Data["StateActions"] = md.GetSaleOrderStateActions()
+	ctl.Data["FormField"] = "form-edit"
 	ctl.Data["Action"] = "edit"
 	ctl.Data["RecordID"] = id
-	ctl.Data["state"] = stateInfo
 	ctl.Layout = "base/base.html"
 	ctl.TplName = "sale/sale_order_state_form.html"
 }
@@ -96,6 +106,8 @@ func (ctl *SaleOrderStateController) Edit() {
 func (ctl *SaleOrderStateController) Create() {
 	ctl.Data["Action"] = "create"
 	ctl.Data["Readonly"] = false
+	ctl.Data["FormField"] = "form-create"
+	ctl.Data["StateActions"] = md.GetSaleOrderStateActions()
 	ctl.PageAction = "创建"
 	ctl.Layout = "base/base.html"
 	ctl.TplName = "sale/sale_order_state_form.html"
@@ -111,17 +123,29 @@ func (ctl *SaleOrderStateController) Detail() {
 
 // PostCreate post params create sale order state
 func (ctl *SaleOrderStateController) PostCreate() {
+	result := make(map[string]interface{})
+	postData := ctl.GetString("postData")
 	state := new(md.SaleOrderState)
-	if err := ctl.ParseForm(state); err == nil {
-
-		if id, err := md.AddSaleOrderState(state); err == nil {
-			ctl.Redirect("/sale/order/state/"+strconv.FormatInt(id, 10)+"?action=detail", 302)
+	var (
+		err error
+		id  int64
+	)
+	if err = json.Unmarshal([]byte(postData), state); err == nil {
+		if id, err = md.AddSaleOrderState(state, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = "/sale/order/state/" + strconv.FormatInt(id, 10) + "?action=detail"
 		} else {
-			ctl.Get()
+			result["code"] = "failed"
+			result["message"] = "数据创建失败"
+			result["debug"] = err.Error()
 		}
 	} else {
-		ctl.Get()
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
 	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
 }
 
 // Validator js valid
@@ -163,6 +187,29 @@ func (ctl *SaleOrderStateController) SaleOrderStateList(query map[string]interfa
 		for _, line := range arrs {
 			oneLine := make(map[string]interface{})
 			oneLine["name"] = line.Name
+			oneLine["Name"] = line.Name
+			oneLine["Sequence"] = line.Sequence
+			oneLine["Conditions"] = line.Conditions
+			oneLine["Action"] = line.Action
+			oneLine["Active"] = line.Active
+			if line.Company != nil {
+				company := make(map[string]interface{})
+				company["id"] = line.Company.ID
+				company["name"] = line.Company.Name
+				oneLine["Company"] = company
+			}
+			if line.StockWarehouse != nil {
+				warehouse := make(map[string]interface{})
+				warehouse["id"] = line.StockWarehouse.ID
+				warehouse["name"] = line.StockWarehouse.Name
+				oneLine["StockWarehouse"] = warehouse
+			}
+			if line.NextStep != nil {
+				next := make(map[string]interface{})
+				next["id"] = line.NextStep.ID
+				next["name"] = line.NextStep.Name
+				oneLine["NextStep"] = next
+			}
 			oneLine["ID"] = line.ID
 			oneLine["id"] = line.ID
 			tableLines = append(tableLines, oneLine)
@@ -181,7 +228,23 @@ func (ctl *SaleOrderStateController) PostList() {
 	query := make(map[string]interface{})
 	exclude := make(map[string]interface{})
 	cond := make(map[string]map[string]interface{})
-
+	condAnd := make(map[string]interface{})
+	excludeIdsStr := ctl.GetStrings("exclude[]")
+	var excludeIds []int64
+	for _, v := range excludeIdsStr {
+		if val, err := strconv.ParseInt(v, 10, 64); err == nil {
+			excludeIds = append(excludeIds, val)
+		}
+	}
+	if len(excludeIds) > 0 {
+		exclude["Id.in"] = excludeIds
+	}
+	if name := strings.TrimSpace(ctl.GetString("name")); name != "" {
+		condAnd["Name.icontains"] = name
+	}
+	if len(condAnd) > 0 {
+		cond["and"] = condAnd
+	}
 	fields := make([]string, 0, 0)
 	sortby := make([]string, 0, 1)
 	order := make([]string, 0, 1)
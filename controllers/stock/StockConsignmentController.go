@@ -0,0 +1,175 @@
+package stock
+
+import (
+	"bytes"
+	"encoding/json"
+	"goERP/controllers/base"
+	md "goERP/models"
+	"strconv"
+)
+
+// StockConsignmentController 代售明细及结算
+type StockConsignmentController struct {
+	base.BaseController
+}
+
+// Post post请求
+func (ctl *StockConsignmentController) Post() {
+	action := ctl.Input().Get("action")
+	switch action {
+	case "table": //bootstrap table的post请求
+		ctl.PostList()
+	case "settle":
+		ctl.PostSettle()
+	default:
+		ctl.PostList()
+	}
+}
+
+// Get 代售明细get请求
+func (ctl *StockConsignmentController) Get() {
+	ctl.PageName = "代售结算"
+	ctl.GetList()
+	// 标题合成
+	b := bytes.Buffer{}
+	b.WriteString(ctl.PageName)
+	b.WriteString("\\")
+	b.WriteString(ctl.PageAction)
+	ctl.Data["PageName"] = b.String()
+	ctl.URL = "/stock/consignment/"
+	ctl.Data["URL"] = ctl.URL
+
+	ctl.Data["MenuStockConsignmentActive"] = "active"
+}
+
+// PostSettle 结算选中的销售和客户退回明细
+func (ctl *StockConsignmentController) PostSettle() {
+	result := make(map[string]interface{})
+	var ids []int64
+	for _, idStr := range ctl.GetStrings("ids[]") {
+		if id, err := strconv.ParseInt(idStr, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if num, err := md.SettleStockConsignmentLines(ids, &ctl.User); err == nil {
+		result["code"] = "success"
+		result["num"] = num
+	} else {
+		result["code"] = "failed"
+		result["message"] = "结算失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// 获得符合要求的数据
+func (ctl *StockConsignmentController) stockConsignmentList(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (map[string]interface{}, error) {
+
+	var arrs []md.StockConsignmentLine
+	paginator, arrs, err := md.GetAllStockConsignmentLine(query, exclude, condMap, fields, sortby, order, offset, limit)
+	result := make(map[string]interface{})
+	if err == nil {
+
+		tableLines := make([]interface{}, 0, 4)
+		for _, line := range arrs {
+			oneLine := make(map[string]interface{})
+			oneLine["ID"] = line.ID
+			oneLine["id"] = line.ID
+			oneLine["Date"] = line.Date.Format("2006-01-02 15:04:05")
+			oneLine["Type"] = line.Type
+			oneLine["FirstUomQty"] = line.FirstUomQty
+			oneLine["SecondUomQty"] = line.SecondUomQty
+			oneLine["PriceUnit"] = line.PriceUnit
+			oneLine["Amount"] = line.Amount
+			oneLine["State"] = line.State
+			if !line.DateSettled.IsZero() {
+				oneLine["DateSettled"] = line.DateSettled.Format("2006-01-02 15:04:05")
+			}
+			if line.Owner != nil {
+				if owner, err := md.GetPartnerByID(line.Owner.ID); err == nil {
+					oneLine["Owner"] = owner.Name
+				}
+			}
+			if line.Product != nil {
+				product := make(map[string]interface{})
+				product["id"] = line.Product.ID
+				product["name"] = line.Product.Name
+				oneLine["Product"] = product
+			}
+			if line.Lot != nil {
+				if lot, err := md.GetStockProductionLotByID(line.Lot.ID); err == nil {
+					oneLine["Lot"] = lot.Name
+				}
+			}
+			if line.Move != nil {
+				if move, err := md.GetStockMoveByID(line.Move.ID); err == nil {
+					oneLine["Move"] = move.Name
+					oneLine["Origin"] = move.Origin
+				}
+			}
+			tableLines = append(tableLines, oneLine)
+		}
+		result["data"] = tableLines
+		if jsonResult, er := json.Marshal(&paginator); er == nil {
+			result["paginator"] = string(jsonResult)
+			result["total"] = paginator.TotalCount
+		}
+	}
+	return result, err
+}
+
+// PostList 代售明细post请求，按货主、类型和结算状态过滤
+func (ctl *StockConsignmentController) PostList() {
+	query := make(map[string]interface{})
+	exclude := make(map[string]interface{})
+	fields := make([]string, 0, 0)
+	sortby := make([]string, 0, 1)
+	order := make([]string, 0, 1)
+	cond := make(map[string]map[string]interface{})
+	condAnd := make(map[string]interface{})
+	filterMap := make(map[string]interface{})
+	if filter := ctl.GetString("filter"); filter != "" {
+		json.Unmarshal([]byte(filter), &filterMap)
+	}
+	if ownerID := reportFilterInt64(filterMap, "Owner"); ownerID > 0 {
+		condAnd["Owner.Id"] = ownerID
+	}
+	if productID := reportFilterInt64(filterMap, "Product"); productID > 0 {
+		condAnd["Product.Id"] = productID
+	}
+	if lineType, ok := filterMap["Type"].(string); ok && lineType != "" {
+		condAnd["Type"] = lineType
+	}
+	if state, ok := filterMap["State"].(string); ok && state != "" {
+		condAnd["State"] = state
+	}
+	offset, _ := ctl.GetInt64("offset")
+	limit, _ := ctl.GetInt64("limit")
+	orderStr := ctl.GetString("order")
+	sortStr := ctl.GetString("sort")
+	if orderStr != "" && sortStr != "" {
+		sortby = append(sortby, sortStr)
+		order = append(order, orderStr)
+	} else {
+		sortby = append(sortby, "Id")
+		order = append(order, "desc")
+	}
+	if len(condAnd) > 0 {
+		cond["and"] = condAnd
+	}
+	if result, err := ctl.stockConsignmentList(query, exclude, cond, fields, sortby, order, offset, limit); err == nil {
+		ctl.Data["json"] = result
+	}
+	ctl.ServeJSON()
+
+}
+
+// GetList 代售明细get请求，列出代售明细
+func (ctl *StockConsignmentController) GetList() {
+	ctl.Data["ViewType"] = "table"
+	ctl.PageAction = "代售明细"
+	ctl.Data["tableId"] = "table-stock-consignment"
+	ctl.Layout = "base/base_list_view.html"
+	ctl.TplName = "stock/stock_consignment_list_search.html"
+}
@@ -24,6 +24,10 @@ func (ctl *StockInventoryController) Post() {
 		ctl.PostList()
 	case "create":
 		ctl.PostCreate()
+	case "start":
+		ctl.PostStart()
+	case "done":
+		ctl.PostDone()
 	default:
 		ctl.PostList()
 	}
@@ -126,6 +130,50 @@ func (ctl *StockInventoryController) PostCreate() {
 	}
 }
 
+// PostStart 开始盘点，生成盘点明细
+func (ctl *StockInventoryController) PostStart() {
+	result := make(map[string]interface{})
+	id := ctl.Ctx.Input.Param(":id")
+	if idInt64, err := strconv.ParseInt(id, 10, 64); err == nil {
+		if err = md.StartStockInventory(idInt64, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = "/stock/inventory/" + id + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "盘点开始失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// PostDone 验证盘点，按差异调整库存
+func (ctl *StockInventoryController) PostDone() {
+	result := make(map[string]interface{})
+	id := ctl.Ctx.Input.Param(":id")
+	if idInt64, err := strconv.ParseInt(id, 10, 64); err == nil {
+		if err = md.DoneStockInventory(idInt64, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = "/stock/inventory/" + id + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "盘点验证失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
 // Validator js valid
 func (ctl *StockInventoryController) Validator() {
 	name := ctl.GetString("name")
@@ -186,6 +186,7 @@ func (ctl *StockLocationController) stockLocationList(query map[string]interface
 			oneLine["Barcode"] = line.Barcode
 			oneLine["ReturnLocation"] = line.ReturnLocation
 			oneLine["ScrapLocation"] = line.ScrapLocation
+			oneLine["AllowNegative"] = line.AllowNegative
 			oneLine["Posx"] = line.Posx
 			oneLine["Posy"] = line.Posy
 			oneLine["Posz"] = line.Posz
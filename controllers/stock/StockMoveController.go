@@ -24,6 +24,8 @@ func (ctl *StockMoveController) Post() {
 		ctl.PostList()
 	case "create":
 		ctl.PostCreate()
+	case "done":
+		ctl.PostDone()
 	default:
 		ctl.PostList()
 	}
@@ -125,6 +127,28 @@ func (ctl *StockMoveController) PostCreate() {
 	}
 }
 
+// PostDone 完成移动，转移库存份
+func (ctl *StockMoveController) PostDone() {
+	result := make(map[string]interface{})
+	id := ctl.Ctx.Input.Param(":id")
+	if idInt64, err := strconv.ParseInt(id, 10, 64); err == nil {
+		if err = md.DoneStockMove(idInt64, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = "/stock/move/" + id + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "移动完成失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
 // Validator js valid
 func (ctl *StockMoveController) Validator() {
 	name := ctl.GetString("name")
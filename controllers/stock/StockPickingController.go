@@ -23,6 +23,28 @@ func (ctl *StockPickingController) Post() {
 		ctl.PostList()
 	case "create":
 		ctl.PostCreate()
+	case "assign":
+		ctl.PostAssign()
+	case "cancel":
+		ctl.PostCancel()
+	case "done":
+		ctl.PostDone()
+	case "package":
+		ctl.PostMovePackage()
+	case "pack":
+		ctl.PostPutInPack()
+	case "returnLines":
+		ctl.PostReturnLines()
+	case "return":
+		ctl.PostReturn()
+	case "barcode":
+		ctl.PostBarcode()
+	case "scan":
+		ctl.PostScan()
+	case "pickPath":
+		ctl.PostPickPath()
+	case "sortPickPath":
+		ctl.PostSortPickPath()
 	default:
 		ctl.PostList()
 	}
@@ -101,6 +123,131 @@ func (ctl *StockPickingController) PostCreate() {
 	ctl.Data["json"] = result
 	ctl.ServeJSON()
 }
+
+// PostAssign 检查可用性，为调拨单保留库存
+func (ctl *StockPickingController) PostAssign() {
+	result := make(map[string]interface{})
+	id := ctl.Ctx.Input.Param(":id")
+	if idInt64, err := strconv.ParseInt(id, 10, 64); err == nil {
+		if err = md.AssignStockPicking(idInt64, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = "/stock/picking/" + id + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "检查可用性失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// PostCancel 取消调拨单，释放保留的库存
+func (ctl *StockPickingController) PostCancel() {
+	result := make(map[string]interface{})
+	id := ctl.Ctx.Input.Param(":id")
+	if idInt64, err := strconv.ParseInt(id, 10, 64); err == nil {
+		if err = md.CancelStockPicking(idInt64, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = "/stock/picking/" + id + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "调拨单取消失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// PostReturnLines 获得已完成调拨单的可退货明细，用于预填退货数量
+func (ctl *StockPickingController) PostReturnLines() {
+	result := make(map[string]interface{})
+	id := ctl.Ctx.Input.Param(":id")
+	if idInt64, err := strconv.ParseInt(id, 10, 64); err == nil {
+		if lines, err := md.GetStockPickingReturnLines(idInt64); err == nil {
+			tableLines := make([]interface{}, 0, 4)
+			for _, line := range lines {
+				oneLine := make(map[string]interface{})
+				oneLine["ID"] = line.Move.ID
+				oneLine["id"] = line.Move.ID
+				oneLine["Name"] = line.Move.Name
+				oneLine["FirstUomQty"] = line.FirstUomQty
+				oneLine["SecondUomQty"] = line.SecondUomQty
+				if line.Move.Product != nil {
+					product := make(map[string]interface{})
+					product["id"] = line.Move.Product.ID
+					product["name"] = line.Move.Product.Name
+					oneLine["Product"] = product
+				}
+				if line.Move.FirstUom != nil {
+					oneLine["FirstUom"] = line.Move.FirstUom.Name
+				}
+				if line.Move.SecondUom != nil {
+					oneLine["SecondUom"] = line.Move.SecondUom.Name
+				}
+				if line.Move.Lot != nil {
+					oneLine["Lot"] = line.Move.Lot.Name
+				}
+				tableLines = append(tableLines, oneLine)
+			}
+			result["code"] = "success"
+			result["data"] = tableLines
+			result["total"] = len(tableLines)
+		} else {
+			result["code"] = "failed"
+			result["message"] = "获取可退货明细失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// PostReturn 为已完成的调拨单创建退货单，postData为退货移动及数量，为空时全部退回
+func (ctl *StockPickingController) PostReturn() {
+	result := make(map[string]interface{})
+	id := ctl.Ctx.Input.Param(":id")
+	var returnMoves []md.StockMove
+	if idInt64, err := strconv.ParseInt(id, 10, 64); err == nil {
+		if postData := ctl.GetString("postData"); postData != "" {
+			err = json.Unmarshal([]byte(postData), &returnMoves)
+		}
+		if err == nil {
+			var returnID int64
+			if returnID, err = md.ReturnStockPicking(idInt64, returnMoves, &ctl.User); err == nil {
+				result["code"] = "success"
+				result["location"] = "/stock/picking/" + strconv.FormatInt(returnID, 10) + "?action=detail"
+			} else {
+				result["code"] = "failed"
+				result["message"] = "退货单创建失败"
+				result["debug"] = err.Error()
+			}
+		} else {
+			result["code"] = "failed"
+			result["message"] = "请求数据解析失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
 func (ctl *StockPickingController) Put() {
 	id := ctl.Ctx.Input.Param(":id")
 	ctl.URL = "/stock/picking/"
@@ -234,3 +381,282 @@ func (ctl *StockPickingController) GetList() {
 	ctl.Layout = "base/base_list_view.html"
 	ctl.TplName = "stock/stock_picking_list_search.html"
 }
+
+// PostDone 完成调拨单，转移库存，postData为各移动的完成数量，backorder为剩余数量的处理方式
+func (ctl *StockPickingController) PostDone() {
+	result := make(map[string]interface{})
+	id := ctl.Ctx.Input.Param(":id")
+	backorder := ctl.GetString("backorder")
+	var doneMoves []md.StockMove
+	if idInt64, err := strconv.ParseInt(id, 10, 64); err == nil {
+		if postData := ctl.GetString("postData"); postData != "" {
+			err = json.Unmarshal([]byte(postData), &doneMoves)
+		}
+		if err == nil {
+			var backorderID int64
+			if backorderID, err = md.DoneStockPicking(idInt64, doneMoves, backorder, &ctl.User); err == nil {
+				result["code"] = "success"
+				result["location"] = "/stock/picking/" + id + "?action=detail"
+				if backorderID > 0 {
+					result["backorder"] = "/stock/picking/" + strconv.FormatInt(backorderID, 10) + "?action=detail"
+				}
+			} else {
+				result["code"] = "failed"
+				result["message"] = "调拨单完成失败"
+				result["debug"] = err.Error()
+			}
+		} else {
+			result["code"] = "failed"
+			result["message"] = "请求数据解析失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// barcodeStateResult 将扫码处理状态转换为扫码界面使用的数据
+func (ctl *StockPickingController) barcodeStateResult(state *md.StockPickingBarcodeState) map[string]interface{} {
+	picking := make(map[string]interface{})
+	picking["id"] = state.Picking.ID
+	picking["Name"] = state.Picking.Name
+	picking["State"] = state.Picking.State
+	if state.Picking.LocationSrc != nil {
+		picking["LocationSrc"] = state.Picking.LocationSrc.Name
+	}
+	if state.Picking.LocationDest != nil {
+		picking["LocationDest"] = state.Picking.LocationDest.Name
+	}
+	lines := make([]interface{}, 0, len(state.Moves))
+	for _, move := range state.Moves {
+		oneLine := make(map[string]interface{})
+		oneLine["id"] = move.ID
+		oneLine["Name"] = move.Name
+		oneLine["State"] = move.State
+		oneLine["FirstUomQty"] = move.FirstUomQty
+		oneLine["SecondUomQty"] = move.SecondUomQty
+		oneLine["FirstQtyDone"] = move.FirstQtyDone
+		oneLine["SecondQtyDone"] = move.SecondQtyDone
+		oneLine["Current"] = move.ID == state.MoveID
+		if move.Product != nil {
+			product := make(map[string]interface{})
+			product["id"] = move.Product.ID
+			product["name"] = move.Product.Name
+			product["barcode"] = move.Product.Barcode
+			oneLine["Product"] = product
+		}
+		if move.FirstUom != nil {
+			oneLine["FirstUom"] = move.FirstUom.Name
+		}
+		if move.SecondUom != nil {
+			oneLine["SecondUom"] = move.SecondUom.Name
+		}
+		if move.Lot != nil {
+			oneLine["Lot"] = move.Lot.Name
+		}
+		if move.ResultPackage != nil {
+			oneLine["Package"] = move.ResultPackage.Name
+		}
+		if move.LocationSrc != nil {
+			oneLine["LocationSrc"] = move.LocationSrc.Name
+		}
+		if move.LocationDest != nil {
+			oneLine["LocationDest"] = move.LocationDest.Name
+		}
+		lines = append(lines, oneLine)
+	}
+	result := make(map[string]interface{})
+	result["picking"] = picking
+	result["data"] = lines
+	result["total"] = len(lines)
+	result["scan"] = state.Message
+	return result
+}
+
+// PostBarcode 获得调拨单扫码处理的当前状态
+func (ctl *StockPickingController) PostBarcode() {
+	result := make(map[string]interface{})
+	id := ctl.Ctx.Input.Param(":id")
+	if idInt64, err := strconv.ParseInt(id, 10, 64); err == nil {
+		if state, err := md.GetStockPickingBarcodeState(idInt64); err == nil {
+			result = ctl.barcodeStateResult(state)
+			result["code"] = "success"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "获取扫码状态失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// PostScan 扫描库位、产品、批次或包的条码处理调拨单，返回处理后的状态
+func (ctl *StockPickingController) PostScan() {
+	result := make(map[string]interface{})
+	id := ctl.Ctx.Input.Param(":id")
+	if idInt64, err := strconv.ParseInt(id, 10, 64); err == nil {
+		if state, err := md.ScanStockPicking(idInt64, ctl.GetString("Barcode"), &ctl.User); err == nil {
+			result = ctl.barcodeStateResult(state)
+			result["code"] = "success"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "扫码处理失败"
+			result["debug"] = err.Error()
+			if state, er := md.GetStockPickingBarcodeState(idInt64); er == nil {
+				for k, v := range ctl.barcodeStateResult(state) {
+					result[k] = v
+				}
+			}
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// pickPathResult 拣货路径的显示字段
+func pickPathResult(lines []*md.StockPickPathLine) []interface{} {
+	tableLines := make([]interface{}, 0, len(lines))
+	for i, line := range lines {
+		oneLine := make(map[string]interface{})
+		oneLine["Sequence"] = i + 1
+		oneLine["MoveID"] = line.Move.ID
+		oneLine["Name"] = line.Move.Name
+		oneLine["FirstUomQty"] = line.FirstUomQty
+		oneLine["SecondUomQty"] = line.SecondUomQty
+		if line.Move.Product != nil {
+			product := make(map[string]interface{})
+			product["id"] = line.Move.Product.ID
+			product["name"] = line.Move.Product.Name
+			product["barcode"] = line.Move.Product.Barcode
+			oneLine["Product"] = product
+		}
+		location := make(map[string]interface{})
+		location["id"] = line.Location.ID
+		location["name"] = line.Location.Name
+		location["barcode"] = line.Location.Barcode
+		location["posx"] = line.Location.Posx
+		location["posy"] = line.Location.Posy
+		location["posz"] = line.Location.Posz
+		oneLine["Location"] = location
+		if line.Lot != nil {
+			oneLine["Lot"] = line.Lot.Name
+		}
+		tableLines = append(tableLines, oneLine)
+	}
+	return tableLines
+}
+
+// PostPickPath 获得调拨单的拣货路径，按通道、货架排列拣货库位
+func (ctl *StockPickingController) PostPickPath() {
+	result := make(map[string]interface{})
+	id := ctl.Ctx.Input.Param(":id")
+	if idInt64, err := strconv.ParseInt(id, 10, 64); err == nil {
+		if lines, err := md.GetStockPickingPickPath(idInt64); err == nil {
+			result["code"] = "success"
+			result["data"] = pickPathResult(lines)
+			result["total"] = len(lines)
+		} else {
+			result["code"] = "failed"
+			result["message"] = "获取拣货路径失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// PostSortPickPath 按拣货路径重新排列调拨单的移动
+func (ctl *StockPickingController) PostSortPickPath() {
+	result := make(map[string]interface{})
+	id := ctl.Ctx.Input.Param(":id")
+	if idInt64, err := strconv.ParseInt(id, 10, 64); err == nil {
+		if err = md.SortStockPickingMoves(idInt64, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = "/stock/picking/" + id + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "按拣货路径排序失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// PostMovePackage 整包移动，将包中的产品加入调拨单
+func (ctl *StockPickingController) PostMovePackage() {
+	result := make(map[string]interface{})
+	id := ctl.Ctx.Input.Param(":id")
+	if idInt64, err := strconv.ParseInt(id, 10, 64); err == nil {
+		var packageID int64
+		if packageID, err = ctl.GetInt64("Package"); err == nil {
+			err = md.MoveStockPickingPackage(idInt64, packageID, &ctl.User)
+		}
+		if err == nil {
+			result["code"] = "success"
+			result["location"] = "/stock/picking/" + id + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "整包移动失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// PostPutInPack 将调拨单的移动装入包中，没有指定包时新建包
+func (ctl *StockPickingController) PostPutInPack() {
+	result := make(map[string]interface{})
+	id := ctl.Ctx.Input.Param(":id")
+	if idInt64, err := strconv.ParseInt(id, 10, 64); err == nil {
+		var moveIDs []int64
+		for _, v := range ctl.GetStrings("moves[]") {
+			if val, e := strconv.ParseInt(v, 10, 64); e == nil {
+				moveIDs = append(moveIDs, val)
+			}
+		}
+		packageID, _ := ctl.GetInt64("Package")
+		var resultPackageID int64
+		if resultPackageID, err = md.PutStockPickingInPack(idInt64, moveIDs, packageID, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = "/stock/picking/" + id + "?action=detail"
+			result["package"] = "/stock/package/" + strconv.FormatInt(resultPackageID, 10) + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "装包失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
@@ -0,0 +1,337 @@
+package stock
+
+import (
+	"bytes"
+	"encoding/json"
+	"goERP/controllers/base"
+	md "goERP/models"
+	"strconv"
+	"strings"
+)
+
+// StockProductionLotController 批次、序列号
+type StockProductionLotController struct {
+	base.BaseController
+}
+
+// Post post请求
+func (ctl *StockProductionLotController) Post() {
+	action := ctl.Input().Get("action")
+	switch action {
+	case "validator":
+		ctl.Validator()
+	case "table": //bootstrap table的post请求
+		// 批次详情页中的追溯表格
+		if ctl.Ctx.Input.Param(":id") != "" {
+			ctl.PostTrace()
+		} else {
+			ctl.PostList()
+		}
+	case "create":
+		ctl.PostCreate()
+	default:
+		ctl.PostList()
+	}
+}
+
+// Put 批次put请求，修改批次信息
+func (ctl *StockProductionLotController) Put() {
+	id := ctl.Ctx.Input.Param(":id")
+	ctl.URL = "/stock/lot/"
+	if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
+		if lot, err := md.GetStockProductionLotByID(idInt64); err == nil {
+			if err := ctl.ParseForm(&lot); err == nil {
+
+				if err := md.UpdateStockProductionLotByID(lot); err == nil {
+					ctl.Redirect(ctl.URL+id+"?action=detail", 302)
+				}
+			}
+		}
+	}
+	ctl.Redirect(ctl.URL+id+"?action=edit", 302)
+
+}
+
+// Get 批次get请求
+func (ctl *StockProductionLotController) Get() {
+	ctl.PageName = "批次管理"
+	action := ctl.Input().Get("action")
+	switch action {
+	case "create":
+		ctl.Create()
+	case "edit":
+		ctl.Edit()
+	case "detail":
+		ctl.Detail()
+	case "trace":
+		ctl.Trace()
+	default:
+		ctl.GetList()
+
+	}
+	// 标题合成
+	b := bytes.Buffer{}
+	b.WriteString(ctl.PageName)
+	b.WriteString("\\")
+	b.WriteString(ctl.PageAction)
+	ctl.Data["PageName"] = b.String()
+	ctl.URL = "/stock/lot/"
+	ctl.Data["URL"] = ctl.URL
+
+	ctl.Data["MenuStockProductionLotActive"] = "active"
+}
+
+// Edit 批次编辑get请求
+func (ctl *StockProductionLotController) Edit() {
+	id := ctl.Ctx.Input.Param(":id")
+	if id != "" {
+		if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
+			if lot, err := md.GetStockProductionLotByID(idInt64); err == nil {
+				ctl.PageAction = lot.Name
+				ctl.Data["Lot"] = lot
+			}
+		}
+	}
+	ctl.Data["FormField"] = "form-edit"
+	ctl.Data["Action"] = "edit"
+	ctl.Data["RecordID"] = id
+	ctl.Layout = "base/base.html"
+	ctl.TplName = "stock/stock_production_lot_form.html"
+}
+
+// Create 批次创建get请求页面
+func (ctl *StockProductionLotController) Create() {
+	ctl.Data["Action"] = "create"
+	ctl.Data["Readonly"] = false
+	ctl.Data["FormField"] = "form-create"
+	ctl.PageAction = "创建"
+	ctl.Layout = "base/base.html"
+	ctl.TplName = "stock/stock_production_lot_form.html"
+}
+
+// Detail 批次信息显示get请求，信息不可修改
+func (ctl *StockProductionLotController) Detail() {
+	//获取信息一样，直接调用Edit
+	ctl.Edit()
+	ctl.Data["Readonly"] = true
+	ctl.Data["Action"] = "detail"
+}
+
+// Trace 批次追溯get请求，列出批次经过的所有移动
+func (ctl *StockProductionLotController) Trace() {
+	id := ctl.Ctx.Input.Param(":id")
+	if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
+		if lot, err := md.GetStockProductionLotByID(idInt64); err == nil {
+			ctl.PageAction = lot.Name + "追溯"
+		}
+	}
+	ctl.Data["tableId"] = "table-stock-lot-trace"
+	ctl.Layout = "base/base_list_view.html"
+	ctl.TplName = "stock/stock_production_lot_list_search.html"
+}
+
+// PostTrace 批次追溯post请求，获得批次经过的所有移动
+func (ctl *StockProductionLotController) PostTrace() {
+	result := make(map[string]interface{})
+	id := ctl.Ctx.Input.Param(":id")
+	if idInt64, err := strconv.ParseInt(id, 10, 64); err == nil {
+		if moves, err := md.GetStockProductionLotTrace(idInt64); err == nil {
+			tableLines := make([]interface{}, 0, 4)
+			for _, move := range moves {
+				oneLine := make(map[string]interface{})
+				oneLine["ID"] = move.ID
+				oneLine["id"] = move.ID
+				oneLine["Name"] = move.Name
+				oneLine["Date"] = move.Date.Format("2006-01-02 15:04:05")
+				oneLine["Origin"] = move.Origin
+				oneLine["FirstUomQty"] = move.FirstUomQty
+				oneLine["SecondUomQty"] = move.SecondUomQty
+				if move.LocationSrc != nil {
+					location := make(map[string]interface{})
+					location["id"] = move.LocationSrc.ID
+					location["name"] = move.LocationSrc.Name
+					oneLine["LocationSrc"] = location
+				}
+				if move.LocationDest != nil {
+					location := make(map[string]interface{})
+					location["id"] = move.LocationDest.ID
+					location["name"] = move.LocationDest.Name
+					oneLine["LocationDest"] = location
+				}
+				if move.Picking != nil {
+					picking := make(map[string]interface{})
+					picking["id"] = move.Picking.ID
+					picking["name"] = move.Picking.Name
+					oneLine["Picking"] = picking
+				}
+				if move.Partner != nil {
+					oneLine["Partner"] = move.Partner.Name
+				}
+				tableLines = append(tableLines, oneLine)
+			}
+			result["data"] = tableLines
+			result["total"] = len(tableLines)
+		}
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// PostCreate 批次post请求创建新批次
+func (ctl *StockProductionLotController) PostCreate() {
+	result := make(map[string]interface{})
+	postData := ctl.GetString("postData")
+	lot := new(md.StockProductionLot)
+	var (
+		err error
+		id  int64
+	)
+	if err = json.Unmarshal([]byte(postData), lot); err == nil {
+		if id, err = md.AddStockProductionLot(lot, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = ctl.URL + strconv.FormatInt(id, 10) + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "数据创建失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// Validator 批次信息post请求，用于验证同一产品规格内批次号唯一
+func (ctl *StockProductionLotController) Validator() {
+	name := ctl.GetString("Name")
+	name = strings.TrimSpace(name)
+	productID, _ := ctl.GetInt64("Product")
+	recordID, _ := ctl.GetInt64("recordID")
+	result := make(map[string]bool)
+	obj, err := md.GetStockProductionLotByName(name, productID)
+	if err != nil {
+		result["valid"] = true
+	} else {
+		if obj.Name == name {
+			if recordID == obj.ID {
+				result["valid"] = true
+			} else {
+				result["valid"] = false
+			}
+
+		} else {
+			result["valid"] = true
+		}
+
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// 获得符合要求的数据
+func (ctl *StockProductionLotController) stockProductionLotList(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (map[string]interface{}, error) {
+
+	var arrs []md.StockProductionLot
+	paginator, arrs, err := md.GetAllStockProductionLot(query, exclude, condMap, fields, sortby, order, offset, limit)
+	result := make(map[string]interface{})
+	if err == nil {
+
+		tableLines := make([]interface{}, 0, 4)
+		for _, line := range arrs {
+			oneLine := make(map[string]interface{})
+			oneLine["Name"] = line.Name
+			oneLine["Ref"] = line.Ref
+			oneLine["Certificate"] = line.Certificate
+			oneLine["ID"] = line.ID
+			oneLine["id"] = line.ID
+			if !line.ExpiryDate.IsZero() {
+				oneLine["ExpiryDate"] = line.ExpiryDate.Format("2006-01-02")
+			}
+			if line.Product != nil {
+				product := make(map[string]interface{})
+				product["id"] = line.Product.ID
+				product["name"] = line.Product.Name
+				oneLine["Product"] = product
+			}
+			if line.Company != nil {
+				company := make(map[string]interface{})
+				company["id"] = line.Company.ID
+				company["name"] = line.Company.Name
+				oneLine["Company"] = company
+			}
+			tableLines = append(tableLines, oneLine)
+		}
+		result["data"] = tableLines
+		if jsonResult, er := json.Marshal(&paginator); er == nil {
+			result["paginator"] = string(jsonResult)
+			result["total"] = paginator.TotalCount
+		}
+	}
+	return result, err
+}
+
+// PostList 批次信息post请求，用于获得多条批次信息
+func (ctl *StockProductionLotController) PostList() {
+	query := make(map[string]interface{})
+	exclude := make(map[string]interface{})
+	fields := make([]string, 0, 0)
+	sortby := make([]string, 0, 1)
+	order := make([]string, 0, 1)
+	cond := make(map[string]map[string]interface{})
+	condAnd := make(map[string]interface{})
+	condOr := make(map[string]interface{})
+	excludeIdsStr := ctl.GetStrings("exclude[]")
+	var excludeIds []int64
+	for _, v := range excludeIdsStr {
+		if val, err := strconv.ParseInt(v, 10, 64); err == nil {
+			excludeIds = append(excludeIds, val)
+		}
+	}
+	if len(excludeIds) > 0 {
+		exclude["Id.in"] = excludeIds
+	}
+	if name := strings.TrimSpace(ctl.GetString("Name")); name != "" {
+		condOr["Name.icontains"] = name
+		condOr["Certificate.icontains"] = name
+	}
+	if productID, err := ctl.GetInt64("ProductID"); err == nil {
+		condAnd["Product.Id"] = productID
+	}
+	offset, _ := ctl.GetInt64("offset")
+	limit, _ := ctl.GetInt64("limit")
+	orderStr := ctl.GetString("order")
+	sortStr := ctl.GetString("sort")
+	if orderStr != "" && sortStr != "" {
+		sortby = append(sortby, sortStr)
+		order = append(order, orderStr)
+	} else {
+		sortby = append(sortby, "Id")
+		order = append(order, "desc")
+	}
+	if len(condAnd) > 0 {
+		cond["and"] = condAnd
+	}
+	if len(condOr) > 0 {
+		cond["or"] = condOr
+	}
+	if result, err := ctl.stockProductionLotList(query, exclude, cond, fields, sortby, order, offset, limit); err == nil {
+		ctl.Data["json"] = result
+	}
+	ctl.ServeJSON()
+
+}
+
+// GetList 批次信息get请求，列出批次
+func (ctl *StockProductionLotController) GetList() {
+	viewType := ctl.Input().Get("view")
+	if viewType == "" || viewType == "table" {
+		ctl.Data["ViewType"] = "table"
+	}
+	ctl.PageAction = "列表"
+	ctl.Data["tableId"] = "table-stock-lot"
+	ctl.Layout = "base/base_list_view.html"
+	ctl.TplName = "stock/stock_production_lot_list_search.html"
+}
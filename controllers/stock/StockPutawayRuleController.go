@@ -0,0 +1,234 @@
+package stock
+
+import (
+	"bytes"
+	"encoding/json"
+	"goERP/controllers/base"
+	md "goERP/models"
+	"strconv"
+)
+
+// StockPutawayRuleController 入库规则
+type StockPutawayRuleController struct {
+	base.BaseController
+}
+
+// Post post请求
+func (ctl *StockPutawayRuleController) Post() {
+	action := ctl.Input().Get("action")
+	switch action {
+	case "table": //bootstrap table的post请求
+		ctl.PostList()
+	case "create":
+		ctl.PostCreate()
+	default:
+		ctl.PostList()
+	}
+}
+
+// Put 入库规则put请求，修改入库规则
+func (ctl *StockPutawayRuleController) Put() {
+	id := ctl.Ctx.Input.Param(":id")
+	ctl.URL = "/stock/putaway/rule/"
+	if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
+		if rule, err := md.GetStockPutawayRuleByID(idInt64); err == nil {
+			if err := ctl.ParseForm(&rule); err == nil {
+
+				if err := md.UpdateStockPutawayRuleByID(rule); err == nil {
+					ctl.Redirect(ctl.URL+id+"?action=detail", 302)
+				}
+			}
+		}
+	}
+	ctl.Redirect(ctl.URL+id+"?action=edit", 302)
+
+}
+
+// Get 入库规则get请求
+func (ctl *StockPutawayRuleController) Get() {
+	ctl.PageName = "入库规则管理"
+	action := ctl.Input().Get("action")
+	switch action {
+	case "create":
+		ctl.Create()
+	case "edit":
+		ctl.Edit()
+	case "detail":
+		ctl.Detail()
+	default:
+		ctl.GetList()
+
+	}
+	// 标题合成
+	b := bytes.Buffer{}
+	b.WriteString(ctl.PageName)
+	b.WriteString("\\")
+	b.WriteString(ctl.PageAction)
+	ctl.Data["PageName"] = b.String()
+	ctl.URL = "/stock/putaway/rule/"
+	ctl.Data["URL"] = ctl.URL
+
+	ctl.Data["MenuStockPutawayRuleActive"] = "active"
+}
+
+// Edit 入库规则编辑get请求
+func (ctl *StockPutawayRuleController) Edit() {
+	id := ctl.Ctx.Input.Param(":id")
+	if id != "" {
+		if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
+			if rule, err := md.GetStockPutawayRuleByID(idInt64); err == nil {
+				if rule.Location != nil {
+					ctl.PageAction = rule.Location.Name
+				}
+				ctl.Data["Rule"] = rule
+			}
+		}
+	}
+	ctl.Data["FormField"] = "form-edit"
+	ctl.Data["Action"] = "edit"
+	ctl.Data["RecordID"] = id
+	ctl.Layout = "base/base.html"
+	ctl.TplName = "stock/stock_putaway_rule_form.html"
+}
+
+// Create 入库规则创建get请求页面
+func (ctl *StockPutawayRuleController) Create() {
+	ctl.Data["Action"] = "create"
+	ctl.Data["Readonly"] = false
+	ctl.Data["FormField"] = "form-create"
+	ctl.PageAction = "创建"
+	ctl.Layout = "base/base.html"
+	ctl.TplName = "stock/stock_putaway_rule_form.html"
+}
+
+// Detail 入库规则显示get请求，信息不可修改
+func (ctl *StockPutawayRuleController) Detail() {
+	//获取信息一样，直接调用Edit
+	ctl.Edit()
+	ctl.Data["Readonly"] = true
+	ctl.Data["Action"] = "detail"
+}
+
+// PostCreate 入库规则post请求创建新规则
+func (ctl *StockPutawayRuleController) PostCreate() {
+	result := make(map[string]interface{})
+	postData := ctl.GetString("postData")
+	rule := new(md.StockPutawayRule)
+	var (
+		err error
+		id  int64
+	)
+	if err = json.Unmarshal([]byte(postData), rule); err == nil {
+		if id, err = md.AddStockPutawayRule(rule, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = ctl.URL + strconv.FormatInt(id, 10) + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "数据创建失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// stockPutawayRuleList 获得符合要求的入库规则，入库策略详情页也使用
+func stockPutawayRuleList(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (map[string]interface{}, error) {
+
+	var arrs []md.StockPutawayRule
+	paginator, arrs, err := md.GetAllStockPutawayRule(query, exclude, condMap, fields, sortby, order, offset, limit)
+	result := make(map[string]interface{})
+	if err == nil {
+
+		tableLines := make([]interface{}, 0, 4)
+		for _, line := range arrs {
+			oneLine := make(map[string]interface{})
+			oneLine["Sequence"] = line.Sequence
+			oneLine["ID"] = line.ID
+			oneLine["id"] = line.ID
+			if line.Strategy != nil {
+				strategy := make(map[string]interface{})
+				strategy["id"] = line.Strategy.ID
+				strategy["name"] = line.Strategy.Name
+				oneLine["Strategy"] = strategy
+			}
+			if line.Product != nil {
+				product := make(map[string]interface{})
+				product["id"] = line.Product.ID
+				product["name"] = line.Product.Name
+				oneLine["Product"] = product
+			}
+			if line.Category != nil {
+				category := make(map[string]interface{})
+				category["id"] = line.Category.ID
+				category["name"] = line.Category.Name
+				oneLine["Category"] = category
+			}
+			if line.Location != nil {
+				location := make(map[string]interface{})
+				location["id"] = line.Location.ID
+				location["name"] = line.Location.Name
+				oneLine["Location"] = location
+			}
+			tableLines = append(tableLines, oneLine)
+		}
+		result["data"] = tableLines
+		if jsonResult, er := json.Marshal(&paginator); er == nil {
+			result["paginator"] = string(jsonResult)
+			result["total"] = paginator.TotalCount
+		}
+	}
+	return result, err
+}
+
+// PostList 入库规则post请求，用于获得多条入库规则
+func (ctl *StockPutawayRuleController) PostList() {
+	query := make(map[string]interface{})
+	exclude := make(map[string]interface{})
+	fields := make([]string, 0, 0)
+	sortby := make([]string, 0, 1)
+	order := make([]string, 0, 1)
+	cond := make(map[string]map[string]interface{})
+	condAnd := make(map[string]interface{})
+	if strategyID, err := ctl.GetInt64("StrategyID"); err == nil {
+		condAnd["Strategy.Id"] = strategyID
+	}
+	if locationID, err := ctl.GetInt64("LocationID"); err == nil {
+		condAnd["Location.Id"] = locationID
+	}
+	offset, _ := ctl.GetInt64("offset")
+	limit, _ := ctl.GetInt64("limit")
+	orderStr := ctl.GetString("order")
+	sortStr := ctl.GetString("sort")
+	if orderStr != "" && sortStr != "" {
+		sortby = append(sortby, sortStr)
+		order = append(order, orderStr)
+	} else {
+		sortby = append(sortby, "Sequence")
+		order = append(order, "asc")
+	}
+	if len(condAnd) > 0 {
+		cond["and"] = condAnd
+	}
+	if result, err := stockPutawayRuleList(query, exclude, cond, fields, sortby, order, offset, limit); err == nil {
+		ctl.Data["json"] = result
+	}
+	ctl.ServeJSON()
+
+}
+
+// GetList 入库规则get请求，列出入库规则
+func (ctl *StockPutawayRuleController) GetList() {
+	viewType := ctl.Input().Get("view")
+	if viewType == "" || viewType == "table" {
+		ctl.Data["ViewType"] = "table"
+	}
+	ctl.PageAction = "列表"
+	ctl.Data["tableId"] = "table-stock-putaway-rule"
+	ctl.Layout = "base/base_list_view.html"
+	ctl.TplName = "stock/stock_putaway_rule_list_search.html"
+}
@@ -0,0 +1,287 @@
+package stock
+
+import (
+	"bytes"
+	"encoding/json"
+	"goERP/controllers/base"
+	md "goERP/models"
+	"strconv"
+	"strings"
+)
+
+// StockPutawayStrategyController 入库策略
+type StockPutawayStrategyController struct {
+	base.BaseController
+}
+
+// Post post请求
+func (ctl *StockPutawayStrategyController) Post() {
+	action := ctl.Input().Get("action")
+	switch action {
+	case "validator":
+		ctl.Validator()
+	case "table": //bootstrap table的post请求
+		// 入库策略详情页中的规则表格
+		if ctl.Ctx.Input.Param(":id") != "" {
+			ctl.PostRules()
+		} else {
+			ctl.PostList()
+		}
+	case "create":
+		ctl.PostCreate()
+	default:
+		ctl.PostList()
+	}
+}
+
+// Put 入库策略put请求，修改入库策略
+func (ctl *StockPutawayStrategyController) Put() {
+	id := ctl.Ctx.Input.Param(":id")
+	ctl.URL = "/stock/putaway/"
+	if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
+		if strategy, err := md.GetStockPutawayStrategyByID(idInt64); err == nil {
+			if err := ctl.ParseForm(&strategy); err == nil {
+
+				if err := md.UpdateStockPutawayStrategyByID(strategy); err == nil {
+					ctl.Redirect(ctl.URL+id+"?action=detail", 302)
+				}
+			}
+		}
+	}
+	ctl.Redirect(ctl.URL+id+"?action=edit", 302)
+
+}
+
+// Get 入库策略get请求
+func (ctl *StockPutawayStrategyController) Get() {
+	ctl.PageName = "入库策略管理"
+	action := ctl.Input().Get("action")
+	switch action {
+	case "create":
+		ctl.Create()
+	case "edit":
+		ctl.Edit()
+	case "detail":
+		ctl.Detail()
+	case "rules":
+		ctl.Rules()
+	default:
+		ctl.GetList()
+
+	}
+	// 标题合成
+	b := bytes.Buffer{}
+	b.WriteString(ctl.PageName)
+	b.WriteString("\\")
+	b.WriteString(ctl.PageAction)
+	ctl.Data["PageName"] = b.String()
+	ctl.URL = "/stock/putaway/"
+	ctl.Data["URL"] = ctl.URL
+
+	ctl.Data["MenuStockPutawayStrategyActive"] = "active"
+}
+
+// Edit 入库策略编辑get请求
+func (ctl *StockPutawayStrategyController) Edit() {
+	id := ctl.Ctx.Input.Param(":id")
+	if id != "" {
+		if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
+			if strategy, err := md.GetStockPutawayStrategyByID(idInt64); err == nil {
+				ctl.PageAction = strategy.Name
+				ctl.Data["Strategy"] = strategy
+			}
+		}
+	}
+	ctl.Data["FormField"] = "form-edit"
+	ctl.Data["Action"] = "edit"
+	ctl.Data["RecordID"] = id
+	ctl.Layout = "base/base.html"
+	ctl.TplName = "stock/stock_putaway_strategy_form.html"
+}
+
+// Create 入库策略创建get请求页面
+func (ctl *StockPutawayStrategyController) Create() {
+	ctl.Data["Action"] = "create"
+	ctl.Data["Readonly"] = false
+	ctl.Data["FormField"] = "form-create"
+	ctl.PageAction = "创建"
+	ctl.Layout = "base/base.html"
+	ctl.TplName = "stock/stock_putaway_strategy_form.html"
+}
+
+// Detail 入库策略显示get请求，信息不可修改
+func (ctl *StockPutawayStrategyController) Detail() {
+	//获取信息一样，直接调用Edit
+	ctl.Edit()
+	ctl.Data["Readonly"] = true
+	ctl.Data["Action"] = "detail"
+}
+
+// Rules 入库策略规则get请求，列出策略的所有入库规则
+func (ctl *StockPutawayStrategyController) Rules() {
+	id := ctl.Ctx.Input.Param(":id")
+	if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
+		if strategy, err := md.GetStockPutawayStrategyByID(idInt64); err == nil {
+			ctl.PageAction = strategy.Name + "规则"
+		}
+	}
+	ctl.Data["tableId"] = "table-stock-putaway-rule"
+	ctl.Layout = "base/base_list_view.html"
+	ctl.TplName = "stock/stock_putaway_strategy_list_search.html"
+}
+
+// PostRules 入库策略规则post请求，获得策略的所有入库规则
+func (ctl *StockPutawayStrategyController) PostRules() {
+	result := make(map[string]interface{})
+	id := ctl.Ctx.Input.Param(":id")
+	if idInt64, err := strconv.ParseInt(id, 10, 64); err == nil {
+		query := map[string]interface{}{"Strategy.Id": idInt64}
+		offset, _ := ctl.GetInt64("offset")
+		limit, _ := ctl.GetInt64("limit")
+		if rules, err := stockPutawayRuleList(query, nil, nil, nil, []string{"Sequence"}, []string{"asc"}, offset, limit); err == nil {
+			result = rules
+		}
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// PostCreate 入库策略post请求创建新策略
+func (ctl *StockPutawayStrategyController) PostCreate() {
+	result := make(map[string]interface{})
+	postData := ctl.GetString("postData")
+	strategy := new(md.StockPutawayStrategy)
+	var (
+		err error
+		id  int64
+	)
+	if err = json.Unmarshal([]byte(postData), strategy); err == nil {
+		if id, err = md.AddStockPutawayStrategy(strategy, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = ctl.URL + strconv.FormatInt(id, 10) + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "数据创建失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// Validator 入库策略post请求，用于验证策略名称唯一
+func (ctl *StockPutawayStrategyController) Validator() {
+	name := ctl.GetString("Name")
+	name = strings.TrimSpace(name)
+	recordID, _ := ctl.GetInt64("recordID")
+	result := make(map[string]bool)
+	obj, err := md.GetStockPutawayStrategyByName(name)
+	if err != nil {
+		result["valid"] = true
+	} else {
+		if obj.Name == name {
+			if recordID == obj.ID {
+				result["valid"] = true
+			} else {
+				result["valid"] = false
+			}
+
+		} else {
+			result["valid"] = true
+		}
+
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// 获得符合要求的数据
+func (ctl *StockPutawayStrategyController) stockPutawayStrategyList(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (map[string]interface{}, error) {
+
+	var arrs []md.StockPutawayStrategy
+	paginator, arrs, err := md.GetAllStockPutawayStrategy(query, exclude, condMap, fields, sortby, order, offset, limit)
+	result := make(map[string]interface{})
+	if err == nil {
+
+		tableLines := make([]interface{}, 0, 4)
+		for _, line := range arrs {
+			oneLine := make(map[string]interface{})
+			oneLine["Name"] = line.Name
+			if line.Company != nil {
+				company := make(map[string]interface{})
+				company["id"] = line.Company.ID
+				company["name"] = line.Company.Name
+				oneLine["Company"] = company
+			}
+			oneLine["Active"] = line.Active
+			oneLine["ID"] = line.ID
+			oneLine["id"] = line.ID
+			tableLines = append(tableLines, oneLine)
+		}
+		result["data"] = tableLines
+		if jsonResult, er := json.Marshal(&paginator); er == nil {
+			result["paginator"] = string(jsonResult)
+			result["total"] = paginator.TotalCount
+		}
+	}
+	return result, err
+}
+
+// PostList 入库策略post请求，用于获得多条入库策略
+func (ctl *StockPutawayStrategyController) PostList() {
+	query := make(map[string]interface{})
+	exclude := make(map[string]interface{})
+	fields := make([]string, 0, 0)
+	sortby := make([]string, 0, 1)
+	order := make([]string, 0, 1)
+	cond := make(map[string]map[string]interface{})
+	condAnd := make(map[string]interface{})
+	excludeIdsStr := ctl.GetStrings("exclude[]")
+	var excludeIds []int64
+	for _, v := range excludeIdsStr {
+		if val, err := strconv.ParseInt(v, 10, 64); err == nil {
+			excludeIds = append(excludeIds, val)
+		}
+	}
+	if len(excludeIds) > 0 {
+		exclude["Id.in"] = excludeIds
+	}
+	if name := strings.TrimSpace(ctl.GetString("Name")); name != "" {
+		condAnd["Name.icontains"] = name
+	}
+	offset, _ := ctl.GetInt64("offset")
+	limit, _ := ctl.GetInt64("limit")
+	orderStr := ctl.GetString("order")
+	sortStr := ctl.GetString("sort")
+	if orderStr != "" && sortStr != "" {
+		sortby = append(sortby, sortStr)
+		order = append(order, orderStr)
+	} else {
+		sortby = append(sortby, "Id")
+		order = append(order, "desc")
+	}
+	if len(condAnd) > 0 {
+		cond["and"] = condAnd
+	}
+	if result, err := ctl.stockPutawayStrategyList(query, exclude, cond, fields, sortby, order, offset, limit); err == nil {
+		ctl.Data["json"] = result
+	}
+	ctl.ServeJSON()
+
+}
+
+// GetList 入库策略get请求，列出入库策略
+func (ctl *StockPutawayStrategyController) GetList() {
+	viewType := ctl.Input().Get("view")
+	if viewType == "" || viewType == "table" {
+		ctl.Data["ViewType"] = "table"
+	}
+	ctl.PageAction = "列表"
+	ctl.Data["tableId"] = "table-stock-putaway"
+	ctl.Layout = "base/base_list_view.html"
+	ctl.TplName = "stock/stock_putaway_strategy_list_search.html"
+}
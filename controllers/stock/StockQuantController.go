@@ -0,0 +1,191 @@
+package stock
+
+import (
+	"bytes"
+	"encoding/json"
+	"goERP/controllers/base"
+	md "goERP/models"
+)
+
+// StockQuantController 库存份
+type StockQuantController struct {
+	base.BaseController
+}
+
+// Post request
+func (ctl *StockQuantController) Post() {
+	action := ctl.Input().Get("action")
+	switch action {
+	case "table": //bootstrap table的post请求
+		ctl.PostList()
+	default:
+		ctl.PostList()
+	}
+}
+
+// Get request
+func (ctl *StockQuantController) Get() {
+	ctl.PageName = "库存查询"
+	action := ctl.Input().Get("action")
+	switch action {
+	case "negative":
+		ctl.PageName = "负库存"
+		ctl.GetNegativeList()
+	default:
+		ctl.GetList()
+	}
+	// 标题合成
+	b := bytes.Buffer{}
+	b.WriteString(ctl.PageName)
+	b.WriteString("\\")
+	b.WriteString(ctl.PageAction)
+	ctl.Data["PageName"] = b.String()
+	ctl.URL = "/stock/quant/"
+	ctl.Data["URL"] = ctl.URL
+
+	if action == "negative" {
+		ctl.Data["MenuStockQuantNegativeActive"] = "active"
+	} else {
+		ctl.Data["MenuStockQuantActive"] = "active"
+	}
+}
+
+// 获得符合要求的数据
+func (ctl *StockQuantController) stockQuantList(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (map[string]interface{}, error) {
+
+	var arrs []md.StockQuant
+	paginator, arrs, err := md.GetAllStockQuant(query, exclude, condMap, fields, sortby, order, offset, limit)
+	result := make(map[string]interface{})
+	if err == nil {
+
+		tableLines := make([]interface{}, 0, 4)
+		for _, line := range arrs {
+			oneLine := make(map[string]interface{})
+			oneLine["Name"] = line.Name
+			oneLine["ID"] = line.ID
+			oneLine["id"] = line.ID
+			oneLine["FirstUomQty"] = line.FirstUomQty
+			oneLine["SecondUomQty"] = line.SecondUomQty
+			oneLine["Cost"] = line.Cost
+			oneLine["InDate"] = line.InDate.Format("2006-01-02 15:04:05")
+			oneLine["Reserved"] = line.Reservation != nil
+			if line.Product != nil {
+				product := make(map[string]interface{})
+				product["id"] = line.Product.ID
+				product["name"] = line.Product.Name
+				oneLine["Product"] = product
+			}
+			if line.Location != nil {
+				location := make(map[string]interface{})
+				location["id"] = line.Location.ID
+				location["name"] = line.Location.Name
+				oneLine["Location"] = location
+			}
+			if line.Lot != nil {
+				lot := make(map[string]interface{})
+				lot["id"] = line.Lot.ID
+				lot["name"] = line.Lot.Name
+				oneLine["Lot"] = lot
+			}
+			if line.Owner != nil {
+				if owner, err := md.GetPartnerByID(line.Owner.ID); err == nil {
+					oneLine["Owner"] = owner.Name
+				}
+			}
+			if line.FirstUom != nil {
+				oneLine["FirstUom"] = line.FirstUom.Name
+			}
+			if line.SecondUom != nil {
+				oneLine["SecondUom"] = line.SecondUom.Name
+			}
+			if line.NegativeMove != nil {
+				if move, err := md.GetStockMoveByID(line.NegativeMove.ID); err == nil {
+					negativeMove := make(map[string]interface{})
+					negativeMove["id"] = move.ID
+					negativeMove["name"] = move.Name
+					negativeMove["origin"] = move.Origin
+					if move.Picking != nil {
+						if picking, err := md.GetStockPickingByID(move.Picking.ID); err == nil {
+							negativeMove["picking"] = picking.Name
+							negativeMove["pickingId"] = picking.ID
+						}
+					}
+					oneLine["NegativeMove"] = negativeMove
+				}
+			}
+			if line.NegativeDestLocation != nil {
+				if location, err := md.GetStockLocationByID(line.NegativeDestLocation.ID); err == nil {
+					destLocation := make(map[string]interface{})
+					destLocation["id"] = location.ID
+					destLocation["name"] = location.Name
+					oneLine["NegativeDestLocation"] = destLocation
+				}
+			}
+			tableLines = append(tableLines, oneLine)
+		}
+		result["data"] = tableLines
+		if jsonResult, er := json.Marshal(&paginator); er == nil {
+			result["paginator"] = string(jsonResult)
+			result["total"] = paginator.TotalCount
+		}
+	}
+	return result, err
+}
+
+// PostList 库存份post请求，可按产品和库位过滤，negative参数只查询未冲销的负库存
+func (ctl *StockQuantController) PostList() {
+	query := make(map[string]interface{})
+	exclude := make(map[string]interface{})
+	fields := make([]string, 0, 0)
+	sortby := make([]string, 0, 1)
+	order := make([]string, 0, 1)
+	cond := make(map[string]map[string]interface{})
+
+	if productID, err := ctl.GetInt64("Product"); err == nil && productID > 0 {
+		query["Product.Id"] = productID
+	}
+	if locationID, err := ctl.GetInt64("Location"); err == nil && locationID > 0 {
+		query["Location.Id"] = locationID
+	}
+	if negative, err := ctl.GetBool("negative"); err == nil && negative {
+		cond["or"] = map[string]interface{}{"FirstUomQty__lt": 0, "SecondUomQty__lt": 0}
+	}
+
+	offset, _ := ctl.GetInt64("offset")
+	limit, _ := ctl.GetInt64("limit")
+	orderStr := ctl.GetString("order")
+	sortStr := ctl.GetString("sort")
+	if orderStr != "" && sortStr != "" {
+		sortby = append(sortby, sortStr)
+		order = append(order, orderStr)
+	} else {
+		sortby = append(sortby, "Id")
+		order = append(order, "desc")
+	}
+	if result, err := ctl.stockQuantList(query, exclude, cond, fields, sortby, order, offset, limit); err == nil {
+		ctl.Data["json"] = result
+	}
+	ctl.ServeJSON()
+
+}
+
+// GetList 库存份列表
+func (ctl *StockQuantController) GetList() {
+	viewType := ctl.Input().Get("view")
+	if viewType == "" || viewType == "table" {
+		ctl.Data["ViewType"] = "table"
+	}
+	ctl.PageAction = "列表"
+	ctl.Data["tableId"] = "table-stock-quant"
+	ctl.Layout = "base/base_list_view.html"
+	ctl.TplName = "stock/stock_quant_list_search.html"
+}
+
+// GetNegativeList 未冲销的负库存列表，到货后自动冲销
+func (ctl *StockQuantController) GetNegativeList() {
+	ctl.Data["ViewType"] = "table"
+	ctl.PageAction = "列表"
+	ctl.Data["tableId"] = "table-stock-quant-negative"
+	ctl.Layout = "base/base_list_view.html"
+	ctl.TplName = "stock/stock_quant_negative_list_search.html"
+}
@@ -0,0 +1,353 @@
+package stock
+
+import (
+	"bytes"
+	"encoding/json"
+	"goERP/controllers/base"
+	md "goERP/models"
+	"strconv"
+	"strings"
+)
+
+// StockQuantPackageController 物理包装
+type StockQuantPackageController struct {
+	base.BaseController
+}
+
+// Post post请求
+func (ctl *StockQuantPackageController) Post() {
+	action := ctl.Input().Get("action")
+	switch action {
+	case "validator":
+		ctl.Validator()
+	case "table": //bootstrap table的post请求
+		// 包详情页中的包内容表格
+		if ctl.Ctx.Input.Param(":id") != "" {
+			ctl.PostContent()
+		} else {
+			ctl.PostList()
+		}
+	case "create":
+		ctl.PostCreate()
+	case "unpack":
+		ctl.PostUnpack()
+	default:
+		ctl.PostList()
+	}
+}
+
+// Put 包put请求，修改包信息
+func (ctl *StockQuantPackageController) Put() {
+	id := ctl.Ctx.Input.Param(":id")
+	ctl.URL = "/stock/package/"
+	if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
+		if pack, err := md.GetStockQuantPackageByID(idInt64); err == nil {
+			if err := ctl.ParseForm(&pack); err == nil {
+
+				if err := md.UpdateStockQuantPackageByID(pack); err == nil {
+					ctl.Redirect(ctl.URL+id+"?action=detail", 302)
+				}
+			}
+		}
+	}
+	ctl.Redirect(ctl.URL+id+"?action=edit", 302)
+
+}
+
+// Get 包get请求
+func (ctl *StockQuantPackageController) Get() {
+	ctl.PageName = "包管理"
+	action := ctl.Input().Get("action")
+	switch action {
+	case "create":
+		ctl.Create()
+	case "edit":
+		ctl.Edit()
+	case "detail":
+		ctl.Detail()
+	case "content":
+		ctl.Content()
+	default:
+		ctl.GetList()
+
+	}
+	// 标题合成
+	b := bytes.Buffer{}
+	b.WriteString(ctl.PageName)
+	b.WriteString("\\")
+	b.WriteString(ctl.PageAction)
+	ctl.Data["PageName"] = b.String()
+	ctl.URL = "/stock/package/"
+	ctl.Data["URL"] = ctl.URL
+
+	ctl.Data["MenuStockQuantPackageActive"] = "active"
+}
+
+// Edit 包编辑get请求
+func (ctl *StockQuantPackageController) Edit() {
+	id := ctl.Ctx.Input.Param(":id")
+	if id != "" {
+		if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
+			if pack, err := md.GetStockQuantPackageByID(idInt64); err == nil {
+				ctl.PageAction = pack.Name
+				ctl.Data["Package"] = pack
+			}
+		}
+	}
+	ctl.Data["FormField"] = "form-edit"
+	ctl.Data["Action"] = "edit"
+	ctl.Data["RecordID"] = id
+	ctl.Layout = "base/base.html"
+	ctl.TplName = "stock/stock_quant_package_form.html"
+}
+
+// Create 包创建get请求页面
+func (ctl *StockQuantPackageController) Create() {
+	ctl.Data["Action"] = "create"
+	ctl.Data["Readonly"] = false
+	ctl.Data["FormField"] = "form-create"
+	ctl.PageAction = "创建"
+	ctl.Layout = "base/base.html"
+	ctl.TplName = "stock/stock_quant_package_form.html"
+}
+
+// Detail 包信息显示get请求，信息不可修改
+func (ctl *StockQuantPackageController) Detail() {
+	//获取信息一样，直接调用Edit
+	ctl.Edit()
+	ctl.Data["Readonly"] = true
+	ctl.Data["Action"] = "detail"
+}
+
+// Content 包内容get请求，列出包及下级包中的产品
+func (ctl *StockQuantPackageController) Content() {
+	id := ctl.Ctx.Input.Param(":id")
+	if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
+		if pack, err := md.GetStockQuantPackageByID(idInt64); err == nil {
+			ctl.PageAction = pack.Name + "内容"
+		}
+	}
+	ctl.Data["tableId"] = "table-stock-package-content"
+	ctl.Layout = "base/base_list_view.html"
+	ctl.TplName = "stock/stock_quant_package_list_search.html"
+}
+
+// PostContent 包内容post请求，获得包及下级包中的份
+func (ctl *StockQuantPackageController) PostContent() {
+	result := make(map[string]interface{})
+	id := ctl.Ctx.Input.Param(":id")
+	if idInt64, err := strconv.ParseInt(id, 10, 64); err == nil {
+		if quants, err := md.GetStockQuantPackageContent(idInt64); err == nil {
+			tableLines := make([]interface{}, 0, 4)
+			for _, quant := range quants {
+				oneLine := make(map[string]interface{})
+				oneLine["ID"] = quant.ID
+				oneLine["id"] = quant.ID
+				oneLine["FirstUomQty"] = quant.FirstUomQty
+				oneLine["SecondUomQty"] = quant.SecondUomQty
+				if quant.Package != nil {
+					oneLine["Package"] = quant.Package.Name
+				}
+				if quant.Product != nil {
+					product := make(map[string]interface{})
+					product["id"] = quant.Product.ID
+					product["name"] = quant.Product.Name
+					oneLine["Product"] = product
+				}
+				if quant.Location != nil {
+					oneLine["Location"] = quant.Location.Name
+				}
+				if quant.FirstUom != nil {
+					oneLine["FirstUom"] = quant.FirstUom.Name
+				}
+				tableLines = append(tableLines, oneLine)
+			}
+			result["data"] = tableLines
+			result["total"] = len(tableLines)
+		}
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// PostUnpack 拆包
+func (ctl *StockQuantPackageController) PostUnpack() {
+	result := make(map[string]interface{})
+	id := ctl.Ctx.Input.Param(":id")
+	if idInt64, err := strconv.ParseInt(id, 10, 64); err == nil {
+		if err = md.UnpackStockQuantPackage(idInt64, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = "/stock/package/" + id + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "拆包失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// PostCreate 包post请求创建新包
+func (ctl *StockQuantPackageController) PostCreate() {
+	result := make(map[string]interface{})
+	postData := ctl.GetString("postData")
+	pack := new(md.StockQuantPackage)
+	var (
+		err error
+		id  int64
+	)
+	if err = json.Unmarshal([]byte(postData), pack); err == nil {
+		if id, err = md.AddStockQuantPackage(pack, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = ctl.URL + strconv.FormatInt(id, 10) + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "数据创建失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// Validator 包信息post请求，用于验证包名称唯一
+func (ctl *StockQuantPackageController) Validator() {
+	name := ctl.GetString("Name")
+	name = strings.TrimSpace(name)
+	recordID, _ := ctl.GetInt64("recordID")
+	result := make(map[string]bool)
+	obj, err := md.GetStockQuantPackageByName(name)
+	if err != nil {
+		result["valid"] = true
+	} else {
+		if obj.Name == name {
+			if recordID == obj.ID {
+				result["valid"] = true
+			} else {
+				result["valid"] = false
+			}
+
+		} else {
+			result["valid"] = true
+		}
+
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// 获得符合要求的数据
+func (ctl *StockQuantPackageController) stockQuantPackageList(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (map[string]interface{}, error) {
+
+	var arrs []md.StockQuantPackage
+	paginator, arrs, err := md.GetAllStockQuantPackage(query, exclude, condMap, fields, sortby, order, offset, limit)
+	result := make(map[string]interface{})
+	if err == nil {
+
+		tableLines := make([]interface{}, 0, 4)
+		for _, line := range arrs {
+			oneLine := make(map[string]interface{})
+			oneLine["Name"] = line.Name
+			oneLine["Barcode"] = line.Barcode
+			oneLine["ID"] = line.ID
+			oneLine["id"] = line.ID
+			if line.Parent != nil {
+				parent := make(map[string]interface{})
+				parent["id"] = line.Parent.ID
+				parent["name"] = line.Parent.Name
+				oneLine["Parent"] = parent
+			}
+			if line.Location != nil {
+				location := make(map[string]interface{})
+				location["id"] = line.Location.ID
+				location["name"] = line.Location.Name
+				oneLine["Location"] = location
+			}
+			if line.Company != nil {
+				company := make(map[string]interface{})
+				company["id"] = line.Company.ID
+				company["name"] = line.Company.Name
+				oneLine["Company"] = company
+			}
+			tableLines = append(tableLines, oneLine)
+		}
+		result["data"] = tableLines
+		if jsonResult, er := json.Marshal(&paginator); er == nil {
+			result["paginator"] = string(jsonResult)
+			result["total"] = paginator.TotalCount
+		}
+	}
+	return result, err
+}
+
+// PostList 包信息post请求，用于获得多条包信息
+func (ctl *StockQuantPackageController) PostList() {
+	query := make(map[string]interface{})
+	exclude := make(map[string]interface{})
+	fields := make([]string, 0, 0)
+	sortby := make([]string, 0, 1)
+	order := make([]string, 0, 1)
+	cond := make(map[string]map[string]interface{})
+	condAnd := make(map[string]interface{})
+	condOr := make(map[string]interface{})
+	excludeIdsStr := ctl.GetStrings("exclude[]")
+	var excludeIds []int64
+	for _, v := range excludeIdsStr {
+		if val, err := strconv.ParseInt(v, 10, 64); err == nil {
+			excludeIds = append(excludeIds, val)
+		}
+	}
+	if len(excludeIds) > 0 {
+		exclude["Id.in"] = excludeIds
+	}
+	if name := strings.TrimSpace(ctl.GetString("Name")); name != "" {
+		condOr["Name.icontains"] = name
+		condOr["Barcode.icontains"] = name
+	}
+	if locationID, err := ctl.GetInt64("LocationID"); err == nil {
+		condAnd["Location.Id"] = locationID
+	}
+	offset, _ := ctl.GetInt64("offset")
+	limit, _ := ctl.GetInt64("limit")
+	orderStr := ctl.GetString("order")
+	sortStr := ctl.GetString("sort")
+	if orderStr != "" && sortStr != "" {
+		sortby = append(sortby, sortStr)
+		order = append(order, orderStr)
+	} else {
+		sortby = append(sortby, "Id")
+		order = append(order, "desc")
+	}
+	if len(condAnd) > 0 {
+		cond["and"] = condAnd
+	}
+	if len(condOr) > 0 {
+		cond["or"] = condOr
+	}
+	if result, err := ctl.stockQuantPackageList(query, exclude, cond, fields, sortby, order, offset, limit); err == nil {
+		ctl.Data["json"] = result
+	}
+	ctl.ServeJSON()
+
+}
+
+// GetList 包信息get请求，列出包
+func (ctl *StockQuantPackageController) GetList() {
+	viewType := ctl.Input().Get("view")
+	if viewType == "" || viewType == "table" {
+		ctl.Data["ViewType"] = "table"
+	}
+	ctl.PageAction = "列表"
+	ctl.Data["tableId"] = "table-stock-package"
+	ctl.Layout = "base/base_list_view.html"
+	ctl.TplName = "stock/stock_quant_package_list_search.html"
+}
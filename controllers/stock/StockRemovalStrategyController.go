@@ -0,0 +1,246 @@
+package stock
+
+import (
+	"bytes"
+	"encoding/json"
+	"goERP/controllers/base"
+	md "goERP/models"
+	"strconv"
+	"strings"
+)
+
+// StockRemovalStrategyController 出库策略
+type StockRemovalStrategyController struct {
+	base.BaseController
+}
+
+// Post post请求
+func (ctl *StockRemovalStrategyController) Post() {
+	action := ctl.Input().Get("action")
+	switch action {
+	case "validator":
+		ctl.Validator()
+	case "table": //bootstrap table的post请求
+		ctl.PostList()
+	case "create":
+		ctl.PostCreate()
+	default:
+		ctl.PostList()
+	}
+}
+
+// Put 出库策略put请求，修改出库策略
+func (ctl *StockRemovalStrategyController) Put() {
+	id := ctl.Ctx.Input.Param(":id")
+	ctl.URL = "/stock/removal/"
+	if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
+		if strategy, err := md.GetStockRemovalStrategyByID(idInt64); err == nil {
+			if err := ctl.ParseForm(&strategy); err == nil {
+
+				if err := md.UpdateStockRemovalStrategyByID(strategy); err == nil {
+					ctl.Redirect(ctl.URL+id+"?action=detail", 302)
+				}
+			}
+		}
+	}
+	ctl.Redirect(ctl.URL+id+"?action=edit", 302)
+
+}
+
+// Get 出库策略get请求
+func (ctl *StockRemovalStrategyController) Get() {
+	ctl.PageName = "出库策略管理"
+	action := ctl.Input().Get("action")
+	switch action {
+	case "create":
+		ctl.Create()
+	case "edit":
+		ctl.Edit()
+	case "detail":
+		ctl.Detail()
+	default:
+		ctl.GetList()
+
+	}
+	// 标题合成
+	b := bytes.Buffer{}
+	b.WriteString(ctl.PageName)
+	b.WriteString("\\")
+	b.WriteString(ctl.PageAction)
+	ctl.Data["PageName"] = b.String()
+	ctl.URL = "/stock/removal/"
+	ctl.Data["URL"] = ctl.URL
+
+	ctl.Data["MenuStockRemovalStrategyActive"] = "active"
+}
+
+// Edit 出库策略编辑get请求
+func (ctl *StockRemovalStrategyController) Edit() {
+	id := ctl.Ctx.Input.Param(":id")
+	if id != "" {
+		if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
+			if strategy, err := md.GetStockRemovalStrategyByID(idInt64); err == nil {
+				ctl.PageAction = strategy.Name
+				ctl.Data["Strategy"] = strategy
+			}
+		}
+	}
+	ctl.Data["FormField"] = "form-edit"
+	ctl.Data["Action"] = "edit"
+	ctl.Data["RecordID"] = id
+	ctl.Layout = "base/base.html"
+	ctl.TplName = "stock/stock_removal_strategy_form.html"
+}
+
+// Create 出库策略创建get请求页面
+func (ctl *StockRemovalStrategyController) Create() {
+	ctl.Data["Action"] = "create"
+	ctl.Data["Readonly"] = false
+	ctl.Data["FormField"] = "form-create"
+	ctl.PageAction = "创建"
+	ctl.Layout = "base/base.html"
+	ctl.TplName = "stock/stock_removal_strategy_form.html"
+}
+
+// Detail 出库策略显示get请求，信息不可修改
+func (ctl *StockRemovalStrategyController) Detail() {
+	//获取信息一样，直接调用Edit
+	ctl.Edit()
+	ctl.Data["Readonly"] = true
+	ctl.Data["Action"] = "detail"
+}
+
+// PostCreate 出库策略post请求创建新策略
+func (ctl *StockRemovalStrategyController) PostCreate() {
+	result := make(map[string]interface{})
+	postData := ctl.GetString("postData")
+	strategy := new(md.StockRemovalStrategy)
+	var (
+		err error
+		id  int64
+	)
+	if err = json.Unmarshal([]byte(postData), strategy); err == nil {
+		if id, err = md.AddStockRemovalStrategy(strategy, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = ctl.URL + strconv.FormatInt(id, 10) + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "数据创建失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// Validator 出库策略post请求，用于验证策略名称唯一
+func (ctl *StockRemovalStrategyController) Validator() {
+	name := ctl.GetString("Name")
+	name = strings.TrimSpace(name)
+	recordID, _ := ctl.GetInt64("recordID")
+	result := make(map[string]bool)
+	obj, err := md.GetStockRemovalStrategyByName(name)
+	if err != nil {
+		result["valid"] = true
+	} else {
+		if obj.Name == name {
+			if recordID == obj.ID {
+				result["valid"] = true
+			} else {
+				result["valid"] = false
+			}
+
+		} else {
+			result["valid"] = true
+		}
+
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// 获得符合要求的数据
+func (ctl *StockRemovalStrategyController) stockRemovalStrategyList(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (map[string]interface{}, error) {
+
+	var arrs []md.StockRemovalStrategy
+	paginator, arrs, err := md.GetAllStockRemovalStrategy(query, exclude, condMap, fields, sortby, order, offset, limit)
+	result := make(map[string]interface{})
+	if err == nil {
+
+		tableLines := make([]interface{}, 0, 4)
+		for _, line := range arrs {
+			oneLine := make(map[string]interface{})
+			oneLine["Name"] = line.Name
+			oneLine["Method"] = line.Method
+			oneLine["Active"] = line.Active
+			oneLine["ID"] = line.ID
+			oneLine["id"] = line.ID
+			tableLines = append(tableLines, oneLine)
+		}
+		result["data"] = tableLines
+		if jsonResult, er := json.Marshal(&paginator); er == nil {
+			result["paginator"] = string(jsonResult)
+			result["total"] = paginator.TotalCount
+		}
+	}
+	return result, err
+}
+
+// PostList 出库策略post请求，用于获得多条出库策略
+func (ctl *StockRemovalStrategyController) PostList() {
+	query := make(map[string]interface{})
+	exclude := make(map[string]interface{})
+	fields := make([]string, 0, 0)
+	sortby := make([]string, 0, 1)
+	order := make([]string, 0, 1)
+	cond := make(map[string]map[string]interface{})
+	condAnd := make(map[string]interface{})
+	excludeIdsStr := ctl.GetStrings("exclude[]")
+	var excludeIds []int64
+	for _, v := range excludeIdsStr {
+		if val, err := strconv.ParseInt(v, 10, 64); err == nil {
+			excludeIds = append(excludeIds, val)
+		}
+	}
+	if len(excludeIds) > 0 {
+		exclude["Id.in"] = excludeIds
+	}
+	if name := strings.TrimSpace(ctl.GetString("Name")); name != "" {
+		condAnd["Name.icontains"] = name
+	}
+	offset, _ := ctl.GetInt64("offset")
+	limit, _ := ctl.GetInt64("limit")
+	orderStr := ctl.GetString("order")
+	sortStr := ctl.GetString("sort")
+	if orderStr != "" && sortStr != "" {
+		sortby = append(sortby, sortStr)
+		order = append(order, orderStr)
+	} else {
+		sortby = append(sortby, "Id")
+		order = append(order, "desc")
+	}
+	if len(condAnd) > 0 {
+		cond["and"] = condAnd
+	}
+	if result, err := ctl.stockRemovalStrategyList(query, exclude, cond, fields, sortby, order, offset, limit); err == nil {
+		ctl.Data["json"] = result
+	}
+	ctl.ServeJSON()
+
+}
+
+// GetList 出库策略get请求，列出出库策略
+func (ctl *StockRemovalStrategyController) GetList() {
+	viewType := ctl.Input().Get("view")
+	if viewType == "" || viewType == "table" {
+		ctl.Data["ViewType"] = "table"
+	}
+	ctl.PageAction = "列表"
+	ctl.Data["tableId"] = "table-stock-removal"
+	ctl.Layout = "base/base_list_view.html"
+	ctl.TplName = "stock/stock_removal_strategy_list_search.html"
+}
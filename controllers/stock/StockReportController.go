@@ -0,0 +1,459 @@
+package stock
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"goERP/controllers/base"
+	md "goERP/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StockReportController 库存报表
+type StockReportController struct {
+	base.BaseController
+}
+
+// Post post请求
+func (ctl *StockReportController) Post() {
+	// 不同报表的表格通过report参数区分
+	switch ctl.Input().Get("report") {
+	case "ledger":
+		ctl.PostLedger()
+	case "trace":
+		ctl.PostTrace()
+	case "consignment":
+		ctl.PostConsignment()
+	default:
+		ctl.PostValuation()
+	}
+}
+
+// Get 库存报表get请求
+func (ctl *StockReportController) Get() {
+	ctl.PageName = "库存报表"
+	action := ctl.Input().Get("action")
+	switch action {
+	case "ledger":
+		ctl.Ledger()
+	case "trace":
+		ctl.Trace()
+	case "consignment":
+		ctl.Consignment()
+	case "export":
+		ctl.Export()
+		return
+	default:
+		ctl.Valuation()
+	}
+	// 标题合成
+	b := bytes.Buffer{}
+	b.WriteString(ctl.PageName)
+	b.WriteString("\\")
+	b.WriteString(ctl.PageAction)
+	ctl.Data["PageName"] = b.String()
+	ctl.URL = "/stock/report/"
+	ctl.Data["URL"] = ctl.URL
+
+	switch action {
+	case "ledger":
+		ctl.Data["MenuStockLedgerActive"] = "active"
+	case "trace":
+		ctl.Data["MenuStockTraceActive"] = "active"
+	case "consignment":
+		ctl.Data["MenuStockConsignmentStatementActive"] = "active"
+	default:
+		ctl.Data["MenuStockReportActive"] = "active"
+	}
+}
+
+// Valuation 库存估值get请求
+func (ctl *StockReportController) Valuation() {
+	ctl.Data["ViewType"] = "table"
+	ctl.PageAction = "库存估值"
+	ctl.Data["tableId"] = "table-stock-valuation"
+	ctl.Layout = "base/base_list_view.html"
+	ctl.TplName = "stock/stock_valuation_list_search.html"
+}
+
+// PostValuation 库存估值post请求，按库位、类别和日期获得库存价值
+func (ctl *StockReportController) PostValuation() {
+	result := make(map[string]interface{})
+	filterMap := make(map[string]interface{})
+	if filter := ctl.GetString("filter"); filter != "" {
+		json.Unmarshal([]byte(filter), &filterMap)
+	}
+	var (
+		date       time.Time
+		locationID int64
+		categoryID int64
+	)
+	if filterDate, ok := filterMap["Date"]; ok {
+		if dateStr := strings.TrimSpace(filterDate.(string)); dateStr != "" {
+			// 截止到当天结束
+			if day, err := time.ParseInLocation("2006-01-02", dateStr, time.Local); err == nil {
+				date = day.AddDate(0, 0, 1).Add(-time.Second)
+			}
+		}
+	}
+	if filterLocation, ok := filterMap["Location"]; ok {
+		locationID = int64(filterLocation.(float64))
+	}
+	if filterCategory, ok := filterMap["Category"]; ok {
+		categoryID = int64(filterCategory.(float64))
+	}
+	offset, _ := ctl.GetInt64("offset")
+	limit, _ := ctl.GetInt64("limit")
+	if lines, err := md.GetStockValuation(date, locationID, categoryID); err == nil {
+		var valueTotal float64
+		tableLines := make([]interface{}, 0, 4)
+		for i, line := range lines {
+			valueTotal += line.Value
+			if int64(i) < offset || (limit > 0 && int64(i) >= offset+limit) {
+				continue
+			}
+			oneLine := make(map[string]interface{})
+			oneLine["FirstUomQty"] = line.FirstUomQty
+			oneLine["SecondUomQty"] = line.SecondUomQty
+			oneLine["UnitCost"] = line.UnitCost
+			oneLine["Value"] = line.Value
+			oneLine["CostMethod"] = line.CostMethod
+			location := make(map[string]interface{})
+			location["id"] = line.Location.ID
+			location["name"] = line.Location.Name
+			oneLine["Location"] = location
+			product := make(map[string]interface{})
+			product["id"] = line.Product.ID
+			product["name"] = line.Product.Name
+			oneLine["Product"] = product
+			if line.Category != nil {
+				oneLine["Category"] = line.Category.Name
+			}
+			tableLines = append(tableLines, oneLine)
+		}
+		result["data"] = tableLines
+		result["total"] = len(lines)
+		result["valueTotal"] = valueTotal
+	} else {
+		result["code"] = "failed"
+		result["message"] = "库存估值计算失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// Ledger 库存台账get请求
+func (ctl *StockReportController) Ledger() {
+	ctl.Data["ViewType"] = "table"
+	ctl.PageAction = "库存台账"
+	ctl.Data["tableId"] = "table-stock-ledger"
+	ctl.Layout = "base/base_list_view.html"
+	ctl.TplName = "stock/stock_ledger_list_search.html"
+}
+
+// Trace 份、批次追溯get请求
+func (ctl *StockReportController) Trace() {
+	ctl.Data["ViewType"] = "table"
+	ctl.PageAction = "追溯"
+	ctl.Data["tableId"] = "table-stock-trace"
+	ctl.Layout = "base/base_list_view.html"
+	ctl.TplName = "stock/stock_trace_list_search.html"
+}
+
+// Consignment 代售对账单get请求
+func (ctl *StockReportController) Consignment() {
+	ctl.Data["ViewType"] = "table"
+	ctl.PageAction = "代售对账单"
+	ctl.Data["tableId"] = "table-stock-consignment-statement"
+	ctl.Layout = "base/base_list_view.html"
+	ctl.TplName = "stock/stock_consignment_statement_list_search.html"
+}
+
+// reportFilterInt64 获得过滤条件中的整数
+func reportFilterInt64(filterMap map[string]interface{}, key string) int64 {
+	if value, ok := filterMap[key].(float64); ok {
+		return int64(value)
+	}
+	return 0
+}
+
+// reportFilterDate 获得过滤条件中的日期，endOfDay为true时取当天结束时间
+func reportFilterDate(filterMap map[string]interface{}, key string, endOfDay bool) time.Time {
+	var date time.Time
+	if dateStr, ok := filterMap[key].(string); ok {
+		if day, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(dateStr), time.Local); err == nil {
+			date = day
+			if endOfDay {
+				date = day.AddDate(0, 0, 1).Add(-time.Second)
+			}
+		}
+	}
+	return date
+}
+
+// reportFilter 获得报表过滤条件，表格请求为filter参数中的json，导出请求为url参数
+func (ctl *StockReportController) reportFilter() map[string]interface{} {
+	filterMap := make(map[string]interface{})
+	if filter := ctl.GetString("filter"); filter != "" {
+		json.Unmarshal([]byte(filter), &filterMap)
+		return filterMap
+	}
+	for _, key := range []string{"Product", "Location", "Quant", "Lot", "Category", "Owner"} {
+		if value, err := ctl.GetInt64(key); err == nil {
+			filterMap[key] = float64(value)
+		}
+	}
+	for _, key := range []string{"Date", "DateStart", "DateEnd"} {
+		if value := ctl.GetString(key); value != "" {
+			filterMap[key] = value
+		}
+	}
+	return filterMap
+}
+
+// reportMoveLine 移动的公共显示字段
+func reportMoveLine(move *md.StockMove) map[string]interface{} {
+	oneLine := make(map[string]interface{})
+	oneLine["ID"] = move.ID
+	oneLine["id"] = move.ID
+	oneLine["Name"] = move.Name
+	oneLine["Date"] = move.Date.Format("2006-01-02 15:04:05")
+	oneLine["Origin"] = move.Origin
+	if move.LocationSrc != nil {
+		oneLine["LocationSrc"] = move.LocationSrc.Name
+	}
+	if move.LocationDest != nil {
+		oneLine["LocationDest"] = move.LocationDest.Name
+	}
+	if move.Picking != nil {
+		picking := make(map[string]interface{})
+		picking["id"] = move.Picking.ID
+		picking["name"] = move.Picking.Name
+		oneLine["Picking"] = picking
+	}
+	if move.Partner != nil {
+		oneLine["Partner"] = move.Partner.Name
+	}
+	if move.Lot != nil {
+		oneLine["Lot"] = move.Lot.Name
+	}
+	return oneLine
+}
+
+// PostLedger 库存台账post请求，按产品规格、库位和日期范围获得期初、逐笔移动及结存
+func (ctl *StockReportController) PostLedger() {
+	result := make(map[string]interface{})
+	filterMap := ctl.reportFilter()
+	productID := reportFilterInt64(filterMap, "Product")
+	if productID == 0 {
+		result["data"] = []interface{}{}
+		result["total"] = 0
+		ctl.Data["json"] = result
+		ctl.ServeJSON()
+		return
+	}
+	ledger, err := md.GetStockLedger(productID, reportFilterInt64(filterMap, "Location"), reportFilterDate(filterMap, "DateStart", false), reportFilterDate(filterMap, "DateEnd", true))
+	if err == nil {
+		tableLines := make([]interface{}, 0, len(ledger.Lines)+1)
+		opening := make(map[string]interface{})
+		opening["Name"] = "期初结存"
+		opening["FirstBalance"] = ledger.FirstOpening
+		opening["SecondBalance"] = ledger.SecondOpening
+		tableLines = append(tableLines, opening)
+		for _, line := range ledger.Lines {
+			oneLine := reportMoveLine(line.Move)
+			oneLine["FirstIn"] = line.FirstIn
+			oneLine["SecondIn"] = line.SecondIn
+			oneLine["FirstOut"] = line.FirstOut
+			oneLine["SecondOut"] = line.SecondOut
+			oneLine["FirstBalance"] = line.FirstBalance
+			oneLine["SecondBalance"] = line.SecondBalance
+			tableLines = append(tableLines, oneLine)
+		}
+		result["data"] = tableLines
+		result["total"] = len(tableLines)
+		result["FirstClosing"] = ledger.FirstClosing
+		result["SecondClosing"] = ledger.SecondClosing
+	} else {
+		result["code"] = "failed"
+		result["message"] = "库存台账获取失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// stockTrace 按过滤条件追溯份或批次，份优先
+func stockTrace(filterMap map[string]interface{}) ([]*md.StockTraceLine, error) {
+	if quantID := reportFilterInt64(filterMap, "Quant"); quantID > 0 {
+		return md.GetStockQuantTrace(quantID)
+	}
+	if lotID := reportFilterInt64(filterMap, "Lot"); lotID > 0 {
+		return md.GetStockLotTrace(lotID)
+	}
+	return nil, nil
+}
+
+// PostTrace 追溯post请求，获得份或批次的上游来源和下游去向
+func (ctl *StockReportController) PostTrace() {
+	result := make(map[string]interface{})
+	if lines, err := stockTrace(ctl.reportFilter()); err == nil {
+		tableLines := make([]interface{}, 0, len(lines))
+		for _, line := range lines {
+			oneLine := reportMoveLine(line.Move)
+			oneLine["Direction"] = line.Direction
+			oneLine["FirstUomQty"] = line.Move.FirstUomQty
+			oneLine["SecondUomQty"] = line.Move.SecondUomQty
+			if line.Quant != nil {
+				oneLine["Quant"] = line.Quant.ID
+			}
+			tableLines = append(tableLines, oneLine)
+		}
+		result["data"] = tableLines
+		result["total"] = len(tableLines)
+	} else {
+		result["code"] = "failed"
+		result["message"] = "追溯失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// PostConsignment 代售对账单post请求，按货主和日期范围汇总代售品的接收、销售、退回和在库数量
+func (ctl *StockReportController) PostConsignment() {
+	result := make(map[string]interface{})
+	filterMap := ctl.reportFilter()
+	if lines, err := md.GetStockConsignmentStatement(reportFilterInt64(filterMap, "Owner"), reportFilterDate(filterMap, "DateStart", false), reportFilterDate(filterMap, "DateEnd", true)); err == nil {
+		var unsettledTotal float64
+		tableLines := make([]interface{}, 0, 4)
+		for _, line := range lines {
+			unsettledTotal += line.UnsettledAmount
+			oneLine := make(map[string]interface{})
+			owner := make(map[string]interface{})
+			owner["id"] = line.Owner.ID
+			owner["name"] = line.Owner.Name
+			oneLine["Owner"] = owner
+			product := make(map[string]interface{})
+			product["id"] = line.Product.ID
+			product["name"] = line.Product.Name
+			oneLine["Product"] = product
+			oneLine["Received"] = line.Received
+			oneLine["Sold"] = line.Sold
+			oneLine["Returned"] = line.Returned
+			oneLine["OnHand"] = line.OnHand
+			oneLine["SaleAmount"] = line.SaleAmount
+			oneLine["SettledAmount"] = line.SettledAmount
+			oneLine["UnsettledAmount"] = line.UnsettledAmount
+			tableLines = append(tableLines, oneLine)
+		}
+		result["data"] = tableLines
+		result["total"] = len(tableLines)
+		result["unsettledTotal"] = unsettledTotal
+	} else {
+		result["code"] = "failed"
+		result["message"] = "代售对账单计算失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// Export 报表导出为csv文件，report为ledger、trace、consignment或valuation，过滤条件为url参数
+func (ctl *StockReportController) Export() {
+	filterMap := ctl.reportFilter()
+	report := ctl.GetString("report")
+	var (
+		records [][]string
+		err     error
+	)
+	formatFloat := func(value float64) string {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	moveLocation := func(location *md.StockLocation) string {
+		if location != nil {
+			return location.Name
+		}
+		return ""
+	}
+	movePicking := func(move *md.StockMove) string {
+		if move.Picking != nil {
+			return move.Picking.Name
+		}
+		return ""
+	}
+	moveLot := func(move *md.StockMove) string {
+		if move.Lot != nil {
+			return move.Lot.Name
+		}
+		return ""
+	}
+	switch report {
+	case "ledger":
+		var ledger *md.StockLedger
+		if ledger, err = md.GetStockLedger(reportFilterInt64(filterMap, "Product"), reportFilterInt64(filterMap, "Location"), reportFilterDate(filterMap, "DateStart", false), reportFilterDate(filterMap, "DateEnd", true)); err == nil {
+			records = append(records, []string{"日期", "移动", "源单据", "调拨单", "批次", "源库位", "目标库位", "第一单位入库", "第二单位入库", "第一单位出库", "第二单位出库", "第一单位结存", "第二单位结存"})
+			records = append(records, []string{"", "期初结存", "", "", "", "", "", "", "", "", "", formatFloat(ledger.FirstOpening), formatFloat(ledger.SecondOpening)})
+			for _, line := range ledger.Lines {
+				move := line.Move
+				records = append(records, []string{move.Date.Format("2006-01-02 15:04:05"), move.Name, move.Origin, movePicking(move), moveLot(move), moveLocation(move.LocationSrc), moveLocation(move.LocationDest),
+					formatFloat(line.FirstIn), formatFloat(line.SecondIn), formatFloat(line.FirstOut), formatFloat(line.SecondOut), formatFloat(line.FirstBalance), formatFloat(line.SecondBalance)})
+			}
+			records = append(records, []string{"", "期末结存", "", "", "", "", "", "", "", "", "", formatFloat(ledger.FirstClosing), formatFloat(ledger.SecondClosing)})
+		}
+	case "trace":
+		var lines []*md.StockTraceLine
+		if lines, err = stockTrace(filterMap); err == nil {
+			directions := map[string]string{"upstream": "上游", "downstream": "下游", "internal": "内部"}
+			records = append(records, []string{"方向", "日期", "移动", "源单据", "调拨单", "批次", "份", "源库位", "目标库位", "第一单位数量", "第二单位数量"})
+			for _, line := range lines {
+				move := line.Move
+				quant := ""
+				if line.Quant != nil {
+					quant = strconv.FormatInt(line.Quant.ID, 10)
+				}
+				records = append(records, []string{directions[line.Direction], move.Date.Format("2006-01-02 15:04:05"), move.Name, move.Origin, movePicking(move), moveLot(move), quant, moveLocation(move.LocationSrc), moveLocation(move.LocationDest),
+					formatFloat(move.FirstUomQty), formatFloat(move.SecondUomQty)})
+			}
+		}
+	case "consignment":
+		var lines []*md.StockConsignmentStatementLine
+		if lines, err = md.GetStockConsignmentStatement(reportFilterInt64(filterMap, "Owner"), reportFilterDate(filterMap, "DateStart", false), reportFilterDate(filterMap, "DateEnd", true)); err == nil {
+			records = append(records, []string{"货主", "产品规格", "接收数量", "销售数量", "退回数量", "在库数量", "销售金额", "已结算金额", "未结算金额"})
+			for _, line := range lines {
+				records = append(records, []string{line.Owner.Name, line.Product.Name, formatFloat(line.Received), formatFloat(line.Sold), formatFloat(line.Returned), formatFloat(line.OnHand),
+					formatFloat(line.SaleAmount), formatFloat(line.SettledAmount), formatFloat(line.UnsettledAmount)})
+			}
+		}
+	default:
+		report = "valuation"
+		var lines []*md.StockValuationLine
+		if lines, err = md.GetStockValuation(reportFilterDate(filterMap, "Date", true), reportFilterInt64(filterMap, "Location"), reportFilterInt64(filterMap, "Category")); err == nil {
+			records = append(records, []string{"库位", "产品规格", "产品类别", "成本方法", "第一单位数量", "第二单位数量", "单位成本", "库存价值"})
+			for _, line := range lines {
+				category := ""
+				if line.Category != nil {
+					category = line.Category.Name
+				}
+				records = append(records, []string{line.Location.Name, line.Product.Name, category, line.CostMethod, formatFloat(line.FirstUomQty), formatFloat(line.SecondUomQty), formatFloat(line.UnitCost), formatFloat(line.Value)})
+			}
+		}
+	}
+	if err != nil {
+		ctl.Ctx.Output.SetStatus(400)
+		ctl.Ctx.Output.Body([]byte(err.Error()))
+		return
+	}
+	b := bytes.Buffer{}
+	// 写入BOM,Excel打开时按utf-8识别中文
+	b.WriteString("\xEF\xBB\xBF")
+	writer := csv.NewWriter(&b)
+	writer.WriteAll(records)
+	ctl.Ctx.Output.Header("Content-Type", "text/csv; charset=utf-8")
+	ctl.Ctx.Output.Header("Content-Disposition", fmt.Sprintf("attachment; filename=stock_%s_%s.csv", report, time.Now().Format("20060102150405")))
+	ctl.Ctx.Output.Body(b.Bytes())
+}
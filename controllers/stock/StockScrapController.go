@@ -0,0 +1,284 @@
+package stock
+
+import (
+	"bytes"
+	"encoding/json"
+	"goERP/controllers/base"
+	md "goERP/models"
+	"strconv"
+	"strings"
+)
+
+// StockScrapController 报废单
+type StockScrapController struct {
+	base.BaseController
+}
+
+// Post post请求
+func (ctl *StockScrapController) Post() {
+	ctl.URL = "/stock/scrap/"
+	action := ctl.Input().Get("action")
+	switch action {
+	case "table": //bootstrap table的post请求
+		ctl.PostList()
+	case "create":
+		ctl.PostCreate()
+	case "done":
+		ctl.PostDone()
+	default:
+		ctl.PostList()
+	}
+}
+
+// Put 报废单put请求，修改报废单
+func (ctl *StockScrapController) Put() {
+	id := ctl.Ctx.Input.Param(":id")
+	ctl.URL = "/stock/scrap/"
+	if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
+		if scrap, err := md.GetStockScrapByID(idInt64); err == nil {
+			if err := ctl.ParseForm(&scrap); err == nil {
+
+				if err := md.UpdateStockScrapByID(scrap); err == nil {
+					ctl.Redirect(ctl.URL+id+"?action=detail", 302)
+				}
+			}
+		}
+	}
+	ctl.Redirect(ctl.URL+id+"?action=edit", 302)
+
+}
+
+// Get 报废单get请求
+func (ctl *StockScrapController) Get() {
+	ctl.PageName = "报废单"
+	action := ctl.Input().Get("action")
+	switch action {
+	case "create":
+		ctl.Create()
+	case "edit":
+		ctl.Edit()
+	case "detail":
+		ctl.Detail()
+	default:
+		ctl.GetList()
+
+	}
+	// 标题合成
+	b := bytes.Buffer{}
+	b.WriteString(ctl.PageName)
+	b.WriteString("\\")
+	b.WriteString(ctl.PageAction)
+	ctl.Data["PageName"] = b.String()
+	ctl.URL = "/stock/scrap/"
+	ctl.Data["URL"] = ctl.URL
+
+	ctl.Data["MenuStockScrapActive"] = "active"
+}
+
+// Edit 报废单编辑get请求
+func (ctl *StockScrapController) Edit() {
+	id := ctl.Ctx.Input.Param(":id")
+	if id != "" {
+		if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
+			if scrap, err := md.GetStockScrapByID(idInt64); err == nil {
+				ctl.PageAction = scrap.Name
+				ctl.Data["Scrap"] = scrap
+			}
+		}
+	}
+	ctl.Data["FormField"] = "form-edit"
+	ctl.Data["Action"] = "edit"
+	ctl.Data["RecordID"] = id
+	ctl.Layout = "base/base.html"
+	ctl.TplName = "stock/stock_scrap_form.html"
+}
+
+// Create 报废单创建get请求页面
+func (ctl *StockScrapController) Create() {
+	ctl.Data["Action"] = "create"
+	ctl.Data["Readonly"] = false
+	ctl.Data["FormField"] = "form-create"
+	ctl.PageAction = "创建"
+	ctl.Layout = "base/base.html"
+	ctl.TplName = "stock/stock_scrap_form.html"
+}
+
+// Detail 报废单信息显示get请求，信息不可修改
+func (ctl *StockScrapController) Detail() {
+	//获取信息一样，直接调用Edit
+	ctl.Edit()
+	ctl.Data["Readonly"] = true
+	ctl.Data["Action"] = "detail"
+}
+
+// PostDone 确认报废，生成报废移动
+func (ctl *StockScrapController) PostDone() {
+	result := make(map[string]interface{})
+	id := ctl.Ctx.Input.Param(":id")
+	if idInt64, err := strconv.ParseInt(id, 10, 64); err == nil {
+		if err = md.DoneStockScrap(idInt64, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = ctl.URL + id + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "报废失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// PostCreate 报废单post请求创建新报废单
+func (ctl *StockScrapController) PostCreate() {
+	result := make(map[string]interface{})
+	postData := ctl.GetString("postData")
+	scrap := new(md.StockScrap)
+	var (
+		err error
+		id  int64
+	)
+	if err = json.Unmarshal([]byte(postData), scrap); err == nil {
+		if id, err = md.AddStockScrap(scrap, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = ctl.URL + strconv.FormatInt(id, 10) + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "数据创建失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// 获得符合要求的数据
+func (ctl *StockScrapController) stockScrapList(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (map[string]interface{}, error) {
+
+	var arrs []md.StockScrap
+	paginator, arrs, err := md.GetAllStockScrap(query, exclude, condMap, fields, sortby, order, offset, limit)
+	result := make(map[string]interface{})
+	if err == nil {
+
+		tableLines := make([]interface{}, 0, 4)
+		for _, line := range arrs {
+			oneLine := make(map[string]interface{})
+			oneLine["Name"] = line.Name
+			oneLine["Origin"] = line.Origin
+			oneLine["Reason"] = line.Reason
+			oneLine["State"] = line.State
+			oneLine["FirstUomQty"] = line.FirstUomQty
+			oneLine["SecondUomQty"] = line.SecondUomQty
+			oneLine["ID"] = line.ID
+			oneLine["id"] = line.ID
+			if !line.DateDone.IsZero() {
+				oneLine["DateDone"] = line.DateDone.Format("2006-01-02 15:04:05")
+			}
+			if line.Product != nil {
+				product := make(map[string]interface{})
+				product["id"] = line.Product.ID
+				product["name"] = line.Product.Name
+				oneLine["Product"] = product
+			}
+			if line.Lot != nil {
+				oneLine["Lot"] = line.Lot.Name
+			}
+			if line.LocationSrc != nil {
+				location := make(map[string]interface{})
+				location["id"] = line.LocationSrc.ID
+				location["name"] = line.LocationSrc.Name
+				oneLine["LocationSrc"] = location
+			}
+			if line.ScrapLocation != nil {
+				location := make(map[string]interface{})
+				location["id"] = line.ScrapLocation.ID
+				location["name"] = line.ScrapLocation.Name
+				oneLine["ScrapLocation"] = location
+			}
+			if line.DoneUser != nil {
+				oneLine["DoneUser"] = line.DoneUser.NameZh
+			}
+			tableLines = append(tableLines, oneLine)
+		}
+		result["data"] = tableLines
+		if jsonResult, er := json.Marshal(&paginator); er == nil {
+			result["paginator"] = string(jsonResult)
+			result["total"] = paginator.TotalCount
+		}
+	}
+	return result, err
+}
+
+// PostList 报废单post请求，用于获得多条报废单
+func (ctl *StockScrapController) PostList() {
+	query := make(map[string]interface{})
+	exclude := make(map[string]interface{})
+	fields := make([]string, 0, 0)
+	sortby := make([]string, 0, 1)
+	order := make([]string, 0, 1)
+	cond := make(map[string]map[string]interface{})
+	condAnd := make(map[string]interface{})
+	condOr := make(map[string]interface{})
+	excludeIdsStr := ctl.GetStrings("exclude[]")
+	var excludeIds []int64
+	for _, v := range excludeIdsStr {
+		if val, err := strconv.ParseInt(v, 10, 64); err == nil {
+			excludeIds = append(excludeIds, val)
+		}
+	}
+	if len(excludeIds) > 0 {
+		exclude["Id.in"] = excludeIds
+	}
+	if name := strings.TrimSpace(ctl.GetString("Name")); name != "" {
+		condOr["Name.icontains"] = name
+		condOr["Origin.icontains"] = name
+	}
+	if productID, err := ctl.GetInt64("ProductID"); err == nil {
+		condAnd["Product.Id"] = productID
+	}
+	if state := ctl.GetString("State"); state != "" {
+		condAnd["State"] = state
+	}
+	offset, _ := ctl.GetInt64("offset")
+	limit, _ := ctl.GetInt64("limit")
+	orderStr := ctl.GetString("order")
+	sortStr := ctl.GetString("sort")
+	if orderStr != "" && sortStr != "" {
+		sortby = append(sortby, sortStr)
+		order = append(order, orderStr)
+	} else {
+		sortby = append(sortby, "Id")
+		order = append(order, "desc")
+	}
+	if len(condAnd) > 0 {
+		cond["and"] = condAnd
+	}
+	if len(condOr) > 0 {
+		cond["or"] = condOr
+	}
+	if result, err := ctl.stockScrapList(query, exclude, cond, fields, sortby, order, offset, limit); err == nil {
+		ctl.Data["json"] = result
+	}
+	ctl.ServeJSON()
+
+}
+
+// GetList 报废单get请求，列出报废单
+func (ctl *StockScrapController) GetList() {
+	viewType := ctl.Input().Get("view")
+	if viewType == "" || viewType == "table" {
+		ctl.Data["ViewType"] = "table"
+	}
+	ctl.PageAction = "列表"
+	ctl.Data["tableId"] = "table-stock-scrap"
+	ctl.Layout = "base/base_list_view.html"
+	ctl.TplName = "stock/stock_scrap_list_search.html"
+}
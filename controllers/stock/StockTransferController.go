@@ -0,0 +1,302 @@
+package stock
+
+import (
+	"bytes"
+	"encoding/json"
+	"goERP/controllers/base"
+	md "goERP/models"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// StockTransferController 仓库间调拨单
+type StockTransferController struct {
+	base.BaseController
+}
+
+// Post post请求
+func (ctl *StockTransferController) Post() {
+	ctl.URL = "/stock/transfer/"
+	action := ctl.Input().Get("action")
+	switch action {
+	case "table": //bootstrap table的post请求
+		ctl.PostList()
+	case "create":
+		ctl.PostCreate()
+	case "confirm":
+		ctl.PostConfirm()
+	case "line":
+		ctl.PostLine()
+	case "deleteLine":
+		ctl.PostDeleteLine()
+	default:
+		ctl.PostList()
+	}
+}
+
+// Put 调拨单put请求，修改调拨单
+func (ctl *StockTransferController) Put() {
+	id := ctl.Ctx.Input.Param(":id")
+	ctl.URL = "/stock/transfer/"
+	if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
+		if transfer, err := md.GetStockTransferByID(idInt64); err == nil {
+			if err := ctl.ParseForm(&transfer); err == nil {
+
+				if err := md.UpdateStockTransferByID(transfer); err == nil {
+					ctl.Redirect(ctl.URL+id+"?action=detail", 302)
+				}
+			}
+		}
+	}
+	ctl.Redirect(ctl.URL+id+"?action=edit", 302)
+
+}
+
+// Get 调拨单get请求
+func (ctl *StockTransferController) Get() {
+	ctl.PageName = "仓库调拨"
+	action := ctl.Input().Get("action")
+	switch action {
+	case "create":
+		ctl.Create()
+	case "edit":
+		ctl.Edit()
+	case "detail":
+		ctl.Detail()
+	default:
+		ctl.GetList()
+
+	}
+	// 标题合成
+	b := bytes.Buffer{}
+	b.WriteString(ctl.PageName)
+	b.WriteString("\\")
+	b.WriteString(ctl.PageAction)
+	ctl.Data["PageName"] = b.String()
+	ctl.URL = "/stock/transfer/"
+	ctl.Data["URL"] = ctl.URL
+
+	ctl.Data["MenuStockTransferActive"] = "active"
+}
+
+// Edit 调拨单编辑get请求
+func (ctl *StockTransferController) Edit() {
+	id := ctl.Ctx.Input.Param(":id")
+	if id != "" {
+		if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
+			if transfer, err := md.GetStockTransferByID(idInt64); err == nil {
+				ctl.PageAction = transfer.Name
+				ctl.Data["Transfer"] = transfer
+				if transfer.SaleOrder != nil || transfer.PurchaseOrder != nil {
+					ctl.Data["InterCompany"] = true
+				}
+			}
+		}
+	}
+	ctl.Data["TransferError"] = ctl.GetString("transferError")
+	ctl.Data["FormField"] = "form-edit"
+	ctl.Data["Action"] = "edit"
+	ctl.Data["RecordID"] = id
+	ctl.Layout = "base/base.html"
+	ctl.TplName = "stock/stock_transfer_form.html"
+}
+
+// Create 调拨单创建get请求页面
+func (ctl *StockTransferController) Create() {
+	ctl.Data["Action"] = "create"
+	ctl.Data["Readonly"] = false
+	ctl.Data["FormField"] = "form-create"
+	ctl.PageAction = "创建"
+	ctl.Layout = "base/base.html"
+	ctl.TplName = "stock/stock_transfer_form.html"
+}
+
+// Detail 调拨单信息显示get请求，信息不可修改
+func (ctl *StockTransferController) Detail() {
+	//获取信息一样，直接调用Edit
+	ctl.Edit()
+	ctl.Data["Readonly"] = true
+	ctl.Data["Action"] = "detail"
+}
+
+// redirectDetail 处理完成后跳转到调拨单详情，出错时在详情页显示错误
+func (ctl *StockTransferController) redirectDetail(id string, err error) {
+	location := ctl.URL + id + "?action=detail"
+	if err != nil {
+		location += "&transferError=" + url.QueryEscape(err.Error())
+	}
+	ctl.Redirect(location, 302)
+}
+
+// PostConfirm 确认调拨单，生成出库单和入库单
+func (ctl *StockTransferController) PostConfirm() {
+	id := ctl.Ctx.Input.Param(":id")
+	idInt64, err := strconv.ParseInt(id, 10, 64)
+	if err == nil {
+		err = md.ConfirmStockTransfer(idInt64, &ctl.User)
+	}
+	ctl.redirectDetail(id, err)
+}
+
+// PostLine 为草稿调拨单添加调拨明细
+func (ctl *StockTransferController) PostLine() {
+	id := ctl.Ctx.Input.Param(":id")
+	idInt64, err := strconv.ParseInt(id, 10, 64)
+	if err == nil {
+		line := &md.StockTransferLine{TransferID: idInt64}
+		line.ProductID, _ = ctl.GetInt64("Product")
+		line.LotID, _ = ctl.GetInt64("Lot")
+		line.FirstUomQty, _ = ctl.GetFloat("FirstUomQty")
+		line.SecondUomQty, _ = ctl.GetFloat("SecondUomQty")
+		line.PriceUnit, _ = ctl.GetFloat("PriceUnit")
+		_, err = md.AddStockTransferLine(line, &ctl.User)
+	}
+	ctl.redirectDetail(id, err)
+}
+
+// PostDeleteLine 删除草稿调拨单的调拨明细
+func (ctl *StockTransferController) PostDeleteLine() {
+	id := ctl.Ctx.Input.Param(":id")
+	lineID, err := ctl.GetInt64("LineID")
+	if err == nil {
+		err = md.DeleteStockTransferLine(lineID)
+	}
+	ctl.redirectDetail(id, err)
+}
+
+// PostCreate 调拨单post请求创建新调拨单
+func (ctl *StockTransferController) PostCreate() {
+	result := make(map[string]interface{})
+	postData := ctl.GetString("postData")
+	transfer := new(md.StockTransfer)
+	var (
+		err error
+		id  int64
+	)
+	if err = json.Unmarshal([]byte(postData), transfer); err == nil {
+		if id, err = md.AddStockTransfer(transfer, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = ctl.URL + strconv.FormatInt(id, 10) + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "数据创建失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// 获得符合要求的数据
+func (ctl *StockTransferController) stockTransferList(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (map[string]interface{}, error) {
+
+	var arrs []md.StockTransfer
+	paginator, arrs, err := md.GetAllStockTransfer(query, exclude, condMap, fields, sortby, order, offset, limit)
+	result := make(map[string]interface{})
+	if err == nil {
+
+		tableLines := make([]interface{}, 0, 4)
+		for _, line := range arrs {
+			oneLine := make(map[string]interface{})
+			oneLine["Name"] = line.Name
+			oneLine["Origin"] = line.Origin
+			oneLine["State"] = line.State
+			oneLine["ID"] = line.ID
+			oneLine["id"] = line.ID
+			oneLine["CreateDate"] = line.CreateDate.Format("2006-01-02 15:04:05")
+			if line.WareHouseSrc != nil {
+				warehouse := make(map[string]interface{})
+				warehouse["id"] = line.WareHouseSrc.ID
+				warehouse["name"] = line.WareHouseSrc.Name
+				oneLine["WareHouseSrc"] = warehouse
+			}
+			if line.WareHouseDest != nil {
+				warehouse := make(map[string]interface{})
+				warehouse["id"] = line.WareHouseDest.ID
+				warehouse["name"] = line.WareHouseDest.Name
+				oneLine["WareHouseDest"] = warehouse
+			}
+			if line.PickingOut != nil {
+				if picking, err := md.GetStockPickingByID(line.PickingOut.ID); err == nil {
+					oneLine["PickingOut"] = map[string]interface{}{"id": picking.ID, "name": picking.Name, "state": picking.State}
+				}
+			}
+			if line.PickingIn != nil {
+				if picking, err := md.GetStockPickingByID(line.PickingIn.ID); err == nil {
+					oneLine["PickingIn"] = map[string]interface{}{"id": picking.ID, "name": picking.Name, "state": picking.State}
+				}
+			}
+			tableLines = append(tableLines, oneLine)
+		}
+		result["data"] = tableLines
+		if jsonResult, er := json.Marshal(&paginator); er == nil {
+			result["paginator"] = string(jsonResult)
+			result["total"] = paginator.TotalCount
+		}
+	}
+	return result, err
+}
+
+// PostList 调拨单post请求，用于获得多条调拨单
+func (ctl *StockTransferController) PostList() {
+	query := make(map[string]interface{})
+	exclude := make(map[string]interface{})
+	fields := make([]string, 0, 0)
+	sortby := make([]string, 0, 1)
+	order := make([]string, 0, 1)
+	cond := make(map[string]map[string]interface{})
+	condAnd := make(map[string]interface{})
+	condOr := make(map[string]interface{})
+	if name := strings.TrimSpace(ctl.GetString("Name")); name != "" {
+		condOr["Name.icontains"] = name
+		condOr["Origin.icontains"] = name
+	}
+	if warehouseID, err := ctl.GetInt64("WareHouseSrc"); err == nil && warehouseID > 0 {
+		condAnd["WareHouseSrc.Id"] = warehouseID
+	}
+	if warehouseID, err := ctl.GetInt64("WareHouseDest"); err == nil && warehouseID > 0 {
+		condAnd["WareHouseDest.Id"] = warehouseID
+	}
+	if state := ctl.GetString("State"); state != "" {
+		condAnd["State"] = state
+	}
+	offset, _ := ctl.GetInt64("offset")
+	limit, _ := ctl.GetInt64("limit")
+	orderStr := ctl.GetString("order")
+	sortStr := ctl.GetString("sort")
+	if orderStr != "" && sortStr != "" {
+		sortby = append(sortby, sortStr)
+		order = append(order, orderStr)
+	} else {
+		sortby = append(sortby, "Id")
+		order = append(order, "desc")
+	}
+	if len(condAnd) > 0 {
+		cond["and"] = condAnd
+	}
+	if len(condOr) > 0 {
+		cond["or"] = condOr
+	}
+	if result, err := ctl.stockTransferList(query, exclude, cond, fields, sortby, order, offset, limit); err == nil {
+		ctl.Data["json"] = result
+	}
+	ctl.ServeJSON()
+
+}
+
+// GetList 调拨单get请求，列出调拨单
+func (ctl *StockTransferController) GetList() {
+	viewType := ctl.Input().Get("view")
+	if viewType == "" || viewType == "table" {
+		ctl.Data["ViewType"] = "table"
+	}
+	ctl.PageAction = "列表"
+	ctl.Data["tableId"] = "table-stock-transfer"
+	ctl.Layout = "base/base_list_view.html"
+	ctl.TplName = "stock/stock_transfer_list_search.html"
+}
@@ -3,6 +3,7 @@ package stock
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"goERP/controllers/base"
 	md "goERP/models"
 	"strconv"
@@ -24,6 +25,8 @@ func (ctl *StockWarehouseController) Post() {
 		ctl.PostList()
 	case "create":
 		ctl.PostCreate()
+	case "map":
+		ctl.PostMap()
 	default:
 		ctl.PostList()
 	}
@@ -58,6 +61,8 @@ func (ctl *StockWarehouseController) Get() {
 		ctl.Edit()
 	case "detail":
 		ctl.Detail()
+	case "map":
+		ctl.Map()
 	default:
 		ctl.GetList()
 
@@ -93,6 +98,102 @@ func (ctl *StockWarehouseController) Edit() {
 	ctl.TplName = "stock/stock_warehouse_form.html"
 }
 
+// warehouseMap 获得仓库库存库位的平面图
+func (ctl *StockWarehouseController) warehouseMap() (warehouse *md.StockWarehouse, locationMap *md.StockLocationMap, err error) {
+	var id int64
+	if id, err = strconv.ParseInt(ctl.Ctx.Input.Param(":id"), 10, 64); err != nil {
+		return nil, nil, err
+	}
+	if warehouse, err = md.GetStockWarehouseByID(id); err != nil {
+		return nil, nil, err
+	}
+	if warehouse.Location == nil {
+		return warehouse, nil, fmt.Errorf("仓库[%s]没有设置库存库位", warehouse.Name)
+	}
+	locationMap, err = md.GetStockLocationMap(warehouse.Location.ID)
+	return warehouse, locationMap, err
+}
+
+// Map 仓库平面图get请求，按通道、货架、层显示货位及其库存
+func (ctl *StockWarehouseController) Map() {
+	warehouse, locationMap, err := ctl.warehouseMap()
+	if warehouse != nil {
+		ctl.PageAction = warehouse.Name + "平面图"
+		ctl.Data["Warehouse"] = warehouse
+	}
+	if err == nil {
+		ctl.Data["LocationMap"] = locationMap
+	} else {
+		ctl.Data["MapError"] = err.Error()
+	}
+	ctl.Data["RecordID"] = ctl.Ctx.Input.Param(":id")
+	ctl.Layout = "base/base.html"
+	ctl.TplName = "stock/stock_warehouse_map.html"
+}
+
+// binResult 货位的显示字段
+func binResult(bin *md.StockBin) map[string]interface{} {
+	oneBin := make(map[string]interface{})
+	oneBin["id"] = bin.Location.ID
+	oneBin["name"] = bin.Location.Name
+	oneBin["barcode"] = bin.Location.Barcode
+	oneBin["posx"] = bin.Location.Posx
+	oneBin["posy"] = bin.Location.Posy
+	oneBin["posz"] = bin.Location.Posz
+	oneBin["occupied"] = bin.Occupied()
+	oneBin["FirstUomQty"] = bin.FirstUomQty
+	oneBin["SecondUomQty"] = bin.SecondUomQty
+	contents := make([]interface{}, 0, len(bin.Contents))
+	for _, content := range bin.Contents {
+		oneContent := make(map[string]interface{})
+		oneContent["productId"] = content.Product.ID
+		oneContent["product"] = content.Product.Name
+		if content.Lot != nil {
+			oneContent["lot"] = content.Lot.Name
+		}
+		oneContent["FirstUomQty"] = content.FirstUomQty
+		oneContent["SecondUomQty"] = content.SecondUomQty
+		contents = append(contents, oneContent)
+	}
+	oneBin["contents"] = contents
+	return oneBin
+}
+
+// PostMap 仓库平面图post请求，返回按通道、货架排列的货位、库存及占用率
+func (ctl *StockWarehouseController) PostMap() {
+	result := make(map[string]interface{})
+	if _, locationMap, err := ctl.warehouseMap(); err == nil {
+		aisles := make([]interface{}, 0, len(locationMap.Aisles))
+		for _, aisle := range locationMap.Aisles {
+			shelves := make([]interface{}, 0, len(aisle.Shelves))
+			for _, shelf := range aisle.Shelves {
+				bins := make([]interface{}, 0, len(shelf.Bins))
+				for _, bin := range shelf.Bins {
+					bins = append(bins, binResult(bin))
+				}
+				shelves = append(shelves, map[string]interface{}{"posy": shelf.Posy, "bins": bins})
+			}
+			aisles = append(aisles, map[string]interface{}{"posx": aisle.Posx, "shelves": shelves})
+		}
+		unplaced := make([]interface{}, 0, len(locationMap.Unplaced))
+		for _, bin := range locationMap.Unplaced {
+			unplaced = append(unplaced, binResult(bin))
+		}
+		result["code"] = "success"
+		result["aisles"] = aisles
+		result["unplaced"] = unplaced
+		result["binCount"] = locationMap.BinCount
+		result["occupiedCount"] = locationMap.OccupiedCount
+		result["occupancy"] = locationMap.Occupancy
+	} else {
+		result["code"] = "failed"
+		result["message"] = "获取仓库平面图失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
 // Create 产品属性创建get请求页面
 func (ctl *StockWarehouseController) Create() {
 	ctl.Data["Action"] = "create"
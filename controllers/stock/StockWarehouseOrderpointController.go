@@ -0,0 +1,267 @@
+package stock
+
+import (
+	"bytes"
+	"encoding/json"
+	"goERP/controllers/base"
+	md "goERP/models"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// StockWarehouseOrderpointController 补货规则
+type StockWarehouseOrderpointController struct {
+	base.BaseController
+}
+
+// Post post请求
+func (ctl *StockWarehouseOrderpointController) Post() {
+	ctl.URL = "/stock/orderpoint/"
+	action := ctl.Input().Get("action")
+	switch action {
+	case "table": //bootstrap table的post请求
+		ctl.PostList()
+	case "create":
+		ctl.PostCreate()
+	case "replenish":
+		ctl.PostReplenish()
+	default:
+		ctl.PostList()
+	}
+}
+
+// Put 补货规则put请求，修改补货规则
+func (ctl *StockWarehouseOrderpointController) Put() {
+	id := ctl.Ctx.Input.Param(":id")
+	ctl.URL = "/stock/orderpoint/"
+	if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
+		if point, err := md.GetStockWarehouseOrderpointByID(idInt64); err == nil {
+			if err := ctl.ParseForm(&point); err == nil {
+
+				if err := md.UpdateStockWarehouseOrderpointByID(point); err == nil {
+					ctl.Redirect(ctl.URL+id+"?action=detail", 302)
+				}
+			}
+		}
+	}
+	ctl.Redirect(ctl.URL+id+"?action=edit", 302)
+
+}
+
+// Get 补货规则get请求
+func (ctl *StockWarehouseOrderpointController) Get() {
+	ctl.PageName = "补货规则"
+	action := ctl.Input().Get("action")
+	switch action {
+	case "create":
+		ctl.Create()
+	case "edit":
+		ctl.Edit()
+	case "detail":
+		ctl.Detail()
+	default:
+		ctl.GetList()
+
+	}
+	// 标题合成
+	b := bytes.Buffer{}
+	b.WriteString(ctl.PageName)
+	b.WriteString("\\")
+	b.WriteString(ctl.PageAction)
+	ctl.Data["PageName"] = b.String()
+	ctl.URL = "/stock/orderpoint/"
+	ctl.Data["URL"] = ctl.URL
+
+	ctl.Data["MenuStockWarehouseOrderpointActive"] = "active"
+}
+
+// Edit 补货规则编辑get请求
+func (ctl *StockWarehouseOrderpointController) Edit() {
+	id := ctl.Ctx.Input.Param(":id")
+	if id != "" {
+		if idInt64, e := strconv.ParseInt(id, 10, 64); e == nil {
+			if point, err := md.GetStockWarehouseOrderpointByID(idInt64); err == nil {
+				ctl.PageAction = point.Name
+				ctl.Data["Orderpoint"] = point
+			}
+		}
+	}
+	ctl.Data["FormField"] = "form-edit"
+	ctl.Data["Action"] = "edit"
+	ctl.Data["RecordID"] = id
+	ctl.Layout = "base/base.html"
+	ctl.TplName = "stock/stock_warehouse_orderpoint_form.html"
+}
+
+// Create 补货规则创建get请求页面
+func (ctl *StockWarehouseOrderpointController) Create() {
+	ctl.Data["Action"] = "create"
+	ctl.Data["Readonly"] = false
+	ctl.Data["FormField"] = "form-create"
+	ctl.PageAction = "创建"
+	ctl.Layout = "base/base.html"
+	ctl.TplName = "stock/stock_warehouse_orderpoint_form.html"
+}
+
+// Detail 补货规则信息显示get请求，信息不可修改
+func (ctl *StockWarehouseOrderpointController) Detail() {
+	//获取信息一样，直接调用Edit
+	ctl.Edit()
+	ctl.Data["Readonly"] = true
+	ctl.Data["Action"] = "detail"
+}
+
+// PostReplenish 立即执行补货，完成后跳转到采购订单列表
+func (ctl *StockWarehouseOrderpointController) PostReplenish() {
+	if _, err := md.RunStockReplenishment(&ctl.User); err != nil {
+		ctl.Redirect(ctl.URL+"?replenishError="+url.QueryEscape(err.Error()), 302)
+		return
+	}
+	ctl.Redirect("/purchase/order/", 302)
+}
+
+// PostCreate 补货规则post请求创建新规则
+func (ctl *StockWarehouseOrderpointController) PostCreate() {
+	result := make(map[string]interface{})
+	postData := ctl.GetString("postData")
+	point := new(md.StockWarehouseOrderpoint)
+	var (
+		err error
+		id  int64
+	)
+	if err = json.Unmarshal([]byte(postData), point); err == nil {
+		if id, err = md.AddStockWarehouseOrderpoint(point, &ctl.User); err == nil {
+			result["code"] = "success"
+			result["location"] = ctl.URL + strconv.FormatInt(id, 10) + "?action=detail"
+		} else {
+			result["code"] = "failed"
+			result["message"] = "数据创建失败"
+			result["debug"] = err.Error()
+		}
+	} else {
+		result["code"] = "failed"
+		result["message"] = "请求数据解析失败"
+		result["debug"] = err.Error()
+	}
+	ctl.Data["json"] = result
+	ctl.ServeJSON()
+}
+
+// 获得符合要求的数据
+func (ctl *StockWarehouseOrderpointController) stockWarehouseOrderpointList(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (map[string]interface{}, error) {
+
+	var arrs []md.StockWarehouseOrderpoint
+	paginator, arrs, err := md.GetAllStockWarehouseOrderpoint(query, exclude, condMap, fields, sortby, order, offset, limit)
+	result := make(map[string]interface{})
+	if err == nil {
+
+		tableLines := make([]interface{}, 0, 4)
+		for _, line := range arrs {
+			oneLine := make(map[string]interface{})
+			oneLine["Name"] = line.Name
+			oneLine["Active"] = line.Active
+			oneLine["FirstMinQty"] = line.FirstMinQty
+			oneLine["FirstMaxQty"] = line.FirstMaxQty
+			oneLine["QtyMultiple"] = line.QtyMultiple
+			oneLine["ID"] = line.ID
+			oneLine["id"] = line.ID
+			if !line.LastRun.IsZero() {
+				oneLine["LastRun"] = line.LastRun.Format("2006-01-02 15:04:05")
+			}
+			if line.WareHouse != nil {
+				warehouse := make(map[string]interface{})
+				warehouse["id"] = line.WareHouse.ID
+				warehouse["name"] = line.WareHouse.Name
+				oneLine["WareHouse"] = warehouse
+			}
+			if line.Location != nil {
+				location := make(map[string]interface{})
+				location["id"] = line.Location.ID
+				location["name"] = line.Location.Name
+				oneLine["Location"] = location
+			}
+			if line.Product != nil {
+				product := make(map[string]interface{})
+				product["id"] = line.Product.ID
+				product["name"] = line.Product.Name
+				oneLine["Product"] = product
+			}
+			if line.Company != nil {
+				company := make(map[string]interface{})
+				company["id"] = line.Company.ID
+				company["name"] = line.Company.Name
+				oneLine["Company"] = company
+			}
+			tableLines = append(tableLines, oneLine)
+		}
+		result["data"] = tableLines
+		if jsonResult, er := json.Marshal(&paginator); er == nil {
+			result["paginator"] = string(jsonResult)
+			result["total"] = paginator.TotalCount
+		}
+	}
+	return result, err
+}
+
+// PostList 补货规则post请求，用于获得多条补货规则
+func (ctl *StockWarehouseOrderpointController) PostList() {
+	query := make(map[string]interface{})
+	exclude := make(map[string]interface{})
+	fields := make([]string, 0, 0)
+	sortby := make([]string, 0, 1)
+	order := make([]string, 0, 1)
+	cond := make(map[string]map[string]interface{})
+	condAnd := make(map[string]interface{})
+	excludeIdsStr := ctl.GetStrings("exclude[]")
+	var excludeIds []int64
+	for _, v := range excludeIdsStr {
+		if val, err := strconv.ParseInt(v, 10, 64); err == nil {
+			excludeIds = append(excludeIds, val)
+		}
+	}
+	if len(excludeIds) > 0 {
+		exclude["Id.in"] = excludeIds
+	}
+	if name := strings.TrimSpace(ctl.GetString("Name")); name != "" {
+		condAnd["Name.icontains"] = name
+	}
+	if warehouseID, err := ctl.GetInt64("WareHouseID"); err == nil {
+		condAnd["WareHouse.Id"] = warehouseID
+	}
+	if productID, err := ctl.GetInt64("ProductID"); err == nil {
+		condAnd["Product.Id"] = productID
+	}
+	offset, _ := ctl.GetInt64("offset")
+	limit, _ := ctl.GetInt64("limit")
+	orderStr := ctl.GetString("order")
+	sortStr := ctl.GetString("sort")
+	if orderStr != "" && sortStr != "" {
+		sortby = append(sortby, sortStr)
+		order = append(order, orderStr)
+	} else {
+		sortby = append(sortby, "Id")
+		order = append(order, "desc")
+	}
+	if len(condAnd) > 0 {
+		cond["and"] = condAnd
+	}
+	if result, err := ctl.stockWarehouseOrderpointList(query, exclude, cond, fields, sortby, order, offset, limit); err == nil {
+		ctl.Data["json"] = result
+	}
+	ctl.ServeJSON()
+
+}
+
+// GetList 补货规则get请求，列出补货规则
+func (ctl *StockWarehouseOrderpointController) GetList() {
+	viewType := ctl.Input().Get("view")
+	if viewType == "" || viewType == "table" {
+		ctl.Data["ViewType"] = "table"
+	}
+	ctl.Data["ReplenishError"] = ctl.Input().Get("replenishError")
+	ctl.PageAction = "列表"
+	ctl.Data["tableId"] = "table-stock-orderpoint"
+	ctl.Layout = "base/base_list_view.html"
+	ctl.TplName = "stock/stock_warehouse_orderpoint_list_search.html"
+}
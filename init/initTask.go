@@ -0,0 +1,40 @@
+// 定时任务
+package init
+
+import (
+	md "goERP/models"
+	"goERP/utils"
+	"strconv"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/toolbox"
+)
+
+// InitTask 注册并启动定时任务
+func InitTask() {
+	// 自动补货，默认每天凌晨1点执行
+	replenishSpec := beego.AppConfig.DefaultString("stock::replenish_spec", "0 0 1 * * *")
+	toolbox.AddTask("stockReplenishment", toolbox.NewTask("stockReplenishment", replenishSpec, func() error {
+		orderIDs, err := md.RunStockReplenishment(nil)
+		if err != nil {
+			utils.LogOut("error", "自动补货失败:"+err.Error())
+			return err
+		}
+		utils.LogOut("info", "自动补货完成，生成询价单数量:"+strconv.Itoa(len(orderIDs)))
+		return nil
+	}))
+	// 预设生效时间的牌价到期后重新计算按克重计价的草稿订单明细，默认每5分钟执行
+	repriceSpec := beego.AppConfig.DefaultString("sale::metal_reprice_spec", "0 */5 * * * *")
+	toolbox.AddTask("saleOrderMetalReprice", toolbox.NewTask("saleOrderMetalReprice", repriceSpec, func() error {
+		num, err := md.RepriceSaleOrderLines(nil)
+		if err != nil {
+			utils.LogOut("error", "按牌价重新计算订单明细失败:"+err.Error())
+			return err
+		}
+		if num > 0 {
+			utils.LogOut("info", "按牌价重新计算订单明细数量:"+strconv.FormatInt(num, 10))
+		}
+		return nil
+	}))
+	toolbox.StartTask()
+}
@@ -56,6 +56,8 @@ func init() {
 	orm.RunSyncdb(dbAlias, coverDb, true)
 	InitApp()
 	InitDb()
+	// 启动定时任务
+	InitTask()
 	// 加载权限控制文件
 	// LoadSecurity()
 	// 初始化cache
@@ -27,6 +27,7 @@ type Company struct {
 	City       *AddressCity     `orm:"rel(fk);null" json:"-"`                //城市
 	District   *AddressDistrict `orm:"rel(fk);null" json:"-"`                //区县
 	Street     string           `orm:"default()" json:"Street"`              //街道
+	Partner    *Partner         `orm:"rel(fk);null" json:"-"`                //公司对应的合作伙伴，用于公司间调拨
 
 	FormAction   string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
 	ActionFields []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
@@ -242,3 +243,42 @@ func DeleteCompany(id int64) (err error) {
 	}
 	return
 }
+
+// companyPartner 获得公司对应的合作伙伴，没有时按公司名称查找，找不到则创建
+func companyPartner(o orm.Ormer, company *Company, user *User) (*Partner, error) {
+	if err := o.Read(company); err != nil {
+		return nil, err
+	}
+	if company.Partner != nil {
+		return company.Partner, nil
+	}
+	partner := &Partner{Name: company.Name}
+	if err := o.Read(partner, "Name"); err != nil {
+		if err != orm.ErrNoRows {
+			return nil, err
+		}
+		partner = &Partner{
+			Name:       company.Name,
+			IsCompany:  true,
+			IsCustomer: true,
+			IsSupplier: true,
+			Active:     true,
+			Country:    company.Country,
+			Province:   company.Province,
+			City:       company.City,
+			District:   company.District,
+			Street:     company.Street,
+			CreateUser: user,
+			UpdateUser: user,
+		}
+		if partner.ID, err = o.Insert(partner); err != nil {
+			return nil, err
+		}
+	}
+	company.Partner = partner
+	company.UpdateUser = user
+	if _, err := o.Update(company, "Partner", "UpdateUser", "UpdateDate"); err != nil {
+		return nil, err
+	}
+	return partner, nil
+}
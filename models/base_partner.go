@@ -12,36 +12,38 @@ import (
 
 // Partner 合作伙伴，包括客户和供应商，后期会为每个合作伙伴自动创建一个登录帐号
 type Partner struct {
-	ID         int64            `orm:"column(id);pk;auto" json:"id"`         //主键
-	CreateUser *User            `orm:"rel(fk);null" json:"-"`                //创建者
-	UpdateUser *User            `orm:"rel(fk);null" json:"-"`                //最后更新者
-	CreateDate time.Time        `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
-	UpdateDate time.Time        `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
-	Name       string           `orm:"unique" json:"Name"`                   //合作伙伴名称
-	IsCompany  bool             `orm:"default(true)" json:"IsCompany"`       //是公司
-	IsSupplier bool             `orm:"default(false)" json:"IsSupplier"`     //是供应商
-	IsCustomer bool             `orm:"default(true)" json:"IsCustomer"`      //是客户
-	Active     bool             `orm:"default(true)" json:"Active"`          //有效
-	Country    *AddressCountry  `orm:"rel(fk);null"`                         //国家
-	Province   *AddressProvince `orm:"rel(fk);null"`                         //省份
-	City       *AddressCity     `orm:"rel(fk);null"`                         //城市
-	District   *AddressDistrict `orm:"rel(fk);null"`                         //区县
-	Street     string           `orm:"default(\"\")" json:"Street"`          //街道
-	Parent     *Partner         `orm:"rel(fk);null"`                         //母公司
-	Childs     []*Partner       `orm:"reverse(many)"`                        //下级
-	Mobile     string           `orm:"default(\"\")" json:"Mobile"`          //电话号码
-	Tel        string           `orm:"default(\"\")" json:"Tel"`             //座机
-	Email      string           `orm:"default(\"\")" json:"Email"`           //邮箱
-	Qq         string           `orm:"default(\"\")" json:"Qq"`              //QQ
-	WeChat     string           `orm:"default(\"\")" json:"WeChat"`          //微信
-	Comment    string           `orm:"type(text)" json:"Comment"`            //备注
-
-	FormAction string `orm:"-" json:"FormAction"` //非数据库字段，用于表示记录的增加，修改
-	ParentID   int64  `orm:"-" json:"Parent"`     //母公司
-	CountryID  int64  `orm:"-" json:"Country"`    //国家
-	ProvinceID int64  `orm:"-" json:"Province"`   //省份
-	CityID     int64  `orm:"-" json:"City"`       //城市
-	DistrictID int64  `orm:"-" json:"District"`   //区县
+	ID         int64             `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser *User             `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser *User             `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate time.Time         `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate time.Time         `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	Name       string            `orm:"unique" json:"Name"`                   //合作伙伴名称
+	IsCompany  bool              `orm:"default(true)" json:"IsCompany"`       //是公司
+	IsSupplier bool              `orm:"default(false)" json:"IsSupplier"`     //是供应商
+	IsCustomer bool              `orm:"default(true)" json:"IsCustomer"`      //是客户
+	Active     bool              `orm:"default(true)" json:"Active"`          //有效
+	Country    *AddressCountry   `orm:"rel(fk);null"`                         //国家
+	Province   *AddressProvince  `orm:"rel(fk);null"`                         //省份
+	City       *AddressCity      `orm:"rel(fk);null"`                         //城市
+	District   *AddressDistrict  `orm:"rel(fk);null"`                         //区县
+	Street     string            `orm:"default(\"\")" json:"Street"`          //街道
+	Parent     *Partner          `orm:"rel(fk);null"`                         //母公司
+	Childs     []*Partner        `orm:"reverse(many)"`                        //下级
+	Mobile     string            `orm:"default(\"\")" json:"Mobile"`          //电话号码
+	Tel        string            `orm:"default(\"\")" json:"Tel"`             //座机
+	Email      string            `orm:"default(\"\")" json:"Email"`           //邮箱
+	Qq         string            `orm:"default(\"\")" json:"Qq"`              //QQ
+	WeChat     string            `orm:"default(\"\")" json:"WeChat"`          //微信
+	Comment    string            `orm:"type(text)" json:"Comment"`            //备注
+	Pricelist  *ProductPriceList `orm:"rel(fk);null"`                         //默认价格表
+
+	FormAction  string `orm:"-" json:"FormAction"` //非数据库字段，用于表示记录的增加，修改
+	ParentID    int64  `orm:"-" json:"Parent"`     //母公司
+	CountryID   int64  `orm:"-" json:"Country"`    //国家
+	ProvinceID  int64  `orm:"-" json:"Province"`   //省份
+	CityID      int64  `orm:"-" json:"City"`       //城市
+	DistrictID  int64  `orm:"-" json:"District"`   //区县
+	PricelistID int64  `orm:"-" json:"Pricelist"`  //默认价格表
 
 }
 
@@ -86,6 +88,9 @@ func AddPartner(obj *Partner, addUser *User) (id int64, err error) {
 	if obj.DistrictID > 0 {
 		obj.District, _ = GetAddressDistrictByID(obj.DistrictID)
 	}
+	if obj.PricelistID > 0 {
+		obj.Pricelist, _ = GetProductPriceListByID(obj.PricelistID)
+	}
 	id, err = o.Insert(obj)
 	if err != nil {
 		return 0, err
@@ -109,6 +114,7 @@ func GetPartnerByID(id int64) (obj *Partner, err error) {
 		o.LoadRelated(obj, "Province")
 		o.LoadRelated(obj, "City")
 		o.LoadRelated(obj, "District")
+		o.LoadRelated(obj, "Pricelist")
 		return obj, nil
 	}
 	return nil, err
@@ -229,6 +235,9 @@ func GetAllPartner(query map[string]interface{}, exclude map[string]interface{},
 func UpdatePartner(obj *Partner, updateUser *User) (id int64, err error) {
 	o := orm.NewOrm()
 	obj.UpdateUser = updateUser
+	if obj.PricelistID > 0 {
+		obj.Pricelist, _ = GetProductPriceListByID(obj.PricelistID)
+	}
 	var num int64
 	if num, err = o.Update(obj); err == nil {
 		fmt.Println("Number of records updated in database:", num)
@@ -64,15 +64,7 @@ func GetNextSequece(structName string, companyID int64) (stStr string, err error
 	qs := o.QueryTable(&sequence)
 	qs = qs.SetCond(cond)
 	if err = qs.One(&sequence); err == nil {
-		b := bytes.Buffer{}
-		b.WriteString(sequence.Prefix)
-		b.WriteString("%0")
-		b.WriteString(strconv.Itoa(int(sequence.Padding)))
-		b.WriteString("s")
-		fmtStr := b.String()
-		sequence.Current++
-		stStr = fmt.Sprintf(fmtStr, strconv.Itoa(int(sequence.Current)))
-		_, err = o.Update(&sequence)
+		stStr, err = sequenceNextValue(o, &sequence)
 	}
 	if err == nil {
 		errCommit := o.Commit()
@@ -83,6 +75,20 @@ func GetNextSequece(structName string, companyID int64) (stStr string, err error
 	return stStr, err
 }
 
+// sequenceNextValue 序号递增并按前缀和位数生成单据编号
+func sequenceNextValue(o orm.Ormer, sequence *Sequence) (string, error) {
+	b := bytes.Buffer{}
+	b.WriteString(sequence.Prefix)
+	b.WriteString("%0")
+	b.WriteString(strconv.Itoa(int(sequence.Padding)))
+	b.WriteString("s")
+	fmtStr := b.String()
+	sequence.Current++
+	stStr := fmt.Sprintf(fmtStr, strconv.Itoa(int(sequence.Current)))
+	_, err := o.Update(sequence, "Current", "UpdateDate")
+	return stStr, err
+}
+
 // AddSequence insert a new Sequence into database and returns
 // last inserted ID on success.
 func AddSequence(obj *Sequence, addUser *User) (id int64, err error) {
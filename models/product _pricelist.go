@@ -12,17 +12,19 @@ import (
 
 // ProductPriceList 产品价格表
 type ProductPriceList struct {
-	ID         int64     `orm:"column(id);pk;auto" json:"id"`         //主键
-	CreateUser *User     `orm:"rel(fk);null" json:"-"`                //创建者
-	UpdateUser *User     `orm:"rel(fk);null" json:"-"`                //最后更新者
-	CreateDate time.Time `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
-	UpdateDate time.Time `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
-	Name       string    //价格表名称
-	Active     bool      `orm:"default(true)"` //有效
-	// Items  []*ProductPricelistItem `orm:"reverse(many)"`
+	ID         int64                   `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser *User                   `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser *User                   `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate time.Time               `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate time.Time               `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	Name       string                  //价格表名称
+	Active     bool                    `orm:"default(true)"` //有效
+	Company    *Company                `orm:"rel(fk);null"`  //公司
+	Items      []*ProductPricelistItem `orm:"reverse(many)"` //价格规则
 
 	FormAction   string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
 	ActionFields []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
+	CompanyID    int64    `orm:"-" json:"Company"`      //公司
 }
 
 func init() {
@@ -31,8 +33,13 @@ func init() {
 
 // AddProductPriceList insert a new ProductPriceList into database and returns
 // last inserted ID on success.
-func AddProductPriceList(obj *ProductPriceList) (id int64, err error) {
+func AddProductPriceList(obj *ProductPriceList, addUser *User) (id int64, err error) {
 	o := orm.NewOrm()
+	obj.CreateUser = addUser
+	obj.UpdateUser = addUser
+	if obj.CompanyID > 0 {
+		obj.Company, _ = GetCompanyByID(obj.CompanyID)
+	}
 	id, err = o.Insert(obj)
 	return id, err
 }
@@ -43,6 +50,10 @@ func GetProductPriceListByID(id int64) (obj *ProductPriceList, err error) {
 	o := orm.NewOrm()
 	obj = &ProductPriceList{ID: id}
 	if err = o.Read(obj); err == nil {
+		if obj.Company != nil {
+			o.Read(obj.Company)
+		}
+		obj.Items, _ = productPricelistItems(o, id)
 		return obj, nil
 	}
 	return nil, err
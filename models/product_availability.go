@@ -0,0 +1,241 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// ProductAvailability 产品库存可用情况，两个单位分别统计
+type ProductAvailability struct {
+	ProductID       int64   `json:"ProductID"`       //产品规格ID，款式汇总时为0
+	TemplateID      int64   `json:"TemplateID"`      //产品款式ID
+	Name            string  `json:"Name"`            //产品名称
+	FirstUom        string  `json:"FirstUom"`        //第一单位
+	SecondUom       string  `json:"SecondUom"`       //第二单位
+	FirstOnHand     float64 `json:"FirstOnHand"`     //第一单位在库数量
+	SecondOnHand    float64 `json:"SecondOnHand"`    //第二单位在库数量
+	FirstReserved   float64 `json:"FirstReserved"`   //第一单位已保留数量
+	SecondReserved  float64 `json:"SecondReserved"`  //第二单位已保留数量
+	FirstIncoming   float64 `json:"FirstIncoming"`   //第一单位待入库数量
+	SecondIncoming  float64 `json:"SecondIncoming"`  //第二单位待入库数量
+	FirstOutgoing   float64 `json:"FirstOutgoing"`   //第一单位待出库数量
+	SecondOutgoing  float64 `json:"SecondOutgoing"`  //第二单位待出库数量
+	FirstAvailable  float64 `json:"FirstAvailable"`  //第一单位可用数量:在库减已保留
+	SecondAvailable float64 `json:"SecondAvailable"` //第二单位可用数量
+	FirstForecast   float64 `json:"FirstForecast"`   //第一单位预测数量:在库加待入库减待出库
+	SecondForecast  float64 `json:"SecondForecast"`  //第二单位预测数量
+}
+
+// add 累加另一条可用情况，用于款式汇总
+func (obj *ProductAvailability) add(other *ProductAvailability) {
+	obj.FirstOnHand += other.FirstOnHand
+	obj.SecondOnHand += other.SecondOnHand
+	obj.FirstReserved += other.FirstReserved
+	obj.SecondReserved += other.SecondReserved
+	obj.FirstIncoming += other.FirstIncoming
+	obj.SecondIncoming += other.SecondIncoming
+	obj.FirstOutgoing += other.FirstOutgoing
+	obj.SecondOutgoing += other.SecondOutgoing
+	obj.FirstAvailable += other.FirstAvailable
+	obj.SecondAvailable += other.SecondAvailable
+	obj.FirstForecast += other.FirstForecast
+	obj.SecondForecast += other.SecondForecast
+}
+
+// productAvailabilityLocations 获得统计的库位范围:指定库位时为库位及下级库位，
+// 指定仓库时为仓库视图库位下的库位，都未指定时为所有内部库位
+func productAvailabilityLocations(o orm.Ormer, warehouseID, locationID int64) ([]int64, error) {
+	if locationID > 0 {
+		location := &StockLocation{ID: locationID}
+		if err := o.Read(location); err != nil {
+			return nil, err
+		}
+		return stockLocationChildIDs(o, location)
+	}
+	if warehouseID > 0 {
+		warehouse := &StockWarehouse{ID: warehouseID}
+		if err := o.Read(warehouse); err != nil {
+			return nil, err
+		}
+		if warehouse.Location == nil {
+			return nil, errors.New("仓库没有设置库存库位")
+		}
+		location := &StockLocation{ID: warehouse.Location.ID}
+		if err := o.Read(location); err != nil {
+			return nil, err
+		}
+		// 库存库位的上级为仓库视图库位时统计整个仓库
+		if location.Parent != nil {
+			parent := &StockLocation{ID: location.Parent.ID}
+			if err := o.Read(parent); err == nil && parent.Usage == "view" {
+				location = parent
+			}
+		}
+		return stockLocationChildIDs(o, location)
+	}
+	var locations []*StockLocation
+	if _, err := o.QueryTable(new(StockLocation)).Filter("Usage__in", "internal", "transit").Limit(-1).All(&locations, "Id"); err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(locations))
+	for _, location := range locations {
+		ids = append(ids, location.ID)
+	}
+	return ids, nil
+}
+
+// productsAvailability 统计产品规格在库位范围内的可用情况，date不为空时只统计预定日期在此之前的移动
+func productsAvailability(o orm.Ormer, products []*ProductProduct, locationIDs []int64, date time.Time) (result []*ProductAvailability, err error) {
+	if len(products) == 0 {
+		return result, nil
+	}
+	productIDs := make([]int64, 0, len(products))
+	byProduct := make(map[int64]*ProductAvailability)
+	for _, product := range products {
+		line := &ProductAvailability{ProductID: product.ID, Name: product.Name}
+		if product.ProductTemplate != nil {
+			line.TemplateID = product.ProductTemplate.ID
+		}
+		if product.FirstSaleUom != nil {
+			uom := &ProductUom{ID: product.FirstSaleUom.ID}
+			if o.Read(uom) == nil {
+				line.FirstUom = uom.Name
+			}
+		}
+		if product.SecondSaleUom != nil {
+			uom := &ProductUom{ID: product.SecondSaleUom.ID}
+			if o.Read(uom) == nil {
+				line.SecondUom = uom.Name
+			}
+		}
+		productIDs = append(productIDs, product.ID)
+		byProduct[product.ID] = line
+		result = append(result, line)
+	}
+	if len(locationIDs) == 0 {
+		return result, nil
+	}
+	inLocation := make(map[int64]bool)
+	for _, id := range locationIDs {
+		inLocation[id] = true
+	}
+	var quants []*StockQuant
+	if _, err = o.QueryTable(new(StockQuant)).Filter("Product__Id__in", productIDs).Filter("Location__Id__in", locationIDs).Limit(-1).All(&quants); err != nil {
+		return nil, err
+	}
+	for _, quant := range quants {
+		line := byProduct[quant.Product.ID]
+		line.FirstOnHand += quant.FirstUomQty
+		line.SecondOnHand += quant.SecondUomQty
+		if quant.Reservation != nil {
+			line.FirstReserved += quant.FirstUomQty
+			line.SecondReserved += quant.SecondUomQty
+		}
+	}
+	var moves []*StockMove
+	qs := o.QueryTable(new(StockMove)).Filter("Product__Id__in", productIDs).Filter("State__in", "confirm", "waiting", "assigned")
+	if !date.IsZero() {
+		qs = qs.Filter("DateExpected__lte", date)
+	}
+	if _, err = qs.Limit(-1).All(&moves); err != nil {
+		return nil, err
+	}
+	for _, move := range moves {
+		srcIn := move.LocationSrc != nil && inLocation[move.LocationSrc.ID]
+		destIn := move.LocationDest != nil && inLocation[move.LocationDest.ID]
+		line := byProduct[move.Product.ID]
+		switch {
+		case destIn && !srcIn:
+			line.FirstIncoming += move.FirstUomQty
+			line.SecondIncoming += move.SecondUomQty
+		case srcIn && !destIn:
+			line.FirstOutgoing += move.FirstUomQty
+			line.SecondOutgoing += move.SecondUomQty
+		}
+	}
+	for _, line := range result {
+		line.FirstAvailable = line.FirstOnHand - line.FirstReserved
+		line.SecondAvailable = line.SecondOnHand - line.SecondReserved
+		line.FirstForecast = line.FirstOnHand + line.FirstIncoming - line.FirstOutgoing
+		line.SecondForecast = line.SecondOnHand + line.SecondIncoming - line.SecondOutgoing
+	}
+	return result, nil
+}
+
+// GetProductAvailability 获得产品规格的在库、保留、待入库、待出库数量，
+// 可按仓库、库位及下级库位、预定日期过滤
+func GetProductAvailability(productID, warehouseID, locationID int64, date time.Time) (*ProductAvailability, error) {
+	o := orm.NewOrm()
+	product := &ProductProduct{ID: productID}
+	if err := o.Read(product); err != nil {
+		return nil, err
+	}
+	locationIDs, err := productAvailabilityLocations(o, warehouseID, locationID)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := productsAvailability(o, []*ProductProduct{product}, locationIDs, date)
+	if err != nil {
+		return nil, err
+	}
+	return lines[0], nil
+}
+
+// GetProductTemplateAvailability 获得产品款式的可用情况，返回款式汇总及各规格明细
+func GetProductTemplateAvailability(templateID, warehouseID, locationID int64, date time.Time) (*ProductAvailability, []*ProductAvailability, error) {
+	o := orm.NewOrm()
+	template := &ProductTemplate{ID: templateID}
+	if err := o.Read(template); err != nil {
+		return nil, nil, err
+	}
+	var products []*ProductProduct
+	if _, err := o.QueryTable(new(ProductProduct)).Filter("ProductTemplate__Id", templateID).OrderBy("Id").Limit(-1).All(&products); err != nil {
+		return nil, nil, err
+	}
+	locationIDs, err := productAvailabilityLocations(o, warehouseID, locationID)
+	if err != nil {
+		return nil, nil, err
+	}
+	lines, err := productsAvailability(o, products, locationIDs, date)
+	if err != nil {
+		return nil, nil, err
+	}
+	total := &ProductAvailability{TemplateID: template.ID, Name: template.Name}
+	for _, line := range lines {
+		total.add(line)
+		// 汇总使用第一个规格的单位
+		if total.FirstUom == "" {
+			total.FirstUom = line.FirstUom
+			total.SecondUom = line.SecondUom
+		}
+	}
+	return total, lines, nil
+}
+
+// GetSaleCounterAvailability 获得柜台产品的可用情况，柜台关联款式时按款式汇总
+func GetSaleCounterAvailability(counterID, warehouseID, locationID int64, date time.Time) ([]*ProductAvailability, error) {
+	o := orm.NewOrm()
+	var counterProducts []*SaleCounterProduct
+	if _, err := o.QueryTable(new(SaleCounterProduct)).Filter("SaleCounter__Id", counterID).OrderBy("Id").Limit(-1).All(&counterProducts); err != nil {
+		return nil, err
+	}
+	result := make([]*ProductAvailability, 0, len(counterProducts))
+	for _, counterProduct := range counterProducts {
+		if counterProduct.ProductProducts != nil {
+			line, err := GetProductAvailability(counterProduct.ProductProducts.ID, warehouseID, locationID, date)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, line)
+		} else if counterProduct.ProductTemplates != nil {
+			total, _, err := GetProductTemplateAvailability(counterProduct.ProductTemplates.ID, warehouseID, locationID, date)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, total)
+		}
+	}
+	return result, nil
+}
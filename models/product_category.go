@@ -23,6 +23,7 @@ type ProductCategory struct {
 	Childs         []*ProductCategory `orm:"reverse(many)"`                        //下级分类
 	Sequence       int64              //序列
 	ParentFullPath string             //上级全路径
+	CostMethod     string             `orm:"default(standard)" json:"CostMethod"` //成本方法 standard标准成本/average移动平均/fifo先进先出
 
 	FormAction   string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
 	ActionFields []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
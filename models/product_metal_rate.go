@@ -0,0 +1,232 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"goERP/utils"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// ProductMetalRate 贵金属牌价，按金属和成色记录每克价格，新牌价生效后未确认的销售明细重新计价
+type ProductMetalRate struct {
+	ID            int64     `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser    *User     `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser    *User     `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate    time.Time `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate    time.Time `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	Metal         string    `orm:"index" json:"Metal"`                   //金属:gold/silver/platinum/palladium
+	Purity        string    `orm:"index" json:"Purity"`                  //成色，如999、990、750
+	PricePerGram  float64   `json:"PricePerGram"`                        //每克价格
+	DateEffective time.Time `orm:"type(datetime);index" json:"-"`        //生效时间
+
+	FormAction   string   `orm:"-" json:"FormAction"`    //非数据库字段，用于表示记录的增加，修改
+	ActionFields []string `orm:"-" json:"ActionFields"`  //需要操作的字段,用于update时
+	DateString   string   `orm:"-" json:"DateEffective"` //生效时间form，格式2006-01-02 15:04
+}
+
+func init() {
+	orm.RegisterModel(new(ProductMetalRate))
+}
+
+// AddProductMetalRate insert a new ProductMetalRate into database and returns
+// last inserted ID on success. 牌价已生效时重新计算未确认的销售明细
+func AddProductMetalRate(obj *ProductMetalRate, addUser *User) (id int64, err error) {
+	o := orm.NewOrm()
+	obj.CreateUser = addUser
+	obj.UpdateUser = addUser
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return 0, errBegin
+	}
+	obj.Metal = strings.TrimSpace(obj.Metal)
+	obj.Purity = strings.TrimSpace(obj.Purity)
+	if obj.Metal == "" || obj.Purity == "" {
+		return 0, errors.New("牌价必须指定金属和成色")
+	}
+	if obj.PricePerGram <= 0 {
+		return 0, errors.New("每克价格必须大于0")
+	}
+	now := time.Now()
+	if obj.DateString != "" {
+		if obj.DateEffective, err = time.ParseInLocation("2006-01-02 15:04", obj.DateString, time.Local); err != nil {
+			return 0, err
+		}
+	}
+	if obj.DateEffective.IsZero() {
+		obj.DateEffective = now
+	}
+	if id, err = o.Insert(obj); err != nil {
+		return 0, err
+	}
+	if !obj.DateEffective.After(now) {
+		if _, err = saleOrderLinesMetalReprice(o, obj.Metal, obj.Purity, now, addUser); err != nil {
+			return 0, err
+		}
+	}
+	return id, o.Commit()
+}
+
+// GetProductMetalRateByID retrieves ProductMetalRate by ID. Returns error if
+// ID doesn't exist
+func GetProductMetalRateByID(id int64) (obj *ProductMetalRate, err error) {
+	o := orm.NewOrm()
+	obj = &ProductMetalRate{ID: id}
+	if err = o.Read(obj); err == nil {
+		return obj, nil
+	}
+	return nil, err
+}
+
+// productMetalRateAt 获得金属和成色在指定时间生效的牌价
+func productMetalRateAt(o orm.Ormer, metal, purity string, at time.Time) (*ProductMetalRate, error) {
+	rate := &ProductMetalRate{}
+	err := o.QueryTable(rate).Filter("Metal", metal).Filter("Purity", purity).Filter("DateEffective__lte", at).OrderBy("-DateEffective", "-Id").One(rate)
+	if err == orm.ErrNoRows {
+		return nil, fmt.Errorf("金属[%s]成色[%s]没有生效的牌价", metal, purity)
+	}
+	return rate, err
+}
+
+// GetProductMetalRate 获得金属和成色当前生效的牌价
+func GetProductMetalRate(metal, purity string) (*ProductMetalRate, error) {
+	return productMetalRateAt(orm.NewOrm(), metal, purity, time.Now())
+}
+
+// GetAllProductMetalRate retrieves all ProductMetalRate matches certain condition. Returns empty list if
+// no records exist
+func GetAllProductMetalRate(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (utils.Paginator, []ProductMetalRate, error) {
+	var (
+		objArrs   []ProductMetalRate
+		paginator utils.Paginator
+		num       int64
+		err       error
+	)
+	if limit == 0 {
+		limit = 20
+	}
+	o := orm.NewOrm()
+	qs := o.QueryTable(new(ProductMetalRate))
+	qs = qs.RelatedSel()
+
+	//cond k=v cond必须放到Filter和Exclude前面
+	cond := orm.NewCondition()
+	if _, ok := condMap["and"]; ok {
+		andMap := condMap["and"]
+		for k, v := range andMap {
+			k = strings.Replace(k, ".", "__", -1)
+			cond = cond.And(k, v)
+		}
+	}
+	if _, ok := condMap["or"]; ok {
+		orMap := condMap["or"]
+		for k, v := range orMap {
+			k = strings.Replace(k, ".", "__", -1)
+			cond = cond.Or(k, v)
+		}
+	}
+	qs = qs.SetCond(cond)
+	// query k=v
+	for k, v := range query {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		qs = qs.Filter(k, v)
+	}
+	//exclude k=v
+	for k, v := range exclude {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		qs = qs.Exclude(k, v)
+	}
+
+	// order by:
+	var sortFields []string
+	if len(sortby) != 0 {
+		if len(sortby) == len(order) {
+			// 1) for each sort field, there is an associated order
+			for i, v := range sortby {
+				orderby := ""
+				if order[i] == "desc" {
+					orderby = "-" + strings.Replace(v, ".", "__", -1)
+				} else if order[i] == "asc" {
+					orderby = strings.Replace(v, ".", "__", -1)
+				} else {
+					return paginator, nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+			qs = qs.OrderBy(sortFields...)
+		} else if len(sortby) != len(order) && len(order) == 1 {
+			// 2) there is exactly one order, all the sorted fields will be sorted by this order
+			for _, v := range sortby {
+				orderby := ""
+				if order[0] == "desc" {
+					orderby = "-" + strings.Replace(v, ".", "__", -1)
+				} else if order[0] == "asc" {
+					orderby = strings.Replace(v, ".", "__", -1)
+				} else {
+					return paginator, nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+		} else if len(sortby) != len(order) && len(order) != 1 {
+			return paginator, nil, errors.New("Error: 'sortby', 'order' sizes mismatch or 'order' size is not 1")
+		}
+	} else {
+		if len(order) != 0 {
+			return paginator, nil, errors.New("Error: unused 'order' fields")
+		}
+	}
+
+	qs = qs.OrderBy(sortFields...)
+	if cnt, err := qs.Count(); err == nil {
+		if cnt > 0 {
+			paginator = utils.GenPaginator(limit, offset, cnt)
+			if num, err = qs.Limit(limit, offset).All(&objArrs, fields...); err == nil {
+				paginator.CurrentPageSize = num
+			}
+		}
+	}
+	return paginator, objArrs, err
+}
+
+// DeleteProductMetalRate deletes ProductMetalRate by ID and returns error if
+// the record to be deleted doesn't exist
+// 删除后按当前生效的牌价重新计算金属和成色相同的草稿订单明细
+func DeleteProductMetalRate(id int64, deleteUser *User) (err error) {
+	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return errBegin
+	}
+	v := ProductMetalRate{ID: id}
+	// ascertain id exists in the database
+	if err = o.Read(&v); err != nil {
+		return err
+	}
+	var num int64
+	if num, err = o.Delete(&ProductMetalRate{ID: id}); err != nil {
+		return err
+	}
+	fmt.Println("Number of records deleted in database:", num)
+	if _, err = saleOrderLinesMetalReprice(o, v.Metal, v.Purity, time.Now(), deleteUser); err != nil {
+		return err
+	}
+	return o.Commit()
+}
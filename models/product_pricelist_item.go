@@ -10,16 +10,40 @@ import (
 	"github.com/astaxie/beego/orm"
 )
 
-//ProductPricelistItem  产品价格
+// ProductPricelistItem  产品价格
 type ProductPricelistItem struct {
-	ID         int64     `orm:"column(id);pk;auto" json:"id"`         //主键
-	CreateUser *User     `orm:"rel(fk);null" json:"-"`                //创建者
-	UpdateUser *User     `orm:"rel(fk);null" json:"-"`                //最后更新者
-	CreateDate time.Time `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
-	UpdateDate time.Time `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	ID              int64             `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser      *User             `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser      *User             `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate      time.Time         `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate      time.Time         `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	Pricelist       *ProductPriceList `orm:"rel(fk)"`                              //价格表
+	Sequence        int64             `orm:"default(0)" json:"Sequence"`           //序号，同级规则序号小的优先
+	AppliedOn       string            `orm:"default(global)" json:"AppliedOn"`     //应用于:product/template/category/global
+	Product         *ProductProduct   `orm:"rel(fk);null"`                         //产品规格
+	ProductTemplate *ProductTemplate  `orm:"rel(fk);null"`                         //产品款式
+	Category        *ProductCategory  `orm:"rel(fk);null"`                         //产品类别，包括下级类别
+	MinQuantity     float64           `orm:"default(0)" json:"MinQuantity"`        //最小数量
+	DateStart       time.Time         `orm:"type(date);null" json:"-"`             //开始日期
+	DateEnd         time.Time         `orm:"type(date);null" json:"-"`             //结束日期
+	ComputePrice    string            `orm:"default(fixed)" json:"ComputePrice"`   //计算方式:fixed固定价格/percentage折扣/formula公式
+	FixedPrice      float64           `orm:"default(0)" json:"FixedPrice"`         //固定价格
+	PercentPrice    float64           `orm:"default(0)" json:"PercentPrice"`       //折扣百分比
+	Base            string            `orm:"default(list_price)" json:"Base"`      //公式基础价格:list_price/standard_price/pricelist
+	BasePricelist   *ProductPriceList `orm:"rel(fk);null"`                         //公式基础价格表
+	PriceDiscount   float64           `orm:"default(0)" json:"PriceDiscount"`      //公式折扣百分比
+	PriceSurcharge  float64           `orm:"default(0)" json:"PriceSurcharge"`     //附加费用，舍入后加上
+	PriceRound      float64           `orm:"default(0)" json:"PriceRound"`         //舍入精度，如0.1、1、10
+	PriceMinMargin  float64           `orm:"default(0)" json:"PriceMinMargin"`     //相对基础价格的最小利润
+	PriceMaxMargin  float64           `orm:"default(0)" json:"PriceMaxMargin"`     //相对基础价格的最大利润
 
-	FormAction   string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
-	ActionFields []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
+	FormAction        string   `orm:"-" json:"FormAction"`      //非数据库字段，用于表示记录的增加，修改
+	ActionFields      []string `orm:"-" json:"ActionFields"`    //需要操作的字段,用于update时
+	PricelistID       int64    `orm:"-" json:"Pricelist"`       //价格表
+	ProductID         int64    `orm:"-" json:"Product"`         //产品规格
+	ProductTemplateID int64    `orm:"-" json:"ProductTemplate"` //产品款式
+	CategoryID        int64    `orm:"-" json:"Category"`        //产品类别
+	BasePricelistID   int64    `orm:"-" json:"BasePricelist"`   //公式基础价格表
 }
 
 func init() {
@@ -28,12 +52,75 @@ func init() {
 
 // AddProductPricelistItem insert a new ProductPricelistItem into database and returns
 // last inserted ID on success.
-func AddProductPricelistItem(obj *ProductPricelistItem) (id int64, err error) {
+func AddProductPricelistItem(obj *ProductPricelistItem, addUser *User) (id int64, err error) {
 	o := orm.NewOrm()
+	obj.CreateUser = addUser
+	obj.UpdateUser = addUser
+	if obj.PricelistID > 0 {
+		obj.Pricelist, _ = GetProductPriceListByID(obj.PricelistID)
+	}
+	if obj.ProductID > 0 {
+		obj.Product, _ = GetProductProductByID(obj.ProductID)
+	}
+	if obj.ProductTemplateID > 0 {
+		obj.ProductTemplate, _ = GetProductTemplateByID(obj.ProductTemplateID)
+	}
+	if obj.CategoryID > 0 {
+		obj.Category, _ = GetProductCategoryByID(obj.CategoryID)
+	}
+	if obj.BasePricelistID > 0 {
+		obj.BasePricelist, _ = GetProductPriceListByID(obj.BasePricelistID)
+	}
+	if err = productPricelistItemCheck(obj); err != nil {
+		return 0, err
+	}
 	id, err = o.Insert(obj)
 	return id, err
 }
 
+// productPricelistItemCheck 检查价格规则的应用对象和计算方式
+func productPricelistItemCheck(obj *ProductPricelistItem) error {
+	if obj.Pricelist == nil {
+		return errors.New("价格规则必须指定价格表")
+	}
+	switch obj.AppliedOn {
+	case "product":
+		if obj.Product == nil {
+			return errors.New("价格规则应用于产品规格时必须指定产品规格")
+		}
+	case "template":
+		if obj.ProductTemplate == nil {
+			return errors.New("价格规则应用于产品款式时必须指定产品款式")
+		}
+	case "category":
+		if obj.Category == nil {
+			return errors.New("价格规则应用于产品类别时必须指定产品类别")
+		}
+	case "", "global":
+		obj.AppliedOn = "global"
+	default:
+		return errors.New("价格规则的应用对象无效")
+	}
+	switch obj.ComputePrice {
+	case "", "fixed":
+		obj.ComputePrice = "fixed"
+	case "percentage":
+	case "formula":
+		if obj.Base == "" {
+			obj.Base = "list_price"
+		}
+		if obj.Base == "pricelist" && (obj.BasePricelist == nil || obj.BasePricelist.ID == obj.Pricelist.ID) {
+			return errors.New("公式基于价格表时必须指定其他价格表")
+		}
+	default:
+		return errors.New("价格规则的计算方式无效")
+	}
+	if !obj.DateStart.IsZero() && !obj.DateEnd.IsZero() && obj.DateEnd.Before(obj.DateStart) {
+		return errors.New("价格规则的结束日期不能早于开始日期")
+	}
+	return nil
+}
+
 // GetProductPricelistItemByID retrieves ProductPricelistItem by ID. Returns error if
 // ID doesn't exist
 func GetProductPricelistItemByID(id int64) (obj *ProductPricelistItem, err error) {
@@ -45,6 +132,12 @@ func GetProductPricelistItemByID(id int64) (obj *ProductPricelistItem, err error
 	return nil, err
 }
 
+// productPricelistItems 获得价格表的价格规则及关联的产品、款式和类别
+func productPricelistItems(o orm.Ormer, pricelistID int64) (items []*ProductPricelistItem, err error) {
+	_, err = o.QueryTable(new(ProductPricelistItem)).Filter("Pricelist__Id", pricelistID).RelatedSel("Product", "ProductTemplate", "Category", "BasePricelist").OrderBy("Sequence", "Id").Limit(-1).All(&items)
+	return items, err
+}
+
 // GetAllProductPricelistItem retrieves all ProductPricelistItem matches certain condition. Returns empty list if
 // no records exist
 func GetAllProductPricelistItem(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (utils.Paginator, []ProductPricelistItem, error) {
@@ -0,0 +1,199 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// productPricelistMaxDepth 公式基于其他价格表时允许的最大嵌套层数
+const productPricelistMaxDepth = 10
+
+// productAttributeExtra 获得产品规格属性值的额外价格，款式设置了属性价格时以款式的属性价格为准
+func productAttributeExtra(o orm.Ormer, template *ProductTemplate, product *ProductProduct) (extra float64, err error) {
+	var values []*ProductAttributeValue
+	if _, err = o.QueryTable(new(ProductAttributeValue)).Filter("Products__Id", product.ID).Limit(-1).All(&values); err != nil {
+		return 0, err
+	}
+	for _, value := range values {
+		attributePrice := ProductAttributePrice{}
+		if errRead := o.QueryTable(new(ProductAttributePrice)).Filter("ProductTemplate__Id", template.ID).Filter("AttributeValue__Id", value.ID).One(&attributePrice); errRead == nil {
+			extra += attributePrice.PriceExtra
+		} else {
+			extra += value.PriceExtra
+		}
+	}
+	return extra, nil
+}
+
+// productListPrice 获得产品规格的销售价格，款式价格加上属性值的额外价格
+func productListPrice(o orm.Ormer, product *ProductProduct) (price float64, err error) {
+	if product.ProductTemplate == nil {
+		return 0, nil
+	}
+	template := &ProductTemplate{ID: product.ProductTemplate.ID}
+	if err = o.Read(template); err != nil {
+		return 0, err
+	}
+	extra, err := productAttributeExtra(o, template, product)
+	if err != nil {
+		return 0, err
+	}
+	return template.Price + extra, nil
+}
+
+// productCategoryDepths 获得产品类别及其上级类别，值为距离产品类别的层数
+func productCategoryDepths(o orm.Ormer, category *ProductCategory) (depths map[int64]int, err error) {
+	depths = make(map[int64]int)
+	for depth := 0; category != nil; depth++ {
+		if _, ok := depths[category.ID]; ok {
+			break
+		}
+		current := &ProductCategory{ID: category.ID}
+		if err = o.Read(current); err != nil {
+			return nil, err
+		}
+		depths[current.ID] = depth
+		category = current.Parent
+	}
+	return depths, nil
+}
+
+// productPricelistItemActive 价格规则在日期内有效，结束日期当天仍然有效
+func productPricelistItemActive(item *ProductPricelistItem, date time.Time) bool {
+	if !item.DateStart.IsZero() && date.Before(item.DateStart) {
+		return false
+	}
+	if !item.DateEnd.IsZero() && !date.Before(item.DateEnd.AddDate(0, 0, 1)) {
+		return false
+	}
+	return true
+}
+
+// productPricelistRule 查找产品适用的价格规则，按产品规格、产品款式、产品类别（近的类别优先）、全局的顺序，
+// 同级的规则最小数量大的优先，再按序号排列
+func productPricelistRule(o orm.Ormer, pricelistID int64, product *ProductProduct, qty float64, date time.Time) (*ProductPricelistItem, error) {
+	var items []*ProductPricelistItem
+	if _, err := o.QueryTable(new(ProductPricelistItem)).Filter("Pricelist__Id", pricelistID).Filter("MinQuantity__lte", qty).Limit(-1).All(&items); err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+	depths, err := productCategoryDepths(o, product.Category)
+	if err != nil {
+		return nil, err
+	}
+	rank := make(map[int64]int)
+	var rules []*ProductPricelistItem
+	for _, item := range items {
+		if !productPricelistItemActive(item, date) {
+			continue
+		}
+		switch item.AppliedOn {
+		case "product":
+			if item.Product == nil || item.Product.ID != product.ID {
+				continue
+			}
+			rank[item.ID] = 0
+		case "template":
+			if item.ProductTemplate == nil || product.ProductTemplate == nil || item.ProductTemplate.ID != product.ProductTemplate.ID {
+				continue
+			}
+			rank[item.ID] = 1
+		case "category":
+			if item.Category == nil {
+				continue
+			}
+			depth, ok := depths[item.Category.ID]
+			if !ok {
+				continue
+			}
+			rank[item.ID] = 2 + depth
+		default:
+			rank[item.ID] = math.MaxInt32
+		}
+		rules = append(rules, item)
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		a, b := rules[i], rules[j]
+		if rank[a.ID] != rank[b.ID] {
+			return rank[a.ID] < rank[b.ID]
+		}
+		if a.MinQuantity != b.MinQuantity {
+			return a.MinQuantity > b.MinQuantity
+		}
+		if a.Sequence != b.Sequence {
+			return a.Sequence < b.Sequence
+		}
+		return a.ID > b.ID
+	})
+	return rules[0], nil
+}
+
+// productPricelistPrice 按价格表计算产品价格，没有适用的价格规则时为产品的销售价格
+func productPricelistPrice(o orm.Ormer, pricelistID int64, product *ProductProduct, qty float64, date time.Time, depth int) (price float64, err error) {
+	if depth > productPricelistMaxDepth {
+		return 0, errors.New("价格表的公式嵌套过深，请检查是否循环引用")
+	}
+	listPrice, err := productListPrice(o, product)
+	if err != nil {
+		return 0, err
+	}
+	if pricelistID == 0 {
+		return listPrice, nil
+	}
+	rule, err := productPricelistRule(o, pricelistID, product, qty, date)
+	if err != nil || rule == nil {
+		return listPrice, err
+	}
+	switch rule.ComputePrice {
+	case "fixed":
+		return rule.FixedPrice, nil
+	case "percentage":
+		return listPrice * (1 - rule.PercentPrice/100), nil
+	}
+	base := listPrice
+	switch rule.Base {
+	case "standard_price":
+		base = product.StandardPrice
+	case "pricelist":
+		if rule.BasePricelist != nil {
+			if base, err = productPricelistPrice(o, rule.BasePricelist.ID, product, qty, date, depth+1); err != nil {
+				return 0, err
+			}
+		}
+	}
+	price = base * (1 - rule.PriceDiscount/100)
+	if rule.PriceRound > 0 {
+		price = math.Floor(price/rule.PriceRound+0.5) * rule.PriceRound
+	}
+	price += rule.PriceSurcharge
+	if rule.PriceMinMargin > 0 {
+		price = math.Max(price, base+rule.PriceMinMargin)
+	}
+	if rule.PriceMaxMargin > 0 {
+		price = math.Min(price, base+rule.PriceMaxMargin)
+	}
+	return price, nil
+}
+
+// GetProductPrice 按价格表获得产品规格在指定数量和日期下的价格，价格表为空时为产品的销售价格
+func GetProductPrice(pricelist *ProductPriceList, product *ProductProduct, qty float64, date time.Time) (float64, error) {
+	o := orm.NewOrm()
+	current := &ProductProduct{ID: product.ID}
+	if err := o.Read(current); err != nil {
+		return 0, err
+	}
+	var pricelistID int64
+	if pricelist != nil {
+		pricelistID = pricelist.ID
+	}
+	return productPricelistPrice(o, pricelistID, current, qty, date, 0)
+}
@@ -49,6 +49,11 @@ type ProductTemplate struct {
 	ProductMethod       string                  `json:"ProductMethod"`                        //产品规格创建方式
 	PackagingDependTemp bool                    `orm:"default(true)"`                         //根据款式打包
 	PurchaseDependTemp  bool                    `orm:"default(true)"`                         //根据款式采购，ture一个供应商可以供应所有的款式
+	Tracking            string                  `orm:"default(none)" json:"Tracking"`         //追踪方式:none/lot/serial
+	PricingMode         string                  `orm:"default(fixed)" json:"PricingMode"`     //定价方式:fixed按价格表/metal按克重和牌价
+	Metal               string                  `orm:"default()" json:"Metal"`                //按克重定价的金属:gold/silver/platinum/palladium
+	Purity              string                  `orm:"default()" json:"Purity"`               //按克重定价的成色，如999、990、750
+	LaborFee            float64                 `orm:"default(0)" json:"LaborFee"`            //工费，每件
 
 	FormAction            string                 `orm:"-" json:"FormAction"`        //非数据库字段，用于表示记录的增加，修改
 	ActionFields          []string               `orm:"-" json:"ActionFields"`      //需要操作的字段,用于update时
@@ -13,25 +13,30 @@ import (
 
 // PurchaseOrder 产品分类
 type PurchaseOrder struct {
-	ID           int64                `orm:"column(id);pk;auto" json:"id"`         //主键
-	CreateUser   *User                `orm:"rel(fk);null" json:"-"`                //创建者
-	UpdateUser   *User                `orm:"rel(fk);null" json:"-"`                //最后更新者
-	CreateDate   time.Time            `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
-	UpdateDate   time.Time            `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
-	Name         string               `orm:"unique" json:"name"`                   //订单号
-	Partner      *Partner             `orm:"rel(fk)"`                              //客户
-	PurchasesMan *User                `orm:"rel(fk)"`                              //业务员
-	Company      *Company             `orm:"rel(fk)"`                              //公司
-	Country      *AddressCountry      `orm:"rel(fk);null" json:"country"`          //国家
-	Province     *AddressProvince     `orm:"rel(fk);null" json:"province"`         //省份
-	City         *AddressCity         `orm:"rel(fk);null" json:"city"`             //城市
-	District     *AddressDistrict     `orm:"rel(fk);null" json:"district"`         //区县
-	Street       string               `orm:"default()" json:"street"`          //街道
-	OrderLine    []*PurchaseOrderLine `orm:"reverse(many)"`                        //订单明细
-	State        *PurchaseOrderState  `orm:"rel(fk)"`                              //订单状态
-
-	FormAction   string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
-	ActionFields []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
+	ID             int64                `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser     *User                `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser     *User                `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate     time.Time            `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate     time.Time            `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	Name           string               `orm:"unique" json:"name"`                   //订单号
+	Partner        *Partner             `orm:"rel(fk)"`                              //客户
+	PurchasesMan   *User                `orm:"rel(fk)"`                              //业务员
+	Company        *Company             `orm:"rel(fk)"`                              //公司
+	Country        *AddressCountry      `orm:"rel(fk);null" json:"country"`          //国家
+	Province       *AddressProvince     `orm:"rel(fk);null" json:"province"`         //省份
+	City           *AddressCity         `orm:"rel(fk);null" json:"city"`             //城市
+	District       *AddressDistrict     `orm:"rel(fk);null" json:"district"`         //区县
+	Street         string               `orm:"default()" json:"street"`              //街道
+	OrderLine      []*PurchaseOrderLine `orm:"reverse(many)"`                        //订单明细
+	State          *PurchaseOrderState  `orm:"rel(fk)"`                              //订单状态
+	StockWarehouse *StockWarehouse      `orm:"rel(fk);null"`                         //收货仓库
+	ReceiptState   string               `orm:"default(draft)" json:"ReceiptState"`   //收货状态:draft/confirm/process/done/cancel
+
+	FormAction       string   `orm:"-" json:"FormAction"`     //非数据库字段，用于表示记录的增加，修改
+	ActionFields     []string `orm:"-" json:"ActionFields"`   //需要操作的字段,用于update时
+	PartnerID        int64    `orm:"-" json:"Partner"`        //供应商
+	CompanyID        int64    `orm:"-" json:"Company"`        //公司
+	StockWarehouseID int64    `orm:"-" json:"StockWarehouse"` //收货仓库
 }
 
 func init() {
@@ -40,11 +45,82 @@ func init() {
 
 // AddPurchaseOrder insert a new PurchaseOrder into database and returns
 // last inserted ID on success.
-func AddPurchaseOrder(obj *PurchaseOrder) (id int64, err error) {
+func AddPurchaseOrder(obj *PurchaseOrder, addUser *User) (id int64, err error) {
 	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return 0, errBegin
+	}
+	if obj.PartnerID > 0 {
+		obj.Partner, _ = GetPartnerByID(obj.PartnerID)
+	}
+	if obj.Partner == nil {
+		return 0, errors.New("采购订单必须指定供应商")
+	}
+	if obj.CompanyID > 0 {
+		obj.Company, _ = GetCompanyByID(obj.CompanyID)
+	}
+	if obj.Company == nil && addUser != nil {
+		obj.Company = addUser.Company
+	}
+	if obj.Company == nil {
+		return 0, errors.New("采购订单必须指定公司")
+	}
+	if obj.StockWarehouseID > 0 {
+		obj.StockWarehouse, _ = GetStockWarehouseByID(obj.StockWarehouseID)
+	}
+	if obj.StockWarehouse == nil {
+		if obj.StockWarehouse, err = purchaseOrderDefaultWarehouse(o, obj.Company); err != nil {
+			return 0, err
+		}
+	}
+	if err = purchaseOrderCheckWarehouse(o, obj); err != nil {
+		return 0, err
+	}
+	if obj.State, err = purchaseOrderDraftState(o); err != nil {
+		return 0, err
+	}
+	if obj.Name, err = GetNextSequece("PurchaseOrder", obj.Company.ID); err != nil {
+		return 0, fmt.Errorf("采购订单序号获取失败:%s", err.Error())
+	}
+	if obj.PurchasesMan == nil {
+		obj.PurchasesMan = addUser
+	}
+	obj.ReceiptState = "draft"
+	obj.CreateUser = addUser
+	obj.UpdateUser = addUser
+	if id, err = o.Insert(obj); err != nil {
+		return 0, err
+	}
+	return id, o.Commit()
+}
+
+// purchaseOrderDefaultWarehouse 获得公司的默认收货仓库，即公司下第一个仓库
+func purchaseOrderDefaultWarehouse(o orm.Ormer, company *Company) (*StockWarehouse, error) {
+	warehouse := new(StockWarehouse)
+	if err := o.QueryTable(warehouse).Filter("Company__Id", company.ID).OrderBy("Id").One(warehouse); err != nil {
+		return nil, fmt.Errorf("公司[%d]没有可用的收货仓库", company.ID)
+	}
+	return warehouse, nil
+}
 
-	id, err = o.Insert(obj)
-	return id, err
+// purchaseOrderCheckWarehouse 收货仓库必须属于订单的公司
+func purchaseOrderCheckWarehouse(o orm.Ormer, order *PurchaseOrder) error {
+	warehouse := order.StockWarehouse
+	if err := o.Read(warehouse); err != nil {
+		return err
+	}
+	if warehouse.Company == nil || warehouse.Company.ID != order.Company.ID {
+		return fmt.Errorf("仓库[%s]不属于采购订单的公司", warehouse.Name)
+	}
+	return nil
 }
 
 // GetPurchaseOrderByID retrieves PurchaseOrder by ID. Returns error if
@@ -53,6 +129,10 @@ func GetPurchaseOrderByID(id int64) (obj *PurchaseOrder, err error) {
 	o := orm.NewOrm()
 	obj = &PurchaseOrder{ID: id}
 	if err = o.Read(obj); err == nil {
+		o.LoadRelated(obj, "Partner")
+		o.LoadRelated(obj, "Company")
+		o.LoadRelated(obj, "StockWarehouse")
+		o.LoadRelated(obj, "OrderLine", true)
 		return obj, nil
 	}
 	return nil, err
@@ -157,17 +237,89 @@ func GetAllPurchaseOrder(query map[string]interface{}, exclude map[string]interf
 
 // UpdatePurchaseOrderByID updates PurchaseOrder by ID and returns error if
 // the record to be updated doesn't exist
-func UpdatePurchaseOrderByID(m *PurchaseOrder) (err error) {
+// 只有草稿订单可以修改供应商、收货仓库和明细单价
+func UpdatePurchaseOrderByID(m *PurchaseOrder, updateUser *User) (err error) {
 	o := orm.NewOrm()
-	v := PurchaseOrder{ID: m.ID}
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return errBegin
+	}
+	order := &PurchaseOrder{ID: m.ID}
 	// ascertain id exists in the database
-	if err = o.Read(&v); err == nil {
-		var num int64
-		if num, err = o.Update(m); err == nil {
-			fmt.Println("Number of records updated in database:", num)
+	if err = o.Read(order); err != nil {
+		return err
+	}
+	if order.ReceiptState != "draft" {
+		return fmt.Errorf("采购订单[%s]已确认，不能修改", order.Name)
+	}
+	fields := []string{"UpdateUser", "UpdateDate"}
+	for _, field := range m.ActionFields {
+		switch field {
+		case "Partner":
+			if order.Partner, err = GetPartnerByID(m.PartnerID); err != nil {
+				return err
+			}
+		case "StockWarehouse":
+			if order.StockWarehouse, err = GetStockWarehouseByID(m.StockWarehouseID); err != nil {
+				return err
+			}
+		case "Street":
+			order.Street = m.Street
+		default:
+			continue
 		}
+		fields = append(fields, field)
 	}
-	return
+	if order.StockWarehouse == nil {
+		if order.StockWarehouse, err = purchaseOrderDefaultWarehouse(o, order.Company); err != nil {
+			return err
+		}
+		fields = append(fields, "StockWarehouse")
+	}
+	if err = purchaseOrderCheckWarehouse(o, order); err != nil {
+		return err
+	}
+	order.UpdateUser = updateUser
+	if _, err = o.Update(order, fields...); err != nil {
+		return err
+	}
+	for _, line := range m.OrderLine {
+		if line.FormAction != "update" {
+			continue
+		}
+		if err = purchaseOrderLineUpdatePrice(o, order, line.ID, line.PriceUnit, updateUser); err != nil {
+			return err
+		}
+	}
+	return o.Commit()
+}
+
+// purchaseOrderLineUpdatePrice 修改草稿订单明细的单价
+func purchaseOrderLineUpdatePrice(o orm.Ormer, order *PurchaseOrder, lineID int64, price float32, user *User) error {
+	line := &PurchaseOrderLine{ID: lineID}
+	if err := o.Read(line); err != nil {
+		return err
+	}
+	if line.PurchaseOrder == nil || line.PurchaseOrder.ID != order.ID {
+		return fmt.Errorf("订单明细[%d]不属于采购订单[%s]", lineID, order.Name)
+	}
+	if line.State != "draft" {
+		return fmt.Errorf("订单明细[%s]已确认，不能修改单价", line.Name)
+	}
+	if price < 0 {
+		return errors.New("单价不能小于0")
+	}
+	line.PriceUnit = price
+	line.UpdateUser = user
+	_, err := o.Update(line, "PriceUnit", "UpdateUser", "UpdateDate")
+	return err
 }
 
 // GetPurchaseOrderByName retrieves PurchaseOrder by Name. Returns error if
@@ -195,3 +347,173 @@ func DeletePurchaseOrder(id int64) (err error) {
 	}
 	return
 }
+
+// purchaseOrderUpdateReceiptState 根据订单明细状态更新收货状态，全部收货后关闭订单
+func purchaseOrderUpdateReceiptState(o orm.Ormer, order *PurchaseOrder, user *User) error {
+	if err := o.Read(order); err != nil {
+		return err
+	}
+	var lines []*PurchaseOrderLine
+	if _, err := o.QueryTable(new(PurchaseOrderLine)).Filter("PurchaseOrder__Id", order.ID).All(&lines, "Id", "State"); err != nil {
+		return err
+	}
+	var doneCount, cancelCount, processCount int
+	for _, line := range lines {
+		switch line.State {
+		case "done":
+			doneCount++
+		case "cancel":
+			cancelCount++
+		case "process":
+			processCount++
+		}
+	}
+	state := "confirm"
+	switch {
+	case len(lines) > 0 && cancelCount == len(lines):
+		state = "cancel"
+	case len(lines) > 0 && doneCount+cancelCount == len(lines):
+		state = "done"
+	case doneCount > 0 || processCount > 0:
+		state = "process"
+	}
+	order.ReceiptState = state
+	order.UpdateUser = user
+	_, err := o.Update(order, "ReceiptState", "UpdateUser", "UpdateDate")
+	return err
+}
+
+// ConfirmPurchaseOrder 确认采购订单，根据仓库的收货分拣类型生成入库单，每个订单明细生成一个库存移动
+func ConfirmPurchaseOrder(id int64, user *User) (pickingID int64, err error) {
+	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return 0, errBegin
+	}
+	order := &PurchaseOrder{ID: id}
+	if err = o.Read(order); err != nil {
+		return 0, err
+	}
+	if order.ReceiptState != "draft" {
+		return 0, fmt.Errorf("采购订单[%s]已确认", order.Name)
+	}
+	var lines []*PurchaseOrderLine
+	if _, err = o.QueryTable(new(PurchaseOrderLine)).Filter("PurchaseOrder__Id", order.ID).OrderBy("Id").All(&lines); err != nil {
+		return 0, err
+	}
+	if len(lines) == 0 {
+		return 0, fmt.Errorf("采购订单[%s]没有订单明细", order.Name)
+	}
+	if order.StockWarehouse == nil {
+		return 0, fmt.Errorf("采购订单[%s]没有设置收货仓库", order.Name)
+	}
+	warehouse := order.StockWarehouse
+	if err = o.Read(warehouse); err != nil {
+		return 0, err
+	}
+	if warehouse.Location == nil {
+		return 0, fmt.Errorf("仓库[%s]没有设置库位", warehouse.Name)
+	}
+	var (
+		pickingType  *StockPickingType
+		supplierLoc  *StockLocation
+		locationDest *StockLocation
+		pickingName  string
+		now          = time.Now()
+		moveSequence int64
+	)
+	if pickingType, err = stockPickingTypeByWarehouse(o, warehouse, "incoming"); err != nil {
+		return 0, err
+	}
+	if supplierLoc, err = stockLocationByUsage(o, "supplier", order.Company); err != nil {
+		return 0, err
+	}
+	// 多步收货时先收到分拣类型的默认目标库位，如收货->质检->入库
+	locationDest = warehouse.Location
+	if pickingType.DefaultLocationDest != nil {
+		locationDest = pickingType.DefaultLocationDest
+	}
+	if pickingName, err = stockPickingNextName(o, pickingType, order.Company); err != nil {
+		return 0, err
+	}
+	picking := &StockPicking{
+		Name:          pickingName,
+		Origin:        order.Name,
+		MoveType:      "partial",
+		State:         "confirm",
+		Company:       order.Company,
+		LocationSrc:   supplierLoc,
+		LocationDest:  locationDest,
+		Partner:       order.Partner,
+		PickingType:   pickingType,
+		PurchaseOrder: order,
+		CreateUser:    user,
+		UpdateUser:    user,
+	}
+	if pickingID, err = o.Insert(picking); err != nil {
+		return 0, err
+	}
+	for _, line := range lines {
+		product := &ProductProduct{ID: line.Product.ID}
+		if err = o.Read(product); err != nil {
+			return 0, err
+		}
+		moveSequence++
+		move := &StockMove{
+			Sequence:          moveSequence,
+			Name:              line.Name,
+			Date:              now,
+			DateExpected:      now,
+			Product:           product,
+			ProductTemplate:   product.ProductTemplate,
+			FirstUomQty:       float64(line.FirstPurchaseQty),
+			SecondUomQty:      float64(line.SecondPurchaseQty),
+			FirstUom:          line.FirstPurchaseUom,
+			SecondUom:         line.SecondPurchaseUom,
+			LocationSrc:       supplierLoc,
+			LocationDest:      locationDest,
+			Partner:           order.Partner,
+			Picking:           picking,
+			State:             "confirm",
+			PriceUnit:         float64(line.PriceUnit),
+			Company:           order.Company,
+			Origin:            order.Name,
+			ProcureMethod:     "make_to_stock",
+			WareHouse:         warehouse,
+			PurchaseOrderLine: line,
+			CreateUser:        user,
+			UpdateUser:        user,
+		}
+		if move.Name == "" {
+			move.Name = product.Name
+		}
+		if _, err = o.Insert(move); err != nil {
+			return 0, err
+		}
+		line.State = "confirm"
+		line.UpdateUser = user
+		if _, err = o.Update(line, "State", "UpdateUser", "UpdateDate"); err != nil {
+			return 0, err
+		}
+		// 供应商库位不需要保留，直接可用
+		if err = stockMoveAssign(o, move, user); err != nil {
+			return 0, err
+		}
+	}
+	order.ReceiptState = "confirm"
+	order.UpdateUser = user
+	if _, err = o.Update(order, "ReceiptState", "UpdateUser", "UpdateDate"); err != nil {
+		return 0, err
+	}
+	if err = stockPickingUpdateState(o, picking, user); err != nil {
+		return 0, err
+	}
+	return pickingID, o.Commit()
+}
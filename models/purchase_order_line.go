@@ -27,6 +27,9 @@ type PurchaseOrderLine struct {
 	SecondPurchaseUom *ProductUom     `orm:"rel(fk)"`                              //第二销售单位
 	FirstPurchaseQty  float32         `orm:"default(1)"`                           //第一销售单位
 	SecondPurchaseQty float32         `orm:"default(0)"`                           //第二销售单位
+	FirstReceivedQty  float32         `orm:"default(0)"`                           //第一单位已收货数量
+	SecondReceivedQty float32         `orm:"default(0)"`                           //第二单位已收货数量
+	PriceUnit         float32         `orm:"default(0)" json:"PriceUnit"`          //单价
 	State             string          `orm:"default(draft)"`                       //订单明细状态draft/confirm/process/done/cancel
 
 	FormAction   string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
@@ -193,3 +196,56 @@ func DeletePurchaseOrderLine(id int64) (err error) {
 	}
 	return
 }
+
+// purchaseOrderLineUpdateState 根据库存移动更新采购订单明细的已收货数量和状态
+func purchaseOrderLineUpdateState(o orm.Ormer, line *PurchaseOrderLine, user *User) error {
+	if err := o.Read(line); err != nil {
+		return err
+	}
+	var moves []*StockMove
+	if _, err := o.QueryTable(new(StockMove)).RelatedSel("LocationSrc", "LocationDest").Filter("PurchaseOrderLine__Id", line.ID).All(&moves); err != nil {
+		return err
+	}
+	var (
+		firstReceived  float64
+		secondReceived float64
+		doneCount      int
+		cancelCount    int
+	)
+	for _, move := range moves {
+		switch move.State {
+		case "done":
+			doneCount++
+			// 公司间调拨从中转库位收货
+			if move.LocationSrc != nil && (move.LocationSrc.Usage == "supplier" || move.LocationSrc.Usage == "transit") {
+				firstReceived += move.FirstUomQty
+				secondReceived += move.SecondUomQty
+			} else if move.LocationDest != nil && (move.LocationDest.Usage == "supplier" || move.LocationDest.Usage == "transit") {
+				firstReceived -= move.FirstUomQty
+				secondReceived -= move.SecondUomQty
+			}
+		case "cancel":
+			cancelCount++
+		}
+	}
+	state := "confirm"
+	switch {
+	case len(moves) > 0 && cancelCount == len(moves):
+		state = "cancel"
+	case len(moves) > 0 && doneCount+cancelCount == len(moves):
+		state = "done"
+	case doneCount > 0:
+		state = "process"
+	}
+	line.FirstReceivedQty = float32(firstReceived)
+	line.SecondReceivedQty = float32(secondReceived)
+	line.State = state
+	line.UpdateUser = user
+	if _, err := o.Update(line, "FirstReceivedQty", "SecondReceivedQty", "State", "UpdateUser", "UpdateDate"); err != nil {
+		return err
+	}
+	if line.PurchaseOrder != nil {
+		return purchaseOrderUpdateReceiptState(o, line.PurchaseOrder, user)
+	}
+	return nil
+}
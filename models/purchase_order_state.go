@@ -187,3 +187,16 @@ func DeletePurchaseOrderState(id int64) (err error) {
 	}
 	return
 }
+
+// purchaseOrderDraftState 获得新建采购订单使用的草稿状态，优先按名称匹配，没有时取第一个有效状态
+func purchaseOrderDraftState(o orm.Ormer) (*PurchaseOrderState, error) {
+	state := new(PurchaseOrderState)
+	qs := o.QueryTable(state).Filter("Active", true)
+	if err := qs.Filter("Name__in", "draft", "草稿").OrderBy("Id").One(state); err == nil {
+		return state, nil
+	}
+	if err := qs.OrderBy("Id").One(state); err != nil {
+		return nil, errors.New("没有可用的采购订单状态")
+	}
+	return state, nil
+}
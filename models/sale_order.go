@@ -14,24 +14,25 @@ import (
 
 // SaleOrder 产品分类
 type SaleOrder struct {
-	ID             int64            `orm:"column(id);pk;auto" json:"id"`         //主键
-	CreateUser     *User            `orm:"rel(fk);null" json:"-"`                //创建者
-	UpdateUser     *User            `orm:"rel(fk);null" json:"-"`                //最后更新者
-	CreateDate     time.Time        `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
-	UpdateDate     time.Time        `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
-	Name           string           `orm:"unique" json:"name"`                   //订单号
-	Partner        *Partner         `orm:"rel(fk)"`                              //客户
-	SalesMan       *User            `orm:"rel(fk)"`                              //业务员
-	Company        *Company         `orm:"rel(fk)"`                              //公司
-	Country        *AddressCountry  `orm:"rel(fk);null" json:"-"`                //国家
-	Province       *AddressProvince `orm:"rel(fk);null" json:"-"`                //省份
-	City           *AddressCity     `orm:"rel(fk);null" json:"-"`                //城市
-	District       *AddressDistrict `orm:"rel(fk);null" json:"-"`                //区县
-	Street         string           `orm:"default()" json:"Street"`              //街道
-	OrderLine      []*SaleOrderLine `orm:"reverse(many)"`                        //订单明细
-	State          *SaleOrderState  `orm:"rel(fk)"`                              //订单状态
-	StockWarehouse *StockWarehouse  `orm:"rel(fk)"`                              //仓库
-	PickingPolicy  string           `orm:"default(one)" json:"PickingPolicy"`    //发货策略one/mult
+	ID             int64             `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser     *User             `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser     *User             `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate     time.Time         `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate     time.Time         `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	Name           string            `orm:"unique" json:"name"`                   //订单号
+	Partner        *Partner          `orm:"rel(fk)"`                              //客户
+	SalesMan       *User             `orm:"rel(fk)"`                              //业务员
+	Company        *Company          `orm:"rel(fk)"`                              //公司
+	Country        *AddressCountry   `orm:"rel(fk);null" json:"-"`                //国家
+	Province       *AddressProvince  `orm:"rel(fk);null" json:"-"`                //省份
+	City           *AddressCity      `orm:"rel(fk);null" json:"-"`                //城市
+	District       *AddressDistrict  `orm:"rel(fk);null" json:"-"`                //区县
+	Street         string            `orm:"default()" json:"Street"`              //街道
+	OrderLine      []*SaleOrderLine  `orm:"reverse(many)"`                        //订单明细
+	State          *SaleOrderState   `orm:"rel(fk)"`                              //订单状态
+	StockWarehouse *StockWarehouse   `orm:"rel(fk)"`                              //仓库
+	PickingPolicy  string            `orm:"default(one)" json:"PickingPolicy"`    //发货策略one/mult
+	Pricelist      *ProductPriceList `orm:"rel(fk);null"`                         //价格表，默认为客户的价格表
 
 	FormAction       string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
 	ActionFields     []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
@@ -43,6 +44,7 @@ type SaleOrder struct {
 	CityID           int64    `orm:"-" json:"City"`
 	DistrictID       int64    `orm:"-" json:"District"`
 	StockWarehouseID int64    `orm:"-" json:"StockWarehouse"`
+	PricelistID      int64    `orm:"-" json:"Pricelist"`
 }
 
 func init() {
@@ -95,15 +97,26 @@ func AddSaleOrder(obj *SaleOrder, addUser *User) (id int64, err error) {
 	if obj.PartnerID > 0 {
 		obj.Partner, _ = GetPartnerByID(obj.PartnerID)
 	}
+	if obj.PricelistID > 0 {
+		obj.Pricelist, _ = GetProductPriceListByID(obj.PricelistID)
+	} else if obj.Partner != nil && obj.Partner.Pricelist != nil {
+		obj.Pricelist = obj.Partner.Pricelist
+	}
 	// 获得款式产品编码
 	obj.Name, _ = GetNextSequece(reflect.Indirect(reflect.ValueOf(obj)).Type().Name(), obj.Company.ID)
-	id, err = o.Insert(obj)
-	if err == nil {
-		errCommit := o.Commit()
-		if errCommit != nil {
-			return 0, errCommit
+	if id, err = o.Insert(obj); err != nil {
+		return 0, err
+	}
+	// 记录订单的初始状态
+	if obj.State != nil {
+		if err = saleOrderStateLogCreate(o, obj, nil, obj.State, "advance", addUser, ""); err != nil {
+			return 0, err
 		}
 	}
+	errCommit := o.Commit()
+	if errCommit != nil {
+		return 0, errCommit
+	}
 	return id, err
 }
 
@@ -137,6 +150,9 @@ func GetSaleOrderByID(id int64) (obj *SaleOrder, err error) {
 		if obj.State != nil {
 			o.Read(obj.State)
 		}
+		if obj.Pricelist != nil {
+			o.Read(obj.Pricelist)
+		}
 		return obj, nil
 	}
 	return nil, err
@@ -241,17 +257,57 @@ func GetAllSaleOrder(query map[string]interface{}, exclude map[string]interface{
 
 // UpdateSaleOrderByID updates SaleOrder by ID and returns error if
 // the record to be updated doesn't exist
+// 更换客户时价格表改为新客户的价格表，价格表变化后重新计算草稿明细的单价
 func UpdateSaleOrderByID(m *SaleOrder) (err error) {
 	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return errBegin
+	}
 	v := SaleOrder{ID: m.ID}
 	// ascertain id exists in the database
-	if err = o.Read(&v); err == nil {
-		var num int64
-		if num, err = o.Update(m); err == nil {
-			fmt.Println("Number of records updated in database:", num)
+	if err = o.Read(&v); err != nil {
+		return err
+	}
+	// 订单状态只能通过推进和退回改变
+	m.State = v.State
+	if m.PartnerID > 0 {
+		m.Partner = &Partner{ID: m.PartnerID}
+	}
+	if m.PricelistID > 0 {
+		m.Pricelist = &ProductPriceList{ID: m.PricelistID}
+	}
+	pricelistID := func(order *SaleOrder) int64 {
+		if order.Pricelist == nil {
+			return 0
 		}
+		return order.Pricelist.ID
 	}
-	return
+	if m.Partner != nil && v.Partner != nil && m.Partner.ID != v.Partner.ID && pricelistID(m) == pricelistID(&v) {
+		partner := &Partner{ID: m.Partner.ID}
+		if err = o.Read(partner); err != nil {
+			return err
+		}
+		m.Pricelist = partner.Pricelist
+	}
+	var num int64
+	if num, err = o.Update(m); err != nil {
+		return err
+	}
+	fmt.Println("Number of records updated in database:", num)
+	if pricelistID(m) != pricelistID(&v) {
+		if err = saleOrderLinesReprice(o, m, m.UpdateUser); err != nil {
+			return err
+		}
+	}
+	return o.Commit()
 }
 
 // GetSaleOrderByName retrieves SaleOrder by Name. Returns error if
@@ -279,3 +335,137 @@ func DeleteSaleOrder(id int64) (err error) {
 	}
 	return
 }
+
+// saleOrderConfirm 确认销售订单，按订单明细生成出库分拣和库存移动并预留库存
+func saleOrderConfirm(o orm.Ormer, order *SaleOrder, user *User) (pickingID int64, err error) {
+	var lines []*SaleOrderLine
+	if _, err = o.QueryTable(new(SaleOrderLine)).Filter("SaleOrder__Id", order.ID).OrderBy("Id").All(&lines); err != nil {
+		return 0, err
+	}
+	if len(lines) == 0 {
+		return 0, fmt.Errorf("销售订单[%s]没有订单明细", order.Name)
+	}
+	for _, line := range lines {
+		if line.State != "draft" {
+			return 0, fmt.Errorf("销售订单[%s]已确认", order.Name)
+		}
+	}
+	if order.StockWarehouse == nil {
+		return 0, fmt.Errorf("销售订单[%s]没有设置仓库", order.Name)
+	}
+	warehouse := order.StockWarehouse
+	if err = o.Read(warehouse); err != nil {
+		return 0, err
+	}
+	if warehouse.Location == nil {
+		return 0, fmt.Errorf("仓库[%s]没有设置库位", warehouse.Name)
+	}
+	var (
+		pickingType  *StockPickingType
+		customerLoc  *StockLocation
+		locationSrc  *StockLocation
+		locationDest *StockLocation
+		pickingName  string
+		moveType     = "partial"
+		now          = time.Now()
+		moveSequence int64
+	)
+	if pickingType, err = stockPickingTypeByWarehouse(o, warehouse, "outgoing"); err != nil {
+		return 0, err
+	}
+	if customerLoc, err = stockLocationByUsage(o, "customer", order.Company); err != nil {
+		return 0, err
+	}
+	// 多步出货时从流程的第一步开始，如拣货->打包->发货
+	if pickingType, err = stockPickingTypeChainStart(o, pickingType); err != nil {
+		return 0, err
+	}
+	locationSrc = warehouse.Location
+	if pickingType.DefaultLocationSrc != nil {
+		locationSrc = pickingType.DefaultLocationSrc
+	}
+	if pickingType.DefaultLocationDest != nil {
+		locationDest = pickingType.DefaultLocationDest
+	} else if pickingType.Code == "outgoing" {
+		locationDest = customerLoc
+	} else {
+		return 0, fmt.Errorf("分拣类型[%s]没有设置默认目标库位", pickingType.Name)
+	}
+	if pickingName, err = stockPickingNextName(o, pickingType, order.Company); err != nil {
+		return 0, err
+	}
+	if order.PickingPolicy == "one" {
+		moveType = "one"
+	}
+	picking := &StockPicking{
+		Name:         pickingName,
+		Origin:       order.Name,
+		MoveType:     moveType,
+		State:        "confirm",
+		Company:      order.Company,
+		LocationSrc:  locationSrc,
+		LocationDest: locationDest,
+		Partner:      order.Partner,
+		PickingType:  pickingType,
+		SaleOrder:    order,
+		CreateUser:   user,
+		UpdateUser:   user,
+	}
+	if pickingID, err = o.Insert(picking); err != nil {
+		return 0, err
+	}
+	for _, line := range lines {
+		product := &ProductProduct{ID: line.Product.ID}
+		if err = o.Read(product); err != nil {
+			return 0, err
+		}
+		// 按克重计价的明细以确认时的牌价为准，之后牌价变动不再影响
+		if _, err = saleOrderLineMetalReprice(o, line, now, user); err != nil {
+			return 0, err
+		}
+		moveSequence++
+		move := &StockMove{
+			Sequence:        moveSequence,
+			Name:            line.ProductName,
+			Date:            now,
+			DateExpected:    now,
+			Product:         product,
+			ProductTemplate: product.ProductTemplate,
+			FirstUomQty:     float64(line.FirstSaleQty),
+			SecondUomQty:    float64(line.SecondSaleQty),
+			FirstUom:        line.FirstSaleUom,
+			SecondUom:       line.SecondSaleUom,
+			LocationSrc:     locationSrc,
+			LocationDest:    locationDest,
+			Partner:         order.Partner,
+			Picking:         picking,
+			State:           "confirm",
+			PriceUnit:       float64(line.PriceUnit),
+			Company:         order.Company,
+			Origin:          order.Name,
+			ProcureMethod:   "make_to_stock",
+			WareHouse:       warehouse,
+			SaleOrderLine:   line,
+			CreateUser:      user,
+			UpdateUser:      user,
+		}
+		if move.Name == "" {
+			move.Name = product.Name
+		}
+		if _, err = o.Insert(move); err != nil {
+			return 0, err
+		}
+		line.State = "confirm"
+		line.UpdateUser = user
+		if _, err = o.Update(line, "State", "UpdateUser", "UpdateDate"); err != nil {
+			return 0, err
+		}
+		if err = stockMoveAssign(o, move, user); err != nil {
+			return 0, err
+		}
+	}
+	if err = stockPickingUpdateState(o, picking, user); err != nil {
+		return 0, err
+	}
+	return pickingID, nil
+}
@@ -13,25 +13,30 @@ import (
 
 // SaleOrderLine 订单明细
 type SaleOrderLine struct {
-	ID            int64           `orm:"column(id);pk;auto" json:"id"`         //主键
-	CreateUser    *User           `orm:"rel(fk);null" json:"-"`                //创建者
-	UpdateUser    *User           `orm:"rel(fk);null" json:"-"`                //最后更新者
-	CreateDate    time.Time       `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
-	UpdateDate    time.Time       `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
-	Name          string          `orm:"default()" json:"Name"`                //订单明细号
-	Company       *Company        `orm:"rel(fk)"`                              //公司
-	SaleOrder     *SaleOrder      `orm:"rel(fk)"`                              //销售订单
-	Partner       *Partner        `orm:"rel(fk)"`                              //客户
-	Product       *ProductProduct `orm:"rel(fk)"`                              //产品
-	ProductName   string          `json:"ProductName"`                         //产品名称
-	ProductCode   string          `json:"ProductCode"`                         //产品编码
-	FirstSaleUom  *ProductUom     `orm:"rel(fk)"`                              //第一销售单位
-	SecondSaleUom *ProductUom     `orm:"rel(fk);null"`                         //第二销售单位
-	FirstSaleQty  float32         `orm:"default(1)" json:"FirstSaleQty"`       //第一销售单位
-	SecondSaleQty float32         `orm:"default(0)" json:"SecondSaleQty"`      //第二销售单位
-	State         string          `orm:"default(draft)"`                       //订单明细状态:draft/confirm/process/done/cancel
-	PriceUnit     float32         `orm:"default(0)" json:"PriceUnit"`          //单价
-	Total         float32         `orm:"default(0)" json:"Total"`              //小计
+	ID                 int64           `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser         *User           `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser         *User           `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate         time.Time       `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate         time.Time       `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	Name               string          `orm:"default()" json:"Name"`                //订单明细号
+	Company            *Company        `orm:"rel(fk)"`                              //公司
+	SaleOrder          *SaleOrder      `orm:"rel(fk)"`                              //销售订单
+	Partner            *Partner        `orm:"rel(fk)"`                              //客户
+	Product            *ProductProduct `orm:"rel(fk)"`                              //产品
+	ProductName        string          `json:"ProductName"`                         //产品名称
+	ProductCode        string          `json:"ProductCode"`                         //产品编码
+	FirstSaleUom       *ProductUom     `orm:"rel(fk)"`                              //第一销售单位
+	SecondSaleUom      *ProductUom     `orm:"rel(fk);null"`                         //第二销售单位
+	FirstSaleQty       float32         `orm:"default(1)" json:"FirstSaleQty"`       //第一销售单位
+	SecondSaleQty      float32         `orm:"default(0)" json:"SecondSaleQty"`      //第二销售单位
+	FirstDeliveredQty  float32         `orm:"default(0)" json:"FirstDeliveredQty"`  //第一单位已发货数量
+	SecondDeliveredQty float32         `orm:"default(0)" json:"SecondDeliveredQty"` //第二单位已发货数量
+	State              string          `orm:"default(draft)"`                       //订单明细状态:draft/confirm/process/done/cancel
+	PriceUnit          float32         `orm:"default(0)" json:"PriceUnit"`          //单价
+	Total              float32         `orm:"default(0)" json:"Total"`              //小计
+	MetalRate          float32         `orm:"default(0)" json:"MetalRate"`          //按克重计价时的每克牌价，订单确认后不再变动
+	LaborFee           float32         `orm:"default(0)" json:"LaborFee"`           //按克重计价时的每件工费，包括属性额外价格
+	PriceManual        bool            `orm:"default(false)" json:"PriceManual"`    //单价为手工填写，不再按价格表或牌价重新计算
 
 	FormAction   string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
 	ActionFields []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
@@ -48,10 +53,202 @@ func init() {
 // last inserted ID on success.
 func AddSaleOrderLine(obj *SaleOrderLine) (id int64, err error) {
 	o := orm.NewOrm()
+	if obj.SaleOrderID > 0 {
+		obj.SaleOrder = &SaleOrder{ID: obj.SaleOrderID}
+	}
+	if obj.ProductID > 0 {
+		obj.Product = &ProductProduct{ID: obj.ProductID}
+	}
+	if obj.CompanyID > 0 {
+		obj.Company, _ = GetCompanyByID(obj.CompanyID)
+	}
+	if err = saleOrderLinePrepare(o, obj); err != nil {
+		return 0, err
+	}
 	id, err = o.Insert(obj)
 	return id, err
 }
 
+// saleOrderLinePrepare 根据销售订单和产品补全订单明细，没有填写单价时按订单的价格表计算单价，填写了单价时记为手工单价
+func saleOrderLinePrepare(o orm.Ormer, obj *SaleOrderLine) error {
+	if obj.SaleOrder == nil || obj.Product == nil {
+		return errors.New("订单明细必须指定销售订单和产品")
+	}
+	order := obj.SaleOrder
+	if err := o.Read(order); err != nil {
+		return err
+	}
+	product := obj.Product
+	if err := o.Read(product); err != nil {
+		return err
+	}
+	if obj.Company == nil {
+		obj.Company = order.Company
+	}
+	if obj.Partner == nil {
+		obj.Partner = order.Partner
+	}
+	if obj.ProductName == "" {
+		obj.ProductName = product.Name
+	}
+	if obj.ProductCode == "" {
+		obj.ProductCode = product.DefaultCode
+	}
+	if obj.FirstSaleUom == nil {
+		obj.FirstSaleUom = product.FirstSaleUom
+	}
+	if obj.SecondSaleUom == nil {
+		obj.SecondSaleUom = product.SecondSaleUom
+	}
+	if obj.PriceUnit == 0 {
+		obj.PriceManual = false
+		return saleOrderLinePrice(o, obj, order, product)
+	}
+	obj.PriceManual = true
+	obj.MetalRate = 0
+	obj.Total = obj.PriceUnit * obj.FirstSaleQty
+	return nil
+}
+
+// saleOrderLinePrice 按订单的价格表或当前牌价计算订单明细的单价和小计
+func saleOrderLinePrice(o orm.Ormer, obj *SaleOrderLine, order *SaleOrder, product *ProductProduct) error {
+	template := &ProductTemplate{ID: product.ProductTemplate.ID}
+	if err := o.Read(template); err != nil {
+		return err
+	}
+	if template.PricingMode == "metal" {
+		return saleOrderLineMetalPrice(o, obj, template, product, time.Now())
+	}
+	var pricelistID int64
+	if order.Pricelist != nil {
+		pricelistID = order.Pricelist.ID
+	}
+	price, err := productPricelistPrice(o, pricelistID, product, float64(obj.FirstSaleQty), order.CreateDate, 0)
+	if err != nil {
+		return err
+	}
+	obj.PriceUnit = float32(price)
+	obj.MetalRate = 0
+	obj.LaborFee = 0
+	obj.Total = obj.PriceUnit * obj.FirstSaleQty
+	return nil
+}
+
+// saleOrderLinesReprice 订单的客户或价格表变化后重新计算草稿订单明细的单价，手工单价的明细不处理
+func saleOrderLinesReprice(o orm.Ormer, order *SaleOrder, user *User) error {
+	var lines []*SaleOrderLine
+	if _, err := o.QueryTable(new(SaleOrderLine)).Filter("SaleOrder__Id", order.ID).Filter("State", "draft").Filter("PriceManual", false).All(&lines); err != nil {
+		return err
+	}
+	for _, line := range lines {
+		product := &ProductProduct{ID: line.Product.ID}
+		if err := o.Read(product); err != nil {
+			return err
+		}
+		if err := saleOrderLinePrice(o, line, order, product); err != nil {
+			return err
+		}
+		line.UpdateUser = user
+		if _, err := o.Update(line, "MetalRate", "LaborFee", "PriceUnit", "Total", "UpdateUser", "UpdateDate"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saleOrderLineMetalPrice 按克重计价：小计为第二单位数量(克重)乘以牌价，加上第一单位数量(件数)乘以工费和属性额外价格，
+// 单价为小计除以件数
+func saleOrderLineMetalPrice(o orm.Ormer, line *SaleOrderLine, template *ProductTemplate, product *ProductProduct, at time.Time) error {
+	rate, err := productMetalRateAt(o, template.Metal, template.Purity, at)
+	if err != nil {
+		return err
+	}
+	extra, err := productAttributeExtra(o, template, product)
+	if err != nil {
+		return err
+	}
+	laborFee := template.LaborFee + extra
+	total := float64(line.SecondSaleQty)*rate.PricePerGram + float64(line.FirstSaleQty)*laborFee
+	line.MetalRate = float32(rate.PricePerGram)
+	line.LaborFee = float32(laborFee)
+	line.Total = float32(total)
+	line.PriceUnit = 0
+	if line.FirstSaleQty > 0 {
+		line.PriceUnit = float32(total / float64(line.FirstSaleQty))
+	}
+	return nil
+}
+
+// saleOrderLineMetalReprice 按指定时间的牌价重新计算按克重计价的订单明细，手工填写单价的明细不处理
+func saleOrderLineMetalReprice(o orm.Ormer, line *SaleOrderLine, at time.Time, user *User) (changed bool, err error) {
+	if line.MetalRate == 0 || line.PriceManual {
+		return false, nil
+	}
+	product := &ProductProduct{ID: line.Product.ID}
+	if err = o.Read(product); err != nil {
+		return false, err
+	}
+	template := &ProductTemplate{ID: product.ProductTemplate.ID}
+	if err = o.Read(template); err != nil {
+		return false, err
+	}
+	if template.PricingMode != "metal" {
+		return false, nil
+	}
+	metalRate, priceUnit, total := line.MetalRate, line.PriceUnit, line.Total
+	if err = saleOrderLineMetalPrice(o, line, template, product, at); err != nil {
+		return false, err
+	}
+	if line.MetalRate == metalRate && line.PriceUnit == priceUnit && line.Total == total {
+		return false, nil
+	}
+	line.UpdateUser = user
+	_, err = o.Update(line, "MetalRate", "LaborFee", "PriceUnit", "Total", "UpdateUser", "UpdateDate")
+	return err == nil, err
+}
+
+// saleOrderLinesMetalReprice 牌价变动后重新计算金属和成色相同的草稿订单明细
+func saleOrderLinesMetalReprice(o orm.Ormer, metal, purity string, at time.Time, user *User) (num int64, err error) {
+	qs := o.QueryTable(new(SaleOrderLine)).Filter("State", "draft").Filter("MetalRate__gt", 0).Filter("PriceManual", false)
+	if metal != "" {
+		qs = qs.Filter("Product__ProductTemplate__Metal", metal).Filter("Product__ProductTemplate__Purity", purity)
+	}
+	var lines []*SaleOrderLine
+	if _, err = qs.Limit(-1).All(&lines); err != nil {
+		return 0, err
+	}
+	for _, line := range lines {
+		var changed bool
+		if changed, err = saleOrderLineMetalReprice(o, line, at, user); err != nil {
+			return 0, err
+		}
+		if changed {
+			num++
+		}
+	}
+	return num, nil
+}
+
+// RepriceSaleOrderLines 按当前生效的牌价重新计算所有按克重计价的草稿订单明细，用于预设生效时间的牌价到期后
+func RepriceSaleOrderLines(user *User) (num int64, err error) {
+	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return 0, errBegin
+	}
+	if num, err = saleOrderLinesMetalReprice(o, "", "", time.Now(), user); err != nil {
+		return 0, err
+	}
+	return num, o.Commit()
+}
+
 // GetSaleOrderLineByID retrieves SaleOrderLine by ID. Returns error if
 // ID doesn't exist
 func GetSaleOrderLineByID(id int64) (obj *SaleOrderLine, err error) {
@@ -162,17 +359,57 @@ func GetAllSaleOrderLine(query map[string]interface{}, exclude map[string]interf
 
 // UpdateSaleOrderLineByID updates SaleOrderLine by ID and returns error if
 // the record to be updated doesn't exist
+// 草稿明细修改了单价时记为手工单价，否则数量或产品变化后重新计算单价
 func UpdateSaleOrderLineByID(m *SaleOrderLine) (err error) {
 	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return errBegin
+	}
 	v := SaleOrderLine{ID: m.ID}
 	// ascertain id exists in the database
-	if err = o.Read(&v); err == nil {
-		var num int64
-		if num, err = o.Update(m); err == nil {
-			fmt.Println("Number of records updated in database:", num)
+	if err = o.Read(&v); err != nil {
+		return err
+	}
+	if m.ProductID > 0 {
+		m.Product = &ProductProduct{ID: m.ProductID}
+	}
+	if v.State == "draft" && m.Product != nil && m.SaleOrder != nil {
+		changed := m.FirstSaleQty != v.FirstSaleQty || m.SecondSaleQty != v.SecondSaleQty ||
+			v.Product == nil || m.Product.ID != v.Product.ID
+		if m.PriceUnit != v.PriceUnit {
+			m.PriceManual = true
+			m.MetalRate = 0
+			m.Total = m.PriceUnit * m.FirstSaleQty
+		} else if !m.PriceManual && (changed || v.PriceManual) {
+			order := &SaleOrder{ID: m.SaleOrder.ID}
+			if err = o.Read(order); err != nil {
+				return err
+			}
+			product := &ProductProduct{ID: m.Product.ID}
+			if err = o.Read(product); err != nil {
+				return err
+			}
+			if err = saleOrderLinePrice(o, m, order, product); err != nil {
+				return err
+			}
+		} else if m.MetalRate == 0 {
+			m.Total = m.PriceUnit * m.FirstSaleQty
 		}
 	}
-	return
+	var num int64
+	if num, err = o.Update(m); err != nil {
+		return err
+	}
+	fmt.Println("Number of records updated in database:", num)
+	return o.Commit()
 }
 
 // GetSaleOrderLineByName retrieves SaleOrderLine by Name. Returns error if
@@ -200,3 +437,58 @@ func DeleteSaleOrderLine(id int64) (err error) {
 	}
 	return
 }
+
+// saleOrderLineUpdateState 根据库存移动更新销售订单明细的已发货数量和状态
+func saleOrderLineUpdateState(o orm.Ormer, line *SaleOrderLine, user *User) error {
+	if err := o.Read(line); err != nil {
+		return err
+	}
+	var moves []*StockMove
+	if _, err := o.QueryTable(new(StockMove)).RelatedSel("LocationSrc", "LocationDest").Filter("SaleOrderLine__Id", line.ID).All(&moves); err != nil {
+		return err
+	}
+	var (
+		firstDelivered  float64
+		secondDelivered float64
+		doneCount       int
+		cancelCount     int
+		processCount    int
+	)
+	for _, move := range moves {
+		switch move.State {
+		case "done":
+			doneCount++
+			// 公司间调拨发到中转库位即为已发货
+			if move.LocationDest != nil && (move.LocationDest.Usage == "customer" || move.LocationDest.Usage == "transit") {
+				firstDelivered += move.FirstUomQty
+				secondDelivered += move.SecondUomQty
+			} else if move.LocationSrc != nil && (move.LocationSrc.Usage == "customer" || move.LocationSrc.Usage == "transit") {
+				firstDelivered -= move.FirstUomQty
+				secondDelivered -= move.SecondUomQty
+			}
+		case "cancel":
+			cancelCount++
+		case "assigned":
+			processCount++
+		default:
+			if move.PartiallyAvailable {
+				processCount++
+			}
+		}
+	}
+	state := "confirm"
+	switch {
+	case len(moves) > 0 && cancelCount == len(moves):
+		state = "cancel"
+	case len(moves) > 0 && doneCount+cancelCount == len(moves):
+		state = "done"
+	case doneCount > 0 || processCount > 0:
+		state = "process"
+	}
+	line.FirstDeliveredQty = float32(firstDelivered)
+	line.SecondDeliveredQty = float32(secondDelivered)
+	line.State = state
+	line.UpdateUser = user
+	_, err := o.Update(line, "FirstDeliveredQty", "SecondDeliveredQty", "State", "UpdateUser", "UpdateDate")
+	return err
+}
@@ -13,22 +13,27 @@ import (
 
 // SaleOrderState 订单状态
 type SaleOrderState struct {
-	ID               int64           `orm:"column(id);pk;auto" json:"id"`         //主键
-	CreateUser       *User           `orm:"rel(fk);null" json:"-"`                //创建者
-	UpdateUser       *User           `orm:"rel(fk);null" json:"-"`                //最后更新者
-	CreateDate       time.Time       `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
-	UpdateDate       time.Time       `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
-	Name             string          `orm:"default()" json:"name"`                //状态名称
-	Active           bool            `orm:"default(true)" json:"Active"`          //是否有效
-	Company          *Company        `orm:"rel(fk)"`                              //公司
-	StockWarehouse   *StockWarehouse `orm:"rel(fk)"`                              //仓库
-	NextStep         *SaleOrderState `orm:"null;rel(one)"`                        //下一步
-	PrevStep         *SaleOrderState `orm:"null;rel(one)"`                        //上一步
-	Sequence         int64           `orm:"default(1)" json:"Sequence"`           //序号
-	FormAction       string          `orm:"-" json:"FormAction"`                  //非数据库字段，用于表示记录的增加，修改
-	ActionFields     []string        `orm:"-" json:"ActionFields"`                //需要操作的字段,用于update时
-	CompanyID        int64           `orm:"-" json:"Company"`
-	StockWarehouseID int64           `orm:"-" json:"StockWarehouse"`
+	ID               int64              `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser       *User              `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser       *User              `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate       time.Time          `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate       time.Time          `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	Name             string             `orm:"default()" json:"name"`                //状态名称
+	Active           bool               `orm:"default(true)" json:"Active"`          //是否有效
+	Company          *Company           `orm:"rel(fk)"`                              //公司
+	StockWarehouse   *StockWarehouse    `orm:"rel(fk)"`                              //仓库
+	NextStep         *SaleOrderState    `orm:"null;rel(one)"`                        //下一步
+	PrevStep         *SaleOrderState    `orm:"null;rel(one)"`                        //上一步
+	Sequence         int64              `orm:"default(1)" json:"Sequence"`           //序号
+	Conditions       string             `orm:"default()" json:"Conditions"`          //进入条件，多个用逗号分隔:lines/priced/reserved/delivered
+	Action           string             `orm:"default()" json:"Action"`              //进入状态时执行的动作:reserve/picking/cancel，退回时撤销
+	Roles            []*Role            `orm:"rel(m2m)"`                             //可以进入和退出该状态的角色，为空时不限制
+	FormAction       string             `orm:"-" json:"FormAction"`                  //非数据库字段，用于表示记录的增加，修改
+	ActionFields     []string           `orm:"-" json:"ActionFields"`                //需要操作的字段,用于update时
+	CompanyID        int64              `orm:"-" json:"Company"`
+	StockWarehouseID int64              `orm:"-" json:"StockWarehouse"`
+	NextStepID       int64              `orm:"-" json:"NextStep"`
+	RoleIDs          map[string][]int64 `orm:"-" json:"RoleIds"`
 }
 
 func init() {
@@ -37,11 +42,168 @@ func init() {
 
 // AddSaleOrderState insert a new SaleOrderState into database and returns
 // last inserted ID on success.
-func AddSaleOrderState(obj *SaleOrderState) (id int64, err error) {
+func AddSaleOrderState(obj *SaleOrderState, addUser *User) (id int64, err error) {
 	o := orm.NewOrm()
+	obj.CreateUser = addUser
+	obj.UpdateUser = addUser
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return 0, errBegin
+	}
+	if obj.CompanyID > 0 {
+		obj.Company, _ = GetCompanyByID(obj.CompanyID)
+	}
+	if obj.StockWarehouseID > 0 {
+		obj.StockWarehouse, _ = GetStockWarehouseByID(obj.StockWarehouseID)
+	}
+	if obj.Company == nil || obj.StockWarehouse == nil {
+		return 0, errors.New("订单状态必须指定公司和仓库")
+	}
+	if err = saleOrderStateCheckConfig(obj); err != nil {
+		return 0, err
+	}
+	if id, err = o.Insert(obj); err != nil {
+		return 0, err
+	}
+	if err = saleOrderStateUpdateRoles(o, obj); err != nil {
+		return 0, err
+	}
+	if obj.NextStepID > 0 {
+		if err = saleOrderStateLink(o, obj, obj.NextStepID, addUser); err != nil {
+			return 0, err
+		}
+	}
+	return id, o.Commit()
+}
 
-	id, err = o.Insert(obj)
-	return id, err
+// UpdateSaleOrderState 修改订单状态的配置，下一步变化时同时维护上下步链
+func UpdateSaleOrderState(obj *SaleOrderState, updateUser *User) (id int64, err error) {
+	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return 0, errBegin
+	}
+	state := &SaleOrderState{ID: obj.ID}
+	if err = o.Read(state); err != nil {
+		return 0, err
+	}
+	fields := []string{"UpdateUser", "UpdateDate"}
+	for _, field := range obj.ActionFields {
+		switch field {
+		case "Name":
+			state.Name = obj.Name
+		case "Active":
+			state.Active = obj.Active
+		case "Sequence":
+			state.Sequence = obj.Sequence
+		case "Conditions":
+			state.Conditions = obj.Conditions
+		case "Action":
+			state.Action = obj.Action
+		default:
+			continue
+		}
+		fields = append(fields, field)
+	}
+	if err = saleOrderStateCheckConfig(state); err != nil {
+		return 0, err
+	}
+	state.UpdateUser = updateUser
+	if _, err = o.Update(state, fields...); err != nil {
+		return 0, err
+	}
+	state.RoleIDs = obj.RoleIDs
+	if err = saleOrderStateUpdateRoles(o, state); err != nil {
+		return 0, err
+	}
+	if obj.NextStepID > 0 && (state.NextStep == nil || state.NextStep.ID != obj.NextStepID) {
+		if err = saleOrderStateLink(o, state, obj.NextStepID, updateUser); err != nil {
+			return 0, err
+		}
+	}
+	return state.ID, o.Commit()
+}
+
+// saleOrderStateCheckConfig 检查进入条件和动作是否可用
+func saleOrderStateCheckConfig(state *SaleOrderState) error {
+	for _, condition := range saleOrderStateConditionList(state) {
+		if _, ok := saleOrderStateConditions[condition]; !ok {
+			return fmt.Errorf("订单状态的进入条件[%s]无效", condition)
+		}
+	}
+	state.Action = strings.TrimSpace(state.Action)
+	if state.Action != "" {
+		if _, ok := saleOrderStateActions[state.Action]; !ok {
+			return fmt.Errorf("订单状态的动作[%s]无效", state.Action)
+		}
+	}
+	return nil
+}
+
+// saleOrderStateUpdateRoles 按表单增加和删除订单状态的角色
+func saleOrderStateUpdateRoles(o orm.Ormer, state *SaleOrderState) error {
+	m2mRoles := o.QueryM2M(state, "Roles")
+	for _, roleID := range state.RoleIDs["create"] {
+		if _, err := m2mRoles.Add(&Role{ID: roleID}); err != nil {
+			return err
+		}
+	}
+	for _, roleID := range state.RoleIDs["delete"] {
+		if _, err := m2mRoles.Remove(&Role{ID: roleID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saleOrderStateLink 设置订单状态的下一步，并把下一步的上一步指向该状态，原来的链接断开
+func saleOrderStateLink(o orm.Ormer, state *SaleOrderState, nextID int64, user *User) error {
+	if nextID == state.ID {
+		return errors.New("订单状态的下一步不能是自己")
+	}
+	next := &SaleOrderState{ID: nextID}
+	if err := o.Read(next); err != nil {
+		return err
+	}
+	if next.Company == nil || next.StockWarehouse == nil || state.Company == nil || state.StockWarehouse == nil ||
+		next.Company.ID != state.Company.ID || next.StockWarehouse.ID != state.StockWarehouse.ID {
+		return errors.New("订单状态的下一步必须属于同一公司和仓库")
+	}
+	// 原下一步不再指向该状态
+	if state.NextStep != nil {
+		if _, err := o.QueryTable(new(SaleOrderState)).Filter("Id", state.NextStep.ID).Filter("PrevStep__Id", state.ID).Update(orm.Params{"PrevStep": nil}); err != nil {
+			return err
+		}
+	}
+	// 下一步原来的上一步不再指向下一步
+	if next.PrevStep != nil {
+		if _, err := o.QueryTable(new(SaleOrderState)).Filter("Id", next.PrevStep.ID).Update(orm.Params{"NextStep": nil}); err != nil {
+			return err
+		}
+	}
+	state.NextStep = next
+	state.UpdateUser = user
+	if _, err := o.Update(state, "NextStep", "UpdateUser", "UpdateDate"); err != nil {
+		return err
+	}
+	next.PrevStep = state
+	next.UpdateUser = user
+	_, err := o.Update(next, "PrevStep", "UpdateUser", "UpdateDate")
+	return err
 }
 
 // GetSaleOrderStateByID retrieves SaleOrderState by ID. Returns error if
@@ -50,6 +212,19 @@ func GetSaleOrderStateByID(id int64) (obj *SaleOrderState, err error) {
 	o := orm.NewOrm()
 	obj = &SaleOrderState{ID: id}
 	if err = o.Read(obj); err == nil {
+		if obj.Company != nil {
+			o.Read(obj.Company)
+		}
+		if obj.StockWarehouse != nil {
+			o.Read(obj.StockWarehouse)
+		}
+		if obj.NextStep != nil {
+			o.Read(obj.NextStep)
+		}
+		if obj.PrevStep != nil {
+			o.Read(obj.PrevStep)
+		}
+		o.LoadRelated(obj, "Roles")
 		return obj, nil
 	}
 	return nil, err
@@ -65,13 +240,16 @@ func GetSaleOrderStateByCompanyStock(company *Company, stock *StockWarehouse, ne
 	o := orm.NewOrm()
 	qs := o.QueryTable(new(SaleOrderState))
 	cond := orm.NewCondition()
-	cond.And("Active", true)
-	cond.And("Company.Id", company.ID)
-	cond.And("StockWarehouse.Id", stock.ID)
+	cond = cond.And("Active", true)
+	cond = cond.And("Company__Id", company.ID)
+	cond = cond.And("StockWarehouse__Id", stock.ID)
 	if nextStep == nil {
-		cond.And("Name", "draft")
+		// 初始状态为流程的第一步
+		cond = cond.And("PrevStep__isnull", true)
+	} else {
+		cond = cond.And("PrevStep__Id", nextStep.ID)
 	}
-	if num, err = qs.Limit(2, 0).All(&objArrs); err == nil {
+	if num, err = qs.SetCond(cond).OrderBy("Sequence", "Id").Limit(2, 0).All(&objArrs); err == nil {
 		if num == 1 {
 			obj = &objArrs[0]
 		}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// SaleOrderStateLog 销售订单状态变更记录
+type SaleOrderStateLog struct {
+	ID         int64           `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser *User           `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser *User           `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate time.Time       `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate time.Time       `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	SaleOrder  *SaleOrder      `orm:"rel(fk)"`                              //销售订单
+	FromState  *SaleOrderState `orm:"rel(fk);null"`                         //原状态
+	ToState    *SaleOrderState `orm:"rel(fk)"`                              //新状态
+	Direction  string          `json:"Direction"`                           //方向:advance推进/revert退回
+	User       *User           `orm:"rel(fk)"`                              //操作人
+	Date       time.Time       `orm:"type(datetime)" json:"-"`              //操作时间
+	Note       string          `orm:"default()" json:"Note"`                //备注
+}
+
+func init() {
+	orm.RegisterModel(new(SaleOrderStateLog))
+}
+
+// saleOrderStateLogCreate 记录销售订单的状态变更
+func saleOrderStateLogCreate(o orm.Ormer, order *SaleOrder, from, to *SaleOrderState, direction string, user *User, note string) error {
+	log := &SaleOrderStateLog{
+		SaleOrder:  order,
+		FromState:  from,
+		ToState:    to,
+		Direction:  direction,
+		User:       user,
+		Date:       time.Now(),
+		Note:       note,
+		CreateUser: user,
+		UpdateUser: user,
+	}
+	_, err := o.Insert(log)
+	return err
+}
+
+// GetSaleOrderStateLogs 获得销售订单的状态变更记录，按时间先后排列
+func GetSaleOrderStateLogs(orderID int64) (logs []*SaleOrderStateLog, err error) {
+	o := orm.NewOrm()
+	_, err = o.QueryTable(new(SaleOrderStateLog)).Filter("SaleOrder__Id", orderID).RelatedSel("FromState", "ToState", "User").OrderBy("Date", "Id").Limit(-1).All(&logs)
+	return logs, err
+}
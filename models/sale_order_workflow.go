@@ -0,0 +1,384 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// SaleOrderStateAction 进入订单状态时执行的动作，Revert为退回时的撤销，为空时该状态不能退回
+type SaleOrderStateAction struct {
+	Name    string                                                //动作名称
+	Advance func(o orm.Ormer, order *SaleOrder, user *User) error //推进到该状态时执行
+	Revert  func(o orm.Ormer, order *SaleOrder, user *User) error //从该状态退回时执行
+}
+
+// saleOrderStateActions 已注册的订单状态动作
+var saleOrderStateActions = map[string]*SaleOrderStateAction{
+	"reserve": {Name: "预留库存", Advance: saleOrderReserve, Revert: saleOrderUnreserve},
+	"picking": {Name: "生成出库分拣", Advance: saleOrderPicking, Revert: saleOrderPickingCancel},
+	"cancel":  {Name: "取消订单", Advance: saleOrderCancel},
+}
+
+// saleOrderStateConditions 进入订单状态的条件，不满足时返回错误
+var saleOrderStateConditions = map[string]func(o orm.Ormer, order *SaleOrder) error{
+	"lines":     saleOrderHasLines,
+	"priced":    saleOrderPriced,
+	"reserved":  saleOrderReserved,
+	"delivered": saleOrderDelivered,
+}
+
+// RegisterSaleOrderStateAction 注册订单状态动作，如开票等其他模块的动作
+func RegisterSaleOrderStateAction(code string, action *SaleOrderStateAction) {
+	saleOrderStateActions[code] = action
+}
+
+// GetSaleOrderStateActions 获得可用的订单状态动作名称
+func GetSaleOrderStateActions() map[string]string {
+	names := make(map[string]string)
+	for code, action := range saleOrderStateActions {
+		names[code] = action.Name
+	}
+	return names
+}
+
+// saleOrderStateConditionList 获得订单状态的进入条件
+func saleOrderStateConditionList(state *SaleOrderState) []string {
+	var conditions []string
+	for _, condition := range strings.Split(state.Conditions, ",") {
+		if condition = strings.TrimSpace(condition); condition != "" {
+			conditions = append(conditions, condition)
+		}
+	}
+	return conditions
+}
+
+// saleOrderLines 获得销售订单的明细
+func saleOrderLines(o orm.Ormer, order *SaleOrder) (lines []*SaleOrderLine, err error) {
+	_, err = o.QueryTable(new(SaleOrderLine)).Filter("SaleOrder__Id", order.ID).OrderBy("Id").Limit(-1).All(&lines)
+	return lines, err
+}
+
+// saleOrderMoves 获得销售订单出库分拣中的库存移动
+func saleOrderMoves(o orm.Ormer, order *SaleOrder) (moves []*StockMove, err error) {
+	_, err = o.QueryTable(new(StockMove)).Filter("Picking__SaleOrder__Id", order.ID).OrderBy("Id").Limit(-1).All(&moves)
+	return moves, err
+}
+
+// saleOrderPickingsUpdateState 更新销售订单出库分拣的状态
+func saleOrderPickingsUpdateState(o orm.Ormer, order *SaleOrder, user *User) error {
+	var pickings []*StockPicking
+	if _, err := o.QueryTable(new(StockPicking)).Filter("SaleOrder__Id", order.ID).Limit(-1).All(&pickings); err != nil {
+		return err
+	}
+	for _, picking := range pickings {
+		if err := stockPickingUpdateState(o, picking, user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func saleOrderHasLines(o orm.Ormer, order *SaleOrder) error {
+	lines, err := saleOrderLines(o, order)
+	if err != nil {
+		return err
+	}
+	if len(lines) == 0 {
+		return fmt.Errorf("销售订单[%s]没有订单明细", order.Name)
+	}
+	return nil
+}
+
+func saleOrderPriced(o orm.Ormer, order *SaleOrder) error {
+	lines, err := saleOrderLines(o, order)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if line.PriceUnit <= 0 {
+			return fmt.Errorf("销售订单[%s]的明细[%s]没有单价", order.Name, line.ProductName)
+		}
+	}
+	return nil
+}
+
+// saleOrderReserved 需要从库存出货的移动全部已预留
+func saleOrderReserved(o orm.Ormer, order *SaleOrder) error {
+	moves, err := saleOrderMoves(o, order)
+	if err != nil {
+		return err
+	}
+	var count int
+	for _, move := range moves {
+		if move.MoveOrigin != nil || move.State == "cancel" || move.State == "done" {
+			continue
+		}
+		if move.State != "assigned" {
+			return fmt.Errorf("销售订单[%s]的移动[%s]库存未预留", order.Name, move.Name)
+		}
+		count++
+	}
+	if count == 0 {
+		return fmt.Errorf("销售订单[%s]没有需要预留的移动", order.Name)
+	}
+	return nil
+}
+
+func saleOrderDelivered(o orm.Ormer, order *SaleOrder) error {
+	lines, err := saleOrderLines(o, order)
+	if err != nil {
+		return err
+	}
+	var count int
+	for _, line := range lines {
+		if line.State == "cancel" {
+			continue
+		}
+		if line.State != "done" {
+			return fmt.Errorf("销售订单[%s]的明细[%s]未发货完成", order.Name, line.ProductName)
+		}
+		count++
+	}
+	if count == 0 {
+		return fmt.Errorf("销售订单[%s]没有已发货的明细", order.Name)
+	}
+	return nil
+}
+
+// saleOrderReserve 为销售订单未预留的移动预留库存
+func saleOrderReserve(o orm.Ormer, order *SaleOrder, user *User) error {
+	moves, err := saleOrderMoves(o, order)
+	if err != nil {
+		return err
+	}
+	for _, move := range moves {
+		if move.State != "confirm" && move.State != "waiting" {
+			continue
+		}
+		if err = stockMoveAssign(o, move, user); err != nil {
+			return err
+		}
+	}
+	return saleOrderPickingsUpdateState(o, order, user)
+}
+
+// saleOrderUnreserve 释放销售订单未完成移动的预留
+func saleOrderUnreserve(o orm.Ormer, order *SaleOrder, user *User) error {
+	moves, err := saleOrderMoves(o, order)
+	if err != nil {
+		return err
+	}
+	for _, move := range moves {
+		if move.State == "done" || move.State == "cancel" {
+			continue
+		}
+		if err = quantsUnreserve(o, move); err != nil {
+			return err
+		}
+		move.State = "confirm"
+		if move.MoveOrigin != nil {
+			move.State = "waiting"
+		}
+		move.PartiallyAvailable = false
+		move.UpdateUser = user
+		if _, err = o.Update(move, "State", "PartiallyAvailable", "UpdateUser", "UpdateDate"); err != nil {
+			return err
+		}
+	}
+	return saleOrderPickingsUpdateState(o, order, user)
+}
+
+// saleOrderPicking 确认销售订单并生成出库分拣，已确认的订单不再生成
+func saleOrderPicking(o orm.Ormer, order *SaleOrder, user *User) error {
+	lines, err := saleOrderLines(o, order)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if line.State != "draft" {
+			return nil
+		}
+	}
+	_, err = saleOrderConfirm(o, order, user)
+	return err
+}
+
+// saleOrderPickingCancel 取消销售订单的库存移动，明细恢复为草稿
+func saleOrderPickingCancel(o orm.Ormer, order *SaleOrder, user *User) error {
+	return saleOrderMovesCancel(o, order, user, "draft")
+}
+
+// saleOrderCancel 取消销售订单的库存移动和明细
+func saleOrderCancel(o orm.Ormer, order *SaleOrder, user *User) error {
+	return saleOrderMovesCancel(o, order, user, "cancel")
+}
+
+// saleOrderMovesCancel 取消销售订单的库存移动并设置明细状态，已有完成的移动时不能取消
+func saleOrderMovesCancel(o orm.Ormer, order *SaleOrder, user *User, lineState string) error {
+	moves, err := saleOrderMoves(o, order)
+	if err != nil {
+		return err
+	}
+	for _, move := range moves {
+		if move.State == "done" {
+			return fmt.Errorf("销售订单[%s]的移动[%s]已完成,不能取消", order.Name, move.Name)
+		}
+	}
+	for _, move := range moves {
+		if move.State == "cancel" {
+			continue
+		}
+		if err = stockMoveCancel(o, move, user); err != nil {
+			return err
+		}
+	}
+	if err = saleOrderPickingsUpdateState(o, order, user); err != nil {
+		return err
+	}
+	_, err = o.QueryTable(new(SaleOrderLine)).Filter("SaleOrder__Id", order.ID).Update(orm.Params{
+		"State":      lineState,
+		"UpdateUser": user.ID,
+		"UpdateDate": time.Now(),
+	})
+	return err
+}
+
+// saleOrderStateAllowed 用户是否可以操作订单状态，超级用户和未设置角色的状态不限制
+func saleOrderStateAllowed(o orm.Ormer, state *SaleOrderState, user *User) (bool, error) {
+	if user.IsAdmin {
+		return true, nil
+	}
+	if _, err := o.LoadRelated(state, "Roles"); err != nil {
+		return false, err
+	}
+	if len(state.Roles) == 0 {
+		return true, nil
+	}
+	current := &User{ID: user.ID}
+	if _, err := o.LoadRelated(current, "Roles"); err != nil {
+		return false, err
+	}
+	for _, role := range state.Roles {
+		for _, userRole := range current.Roles {
+			if role.ID == userRole.ID {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// saleOrderStateTransit 在事务中改变销售订单的状态并记录
+func saleOrderStateTransit(id int64, user *User, note string, direction string) (err error) {
+	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return errBegin
+	}
+	order := &SaleOrder{ID: id}
+	if err = o.Read(order); err != nil {
+		return err
+	}
+	if order.State == nil {
+		return fmt.Errorf("销售订单[%s]没有订单状态", order.Name)
+	}
+	current := &SaleOrderState{ID: order.State.ID}
+	if err = o.Read(current); err != nil {
+		return err
+	}
+	var (
+		target  *SaleOrderState
+		guarded *SaleOrderState
+		hook    func(o orm.Ormer, order *SaleOrder, user *User) error
+	)
+	if direction == "advance" {
+		if current.NextStep == nil {
+			return fmt.Errorf("订单状态[%s]已是最后一步", current.Name)
+		}
+		target = &SaleOrderState{ID: current.NextStep.ID}
+		if err = o.Read(target); err != nil {
+			return err
+		}
+		guarded = target
+		for _, condition := range saleOrderStateConditionList(target) {
+			check, ok := saleOrderStateConditions[condition]
+			if !ok {
+				return fmt.Errorf("订单状态的进入条件[%s]无效", condition)
+			}
+			if err = check(o, order); err != nil {
+				return err
+			}
+		}
+		if target.Action != "" {
+			action, ok := saleOrderStateActions[target.Action]
+			if !ok {
+				return fmt.Errorf("订单状态的动作[%s]无效", target.Action)
+			}
+			hook = action.Advance
+		}
+	} else {
+		if current.PrevStep == nil {
+			return fmt.Errorf("订单状态[%s]已是第一步", current.Name)
+		}
+		target = &SaleOrderState{ID: current.PrevStep.ID}
+		if err = o.Read(target); err != nil {
+			return err
+		}
+		guarded = current
+		if current.Action != "" {
+			action, ok := saleOrderStateActions[current.Action]
+			if !ok {
+				return fmt.Errorf("订单状态的动作[%s]无效", current.Action)
+			}
+			if action.Revert == nil {
+				return fmt.Errorf("订单状态[%s]不能退回", current.Name)
+			}
+			hook = action.Revert
+		}
+	}
+	if !target.Active {
+		return fmt.Errorf("订单状态[%s]已停用", target.Name)
+	}
+	var allowed bool
+	if allowed, err = saleOrderStateAllowed(o, guarded, user); err != nil {
+		return err
+	}
+	if !allowed {
+		return errors.New("没有权限改变订单状态[" + guarded.Name + "]")
+	}
+	if hook != nil {
+		if err = hook(o, order, user); err != nil {
+			return err
+		}
+	}
+	order.State = target
+	order.UpdateUser = user
+	if _, err = o.Update(order, "State", "UpdateUser", "UpdateDate"); err != nil {
+		return err
+	}
+	if err = saleOrderStateLogCreate(o, order, current, target, direction, user, note); err != nil {
+		return err
+	}
+	return o.Commit()
+}
+
+// AdvanceSaleOrder 销售订单推进到下一步状态，检查下一步的进入条件和角色并执行其动作
+func AdvanceSaleOrder(id int64, user *User, note string) error {
+	return saleOrderStateTransit(id, user, note, "advance")
+}
+
+// RevertSaleOrder 销售订单退回到上一步状态，检查当前状态的角色并撤销其动作
+func RevertSaleOrder(id int64, user *User, note string) error {
+	return saleOrderStateTransit(id, user, note, "revert")
+}
@@ -0,0 +1,356 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"goERP/utils"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// StockConsignmentLine 代售品明细，记录货主所有的份的每次移动，
+// 销售和客户退回的明细用于与货主结算
+type StockConsignmentLine struct {
+	ID            int64               `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser    *User               `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser    *User               `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate    time.Time           `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate    time.Time           `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	Date          time.Time           `orm:"type(datetime)"`                       //移动完成时间
+	Type          string              `json:"Type"`                                //类型:receive/sale/refund/return/internal/other
+	Owner         *Partner            `orm:"rel(fk)"`                              //货主
+	Product       *ProductProduct     `orm:"rel(fk)"`                              //产品规格
+	Lot           *StockProductionLot `orm:"rel(fk);null"`                         //批次、序列号
+	Move          *StockMove          `orm:"rel(fk)"`                              //移动
+	LocationSrc   *StockLocation      `orm:"rel(fk)"`                              //移动的源库位
+	LocationDest  *StockLocation      `orm:"rel(fk)"`                              //移动的目标库位
+	SaleOrderLine *SaleOrderLine      `orm:"rel(fk);null"`                         //销售订单明细
+	FirstUomQty   float64             `orm:"default(0)"`                           //第一单位数量
+	SecondUomQty  float64             `orm:"default(0)"`                           //第二单位数量
+	PriceUnit     float64             `orm:"default(0)"`                           //结算单价
+	Amount        float64             `orm:"default(0)"`                           //结算金额，客户退回为负
+	State         string              `orm:"default(draft)" json:"State"`          //结算状态:draft/settled，只有销售和客户退回需要结算
+	SettleUser    *User               `orm:"rel(fk);null" json:"-"`                //结算人
+	DateSettled   time.Time           `orm:"type(datetime);null" json:"-"`         //结算时间
+	Company       *Company            `orm:"rel(fk);null"`                         //公司
+
+	FormAction   string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
+	ActionFields []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
+}
+
+// StockConsignmentStatementLine 代售对账单明细，按货主和产品规格汇总
+type StockConsignmentStatementLine struct {
+	Owner           *Partner        //货主
+	Product         *ProductProduct //产品规格
+	Received        float64         //接收数量
+	Sold            float64         //销售数量，已扣除客户退回
+	Returned        float64         //退回货主数量
+	OnHand          float64         //当前在库数量
+	SaleAmount      float64         //销售结算金额
+	SettledAmount   float64         //已结算金额
+	UnsettledAmount float64         //未结算金额
+}
+
+func init() {
+	orm.RegisterModel(new(StockConsignmentLine))
+}
+
+// stockMoveConsignmentOwner 从供应商接收代售品时的货主和结算单价，代售品为产品款式标记为代售的产品，
+// 货主为移动或调拨单的合作伙伴，结算单价为移动的单价，没有单价时使用产品规格的成本价格
+func stockMoveConsignmentOwner(o orm.Ormer, move *StockMove) (owner *Partner, price float64, err error) {
+	if move.LocationSrc == nil || move.LocationSrc.Usage != "supplier" {
+		return nil, 0, nil
+	}
+	product := &ProductProduct{ID: move.Product.ID}
+	if err = o.Read(product); err != nil {
+		return nil, 0, err
+	}
+	if product.ProductTemplate == nil {
+		return nil, 0, nil
+	}
+	template := &ProductTemplate{ID: product.ProductTemplate.ID}
+	if err = o.Read(template); err != nil {
+		return nil, 0, err
+	}
+	if !template.Rental {
+		return nil, 0, nil
+	}
+	if move.Partner != nil {
+		owner = move.Partner
+	} else if move.Picking != nil {
+		picking := &StockPicking{ID: move.Picking.ID}
+		if err = o.Read(picking); err != nil {
+			return nil, 0, err
+		}
+		owner = picking.Partner
+	}
+	if owner == nil {
+		return nil, 0, fmt.Errorf("代售品移动[%s]没有指定货主", move.Name)
+	}
+	price = move.PriceUnit
+	if price <= 0 {
+		price = product.StandardPrice
+	}
+	return owner, price, nil
+}
+
+// stockConsignmentType 根据移动的库位确定代售明细的类型
+func stockConsignmentType(move *StockMove) string {
+	switch {
+	case move.LocationDest.Usage == "customer":
+		return "sale"
+	case move.LocationSrc.Usage == "customer":
+		return "refund"
+	case move.LocationSrc.Usage == "supplier":
+		return "receive"
+	case move.LocationDest.Usage == "supplier":
+		return "return"
+	case locationNeedQuants(move.LocationSrc) && locationNeedQuants(move.LocationDest):
+		return "internal"
+	}
+	return "other"
+}
+
+// stockConsignmentLineCreate 记录移动转移的代售品的份，结算单价为份的成本
+func stockConsignmentLineCreate(o orm.Ormer, move *StockMove, quant *StockQuant, firstQty, secondQty float64, user *User) error {
+	line := &StockConsignmentLine{
+		CreateUser:    user,
+		UpdateUser:    user,
+		Date:          time.Now(),
+		Type:          stockConsignmentType(move),
+		Owner:         quant.Owner,
+		Product:       move.Product,
+		Lot:           quant.Lot,
+		Move:          move,
+		LocationSrc:   move.LocationSrc,
+		LocationDest:  move.LocationDest,
+		SaleOrderLine: move.SaleOrderLine,
+		FirstUomQty:   firstQty,
+		SecondUomQty:  secondQty,
+		PriceUnit:     quant.Cost,
+		Amount:        quant.Cost * firstQty,
+		State:         "draft",
+		Company:       move.Company,
+	}
+	if line.Type == "refund" {
+		line.Amount = -line.Amount
+	}
+	_, err := o.Insert(line)
+	return err
+}
+
+// stockConsignmentMoveQty 移动转移的代售品第一单位数量
+func stockConsignmentMoveQty(o orm.Ormer, move *StockMove) (qty float64, err error) {
+	var lines []*StockConsignmentLine
+	if _, err = o.QueryTable(new(StockConsignmentLine)).Filter("Move__Id", move.ID).All(&lines, "FirstUomQty"); err != nil {
+		return 0, err
+	}
+	for _, line := range lines {
+		qty += line.FirstUomQty
+	}
+	return qty, nil
+}
+
+// GetStockConsignmentLineByID retrieves StockConsignmentLine by ID. Returns error if
+// ID doesn't exist
+func GetStockConsignmentLineByID(id int64) (obj *StockConsignmentLine, err error) {
+	o := orm.NewOrm()
+	obj = &StockConsignmentLine{ID: id}
+	if err = o.Read(obj); err == nil {
+		return obj, nil
+	}
+	return nil, err
+}
+
+// GetAllStockConsignmentLine retrieves all StockConsignmentLine matches certain condition. Returns empty list if
+// no records exist
+func GetAllStockConsignmentLine(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (utils.Paginator, []StockConsignmentLine, error) {
+	var (
+		objArrs   []StockConsignmentLine
+		paginator utils.Paginator
+		num       int64
+		err       error
+	)
+	if limit == 0 {
+		limit = 20
+	}
+	o := orm.NewOrm()
+	qs := o.QueryTable(new(StockConsignmentLine))
+	qs = qs.RelatedSel()
+
+	//cond k=v cond必须放到Filter和Exclude前面
+	cond := orm.NewCondition()
+	if _, ok := condMap["and"]; ok {
+		andMap := condMap["and"]
+		for k, v := range andMap {
+			k = strings.Replace(k, ".", "__", -1)
+			cond = cond.And(k, v)
+		}
+	}
+	if _, ok := condMap["or"]; ok {
+		orMap := condMap["or"]
+		for k, v := range orMap {
+			k = strings.Replace(k, ".", "__", -1)
+			cond = cond.Or(k, v)
+		}
+	}
+	qs = qs.SetCond(cond)
+	// query k=v
+	for k, v := range query {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		qs = qs.Filter(k, v)
+	}
+	//exclude k=v
+	for k, v := range exclude {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		qs = qs.Exclude(k, v)
+	}
+
+	// order by:
+	var sortFields []string
+	if len(sortby) != 0 {
+		if len(sortby) == len(order) {
+			// 1) for each sort field, there is an associated order
+			for i, v := range sortby {
+				orderby := ""
+				if order[i] == "desc" {
+					orderby = "-" + strings.Replace(v, ".", "__", -1)
+				} else if order[i] == "asc" {
+					orderby = strings.Replace(v, ".", "__", -1)
+				} else {
+					return paginator, nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+			qs = qs.OrderBy(sortFields...)
+		} else if len(sortby) != len(order) && len(order) == 1 {
+			// 2) there is exactly one order, all the sorted fields will be sorted by this order
+			for _, v := range sortby {
+				orderby := ""
+				if order[0] == "desc" {
+					orderby = "-" + strings.Replace(v, ".", "__", -1)
+				} else if order[0] == "asc" {
+					orderby = strings.Replace(v, ".", "__", -1)
+				} else {
+					return paginator, nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+		} else if len(sortby) != len(order) && len(order) != 1 {
+			return paginator, nil, errors.New("Error: 'sortby', 'order' sizes mismatch or 'order' size is not 1")
+		}
+	} else {
+		if len(order) != 0 {
+			return paginator, nil, errors.New("Error: unused 'order' fields")
+		}
+	}
+
+	qs = qs.OrderBy(sortFields...)
+	if cnt, err := qs.Count(); err == nil {
+		if cnt > 0 {
+			paginator = utils.GenPaginator(limit, offset, cnt)
+			if num, err = qs.Limit(limit, offset).All(&objArrs, fields...); err == nil {
+				paginator.CurrentPageSize = num
+			}
+		}
+	}
+	return paginator, objArrs, err
+}
+
+// SettleStockConsignmentLines 将未结算的销售和客户退回明细标记为已结算，返回结算的明细数量
+func SettleStockConsignmentLines(ids []int64, user *User) (num int64, err error) {
+	if len(ids) == 0 {
+		return 0, errors.New("请选择需要结算的代售明细")
+	}
+	o := orm.NewOrm()
+	qs := o.QueryTable(new(StockConsignmentLine)).Filter("Id__in", ids).Filter("Type__in", "sale", "refund").Filter("State", "draft")
+	return qs.Update(orm.Params{
+		"State":       "settled",
+		"SettleUser":  user.ID,
+		"DateSettled": time.Now(),
+		"UpdateUser":  user.ID,
+		"UpdateDate":  time.Now(),
+	})
+}
+
+// GetStockConsignmentStatement 获得代售对账单，ownerID大于0时只统计该货主，
+// 接收、销售、退回数量为日期范围内的合计，在库数量为当前库存
+func GetStockConsignmentStatement(ownerID int64, dateStart, dateEnd time.Time) (lines []*StockConsignmentStatementLine, err error) {
+	o := orm.NewOrm()
+	qs := o.QueryTable(new(StockConsignmentLine))
+	if ownerID > 0 {
+		qs = qs.Filter("Owner__Id", ownerID)
+	}
+	if !dateStart.IsZero() {
+		qs = qs.Filter("Date__gte", dateStart)
+	}
+	if !dateEnd.IsZero() {
+		qs = qs.Filter("Date__lte", dateEnd)
+	}
+	var moveLines []*StockConsignmentLine
+	if _, err = qs.RelatedSel("Owner", "Product").Limit(-1).All(&moveLines); err != nil {
+		return nil, err
+	}
+	lineMap := make(map[[2]int64]*StockConsignmentStatementLine)
+	getLine := func(owner *Partner, product *ProductProduct) *StockConsignmentStatementLine {
+		key := [2]int64{owner.ID, product.ID}
+		line, ok := lineMap[key]
+		if !ok {
+			line = &StockConsignmentStatementLine{Owner: owner, Product: product}
+			lineMap[key] = line
+		}
+		return line
+	}
+	for _, moveLine := range moveLines {
+		line := getLine(moveLine.Owner, moveLine.Product)
+		switch moveLine.Type {
+		case "receive":
+			line.Received += moveLine.FirstUomQty
+		case "return":
+			line.Returned += moveLine.FirstUomQty
+		case "sale", "refund":
+			if moveLine.Type == "sale" {
+				line.Sold += moveLine.FirstUomQty
+			} else {
+				line.Sold -= moveLine.FirstUomQty
+			}
+			line.SaleAmount += moveLine.Amount
+			if moveLine.State == "settled" {
+				line.SettledAmount += moveLine.Amount
+			} else {
+				line.UnsettledAmount += moveLine.Amount
+			}
+		}
+	}
+	quantQs := o.QueryTable(new(StockQuant)).Filter("Owner__isnull", false).Filter("Location__Usage__in", "internal", "transit")
+	if ownerID > 0 {
+		quantQs = quantQs.Filter("Owner__Id", ownerID)
+	}
+	var quants []*StockQuant
+	if _, err = quantQs.RelatedSel("Owner", "Product").Limit(-1).All(&quants); err != nil {
+		return nil, err
+	}
+	for _, quant := range quants {
+		getLine(quant.Owner, quant.Product).OnHand += quant.FirstUomQty
+	}
+	for _, line := range lineMap {
+		if math.Abs(line.OnHand) <= stockQtyEpsilon && math.Abs(line.Received) <= stockQtyEpsilon &&
+			math.Abs(line.Sold) <= stockQtyEpsilon && math.Abs(line.Returned) <= stockQtyEpsilon && math.Abs(line.SaleAmount) <= stockQtyEpsilon {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].Owner.Name != lines[j].Owner.Name {
+			return lines[i].Owner.Name < lines[j].Owner.Name
+		}
+		return lines[i].Product.Name < lines[j].Product.Name
+	})
+	return lines, nil
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"goERP/utils"
+	"math"
 	"strings"
 	"time"
 
@@ -228,3 +229,263 @@ func DeleteStockInventory(id int64) (err error) {
 	}
 	return
 }
+
+// stockInventoryQuantCond 根据盘点对象获得需要盘点的份的查询条件
+func stockInventoryQuantCond(inventory *StockInventory, locationIDs []int64) (*orm.Condition, error) {
+	cond := orm.NewCondition().And("Location__Id__in", locationIDs)
+	switch inventory.Filter {
+	case "all":
+	case "product":
+		if inventory.Product == nil {
+			return nil, fmt.Errorf("盘点[%s]没有指定产品规格", inventory.Name)
+		}
+		cond = cond.And("Product__Id", inventory.Product.ID)
+	case "template":
+		if inventory.Template == nil {
+			return nil, fmt.Errorf("盘点[%s]没有指定产品款式", inventory.Name)
+		}
+		cond = cond.And("Product__ProductTemplate__Id", inventory.Template.ID)
+	case "pack":
+		if inventory.Package == nil {
+			return nil, fmt.Errorf("盘点[%s]没有指定包", inventory.Name)
+		}
+		cond = cond.And("Package__Id", inventory.Package.ID)
+	case "lot":
+		if inventory.Lot == nil {
+			return nil, fmt.Errorf("盘点[%s]没有指定批次", inventory.Name)
+		}
+		cond = cond.And("Lot__Id", inventory.Lot.ID)
+	default:
+		return nil, fmt.Errorf("无效的盘点对象:%s", inventory.Filter)
+	}
+	return cond, nil
+}
+
+// StartStockInventory 开始盘点，根据盘点对象从盘点库位及下级库位的份生成盘点明细，
+// 部分盘点由用户手工录入盘点明细
+func StartStockInventory(id int64, user *User) (err error) {
+	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return errBegin
+	}
+	inventory := &StockInventory{ID: id}
+	if err = o.Read(inventory); err != nil {
+		return err
+	}
+	if inventory.State != "draft" {
+		return fmt.Errorf("盘点[%s]已开始", inventory.Name)
+	}
+	if inventory.Location == nil {
+		return fmt.Errorf("盘点[%s]没有指定盘点库位", inventory.Name)
+	}
+	if err = o.Read(inventory.Location); err != nil {
+		return err
+	}
+	if inventory.Filter != "partial" {
+		var (
+			locationIDs []int64
+			cond        *orm.Condition
+			quants      []*StockQuant
+		)
+		if locationIDs, err = stockLocationChildIDs(o, inventory.Location); err != nil {
+			return err
+		}
+		if cond, err = stockInventoryQuantCond(inventory, locationIDs); err != nil {
+			return err
+		}
+		if _, err = o.QueryTable(new(StockInventoryLine)).Filter("Inventory__Id", inventory.ID).Delete(); err != nil {
+			return err
+		}
+		if _, err = o.QueryTable(new(StockQuant)).SetCond(cond).RelatedSel("Product").OrderBy("Location__Id", "Product__Id", "Id").All(&quants); err != nil {
+			return err
+		}
+		// 同一库位、产品、批次、包的份合并为一条盘点明细
+		lineMap := make(map[string]*StockInventoryLine)
+		var lines []*StockInventoryLine
+		for _, quant := range quants {
+			var lotID, packageID int64
+			if quant.Lot != nil {
+				lotID = quant.Lot.ID
+			}
+			if quant.Package != nil {
+				packageID = quant.Package.ID
+			}
+			key := fmt.Sprintf("%d-%d-%d-%d", quant.Location.ID, quant.Product.ID, lotID, packageID)
+			line, ok := lineMap[key]
+			if !ok {
+				line = &StockInventoryLine{
+					Inventory:   inventory,
+					Location:    quant.Location,
+					Product:     quant.Product,
+					Template:    quant.Product.ProductTemplate,
+					Package:     quant.Package,
+					ProdLot:     quant.Lot,
+					Company:     inventory.Company,
+					State:       "confirm",
+					ProductName: quant.Product.Name,
+					FirstUom:    quant.FirstUom,
+					SecondUom:   quant.SecondUom,
+					CreateUser:  user,
+					UpdateUser:  user,
+				}
+				lineMap[key] = line
+				lines = append(lines, line)
+			}
+			line.MeasureFirstUomQty += quant.FirstUomQty
+			line.MeasureSecondUomQty += quant.SecondUomQty
+		}
+		for _, line := range lines {
+			line.CheckedFirstUomQty = line.MeasureFirstUomQty
+			line.CheckedSecondUomQty = line.MeasureSecondUomQty
+			if _, err = o.Insert(line); err != nil {
+				return err
+			}
+		}
+	}
+	inventory.State = "confirm"
+	inventory.UpdateUser = user
+	if _, err = o.Update(inventory, "State", "UpdateUser", "UpdateDate"); err != nil {
+		return err
+	}
+	return o.Commit()
+}
+
+// stockInventoryLineTheoreticalQty 获得盘点明细对应的当前账面数量
+func stockInventoryLineTheoreticalQty(o orm.Ormer, line *StockInventoryLine) (firstQty, secondQty float64, err error) {
+	qs := o.QueryTable(new(StockQuant)).Filter("Location__Id", line.Location.ID).Filter("Product__Id", line.Product.ID)
+	if line.ProdLot != nil {
+		qs = qs.Filter("Lot__Id", line.ProdLot.ID)
+	} else {
+		qs = qs.Filter("Lot__isnull", true)
+	}
+	if line.Package != nil {
+		qs = qs.Filter("Package__Id", line.Package.ID)
+	} else {
+		qs = qs.Filter("Package__isnull", true)
+	}
+	var quants []*StockQuant
+	if _, err = qs.All(&quants, "FirstUomQty", "SecondUomQty"); err != nil {
+		return 0, 0, err
+	}
+	for _, quant := range quants {
+		firstQty += quant.FirstUomQty
+		secondQty += quant.SecondUomQty
+	}
+	return firstQty, secondQty, nil
+}
+
+// DoneStockInventory 验证盘点，按盘点明细的差异生成并完成与盘点损益库位之间的库存移动
+func DoneStockInventory(id int64, user *User) (err error) {
+	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return errBegin
+	}
+	inventory := &StockInventory{ID: id}
+	if err = o.Read(inventory); err != nil {
+		return err
+	}
+	if inventory.State != "confirm" {
+		return fmt.Errorf("盘点[%s]状态为%s,不能验证", inventory.Name, inventory.State)
+	}
+	var (
+		lines        []*StockInventoryLine
+		inventoryLoc *StockLocation
+		now          = time.Now()
+	)
+	if inventoryLoc, err = stockLocationByUsage(o, "inventory", inventory.Company); err != nil {
+		return err
+	}
+	if _, err = o.QueryTable(new(StockInventoryLine)).Filter("Inventory__Id", inventory.ID).RelatedSel("Product").OrderBy("Id").All(&lines); err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if line.Product == nil || line.Location == nil {
+			return fmt.Errorf("盘点[%s]的明细缺少产品或库位", inventory.Name)
+		}
+		if err = o.Read(line.Location); err != nil {
+			return err
+		}
+		var theoreticalFirst, theoreticalSecond float64
+		if theoreticalFirst, theoreticalSecond, err = stockInventoryLineTheoreticalQty(o, line); err != nil {
+			return err
+		}
+		diffFirst := line.CheckedFirstUomQty - theoreticalFirst
+		diffSecond := line.CheckedSecondUomQty - theoreticalSecond
+		// 盘盈从盘点损益库位移入，盘亏移出到盘点损益库位，两个单位差异方向不同时分别生成移动
+		in := [2]float64{math.Max(diffFirst, 0), math.Max(diffSecond, 0)}
+		out := [2]float64{math.Max(-diffFirst, 0), math.Max(-diffSecond, 0)}
+		for i, qty := range [][2]float64{in, out} {
+			if qty[0] <= stockQtyEpsilon && qty[1] <= stockQtyEpsilon {
+				continue
+			}
+			move := &StockMove{
+				Name:            line.ProductName,
+				Date:            now,
+				DateExpected:    now,
+				Product:         line.Product,
+				ProductTemplate: line.Product.ProductTemplate,
+				FirstUomQty:     qty[0],
+				SecondUomQty:    qty[1],
+				FirstUom:        line.FirstUom,
+				SecondUom:       line.SecondUom,
+				LocationSrc:     inventoryLoc,
+				LocationDest:    line.Location,
+				State:           "confirm",
+				Company:         inventory.Company,
+				Origin:          inventory.Name,
+				ProcureMethod:   "make_to_stock",
+				Inventory:       inventory,
+				Lot:             line.ProdLot,
+				ResultPackage:   line.Package,
+				CreateUser:      user,
+				UpdateUser:      user,
+			}
+			// 盘亏只从账面数量对应的份中移出，即同一库位、批次和包，盘盈放入盘点的包
+			if i == 1 {
+				move.LocationSrc, move.LocationDest = line.Location, inventoryLoc
+				move.Package, move.ResultPackage = line.Package, nil
+			}
+			if move.Name == "" {
+				move.Name = line.Product.Name
+			}
+			if move.FirstUom == nil {
+				move.FirstUom = line.Product.FirstSaleUom
+			}
+			if _, err = o.Insert(move); err != nil {
+				return err
+			}
+			if err = stockMoveDone(o, move, user); err != nil {
+				return err
+			}
+		}
+		line.MeasureFirstUomQty = theoreticalFirst
+		line.MeasureSecondUomQty = theoreticalSecond
+		line.State = "done"
+		line.UpdateUser = user
+		if _, err = o.Update(line, "MeasureFirstUomQty", "MeasureSecondUomQty", "State", "UpdateUser", "UpdateDate"); err != nil {
+			return err
+		}
+	}
+	inventory.State = "done"
+	inventory.UpdateUser = user
+	if _, err = o.Update(inventory, "State", "UpdateUser", "UpdateDate"); err != nil {
+		return err
+	}
+	return o.Commit()
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// StockLedgerLine 库存台账明细，每个已完成的移动一行
+type StockLedgerLine struct {
+	Move          *StockMove //移动
+	FirstIn       float64    //第一单位入库数量
+	SecondIn      float64    //第二单位入库数量
+	FirstOut      float64    //第一单位出库数量
+	SecondOut     float64    //第二单位出库数量
+	FirstBalance  float64    //第一单位结存数量
+	SecondBalance float64    //第二单位结存数量
+}
+
+// StockLedger 产品在库位中一段时间的库存台账
+type StockLedger struct {
+	Product       *ProductProduct    //产品规格
+	Location      *StockLocation     //库位，为空时为所有内部库位
+	DateStart     time.Time          //开始时间
+	DateEnd       time.Time          //结束时间
+	FirstOpening  float64            //第一单位期初数量
+	SecondOpening float64            //第二单位期初数量
+	FirstClosing  float64            //第一单位期末数量
+	SecondClosing float64            //第二单位期末数量
+	Lines         []*StockLedgerLine //明细
+}
+
+// GetStockLedger 获得产品在库位及其下级库位中的库存台账，期初为开始时间之前已完成移动的结存，
+// 明细为时间范围内的已完成移动及逐笔结存，库位内部的调拨不影响结存
+func GetStockLedger(productID, locationID int64, dateStart, dateEnd time.Time) (ledger *StockLedger, err error) {
+	o := orm.NewOrm()
+	product := &ProductProduct{ID: productID}
+	if err = o.Read(product); err != nil {
+		return nil, errors.New("请选择产品规格")
+	}
+	ledger = &StockLedger{Product: product, DateStart: dateStart, DateEnd: dateEnd}
+	var locationIDs []int64
+	if locationID > 0 {
+		location := &StockLocation{ID: locationID}
+		if err = o.Read(location); err != nil {
+			return nil, err
+		}
+		ledger.Location = location
+	}
+	if locationIDs, err = productAvailabilityLocations(o, 0, locationID); err != nil {
+		return nil, err
+	}
+	inLocation := make(map[int64]bool)
+	for _, id := range locationIDs {
+		inLocation[id] = true
+	}
+	qs := o.QueryTable(new(StockMove)).Filter("Product__Id", productID).Filter("State", "done")
+	if !dateEnd.IsZero() {
+		qs = qs.Filter("Date__lte", dateEnd)
+	}
+	var moves []*StockMove
+	if _, err = qs.RelatedSel("LocationSrc", "LocationDest", "Picking", "Partner", "Lot").OrderBy("Date", "Id").Limit(-1).All(&moves); err != nil {
+		return nil, err
+	}
+	firstBalance, secondBalance := 0.0, 0.0
+	for _, move := range moves {
+		srcIn := move.LocationSrc != nil && inLocation[move.LocationSrc.ID]
+		destIn := move.LocationDest != nil && inLocation[move.LocationDest.ID]
+		if srcIn == destIn {
+			continue
+		}
+		line := &StockLedgerLine{Move: move}
+		if destIn {
+			line.FirstIn = move.FirstUomQty
+			line.SecondIn = move.SecondUomQty
+		} else {
+			line.FirstOut = move.FirstUomQty
+			line.SecondOut = move.SecondUomQty
+		}
+		firstBalance += line.FirstIn - line.FirstOut
+		secondBalance += line.SecondIn - line.SecondOut
+		if !dateStart.IsZero() && move.Date.Before(dateStart) {
+			ledger.FirstOpening = firstBalance
+			ledger.SecondOpening = secondBalance
+			continue
+		}
+		line.FirstBalance = firstBalance
+		line.SecondBalance = secondBalance
+		ledger.Lines = append(ledger.Lines, line)
+	}
+	ledger.FirstClosing = firstBalance
+	ledger.SecondClosing = secondBalance
+	return ledger, nil
+}
@@ -12,30 +12,33 @@ import (
 
 // StockLocation 库位
 type StockLocation struct {
-	ID             int64            `orm:"column(id);pk;auto" json:"id"`         //主键
-	CreateUser     *User            `orm:"rel(fk);null" json:"-"`                //创建者
-	UpdateUser     *User            `orm:"rel(fk);null" json:"-"`                //最后更新者
-	CreateDate     time.Time        `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
-	UpdateDate     time.Time        `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
-	Name           string           `orm:"unique"`                               //库位名称
-	Company        *Company         `orm:"rel(fk);null"`                         //公司
-	Usage          string           `json:"Usage"`                               //库位类型 supplier/view/internal/customer/inventory/procurement/production/transit
-	Active         bool             `orm:"default(true)"`                        //有效
-	Barcode        string           `json:"Barcode"`                             //条码
-	Parent         *StockLocation   `orm:"rel(fk);null"`                         //上级库位
-	Childs         []*StockLocation `orm:"reverse(many)"`                        //子库位
-	ReturnLocation bool             `orm:"default(false)"`                       //是一个退货库位
-	ScrapLocation  bool             `orm:"default(false)"`                       //是一个废料库位
-	Posx           int64            `json:"Posx"`                                //通道(X)
-	Posy           int64            `json:"Posy"`                                //货架(Y)
-	Posz           int64            `json:"Posz"`                                //层
-	// PutawayStrategy string           ``                                           //入库策略,需后续改为many2one
-	// RemovalStrategy string           ``                                           //出库策略需后续改为many2one
-
-	FormAction   string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
-	ActionFields []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
-	CompanyID    int64    `orm:"-" json:"Company"`
-	ParentID     int64    `orm:"-" json:"Parent"`
+	ID              int64                 `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser      *User                 `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser      *User                 `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate      time.Time             `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate      time.Time             `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	Name            string                `orm:"unique"`                               //库位名称
+	Company         *Company              `orm:"rel(fk);null"`                         //公司
+	Usage           string                `json:"Usage"`                               //库位类型 supplier/view/internal/customer/inventory/procurement/production/transit
+	Active          bool                  `orm:"default(true)"`                        //有效
+	Barcode         string                `json:"Barcode"`                             //条码
+	Parent          *StockLocation        `orm:"rel(fk);null"`                         //上级库位
+	Childs          []*StockLocation      `orm:"reverse(many)"`                        //子库位
+	ReturnLocation  bool                  `orm:"default(false)"`                       //是一个退货库位
+	ScrapLocation   bool                  `orm:"default(false)"`                       //是一个废料库位
+	AllowNegative   bool                  `orm:"default(false)"`                       //允许负库存
+	Posx            int64                 `json:"Posx"`                                //通道(X)
+	Posy            int64                 `json:"Posy"`                                //货架(Y)
+	Posz            int64                 `json:"Posz"`                                //层
+	PutawayStrategy *StockPutawayStrategy `orm:"rel(fk);null"`                         //入库策略
+	RemovalStrategy *StockRemovalStrategy `orm:"rel(fk);null"`                         //出库策略
+
+	FormAction        string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
+	ActionFields      []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
+	CompanyID         int64    `orm:"-" json:"Company"`
+	ParentID          int64    `orm:"-" json:"Parent"`
+	PutawayStrategyID int64    `orm:"-" json:"PutawayStrategy"`
+	RemovalStrategyID int64    `orm:"-" json:"RemovalStrategy"`
 }
 
 func init() {
@@ -66,6 +69,12 @@ func AddStockLocation(obj *StockLocation, addUser *User) (id int64, err error) {
 	if obj.ParentID > 0 {
 		obj.Parent, _ = GetStockLocationByID(obj.ParentID)
 	}
+	if obj.PutawayStrategyID > 0 {
+		obj.PutawayStrategy, _ = GetStockPutawayStrategyByID(obj.PutawayStrategyID)
+	}
+	if obj.RemovalStrategyID > 0 {
+		obj.RemovalStrategy, _ = GetStockRemovalStrategyByID(obj.RemovalStrategyID)
+	}
 	id, err = o.Insert(obj)
 	if err == nil {
 		errCommit := o.Commit()
@@ -82,6 +91,12 @@ func GetStockLocationByID(id int64) (obj *StockLocation, err error) {
 	o := orm.NewOrm()
 	obj = &StockLocation{ID: id}
 	if err = o.Read(obj); err == nil {
+		if obj.PutawayStrategy != nil {
+			o.Read(obj.PutawayStrategy)
+		}
+		if obj.RemovalStrategy != nil {
+			o.Read(obj.RemovalStrategy)
+		}
 		return obj, nil
 	}
 	return nil, err
@@ -225,3 +240,37 @@ func DeleteStockLocation(id int64) (err error) {
 	}
 	return
 }
+
+// stockLocationByUsage 根据库位类型获得公司的库位，公司没有时使用公共库位，废料库位只能由报废使用
+func stockLocationByUsage(o orm.Ormer, usage string, company *Company) (*StockLocation, error) {
+	var location StockLocation
+	if company != nil {
+		qs := o.QueryTable(new(StockLocation)).Filter("Usage", usage).Filter("Active", true).Filter("ScrapLocation", false).Filter("Company__Id", company.ID)
+		if err := qs.OrderBy("Id").One(&location); err == nil {
+			return &location, nil
+		}
+	}
+	qs := o.QueryTable(new(StockLocation)).Filter("Usage", usage).Filter("Active", true).Filter("ScrapLocation", false).Filter("Company__isnull", true)
+	if err := qs.OrderBy("Id").One(&location); err != nil {
+		return nil, fmt.Errorf("没有找到类型为%s的库位", usage)
+	}
+	return &location, nil
+}
+
+// stockLocationChildIDs 获得库位及其所有下级库位的ID
+func stockLocationChildIDs(o orm.Ormer, location *StockLocation) ([]int64, error) {
+	ids := []int64{location.ID}
+	parents := []int64{location.ID}
+	for len(parents) > 0 {
+		var childs []*StockLocation
+		if _, err := o.QueryTable(new(StockLocation)).Filter("Parent__Id__in", parents).All(&childs, "Id"); err != nil {
+			return nil, err
+		}
+		parents = parents[:0]
+		for _, child := range childs {
+			ids = append(ids, child.ID)
+			parents = append(parents, child.ID)
+		}
+	}
+	return ids, nil
+}
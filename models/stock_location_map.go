@@ -0,0 +1,345 @@
+package models
+
+import (
+	"math"
+	"sort"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// StockBinContent 货位中的产品，按产品规格和批次汇总
+type StockBinContent struct {
+	Product      *ProductProduct     //产品规格
+	Lot          *StockProductionLot //批次
+	FirstUomQty  float64             //第一单位数量
+	SecondUomQty float64             //第二单位数量
+}
+
+// StockBin 货位，位置由库位的通道、货架、层确定
+type StockBin struct {
+	Location     *StockLocation     //库位
+	FirstUomQty  float64            //第一单位数量
+	SecondUomQty float64            //第二单位数量
+	Contents     []*StockBinContent //货位中的产品
+}
+
+// Occupied 货位中有库存
+func (bin *StockBin) Occupied() bool {
+	return bin.FirstUomQty > stockQtyEpsilon || bin.SecondUomQty > stockQtyEpsilon
+}
+
+// StockMapShelf 通道中的货架，货位按层从高到低排列
+type StockMapShelf struct {
+	Posy int64       //货架
+	Bins []*StockBin //货位
+}
+
+// StockMapAisle 通道，货架按编号排列
+type StockMapAisle struct {
+	Posx    int64            //通道
+	Shelves []*StockMapShelf //货架
+}
+
+// StockLocationMap 库位平面图
+type StockLocationMap struct {
+	Location      *StockLocation   //根库位
+	Aisles        []*StockMapAisle //通道
+	Unplaced      []*StockBin      //没有设置坐标的货位
+	BinCount      int64            //货位数量
+	OccupiedCount int64            //有库存的货位数量
+	Occupancy     float64          //占用率，百分比
+}
+
+// stockLocationPlaced 库位设置了通道、货架或层的坐标
+func stockLocationPlaced(location *StockLocation) bool {
+	return location.Posx != 0 || location.Posy != 0 || location.Posz != 0
+}
+
+// stockLocationBins 获得根库位下的货位，货位为没有有效下级库位的内部库位
+func stockLocationBins(o orm.Ormer, root *StockLocation) (bins []*StockLocation, err error) {
+	ids, err := stockLocationChildIDs(o, root)
+	if err != nil {
+		return nil, err
+	}
+	var locations []*StockLocation
+	if _, err = o.QueryTable(new(StockLocation)).Filter("Id__in", ids).Filter("Active", true).Limit(-1).All(&locations); err != nil {
+		return nil, err
+	}
+	hasChild := make(map[int64]bool)
+	for _, location := range locations {
+		if location.Parent != nil {
+			hasChild[location.Parent.ID] = true
+		}
+	}
+	for _, location := range locations {
+		if location.Usage == "internal" && !hasChild[location.ID] {
+			bins = append(bins, location)
+		}
+	}
+	return bins, nil
+}
+
+// GetStockLocationMap 获得库位的平面图，按通道、货架、层排列货位并统计每个货位的库存和占用率
+func GetStockLocationMap(locationID int64) (locationMap *StockLocationMap, err error) {
+	o := orm.NewOrm()
+	root := &StockLocation{ID: locationID}
+	if err = o.Read(root); err != nil {
+		return nil, err
+	}
+	locations, err := stockLocationBins(o, root)
+	if err != nil {
+		return nil, err
+	}
+	locationMap = &StockLocationMap{Location: root}
+	if len(locations) == 0 {
+		return locationMap, nil
+	}
+	binMap := make(map[int64]*StockBin)
+	locationIDs := make([]int64, 0, len(locations))
+	for _, location := range locations {
+		binMap[location.ID] = &StockBin{Location: location}
+		locationIDs = append(locationIDs, location.ID)
+	}
+	qtyCond := orm.NewCondition().Or("FirstUomQty__gt", 0).Or("SecondUomQty__gt", 0)
+	cond := orm.NewCondition().And("Location__Id__in", locationIDs).AndCond(qtyCond)
+	var quants []*StockQuant
+	if _, err = o.QueryTable(new(StockQuant)).SetCond(cond).RelatedSel("Product").Limit(-1).All(&quants); err != nil {
+		return nil, err
+	}
+	lots := make(map[int64]*StockProductionLot)
+	for _, quant := range quants {
+		bin := binMap[quant.Location.ID]
+		var lot *StockProductionLot
+		if quant.Lot != nil {
+			var ok bool
+			if lot, ok = lots[quant.Lot.ID]; !ok {
+				lot = &StockProductionLot{ID: quant.Lot.ID}
+				if err = o.Read(lot); err != nil {
+					return nil, err
+				}
+				lots[lot.ID] = lot
+			}
+		}
+		var content *StockBinContent
+		for _, c := range bin.Contents {
+			if c.Product.ID == quant.Product.ID && c.Lot == lot {
+				content = c
+				break
+			}
+		}
+		if content == nil {
+			content = &StockBinContent{Product: quant.Product, Lot: lot}
+			bin.Contents = append(bin.Contents, content)
+		}
+		content.FirstUomQty += math.Max(quant.FirstUomQty, 0)
+		content.SecondUomQty += math.Max(quant.SecondUomQty, 0)
+		bin.FirstUomQty += math.Max(quant.FirstUomQty, 0)
+		bin.SecondUomQty += math.Max(quant.SecondUomQty, 0)
+	}
+	aisles := make(map[int64]*StockMapAisle)
+	shelves := make(map[[2]int64]*StockMapShelf)
+	for _, location := range locations {
+		bin := binMap[location.ID]
+		locationMap.BinCount++
+		if bin.Occupied() {
+			locationMap.OccupiedCount++
+		}
+		if !stockLocationPlaced(location) {
+			locationMap.Unplaced = append(locationMap.Unplaced, bin)
+			continue
+		}
+		aisle, ok := aisles[location.Posx]
+		if !ok {
+			aisle = &StockMapAisle{Posx: location.Posx}
+			aisles[location.Posx] = aisle
+			locationMap.Aisles = append(locationMap.Aisles, aisle)
+		}
+		key := [2]int64{location.Posx, location.Posy}
+		shelf, ok := shelves[key]
+		if !ok {
+			shelf = &StockMapShelf{Posy: location.Posy}
+			shelves[key] = shelf
+			aisle.Shelves = append(aisle.Shelves, shelf)
+		}
+		shelf.Bins = append(shelf.Bins, bin)
+	}
+	locationMap.Occupancy = float64(locationMap.OccupiedCount) * 100 / float64(locationMap.BinCount)
+	sort.Slice(locationMap.Aisles, func(i, j int) bool {
+		return locationMap.Aisles[i].Posx < locationMap.Aisles[j].Posx
+	})
+	for _, aisle := range locationMap.Aisles {
+		sort.Slice(aisle.Shelves, func(i, j int) bool {
+			return aisle.Shelves[i].Posy < aisle.Shelves[j].Posy
+		})
+		for _, shelf := range aisle.Shelves {
+			bins := shelf.Bins
+			sort.Slice(bins, func(i, j int) bool {
+				if bins[i].Location.Posz != bins[j].Location.Posz {
+					return bins[i].Location.Posz > bins[j].Location.Posz
+				}
+				return bins[i].Location.Name < bins[j].Location.Name
+			})
+		}
+	}
+	sort.Slice(locationMap.Unplaced, func(i, j int) bool {
+		return locationMap.Unplaced[i].Location.Name < locationMap.Unplaced[j].Location.Name
+	})
+	return locationMap, nil
+}
+
+// StockPickPathLine 拣货路径明细，移动按保留的份所在的库位拆分
+type StockPickPathLine struct {
+	Move         *StockMove          //移动
+	Location     *StockLocation      //拣货库位
+	Lot          *StockProductionLot //批次
+	FirstUomQty  float64             //第一单位数量
+	SecondUomQty float64             //第二单位数量
+}
+
+// stockPickPathSort 按S形路线排列拣货明细：通道从小到大，相邻通道的货架方向相反，
+// 同一货架从低层到高层，没有设置坐标的库位排在最后
+func stockPickPathSort(lines []*StockPickPathLine) {
+	var aisles []int64
+	seen := make(map[int64]bool)
+	for _, line := range lines {
+		if stockLocationPlaced(line.Location) && !seen[line.Location.Posx] {
+			seen[line.Location.Posx] = true
+			aisles = append(aisles, line.Location.Posx)
+		}
+	}
+	sort.Slice(aisles, func(i, j int) bool { return aisles[i] < aisles[j] })
+	reverse := make(map[int64]bool)
+	for i, posx := range aisles {
+		reverse[posx] = i%2 == 1
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		a, b := lines[i].Location, lines[j].Location
+		if placedA, placedB := stockLocationPlaced(a), stockLocationPlaced(b); placedA != placedB {
+			return placedA
+		}
+		if a.Posx != b.Posx {
+			return a.Posx < b.Posx
+		}
+		if a.Posy != b.Posy {
+			if reverse[a.Posx] {
+				return a.Posy > b.Posy
+			}
+			return a.Posy < b.Posy
+		}
+		if a.Posz != b.Posz {
+			return a.Posz < b.Posz
+		}
+		return a.Name < b.Name
+	})
+}
+
+// stockPickingPickPath 获得调拨单未完成移动的拣货路径，已保留的份按所在库位拣货，
+// 未保留的数量在移动的源库位拣货
+func stockPickingPickPath(o orm.Ormer, picking *StockPicking) (lines []*StockPickPathLine, err error) {
+	moves, err := stockPickingMoves(o, picking)
+	if err != nil {
+		return nil, err
+	}
+	locations := make(map[int64]*StockLocation)
+	getLocation := func(id int64) (*StockLocation, error) {
+		if location, ok := locations[id]; ok {
+			return location, nil
+		}
+		location := &StockLocation{ID: id}
+		if err := o.Read(location); err != nil {
+			return nil, err
+		}
+		locations[id] = location
+		return location, nil
+	}
+	for _, move := range moves {
+		if move.State == "done" || move.State == "cancel" || move.LocationSrc == nil {
+			continue
+		}
+		if _, err = o.LoadRelated(move, "Product"); err != nil {
+			return nil, err
+		}
+		var quants []*StockQuant
+		if _, err = o.QueryTable(new(StockQuant)).Filter("Reservation__Id", move.ID).OrderBy("Id").All(&quants); err != nil {
+			return nil, err
+		}
+		firstQty, secondQty := move.FirstUomQty, move.SecondUomQty
+		for _, quant := range quants {
+			line := &StockPickPathLine{Move: move, Lot: quant.Lot, FirstUomQty: quant.FirstUomQty, SecondUomQty: quant.SecondUomQty}
+			if line.Location, err = getLocation(quant.Location.ID); err != nil {
+				return nil, err
+			}
+			if line.Lot != nil {
+				if err = o.Read(line.Lot); err != nil {
+					return nil, err
+				}
+			}
+			lines = append(lines, line)
+			firstQty -= quant.FirstUomQty
+			secondQty -= quant.SecondUomQty
+		}
+		if firstQty > stockQtyEpsilon || secondQty > stockQtyEpsilon {
+			line := &StockPickPathLine{Move: move, Lot: move.Lot, FirstUomQty: math.Max(firstQty, 0), SecondUomQty: math.Max(secondQty, 0)}
+			if line.Location, err = getLocation(move.LocationSrc.ID); err != nil {
+				return nil, err
+			}
+			if line.Lot != nil {
+				if err = o.Read(line.Lot); err != nil {
+					return nil, err
+				}
+			}
+			lines = append(lines, line)
+		}
+	}
+	stockPickPathSort(lines)
+	return lines, nil
+}
+
+// GetStockPickingPickPath 获得调拨单的拣货路径
+func GetStockPickingPickPath(id int64) ([]*StockPickPathLine, error) {
+	o := orm.NewOrm()
+	picking := &StockPicking{ID: id}
+	if err := o.Read(picking); err != nil {
+		return nil, err
+	}
+	return stockPickingPickPath(o, picking)
+}
+
+// SortStockPickingMoves 按拣货路径重新设置调拨单移动的序号，扫码和明细按拣货顺序显示
+func SortStockPickingMoves(id int64, user *User) (err error) {
+	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return errBegin
+	}
+	picking := &StockPicking{ID: id}
+	if err = o.Read(picking); err != nil {
+		return err
+	}
+	var lines []*StockPickPathLine
+	if lines, err = stockPickingPickPath(o, picking); err != nil {
+		return err
+	}
+	var sequence int64
+	sorted := make(map[int64]bool)
+	for _, line := range lines {
+		if sorted[line.Move.ID] {
+			continue
+		}
+		sorted[line.Move.ID] = true
+		sequence++
+		line.Move.Sequence = sequence
+		line.Move.UpdateUser = user
+		if _, err = o.Update(line.Move, "Sequence", "UpdateUser", "UpdateDate"); err != nil {
+			return err
+		}
+	}
+	return o.Commit()
+}
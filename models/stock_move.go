@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"goERP/utils"
+	"math"
 	"strings"
 	"time"
 
@@ -12,56 +13,243 @@ import (
 
 // StockMove  	移动明细
 type StockMove struct {
-	ID                 int64             `orm:"column(id);pk;auto" json:"id"`                //主键
-	CreateUser         *User             `orm:"rel(fk);null" json:"-"`                       //创建者
-	UpdateUser         *User             `orm:"rel(fk);null" json:"-"`                       //最后更新者
-	CreateDate         time.Time         `orm:"auto_now_add;type(datetime)" json:"-"`        //创建时间
-	UpdateDate         time.Time         `orm:"auto_now;type(datetime)" json:"-"`            //最后更新时间
-	Sequence           int64             `orm:"default(0)" json:"Sequence"`                  //序列号
-	Name               string            `json:"Name"`                                       //明细产品名称
-	Priority           int64             `orm:"default(1)" json:"Priority"`                  //优先级
-	Date               time.Time         `orm:" type(datetime)"`                             //预定日期
-	DateExpected       time.Time         `orm:" type(datetime)"`                             //预定日期
-	Product            *ProductProduct   `orm:"rel(fk)"`                                     //产品规格
-	FirstUomQty        float64           `orm:"default(0)"`                                  //第一单位数量
-	SecondUomQty       float64           `orm:"default(0)"`                                  //第二单位数量
-	FirstUom           *ProductUom       `orm:"rel(fk)"`                                     //第一单位
-	SecondUom          *ProductUom       `orm:"rel(fk);null"`                                //第二单位
-	ProductTemplate    *ProductTemplate  `orm:"rel(fk);null"`                                //产品款式
-	ProductPackaging   *ProductPackaging `orm:"rel(fk);null"`                                //包装类型、包装数量等属性
-	LocationSrc        *StockLocation    `orm:"rel(fk);null"`                                //源库位
-	LocationDest       *StockLocation    `orm:"rel(fk);null"`                                //目标库位
-	Partner            *Partner          `orm:"rel(fk);null"`                                //合作伙伴
-	Picking            *StockPicking     `orm:"rel(fk)"`                                     //调拨单
-	State              string            `orm:"default(draft)" json:"State"`                 //状态
-	Note               string            `json:"Note"`                                       //备注
-	PartiallyAvailable bool              `orm:"default(false)"`                              //部分出货
-	PriceUnit          float64           `orm:"default(0)" json:"PriceUnit"`                 //单价
-	Company            *Company          `orm:"rel(fk)"`                                     //所属公司
-	BackOrder          *StockPicking     `orm:"rel(fk);null"`                                //退货单
-	Origin             string            `json:"Origin"`                                     //源数据
-	ProcureMethod      string            `orm:"default(make_to_order)" json:"ProcureMethod"` //单据来源:make_to_stock/make_to_order
-	Scrapped           bool              `orm:"default(false)" json:"Scrapped"`              //报废，跟LocationDest的类型一致
-	Quants             []*StockQuant     `orm:"rel(m2m);rel_table(stock_quant_move_rel)"`    //迁移数量
-	ReservedQuant      []*StockQuant     `orm:"reverse(many)"`                               //保留数量
-	Inventory          *StockInventory   `orm:"rel(fk);null"`                                //盘点单
-	WareHouse          *StockWarehouse   `orm:"rel(fk);null"`                                //仓库
-	FormAction         string            `orm:"-" json:"FormAction"`                         //非数据库字段，用于表示记录的增加，修改
-	ActionFields       []string          `orm:"-" json:"ActionFields"`                       //需要操作的字段,用于update时
-	PickingID          int64             `orm:"-" json:"Picking"`                            //
-	FirstUomID         int64             `orm:"-" json:"FirstUom"`                           //
-	SecondUomID        int64             `orm:"-" json:"SecondUom"`                          //
+	ID                 int64               `orm:"column(id);pk;auto" json:"id"`                //主键
+	CreateUser         *User               `orm:"rel(fk);null" json:"-"`                       //创建者
+	UpdateUser         *User               `orm:"rel(fk);null" json:"-"`                       //最后更新者
+	CreateDate         time.Time           `orm:"auto_now_add;type(datetime)" json:"-"`        //创建时间
+	UpdateDate         time.Time           `orm:"auto_now;type(datetime)" json:"-"`            //最后更新时间
+	Sequence           int64               `orm:"default(0)" json:"Sequence"`                  //序列号
+	Name               string              `json:"Name"`                                       //明细产品名称
+	Priority           int64               `orm:"default(1)" json:"Priority"`                  //优先级
+	Date               time.Time           `orm:" type(datetime)"`                             //预定日期
+	DateExpected       time.Time           `orm:" type(datetime)"`                             //预定日期
+	Product            *ProductProduct     `orm:"rel(fk)"`                                     //产品规格
+	FirstUomQty        float64             `orm:"default(0)"`                                  //第一单位数量
+	SecondUomQty       float64             `orm:"default(0)"`                                  //第二单位数量
+	FirstUom           *ProductUom         `orm:"rel(fk)"`                                     //第一单位
+	SecondUom          *ProductUom         `orm:"rel(fk);null"`                                //第二单位
+	ProductTemplate    *ProductTemplate    `orm:"rel(fk);null"`                                //产品款式
+	ProductPackaging   *ProductPackaging   `orm:"rel(fk);null"`                                //包装类型、包装数量等属性
+	LocationSrc        *StockLocation      `orm:"rel(fk);null"`                                //源库位
+	LocationDest       *StockLocation      `orm:"rel(fk);null"`                                //目标库位
+	Partner            *Partner            `orm:"rel(fk);null"`                                //合作伙伴
+	Picking            *StockPicking       `orm:"rel(fk);null"`                                //调拨单
+	State              string              `orm:"default(draft)" json:"State"`                 //状态:draft/confirm/waiting/assigned/done/cancel
+	Note               string              `json:"Note"`                                       //备注
+	PartiallyAvailable bool                `orm:"default(false)"`                              //部分出货
+	PriceUnit          float64             `orm:"default(0)" json:"PriceUnit"`                 //单价
+	Company            *Company            `orm:"rel(fk)"`                                     //所属公司
+	BackOrder          *StockPicking       `orm:"rel(fk);null"`                                //剩余数量所在的欠单
+	FirstQtyDone       float64             `orm:"default(0)" json:"FirstQtyDone"`              //第一单位完成数量
+	SecondQtyDone      float64             `orm:"default(0)" json:"SecondQtyDone"`             //第二单位完成数量
+	Origin             string              `json:"Origin"`                                     //源数据
+	ProcureMethod      string              `orm:"default(make_to_order)" json:"ProcureMethod"` //单据来源:make_to_stock/make_to_order
+	Scrapped           bool                `orm:"default(false)" json:"Scrapped"`              //报废，跟LocationDest的类型一致
+	Quants             []*StockQuant       `orm:"rel(m2m);rel_table(stock_quant_move_rel)"`    //迁移数量
+	ReservedQuant      []*StockQuant       `orm:"reverse(many)"`                               //保留数量
+	Inventory          *StockInventory     `orm:"rel(fk);null"`                                //盘点单
+	WareHouse          *StockWarehouse     `orm:"rel(fk);null"`                                //仓库
+	SaleOrderLine      *SaleOrderLine      `orm:"rel(fk);null"`                                //销售订单明细
+	PurchaseOrderLine  *PurchaseOrderLine  `orm:"rel(fk);null"`                                //采购订单明细
+	Lot                *StockProductionLot `orm:"rel(fk);null"`                                //批次、序列号
+	Package            *StockQuantPackage  `orm:"rel(fk);null"`                                //源包，整包移动时只使用包内的份
+	ResultPackage      *StockQuantPackage  `orm:"rel(fk);null"`                                //目标包，完成后份装入该包
+	MoveOrigin         *StockMove          `orm:"rel(fk);null"`                                //多步流程中的上一步移动
+	ReturnedMove       *StockMove          `orm:"rel(fk);null"`                                //退货对应的原移动
+	Value              float64             `orm:"default(0)" json:"Value"`                     //库存价值变动，入库为正出库为负
+	FormAction         string              `orm:"-" json:"FormAction"`                         //非数据库字段，用于表示记录的增加，修改
+	ActionFields       []string            `orm:"-" json:"ActionFields"`                       //需要操作的字段,用于update时
+	PickingID          int64               `orm:"-" json:"Picking"`                            //
+	FirstUomID         int64               `orm:"-" json:"FirstUom"`                           //
+	SecondUomID        int64               `orm:"-" json:"SecondUom"`                          //
+	LotID              int64               `orm:"-" json:"Lot"`                                //
 
 }
 
 func init() {
 	orm.RegisterModel(new(StockMove))
 }
-func FirstRemainingQty(obj *StockMove) int {
-	return 0
+
+// stockMoveReservedQty 获得为移动保留的份的数量
+func stockMoveReservedQty(o orm.Ormer, obj *StockMove) (firstQty, secondQty float64) {
+	var quants []*StockQuant
+	if _, err := o.QueryTable(new(StockQuant)).Filter("Reservation__Id", obj.ID).All(&quants, "FirstUomQty", "SecondUomQty"); err == nil {
+		for _, quant := range quants {
+			firstQty += quant.FirstUomQty
+			secondQty += quant.SecondUomQty
+		}
+	}
+	return firstQty, secondQty
+}
+
+// FirstRemainingQty 第一单位尚未被份满足的数量，完成或取消的移动为0
+func FirstRemainingQty(obj *StockMove) float64 {
+	if obj.State == "done" || obj.State == "cancel" {
+		return 0
+	}
+	firstQty, _ := stockMoveReservedQty(orm.NewOrm(), obj)
+	return math.Max(obj.FirstUomQty-firstQty, 0)
+}
+
+// SecondRemainingQty 第二单位尚未被份满足的数量，完成或取消的移动为0
+func SecondRemainingQty(obj *StockMove) float64 {
+	if obj.State == "done" || obj.State == "cancel" {
+		return 0
+	}
+	_, secondQty := stockMoveReservedQty(orm.NewOrm(), obj)
+	return math.Max(obj.SecondUomQty-secondQty, 0)
+}
+
+// DoneStockMove 完成移动，将源库位的份转移到目标库位
+func DoneStockMove(id int64, doneUser *User) (err error) {
+	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return errBegin
+	}
+	move := &StockMove{ID: id}
+	if err = o.Read(move); err != nil {
+		return err
+	}
+	if err = stockMoveDone(o, move, doneUser); err != nil {
+		return err
+	}
+	return o.Commit()
+}
+
+// stockMoveDone 在事务中完成移动，释放移动剩余的保留
+func stockMoveDone(o orm.Ormer, move *StockMove, doneUser *User) (err error) {
+	if move.State == "done" || move.State == "cancel" {
+		return fmt.Errorf("移动[%s]状态为%s,不能完成", move.Name, move.State)
+	}
+	if move.LocationSrc == nil || move.LocationDest == nil {
+		return fmt.Errorf("移动[%s]缺少源库位或目标库位", move.Name)
+	}
+	if err = o.Read(move.LocationSrc); err != nil {
+		return err
+	}
+	if err = o.Read(move.LocationDest); err != nil {
+		return err
+	}
+	if move.Picking != nil || stockMoveIncoming(move) {
+		if err = stockMoveCheckLot(o, move); err != nil {
+			return err
+		}
+	}
+	if err = stockMoveValuationIn(o, move, doneUser); err != nil {
+		return err
+	}
+	var movedCost float64
+	if movedCost, err = quantsMoveForMove(o, move, doneUser); err != nil {
+		return err
+	}
+	if err = stockMoveValuationConsignment(o, move); err != nil {
+		return err
+	}
+	if err = stockMoveValuationOut(o, move, movedCost); err != nil {
+		return err
+	}
+	if err = quantsUnreserve(o, move); err != nil {
+		return err
+	}
+	move.State = "done"
+	move.Date = time.Now()
+	move.PartiallyAvailable = false
+	move.UpdateUser = doneUser
+	if _, err = o.Update(move, "State", "Date", "Value", "PartiallyAvailable", "UpdateUser", "UpdateDate"); err != nil {
+		return err
+	}
+	if err = stockMoveAssignDests(o, move, doneUser); err != nil {
+		return err
+	}
+	return stockMoveUpdateOrigin(o, move, doneUser)
+}
+
+// stockMoveAssignDests 移动完成后为等待该移动的后续移动保留库存，后续移动没有批次时使用该移动的批次
+func stockMoveAssignDests(o orm.Ormer, move *StockMove, user *User) (err error) {
+	var dests []*StockMove
+	if _, err = o.QueryTable(new(StockMove)).Filter("MoveOrigin__Id", move.ID).Filter("State", "waiting").All(&dests); err != nil {
+		return err
+	}
+	for _, dest := range dests {
+		if dest.Lot == nil && move.Lot != nil {
+			dest.Lot = move.Lot
+			if _, err = o.Update(dest, "Lot"); err != nil {
+				return err
+			}
+		}
+		if err = stockMoveAssign(o, dest, user); err != nil {
+			return err
+		}
+		if dest.Picking != nil {
+			picking := &StockPicking{ID: dest.Picking.ID}
+			if err = o.Read(picking); err != nil {
+				return err
+			}
+			if err = stockPickingUpdateState(o, picking, user); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
-func SecondRemainingQty(obj *StockMove) int {
-	return 0
+
+// stockMoveIncoming 移动是否从外部库位（供应商、客户、盘点损益等）进入库存
+func stockMoveIncoming(move *StockMove) bool {
+	return move.LocationSrc != nil && move.LocationDest != nil && !locationNeedQuants(move.LocationSrc) && locationNeedQuants(move.LocationDest)
+}
+
+// stockMoveCheckLot 调拨单和入库移动中追踪批次或序列号的产品必须指定批次，序列号产品每个移动只能处理一件，
+// 入库的序列号不能已经在库存中
+func stockMoveCheckLot(o orm.Ormer, move *StockMove) error {
+	tracking, err := productTracking(o, move.Product)
+	if err != nil {
+		return err
+	}
+	if tracking == "none" {
+		return nil
+	}
+	if move.Lot == nil {
+		return fmt.Errorf("移动[%s]的产品需要追踪批次或序列号,请指定批次", move.Name)
+	}
+	lot := &StockProductionLot{ID: move.Lot.ID}
+	if err = o.Read(lot); err != nil {
+		return err
+	}
+	if lot.Product.ID != move.Product.ID {
+		return fmt.Errorf("批次[%s]不属于移动[%s]的产品", lot.Name, move.Name)
+	}
+	if tracking == "serial" && move.FirstUomQty > 1+stockQtyEpsilon {
+		return fmt.Errorf("移动[%s]的产品按序列号追踪,每个移动只能处理一件", move.Name)
+	}
+	if tracking == "serial" && stockMoveIncoming(move) {
+		cnt, err := o.QueryTable(new(StockQuant)).Filter("Lot__Id", lot.ID).Filter("Location__Usage__in", "internal", "transit").Filter("FirstUomQty__gt", 0).Count()
+		if err != nil {
+			return err
+		}
+		if cnt > 0 {
+			return fmt.Errorf("序列号[%s]已在库存中,不能重复入库", lot.Name)
+		}
+	}
+	return nil
+}
+
+// stockMoveUpdateOrigin 移动状态变化后更新来源单据明细的状态
+func stockMoveUpdateOrigin(o orm.Ormer, move *StockMove, user *User) error {
+	if move.SaleOrderLine != nil {
+		return saleOrderLineUpdateState(o, move.SaleOrderLine, user)
+	}
+	if move.PurchaseOrderLine != nil {
+		return purchaseOrderLineUpdateState(o, move.PurchaseOrderLine, user)
+	}
+	return nil
 }
 
 // AddStockMove insert a new StockMove into database and returns
@@ -84,6 +272,9 @@ func AddStockMove(obj *StockMove, addUser *User) (id int64, err error) {
 	if obj.PickingID > 0 {
 		obj.Picking, _ = GetStockPickingByID(obj.PickingID)
 	}
+	if obj.LotID > 0 {
+		obj.Lot, _ = GetStockProductionLotByID(obj.LotID)
+	}
 	id, err = o.Insert(obj)
 	if err == nil {
 		errCommit := o.Commit()
@@ -242,3 +433,75 @@ func DeleteStockMove(id int64) (err error) {
 	}
 	return
 }
+
+// stockMoveAssign 为移动保留库存：全部保留为assigned，部分保留为confirm且部分可用，无可用库存为waiting
+func stockMoveAssign(o orm.Ormer, move *StockMove, user *User) (err error) {
+	if move.State == "done" || move.State == "cancel" || move.State == "assigned" {
+		return nil
+	}
+	if move.LocationSrc == nil {
+		return fmt.Errorf("移动[%s]缺少源库位", move.Name)
+	}
+	if err = o.Read(move.LocationSrc); err != nil {
+		return err
+	}
+	// 上一步移动未完成时等待
+	if move.MoveOrigin != nil {
+		origin := &StockMove{ID: move.MoveOrigin.ID}
+		if err = o.Read(origin); err != nil {
+			return err
+		}
+		if origin.State != "done" {
+			move.State = "waiting"
+			move.PartiallyAvailable = false
+			move.UpdateUser = user
+			_, err = o.Update(move, "State", "PartiallyAvailable", "UpdateUser", "UpdateDate")
+			return err
+		}
+	}
+	if locationNeedQuants(move.LocationSrc) {
+		reservedFirstQty, reservedSecondQty := stockMoveReservedQty(o, move)
+		var firstQty, secondQty float64
+		if firstQty, secondQty, err = quantsReserve(o, move, move.FirstUomQty-reservedFirstQty, move.SecondUomQty-reservedSecondQty, user); err != nil {
+			return err
+		}
+		reservedFirstQty += firstQty
+		reservedSecondQty += secondQty
+		if move.FirstUomQty-reservedFirstQty <= stockQtyEpsilon && move.SecondUomQty-reservedSecondQty <= stockQtyEpsilon {
+			move.State = "assigned"
+			move.PartiallyAvailable = false
+		} else if reservedFirstQty > stockQtyEpsilon || reservedSecondQty > stockQtyEpsilon {
+			move.State = "confirm"
+			move.PartiallyAvailable = true
+		} else {
+			move.State = "waiting"
+			move.PartiallyAvailable = false
+		}
+	} else {
+		// 供应商、客户等外部库位不需要保留
+		move.State = "assigned"
+		move.PartiallyAvailable = false
+	}
+	move.UpdateUser = user
+	if _, err = o.Update(move, "State", "PartiallyAvailable", "UpdateUser", "UpdateDate"); err != nil {
+		return err
+	}
+	return stockMoveUpdateOrigin(o, move, user)
+}
+
+// stockMoveCancel 取消移动并释放保留
+func stockMoveCancel(o orm.Ormer, move *StockMove, user *User) (err error) {
+	if move.State == "done" {
+		return fmt.Errorf("移动[%s]已完成,不能取消", move.Name)
+	}
+	if err = quantsUnreserve(o, move); err != nil {
+		return err
+	}
+	move.State = "cancel"
+	move.PartiallyAvailable = false
+	move.UpdateUser = user
+	if _, err = o.Update(move, "State", "PartiallyAvailable", "UpdateUser", "UpdateDate"); err != nil {
+		return err
+	}
+	return stockMoveUpdateOrigin(o, move, user)
+}
@@ -1 +1,306 @@
 package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// StockPackOperation 包装操作，调拨单处理时整包移动或将产品装入包中
+type StockPackOperation struct {
+	ID            int64              `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser    *User              `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser    *User              `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate    time.Time          `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate    time.Time          `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	Picking       *StockPicking      `orm:"rel(fk)"`                              //调拨单
+	OperationType string             `orm:"default(pack)" json:"OperationType"`   //操作类型:pack装包/move整包移动
+	Package       *StockQuantPackage `orm:"rel(fk);null"`                         //整包移动的包
+	ResultPackage *StockQuantPackage `orm:"rel(fk);null"`                         //装入的目标包
+	Move          *StockMove         `orm:"rel(fk);null"`                         //装包的移动
+	LocationSrc   *StockLocation     `orm:"rel(fk);null"`                         //源库位
+	LocationDest  *StockLocation     `orm:"rel(fk);null"`                         //目标库位
+	State         string             `orm:"default(draft)" json:"State"`          //状态:draft/done/cancel
+
+	FormAction   string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
+	ActionFields []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
+}
+
+func init() {
+	orm.RegisterModel(new(StockPackOperation))
+}
+
+// stockPickingCheckOpen 检查调拨单是否可以继续处理
+func stockPickingCheckOpen(o orm.Ormer, picking *StockPicking) error {
+	if err := o.Read(picking); err != nil {
+		return err
+	}
+	if picking.State == "done" || picking.State == "cancel" {
+		return fmt.Errorf("调拨单[%s]状态为%s,不能处理", picking.Name, picking.State)
+	}
+	return nil
+}
+
+// MoveStockPickingPackage 整包移动，按包内的份为调拨单生成移动，完成后包及下级包一起转到目标库位
+func MoveStockPickingPackage(pickingID, packageID int64, user *User) (err error) {
+	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return errBegin
+	}
+	picking := &StockPicking{ID: pickingID}
+	if err = stockPickingCheckOpen(o, picking); err != nil {
+		return err
+	}
+	pack := &StockQuantPackage{ID: packageID}
+	if err = o.Read(pack); err != nil {
+		return err
+	}
+	if _, err = stockPickingMovePackage(o, picking, pack, user); err != nil {
+		return err
+	}
+	return o.Commit()
+}
+
+// stockPickingPackageQuants 检查包可以由调拨单整包移动，包可以在源库位的下级库位中，返回包及下级包中的份
+func stockPickingPackageQuants(o orm.Ormer, picking *StockPicking, pack *StockQuantPackage) (quants []*StockQuant, err error) {
+	if pack.Parent != nil {
+		return nil, fmt.Errorf("包[%s]在其他包中,请移动最上级的包", pack.Name)
+	}
+	if pack.Location == nil {
+		return nil, fmt.Errorf("包[%s]不在调拨单的源库位", pack.Name)
+	}
+	var srcIDs []int64
+	if srcIDs, err = stockLocationChildIDs(o, picking.LocationSrc); err != nil {
+		return nil, err
+	}
+	inSrc := false
+	for _, id := range srcIDs {
+		if id == pack.Location.ID {
+			inSrc = true
+			break
+		}
+	}
+	if !inSrc {
+		return nil, fmt.Errorf("包[%s]不在调拨单的源库位", pack.Name)
+	}
+	if o.QueryTable(new(StockPackOperation)).Filter("Package__Id", pack.ID).Filter("State", "draft").Exist() {
+		return nil, fmt.Errorf("包[%s]已在其他调拨单中", pack.Name)
+	}
+	var packageIDs []int64
+	if packageIDs, err = stockQuantPackageChildIDs(o, pack); err != nil {
+		return nil, err
+	}
+	qs := o.QueryTable(new(StockQuant)).Filter("Package__Id__in", packageIDs).Filter("Location__Id", pack.Location.ID)
+	if _, err = qs.RelatedSel("Product").OrderBy("Package__Id", "Product__Id", "Id").All(&quants); err != nil {
+		return nil, err
+	}
+	if len(quants) == 0 {
+		return nil, fmt.Errorf("包[%s]中没有产品", pack.Name)
+	}
+	return quants, nil
+}
+
+// stockPackageQuantKey 同一个包中相同产品和批次的份由一个移动处理
+func stockPackageQuantKey(quant *StockQuant) string {
+	var lotID int64
+	if quant.Lot != nil {
+		lotID = quant.Lot.ID
+	}
+	return fmt.Sprintf("%d-%d-%d", quant.Package.ID, quant.Product.ID, lotID)
+}
+
+// stockPackageNewMove 为包内的份生成调拨单的新移动，数量由调用者累加
+func stockPackageNewMove(picking *StockPicking, pack *StockQuantPackage, quant *StockQuant, sequence int64, user *User) *StockMove {
+	now := time.Now()
+	return &StockMove{
+		Sequence:        sequence,
+		Name:            quant.Product.Name,
+		Date:            now,
+		DateExpected:    now,
+		Product:         quant.Product,
+		ProductTemplate: quant.Product.ProductTemplate,
+		FirstUom:        quant.FirstUom,
+		SecondUom:       quant.SecondUom,
+		LocationSrc:     pack.Location,
+		LocationDest:    picking.LocationDest,
+		Partner:         picking.Partner,
+		Picking:         picking,
+		State:           "confirm",
+		Company:         picking.Company,
+		Origin:          picking.Name,
+		ProcureMethod:   "make_to_stock",
+		Lot:             quant.Lot,
+		Package:         quant.Package,
+		ResultPackage:   quant.Package,
+		CreateUser:      user,
+		UpdateUser:      user,
+	}
+}
+
+// stockPickingPackageOperation 记录整包移动操作，调拨单完成时包转到目标库位
+func stockPickingPackageOperation(o orm.Ormer, picking *StockPicking, pack *StockQuantPackage, user *User) error {
+	operation := &StockPackOperation{
+		Picking:       picking,
+		OperationType: "move",
+		Package:       pack,
+		ResultPackage: pack,
+		LocationSrc:   pack.Location,
+		LocationDest:  picking.LocationDest,
+		State:         "draft",
+		CreateUser:    user,
+		UpdateUser:    user,
+	}
+	if _, err := o.Insert(operation); err != nil {
+		return err
+	}
+	return stockPickingUpdateState(o, picking, user)
+}
+
+// stockPickingMovePackage 为调拨单生成包内份的移动并记录整包移动操作，返回生成的移动
+func stockPickingMovePackage(o orm.Ormer, picking *StockPicking, pack *StockQuantPackage, user *User) (packMoves []*StockMove, err error) {
+	var (
+		quants []*StockQuant
+		moves  []*StockMove
+	)
+	if quants, err = stockPickingPackageQuants(o, picking, pack); err != nil {
+		return nil, err
+	}
+	if _, err = o.QueryTable(new(StockMove)).Filter("Picking__Id", picking.ID).All(&moves, "Id", "Sequence"); err != nil {
+		return nil, err
+	}
+	sequence := int64(len(moves))
+	moveMap := make(map[string]*StockMove)
+	for _, quant := range quants {
+		key := stockPackageQuantKey(quant)
+		move, ok := moveMap[key]
+		if !ok {
+			sequence++
+			move = stockPackageNewMove(picking, pack, quant, sequence, user)
+			moveMap[key] = move
+			packMoves = append(packMoves, move)
+		}
+		move.FirstUomQty += quant.FirstUomQty
+		move.SecondUomQty += quant.SecondUomQty
+	}
+	for _, move := range packMoves {
+		if _, err = o.Insert(move); err != nil {
+			return nil, err
+		}
+		if err = stockMoveAssign(o, move, user); err != nil {
+			return nil, err
+		}
+	}
+	if err = stockPickingPackageOperation(o, picking, pack, user); err != nil {
+		return nil, err
+	}
+	return packMoves, nil
+}
+
+// PutStockPickingInPack 将调拨单的移动装入包中，packageID为0时新建包，移动完成后份转入该包
+func PutStockPickingInPack(pickingID int64, moveIDs []int64, packageID int64, user *User) (resultPackageID int64, err error) {
+	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return 0, errBegin
+	}
+	picking := &StockPicking{ID: pickingID}
+	if err = stockPickingCheckOpen(o, picking); err != nil {
+		return 0, err
+	}
+	var moves []*StockMove
+	qs := o.QueryTable(new(StockMove)).Filter("Picking__Id", picking.ID).Exclude("State__in", "done", "cancel")
+	if len(moveIDs) > 0 {
+		qs = qs.Filter("Id__in", moveIDs)
+	}
+	if _, err = qs.All(&moves); err != nil {
+		return 0, err
+	}
+	if len(moves) == 0 {
+		return 0, fmt.Errorf("调拨单[%s]没有可以装包的移动", picking.Name)
+	}
+	pack := &StockQuantPackage{ID: packageID}
+	if packageID > 0 {
+		if err = o.Read(pack); err != nil {
+			return 0, err
+		}
+	} else {
+		pack.Company = picking.Company
+		pack.CreateUser = user
+		pack.UpdateUser = user
+		if err = stockQuantPackageSetName(pack); err != nil {
+			return 0, err
+		}
+		if pack.ID, err = o.Insert(pack); err != nil {
+			return 0, err
+		}
+	}
+	for _, move := range moves {
+		move.ResultPackage = pack
+		move.UpdateUser = user
+		if _, err = o.Update(move, "ResultPackage", "UpdateUser", "UpdateDate"); err != nil {
+			return 0, err
+		}
+		operation := &StockPackOperation{
+			Picking:       picking,
+			OperationType: "pack",
+			ResultPackage: pack,
+			Move:          move,
+			LocationSrc:   move.LocationSrc,
+			LocationDest:  move.LocationDest,
+			State:         "draft",
+			CreateUser:    user,
+			UpdateUser:    user,
+		}
+		if _, err = o.Insert(operation); err != nil {
+			return 0, err
+		}
+	}
+	return pack.ID, o.Commit()
+}
+
+// stockPickingDonePackOperations 调拨单完成后将包转到目标库位
+func stockPickingDonePackOperations(o orm.Ormer, picking *StockPicking, user *User) error {
+	var operations []*StockPackOperation
+	if _, err := o.QueryTable(new(StockPackOperation)).Filter("Picking__Id", picking.ID).Filter("State", "draft").All(&operations); err != nil {
+		return err
+	}
+	for _, operation := range operations {
+		if operation.ResultPackage != nil {
+			if err := stockQuantPackageSetLocation(o, operation.ResultPackage, picking.LocationDest, user); err != nil {
+				return err
+			}
+		}
+		operation.State = "done"
+		operation.UpdateUser = user
+		if _, err := o.Update(operation, "State", "UpdateUser", "UpdateDate"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stockPickingCancelPackOperations 调拨单取消时取消包装操作
+func stockPickingCancelPackOperations(o orm.Ormer, picking *StockPicking, user *User) error {
+	_, err := o.QueryTable(new(StockPackOperation)).Filter("Picking__Id", picking.ID).Filter("State", "draft").Update(orm.Params{
+		"State":      "cancel",
+		"UpdateUser": user.ID,
+		"UpdateDate": time.Now(),
+	})
+	return err
+}
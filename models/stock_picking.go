@@ -4,30 +4,35 @@ import (
 	"errors"
 	"fmt"
 	"goERP/utils"
+	"math"
 	"strings"
 	"time"
 
 	"github.com/astaxie/beego/orm"
 )
 
-//  StockPicking 调拨单
+// StockPicking 调拨单
 type StockPicking struct {
-	ID           int64             `orm:"column(id);pk;auto" json:"id"`         //主键
-	CreateUser   *User             `orm:"rel(fk);null" json:"-"`                //创建者
-	UpdateUser   *User             `orm:"rel(fk);null" json:"-"`                //最后更新者
-	CreateDate   time.Time         `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
-	UpdateDate   time.Time         `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
-	Name         string            `orm:"unique" json:"Name"`                   //单据名称
-	Origin       string            `json:"Origin"`                              //源单据
-	Note         string            `orm:"type(text)" json:"Note"`               //备注
-	MoveType     string            `orm:"default(one)" json:"MoveType"`         //移动类型:one partial
-	State        string            `orm:"default(draft)" json:"-"`              //状态 draft confirm process done cancel
-	Company      *Company          `orm:"rel(fk)"`                              //公司
-	LocationDest *StockLocation    `orm:"rel(fk)"`                              //目标库位
-	LocationSrc  *StockLocation    `orm:"rel(fk)"`                              //源库位
-	Partner      *Partner          `orm:"rel(fk)"`                              //合作伙伴
-	Priority     int64             `orm:"default(1)" json:"Priority"`           //优先级,值越大优先级越高
-	PickingType  *StockPickingType `orm:"rel(fk)"`                              //分拣类型决定分拣视图
+	ID            int64             `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser    *User             `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser    *User             `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate    time.Time         `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate    time.Time         `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	Name          string            `orm:"unique" json:"Name"`                   //单据名称
+	Origin        string            `json:"Origin"`                              //源单据
+	Note          string            `orm:"type(text)" json:"Note"`               //备注
+	MoveType      string            `orm:"default(one)" json:"MoveType"`         //移动类型:one partial
+	State         string            `orm:"default(draft)" json:"-"`              //状态 draft confirm waiting process assigned done cancel,process为部分可用
+	Company       *Company          `orm:"rel(fk)"`                              //公司
+	LocationDest  *StockLocation    `orm:"rel(fk)"`                              //目标库位
+	LocationSrc   *StockLocation    `orm:"rel(fk)"`                              //源库位
+	Partner       *Partner          `orm:"rel(fk)"`                              //合作伙伴
+	Priority      int64             `orm:"default(1)" json:"Priority"`           //优先级,值越大优先级越高
+	PickingType   *StockPickingType `orm:"rel(fk)"`                              //分拣类型决定分拣视图
+	SaleOrder     *SaleOrder        `orm:"rel(fk);null"`                         //销售订单
+	PurchaseOrder *PurchaseOrder    `orm:"rel(fk);null"`                         //采购订单
+	BackOrder     *StockPicking     `orm:"rel(fk);null"`                         //欠单对应的原调拨单
+	ReturnOf      *StockPicking     `orm:"rel(fk);null"`                         //退货对应的原调拨单
 
 	FormAction   string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
 	ActionFields []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
@@ -239,3 +244,578 @@ func DeleteStockPicking(id int64) (err error) {
 	}
 	return
 }
+
+// stockPickingMoves 获得调拨单的移动明细
+func stockPickingMoves(o orm.Ormer, picking *StockPicking) (moves []*StockMove, err error) {
+	_, err = o.QueryTable(new(StockMove)).Filter("Picking__Id", picking.ID).OrderBy("Sequence", "Id").All(&moves)
+	return moves, err
+}
+
+// stockPickingComputeState 根据移动明细的状态计算调拨单状态
+func stockPickingComputeState(picking *StockPicking, moves []*StockMove) string {
+	if len(moves) == 0 {
+		return picking.State
+	}
+	var cancelCount, doneCount, draftCount, assignedCount, partialCount, waitingCount int
+	for _, move := range moves {
+		switch move.State {
+		case "cancel":
+			cancelCount++
+		case "done":
+			doneCount++
+		case "draft":
+			draftCount++
+		case "assigned":
+			assignedCount++
+		case "waiting":
+			waitingCount++
+		default:
+			if move.PartiallyAvailable {
+				partialCount++
+			}
+		}
+	}
+	openCount := len(moves) - cancelCount - doneCount
+	switch {
+	case cancelCount == len(moves):
+		return "cancel"
+	case openCount == 0:
+		return "done"
+	case draftCount == openCount:
+		return "draft"
+	case assignedCount == openCount:
+		return "assigned"
+	case assignedCount+partialCount > 0:
+		// 允许部分出货时部分可用即可处理
+		if picking.MoveType == "partial" {
+			return "assigned"
+		}
+		return "process"
+	case waitingCount > 0:
+		return "waiting"
+	}
+	return "confirm"
+}
+
+// stockPickingUpdateState 重新计算并保存调拨单状态
+func stockPickingUpdateState(o orm.Ormer, picking *StockPicking, user *User) (err error) {
+	moves, err := stockPickingMoves(o, picking)
+	if err != nil {
+		return err
+	}
+	picking.State = stockPickingComputeState(picking, moves)
+	picking.UpdateUser = user
+	_, err = o.Update(picking, "State", "UpdateUser", "UpdateDate")
+	return err
+}
+
+// AssignStockPicking 检查调拨单可用性，按接收时间先进先出为每个移动保留份
+func AssignStockPicking(id int64, user *User) (err error) {
+	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return errBegin
+	}
+	picking := &StockPicking{ID: id}
+	if err = o.Read(picking); err != nil {
+		return err
+	}
+	if picking.State == "done" || picking.State == "cancel" {
+		return fmt.Errorf("调拨单[%s]状态为%s,不能检查可用性", picking.Name, picking.State)
+	}
+	var moves []*StockMove
+	if moves, err = stockPickingMoves(o, picking); err != nil {
+		return err
+	}
+	if len(moves) == 0 {
+		return fmt.Errorf("调拨单[%s]没有移动明细", picking.Name)
+	}
+	for _, move := range moves {
+		if err = stockMoveAssign(o, move, user); err != nil {
+			return err
+		}
+	}
+	if err = stockPickingUpdateState(o, picking, user); err != nil {
+		return err
+	}
+	return o.Commit()
+}
+
+// CancelStockPicking 取消调拨单，释放所有移动的保留
+func CancelStockPicking(id int64, user *User) (err error) {
+	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return errBegin
+	}
+	picking := &StockPicking{ID: id}
+	if err = o.Read(picking); err != nil {
+		return err
+	}
+	if picking.State == "done" {
+		return fmt.Errorf("调拨单[%s]已完成,不能取消", picking.Name)
+	}
+	var moves []*StockMove
+	if moves, err = stockPickingMoves(o, picking); err != nil {
+		return err
+	}
+	for _, move := range moves {
+		if move.State == "done" || move.State == "cancel" {
+			continue
+		}
+		if err = stockMoveCancel(o, move, user); err != nil {
+			return err
+		}
+	}
+	if err = stockPickingCancelPackOperations(o, picking, user); err != nil {
+		return err
+	}
+	picking.State = "cancel"
+	picking.UpdateUser = user
+	if _, err = o.Update(picking, "State", "UpdateUser", "UpdateDate"); err != nil {
+		return err
+	}
+	return o.Commit()
+}
+
+// stockPickingNextName 获得调拨单的单据编号，分拣类型设置了序号时使用分拣类型的序号
+func stockPickingNextName(o orm.Ormer, pickingType *StockPickingType, company *Company) (string, error) {
+	if pickingType != nil {
+		pickingType = &StockPickingType{ID: pickingType.ID}
+		if err := o.Read(pickingType); err == nil && pickingType.Sequence != nil {
+			sequence := &Sequence{ID: pickingType.Sequence.ID}
+			if err = o.Read(sequence); err == nil && sequence.Active {
+				return sequenceNextValue(o, sequence)
+			}
+		}
+	}
+	name, err := GetNextSequece("StockPicking", company.ID)
+	if err != nil {
+		return "", fmt.Errorf("调拨单序号获取失败:%s", err.Error())
+	}
+	return name, nil
+}
+
+// DoneStockPicking 完成调拨单，doneMoves为各移动的完成数量，未填写完成数量时按计划数量完成。
+// 完成数量小于计划数量时，backorder为backorder则为剩余数量创建欠单，为cancel则取消剩余数量，
+// 未指定时允许部分出货的调拨单创建欠单，一次性出货的调拨单需要用户选择
+func DoneStockPicking(id int64, doneMoves []StockMove, backorder string, user *User) (backorderID int64, err error) {
+	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return 0, errBegin
+	}
+	picking := &StockPicking{ID: id}
+	if err = o.Read(picking); err != nil {
+		return 0, err
+	}
+	if picking.State == "done" || picking.State == "cancel" {
+		return 0, fmt.Errorf("调拨单[%s]状态为%s,不能完成", picking.Name, picking.State)
+	}
+	var moves []*StockMove
+	if moves, err = stockPickingMoves(o, picking); err != nil {
+		return 0, err
+	}
+	var openMoves []*StockMove
+	for _, move := range moves {
+		if move.State != "done" && move.State != "cancel" {
+			openMoves = append(openMoves, move)
+		}
+	}
+	if len(openMoves) == 0 {
+		return 0, fmt.Errorf("调拨单[%s]没有需要完成的移动明细", picking.Name)
+	}
+	for _, doneMove := range doneMoves {
+		for _, move := range openMoves {
+			if move.ID == doneMove.ID {
+				move.FirstQtyDone = doneMove.FirstQtyDone
+				move.SecondQtyDone = doneMove.SecondQtyDone
+				if doneMove.LotID > 0 {
+					move.Lot = &StockProductionLot{ID: doneMove.LotID}
+					if _, err = o.Update(move, "Lot"); err != nil {
+						return 0, err
+					}
+				}
+			}
+		}
+	}
+	hasQtyDone := false
+	for _, move := range openMoves {
+		if move.FirstQtyDone < 0 || move.SecondQtyDone < 0 {
+			return 0, fmt.Errorf("移动[%s]的完成数量不能为负数", move.Name)
+		}
+		if move.FirstQtyDone > stockQtyEpsilon || move.SecondQtyDone > stockQtyEpsilon {
+			hasQtyDone = true
+		}
+	}
+	// 没有填写完成数量时按计划数量全部完成
+	if !hasQtyDone {
+		for _, move := range openMoves {
+			move.FirstQtyDone = move.FirstUomQty
+			move.SecondQtyDone = move.SecondUomQty
+		}
+	}
+	var remainMoves []*StockMove
+	for _, move := range openMoves {
+		if move.FirstUomQty-move.FirstQtyDone > stockQtyEpsilon || move.SecondUomQty-move.SecondQtyDone > stockQtyEpsilon {
+			remainMoves = append(remainMoves, move)
+		}
+	}
+	var backorderPicking *StockPicking
+	if len(remainMoves) > 0 {
+		if backorder == "" {
+			if picking.MoveType != "partial" {
+				return 0, fmt.Errorf("调拨单[%s]要求一次性完成,请选择创建欠单或取消剩余数量", picking.Name)
+			}
+			backorder = "backorder"
+		}
+		switch backorder {
+		case "backorder":
+			if backorderPicking, err = stockPickingCreateBackorder(o, picking, user); err != nil {
+				return 0, err
+			}
+			backorderID = backorderPicking.ID
+		case "cancel":
+		default:
+			return 0, fmt.Errorf("无效的欠单处理方式:%s", backorder)
+		}
+	}
+	for _, move := range remainMoves {
+		if err = stockMoveSplitRemaining(o, move, backorderPicking, user); err != nil {
+			return 0, err
+		}
+	}
+	var finishedMoves []*StockMove
+	for _, move := range openMoves {
+		if move.State == "cancel" || move.Picking.ID != picking.ID {
+			continue
+		}
+		// 完成数量超过计划数量时按完成数量转移
+		move.FirstUomQty = move.FirstQtyDone
+		move.SecondUomQty = move.SecondQtyDone
+		if _, err = o.Update(move, "FirstUomQty", "SecondUomQty", "FirstQtyDone", "SecondQtyDone"); err != nil {
+			return 0, err
+		}
+		if err = stockMoveDone(o, move, user); err != nil {
+			return 0, err
+		}
+		finishedMoves = append(finishedMoves, move)
+	}
+	if err = stockPickingUpdateState(o, picking, user); err != nil {
+		return 0, err
+	}
+	if err = stockPickingDonePackOperations(o, picking, user); err != nil {
+		return 0, err
+	}
+	if err = stockPickingCreateNextStep(o, picking, finishedMoves, user); err != nil {
+		return 0, err
+	}
+	if backorderPicking != nil {
+		var backorderMoves []*StockMove
+		if backorderMoves, err = stockPickingMoves(o, backorderPicking); err != nil {
+			return 0, err
+		}
+		for _, move := range backorderMoves {
+			if err = stockMoveAssign(o, move, user); err != nil {
+				return 0, err
+			}
+		}
+		if err = stockPickingUpdateState(o, backorderPicking, user); err != nil {
+			return 0, err
+		}
+	}
+	return backorderID, o.Commit()
+}
+
+// stockPickingCreateNextStep 分拣类型有下一步时，为已完成的移动创建下一步的调拨单，
+// 新移动从本调拨单的目标库位出发并关联上一步移动，原来等待上一步移动的后续移动改为等待新移动
+func stockPickingCreateNextStep(o orm.Ormer, picking *StockPicking, doneMoves []*StockMove, user *User) (err error) {
+	// 退货单不进入多步流程的下一步
+	if len(doneMoves) == 0 || picking.PickingType == nil || picking.ReturnOf != nil {
+		return nil
+	}
+	pickingType := &StockPickingType{ID: picking.PickingType.ID}
+	if err = o.Read(pickingType); err != nil {
+		return err
+	}
+	if pickingType.IsEnd || pickingType.NextStep == nil {
+		return nil
+	}
+	nextType := &StockPickingType{ID: pickingType.NextStep.ID}
+	if err = o.Read(nextType); err != nil {
+		return err
+	}
+	if !nextType.Active {
+		return nil
+	}
+	company := &Company{ID: picking.Company.ID}
+	if err = o.Read(company); err != nil {
+		return err
+	}
+	// 上一步移动已有等待它的后续移动时(如公司间调拨的入库移动)，改为等待新一步的移动，
+	// 发货步骤发往后续移动的源库位
+	doneIDs := make([]int64, 0, len(doneMoves))
+	for _, prev := range doneMoves {
+		doneIDs = append(doneIDs, prev.ID)
+	}
+	var chainedDests []*StockMove
+	if _, err = o.QueryTable(new(StockMove)).Filter("MoveOrigin__Id__in", doneIDs).Exclude("State__in", "done", "cancel").All(&chainedDests); err != nil {
+		return err
+	}
+	locationDest := nextType.DefaultLocationDest
+	if locationDest == nil {
+		switch {
+		case nextType.Code == "outgoing" && len(chainedDests) > 0 && chainedDests[0].LocationSrc != nil:
+			locationDest = chainedDests[0].LocationSrc
+		case nextType.Code == "outgoing":
+			if locationDest, err = stockLocationByUsage(o, "customer", company); err != nil {
+				return err
+			}
+		case nextType.WareHouse != nil:
+			warehouse := &StockWarehouse{ID: nextType.WareHouse.ID}
+			if err = o.Read(warehouse); err != nil {
+				return err
+			}
+			locationDest = warehouse.Location
+		}
+	}
+	if locationDest == nil {
+		return fmt.Errorf("分拣类型[%s]没有设置默认目标库位", nextType.Name)
+	}
+	name, err := stockPickingNextName(o, nextType, company)
+	if err != nil {
+		return err
+	}
+	origin := picking.Origin
+	if origin == "" {
+		origin = picking.Name
+	}
+	nextPicking := &StockPicking{
+		Name:          name,
+		Origin:        origin,
+		MoveType:      picking.MoveType,
+		State:         "confirm",
+		Company:       company,
+		LocationSrc:   picking.LocationDest,
+		LocationDest:  locationDest,
+		Partner:       picking.Partner,
+		Priority:      picking.Priority,
+		PickingType:   nextType,
+		SaleOrder:     picking.SaleOrder,
+		PurchaseOrder: picking.PurchaseOrder,
+		CreateUser:    user,
+		UpdateUser:    user,
+	}
+	if nextPicking.ID, err = o.Insert(nextPicking); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, prev := range doneMoves {
+		move := &StockMove{
+			Sequence:          prev.Sequence,
+			Name:              prev.Name,
+			Priority:          prev.Priority,
+			Date:              now,
+			DateExpected:      now,
+			Product:           prev.Product,
+			ProductTemplate:   prev.ProductTemplate,
+			FirstUomQty:       prev.FirstUomQty,
+			SecondUomQty:      prev.SecondUomQty,
+			FirstUom:          prev.FirstUom,
+			SecondUom:         prev.SecondUom,
+			LocationSrc:       nextPicking.LocationSrc,
+			LocationDest:      nextPicking.LocationDest,
+			Partner:           prev.Partner,
+			Picking:           nextPicking,
+			State:             "confirm",
+			PriceUnit:         prev.PriceUnit,
+			Company:           company,
+			Origin:            origin,
+			ProcureMethod:     prev.ProcureMethod,
+			WareHouse:         prev.WareHouse,
+			SaleOrderLine:     prev.SaleOrderLine,
+			PurchaseOrderLine: prev.PurchaseOrderLine,
+			Lot:               prev.Lot,
+			Package:           prev.ResultPackage,
+			ResultPackage:     prev.ResultPackage,
+			MoveOrigin:        prev,
+			CreateUser:        user,
+			UpdateUser:        user,
+		}
+		if move.ID, err = o.Insert(move); err != nil {
+			return err
+		}
+		if err = stockMoveAssign(o, move, user); err != nil {
+			return err
+		}
+		for _, dest := range chainedDests {
+			if dest.MoveOrigin == nil || dest.MoveOrigin.ID != prev.ID {
+				continue
+			}
+			if err = quantsUnreserve(o, dest); err != nil {
+				return err
+			}
+			dest.MoveOrigin = move
+			dest.UpdateUser = user
+			if _, err = o.Update(dest, "MoveOrigin", "UpdateUser", "UpdateDate"); err != nil {
+				return err
+			}
+			if err = stockMoveAssign(o, dest, user); err != nil {
+				return err
+			}
+			if dest.Picking != nil {
+				picking := &StockPicking{ID: dest.Picking.ID}
+				if err = o.Read(picking); err != nil {
+					return err
+				}
+				if err = stockPickingUpdateState(o, picking, user); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return stockPickingUpdateState(o, nextPicking, user)
+}
+
+// stockPickingCreateBackorder 为调拨单创建欠单，欠单关联原调拨单
+func stockPickingCreateBackorder(o orm.Ormer, picking *StockPicking, user *User) (*StockPicking, error) {
+	name, err := stockPickingNextName(o, picking.PickingType, picking.Company)
+	if err != nil {
+		return nil, err
+	}
+	backorder := *picking
+	backorder.ID = 0
+	backorder.Name = name
+	backorder.State = "confirm"
+	backorder.BackOrder = picking
+	backorder.CreateUser = user
+	backorder.UpdateUser = user
+	if backorder.Origin == "" {
+		backorder.Origin = picking.Name
+	}
+	if backorder.ID, err = o.Insert(&backorder); err != nil {
+		return nil, err
+	}
+	return &backorder, nil
+}
+
+// stockMoveSplitRemaining 移动的完成数量小于计划数量时拆分剩余数量，
+// backorder不为空时剩余数量转到欠单，否则取消剩余数量
+func stockMoveSplitRemaining(o orm.Ormer, move *StockMove, backorder *StockPicking, user *User) (err error) {
+	// 没有完成任何数量的移动整体转到欠单或取消
+	if move.FirstQtyDone <= stockQtyEpsilon && move.SecondQtyDone <= stockQtyEpsilon {
+		if backorder == nil {
+			return stockMoveCancel(o, move, user)
+		}
+		move.Picking = backorder
+		move.UpdateUser = user
+		_, err = o.Update(move, "Picking", "UpdateUser", "UpdateDate")
+		return err
+	}
+	// 已完成数量继续保留，剩余数量的保留释放后由欠单重新检查可用性
+	if err = quantsUnreserveExcess(o, move, move.FirstQtyDone, move.SecondQtyDone); err != nil {
+		return err
+	}
+	remaining := *move
+	remaining.ID = 0
+	remaining.FirstUomQty = math.Max(move.FirstUomQty-move.FirstQtyDone, 0)
+	remaining.SecondUomQty = math.Max(move.SecondUomQty-move.SecondQtyDone, 0)
+	remaining.FirstQtyDone = 0
+	remaining.SecondQtyDone = 0
+	remaining.State = "confirm"
+	remaining.PartiallyAvailable = false
+	remaining.BackOrder = nil
+	remaining.Quants = nil
+	remaining.ReservedQuant = nil
+	remaining.CreateUser = user
+	remaining.UpdateUser = user
+	if backorder != nil {
+		remaining.Picking = backorder
+	}
+	if remaining.ID, err = o.Insert(&remaining); err != nil {
+		return err
+	}
+	move.FirstUomQty = move.FirstQtyDone
+	move.SecondUomQty = move.SecondQtyDone
+	move.BackOrder = backorder
+	move.UpdateUser = user
+	if _, err = o.Update(move, "FirstUomQty", "SecondUomQty", "FirstQtyDone", "SecondQtyDone", "BackOrder", "UpdateUser", "UpdateDate"); err != nil {
+		return err
+	}
+	var restDests []*StockMove
+	if restDests, err = stockMoveSplitDests(o, move, &remaining, user); err != nil {
+		return err
+	}
+	if backorder == nil {
+		for _, dest := range restDests {
+			if err = stockMoveCancel(o, dest, user); err != nil {
+				return err
+			}
+		}
+		return stockMoveCancel(o, &remaining, user)
+	}
+	return nil
+}
+
+// stockMoveSplitDests 按拆分后的数量拆分等待该移动的后续移动，剩余部分等待拆分出的剩余移动，
+// 剩余移动完成后才能为后续移动保留剩余的库存
+func stockMoveSplitDests(o orm.Ormer, move *StockMove, remaining *StockMove, user *User) (restDests []*StockMove, err error) {
+	var dests []*StockMove
+	if _, err = o.QueryTable(new(StockMove)).Filter("MoveOrigin__Id", move.ID).Filter("State__in", "draft", "waiting", "confirm").All(&dests); err != nil {
+		return nil, err
+	}
+	for _, dest := range dests {
+		keepFirstQty := math.Min(dest.FirstUomQty, move.FirstUomQty)
+		keepSecondQty := math.Min(dest.SecondUomQty, move.SecondUomQty)
+		restFirstQty := dest.FirstUomQty - keepFirstQty
+		restSecondQty := dest.SecondUomQty - keepSecondQty
+		if restFirstQty <= stockQtyEpsilon && restSecondQty <= stockQtyEpsilon {
+			continue
+		}
+		rest := *dest
+		rest.ID = 0
+		rest.FirstUomQty = restFirstQty
+		rest.SecondUomQty = restSecondQty
+		rest.FirstQtyDone = 0
+		rest.SecondQtyDone = 0
+		rest.State = "waiting"
+		rest.PartiallyAvailable = false
+		rest.MoveOrigin = remaining
+		rest.Quants = nil
+		rest.ReservedQuant = nil
+		rest.CreateUser = user
+		rest.UpdateUser = user
+		if rest.ID, err = o.Insert(&rest); err != nil {
+			return nil, err
+		}
+		dest.FirstUomQty = keepFirstQty
+		dest.SecondUomQty = keepSecondQty
+		dest.UpdateUser = user
+		if _, err = o.Update(dest, "FirstUomQty", "SecondUomQty", "UpdateUser", "UpdateDate"); err != nil {
+			return nil, err
+		}
+		restDests = append(restDests, &rest)
+	}
+	return restDests, nil
+}
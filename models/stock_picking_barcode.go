@@ -0,0 +1,547 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// StockPickingBarcodeState 扫码处理调拨单后的状态，供扫码界面显示
+type StockPickingBarcodeState struct {
+	Picking *StockPicking //调拨单
+	Moves   []*StockMove  //移动明细，已读取产品、单位、批次和库位
+	Message string        //本次扫描的处理结果
+	MoveID  int64         //本次扫描处理的移动
+}
+
+// stockBarcodeScanQty 每次扫描产品或批次增加的数量，第二单位按计划数量的比例增加
+func stockBarcodeScanQty(move *StockMove) (firstQty, secondQty float64) {
+	firstQty = 1
+	if move.SecondUom != nil && move.FirstUomQty > stockQtyEpsilon {
+		secondQty = move.SecondUomQty / move.FirstUomQty
+	}
+	return firstQty, secondQty
+}
+
+// stockMoveReassign 移动的源库位或批次变化后重新保留库存
+func stockMoveReassign(o orm.Ormer, move *StockMove, user *User) (err error) {
+	if move.State != "confirm" && move.State != "assigned" {
+		return nil
+	}
+	if err = quantsUnreserve(o, move); err != nil {
+		return err
+	}
+	move.State = "confirm"
+	move.PartiallyAvailable = false
+	move.UpdateUser = user
+	if _, err = o.Update(move, "State", "PartiallyAvailable", "UpdateUser", "UpdateDate"); err != nil {
+		return err
+	}
+	return stockMoveAssign(o, move, user)
+}
+
+// stockMoveAddQtyDone 增加移动的完成数量
+func stockMoveAddQtyDone(o orm.Ormer, move *StockMove, firstQty, secondQty float64, user *User) error {
+	move.FirstQtyDone += firstQty
+	move.SecondQtyDone += secondQty
+	move.UpdateUser = user
+	_, err := o.Update(move, "FirstQtyDone", "SecondQtyDone", "UpdateUser", "UpdateDate")
+	return err
+}
+
+// stockMoveHasQtyDone 移动是否已经扫描了完成数量
+func stockMoveHasQtyDone(move *StockMove) bool {
+	return move.FirstQtyDone > stockQtyEpsilon || move.SecondQtyDone > stockQtyEpsilon
+}
+
+// stockPickingScanLocation 扫描库位，未扫描产品前设置源库位，扫描产品后为已扫描的移动设置目标库位
+func stockPickingScanLocation(o orm.Ormer, picking *StockPicking, moves []*StockMove, location *StockLocation, user *User) (message string, err error) {
+	var srcIDs, destIDs []int64
+	if srcIDs, err = stockLocationChildIDs(o, picking.LocationSrc); err != nil {
+		return "", err
+	}
+	if destIDs, err = stockLocationChildIDs(o, picking.LocationDest); err != nil {
+		return "", err
+	}
+	inLocations := func(ids []int64) bool {
+		for _, id := range ids {
+			if id == location.ID {
+				return true
+			}
+		}
+		return false
+	}
+	inSrc := inLocations(srcIDs)
+	inDest := inLocations(destIDs)
+	scanned := false
+	for _, move := range moves {
+		if stockMoveHasQtyDone(move) {
+			scanned = true
+			break
+		}
+	}
+	if inSrc && (!inDest || !scanned) {
+		for _, move := range moves {
+			if stockMoveHasQtyDone(move) || (move.LocationSrc != nil && move.LocationSrc.ID == location.ID) {
+				continue
+			}
+			move.LocationSrc = location
+			move.UpdateUser = user
+			if _, err = o.Update(move, "LocationSrc", "UpdateUser", "UpdateDate"); err != nil {
+				return "", err
+			}
+			if err = stockMoveReassign(o, move, user); err != nil {
+				return "", err
+			}
+		}
+		if err = stockPickingUpdateState(o, picking, user); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("源库位:%s", location.Name), nil
+	}
+	if inDest {
+		for _, move := range moves {
+			if !stockMoveHasQtyDone(move) {
+				continue
+			}
+			move.LocationDest = location
+			move.UpdateUser = user
+			if _, err = o.Update(move, "LocationDest", "UpdateUser", "UpdateDate"); err != nil {
+				return "", err
+			}
+		}
+		return fmt.Sprintf("目标库位:%s", location.Name), nil
+	}
+	return "", fmt.Errorf("库位[%s]不在调拨单的源库位或目标库位中", location.Name)
+}
+
+// stockPickingScanProduct 扫描产品，为该产品未完成的移动增加完成数量，全部完成后继续累加到最后一个移动
+func stockPickingScanProduct(o orm.Ormer, moves []*StockMove, productIDs []int64, user *User) (move *StockMove, err error) {
+	var matched []*StockMove
+	for _, m := range moves {
+		for _, productID := range productIDs {
+			if m.Product.ID == productID {
+				matched = append(matched, m)
+				break
+			}
+		}
+	}
+	if len(matched) == 0 {
+		return nil, errors.New("产品不在调拨单中")
+	}
+	for _, m := range matched {
+		if m.FirstUomQty-m.FirstQtyDone > stockQtyEpsilon {
+			move = m
+			break
+		}
+	}
+	if move == nil {
+		move = matched[len(matched)-1]
+	}
+	var tracking string
+	if tracking, err = productTracking(o, move.Product); err != nil {
+		return nil, err
+	}
+	if tracking != "none" {
+		return nil, fmt.Errorf("移动[%s]的产品需要追踪批次或序列号,请扫描批次", move.Name)
+	}
+	firstQty, secondQty := stockBarcodeScanQty(move)
+	return move, stockMoveAddQtyDone(o, move, firstQty, secondQty, user)
+}
+
+// stockMoveSplitForLot 将移动未完成的数量拆分为指定批次的新移动，原移动保留已完成的数量
+func stockMoveSplitForLot(o orm.Ormer, move *StockMove, lot *StockProductionLot, user *User) (newMove *StockMove, err error) {
+	restFirstQty := move.FirstUomQty - move.FirstQtyDone
+	if restFirstQty <= stockQtyEpsilon {
+		return nil, nil
+	}
+	restSecondQty := move.SecondUomQty - move.SecondQtyDone
+	if restSecondQty < 0 {
+		restSecondQty = 0
+	}
+	newMove = new(StockMove)
+	*newMove = *move
+	newMove.ID = 0
+	newMove.Quants = nil
+	newMove.ReservedQuant = nil
+	newMove.FirstUomQty = restFirstQty
+	newMove.SecondUomQty = restSecondQty
+	newMove.FirstQtyDone = 0
+	newMove.SecondQtyDone = 0
+	newMove.Lot = lot
+	newMove.CreateUser = user
+	newMove.UpdateUser = user
+	if newMove.ID, err = o.Insert(newMove); err != nil {
+		return nil, err
+	}
+	move.FirstUomQty = move.FirstQtyDone
+	move.SecondUomQty = move.SecondQtyDone
+	move.UpdateUser = user
+	if _, err = o.Update(move, "FirstUomQty", "SecondUomQty", "UpdateUser", "UpdateDate"); err != nil {
+		return nil, err
+	}
+	if err = stockMoveReassign(o, move, user); err != nil {
+		return nil, err
+	}
+	if err = stockMoveReassign(o, newMove, user); err != nil {
+		return nil, err
+	}
+	return newMove, nil
+}
+
+// stockPickingScanLot 扫描批次，优先累加到该批次未完成的移动，其次将批次指定给没有批次的移动，
+// 都没有时从同产品移动的未完成数量中拆分出该批次的移动
+func stockPickingScanLot(o orm.Ormer, moves []*StockMove, lot *StockProductionLot, user *User) (move *StockMove, err error) {
+	var sameLot, noLot, otherLot []*StockMove
+	for _, m := range moves {
+		if m.Product.ID != lot.Product.ID {
+			continue
+		}
+		switch {
+		case m.Lot != nil && m.Lot.ID == lot.ID:
+			sameLot = append(sameLot, m)
+		case m.Lot == nil && !stockMoveHasQtyDone(m):
+			noLot = append(noLot, m)
+		case m.Lot != nil:
+			otherLot = append(otherLot, m)
+		}
+	}
+	for _, m := range sameLot {
+		if m.FirstUomQty-m.FirstQtyDone > stockQtyEpsilon {
+			move = m
+			break
+		}
+	}
+	if move == nil && len(noLot) > 0 {
+		move = noLot[0]
+		move.Lot = lot
+		move.UpdateUser = user
+		if _, err = o.Update(move, "Lot", "UpdateUser", "UpdateDate"); err != nil {
+			return nil, err
+		}
+		if err = stockMoveReassign(o, move, user); err != nil {
+			return nil, err
+		}
+	}
+	if move == nil {
+		for _, m := range otherLot {
+			if move, err = stockMoveSplitForLot(o, m, lot, user); err != nil {
+				return nil, err
+			}
+			if move != nil {
+				break
+			}
+		}
+	}
+	if move == nil && len(sameLot) > 0 {
+		move = sameLot[len(sameLot)-1]
+	}
+	if move == nil {
+		return nil, fmt.Errorf("批次[%s]没有可以处理的移动明细", lot.Name)
+	}
+	var tracking string
+	if tracking, err = productTracking(o, move.Product); err != nil {
+		return nil, err
+	}
+	if tracking == "serial" && move.FirstQtyDone >= 1-stockQtyEpsilon {
+		return nil, fmt.Errorf("序列号[%s]已经扫描", lot.Name)
+	}
+	firstQty, secondQty := stockBarcodeScanQty(move)
+	return move, stockMoveAddQtyDone(o, move, firstQty, secondQty, user)
+}
+
+// stockMoveSplitQty 将移动拆分为指定数量和剩余数量两个移动，剩余数量的新移动保留原移动的订单明细等关联
+func stockMoveSplitQty(o orm.Ormer, move *StockMove, firstQty, secondQty float64, user *User) (rest *StockMove, err error) {
+	rest = new(StockMove)
+	*rest = *move
+	rest.ID = 0
+	rest.Quants = nil
+	rest.ReservedQuant = nil
+	rest.FirstUomQty = move.FirstUomQty - firstQty
+	rest.SecondUomQty = math.Max(move.SecondUomQty-secondQty, 0)
+	rest.FirstQtyDone = 0
+	rest.SecondQtyDone = 0
+	if rest.State != "waiting" {
+		rest.State = "confirm"
+	}
+	rest.PartiallyAvailable = false
+	rest.CreateUser = user
+	rest.UpdateUser = user
+	if rest.ID, err = o.Insert(rest); err != nil {
+		return nil, err
+	}
+	move.FirstUomQty = firstQty
+	move.SecondUomQty = secondQty
+	move.UpdateUser = user
+	if _, err = o.Update(move, "FirstUomQty", "SecondUomQty", "UpdateUser", "UpdateDate"); err != nil {
+		return nil, err
+	}
+	if err = stockMoveReassign(o, move, user); err != nil {
+		return nil, err
+	}
+	if err = stockMoveAssign(o, rest, user); err != nil {
+		return nil, err
+	}
+	return rest, nil
+}
+
+// stockPickingScanPackage 扫描包，包内的份优先由调拨单中相同产品尚未处理的移动处理，计划数量多于包内数量时拆分移动，
+// 调拨单中没有计划的产品或超出计划的数量才生成新移动，超出部分沿用已有移动的订单明细，处理的移动全部标记为完成
+func stockPickingScanPackage(o orm.Ormer, picking *StockPicking, pack *StockQuantPackage, user *User) (packMoves []*StockMove, err error) {
+	var (
+		quants []*StockQuant
+		moves  []*StockMove
+		open   []*StockMove
+	)
+	if quants, err = stockPickingPackageQuants(o, picking, pack); err != nil {
+		return nil, err
+	}
+	if _, err = o.QueryTable(new(StockMove)).Filter("Picking__Id", picking.ID).OrderBy("Sequence", "Id").All(&moves); err != nil {
+		return nil, err
+	}
+	sequence := int64(len(moves))
+	for _, move := range moves {
+		if move.State != "done" && move.State != "cancel" && move.Package == nil && !stockMoveHasQtyDone(move) {
+			open = append(open, move)
+		}
+	}
+	// 同一个包中相同产品和批次的份合并处理
+	var groups []*StockMove
+	groupMap := make(map[string]*StockMove)
+	for _, quant := range quants {
+		key := stockPackageQuantKey(quant)
+		group, ok := groupMap[key]
+		if !ok {
+			group = stockPackageNewMove(picking, pack, quant, 0, user)
+			groupMap[key] = group
+			groups = append(groups, group)
+		}
+		group.FirstUomQty += quant.FirstUomQty
+		group.SecondUomQty += quant.SecondUomQty
+	}
+	for _, group := range groups {
+		var planned *StockMove
+		for k := 0; k < len(open) && group.FirstUomQty > stockQtyEpsilon; k++ {
+			move := open[k]
+			if move == nil || move.Product.ID != group.Product.ID || (move.Lot != nil && (group.Lot == nil || move.Lot.ID != group.Lot.ID)) {
+				continue
+			}
+			open[k] = nil
+			planned = move
+			takeFirstQty := math.Min(move.FirstUomQty, group.FirstUomQty)
+			takeSecondQty := group.SecondUomQty * takeFirstQty / group.FirstUomQty
+			if move.FirstUomQty-takeFirstQty > stockQtyEpsilon {
+				var rest *StockMove
+				if rest, err = stockMoveSplitQty(o, move, takeFirstQty, takeSecondQty, user); err != nil {
+					return nil, err
+				}
+				open = append(open, rest)
+			}
+			move.Lot = group.Lot
+			move.Package = group.Package
+			move.ResultPackage = group.ResultPackage
+			move.LocationSrc = group.LocationSrc
+			move.UpdateUser = user
+			if _, err = o.Update(move, "Lot", "Package", "ResultPackage", "LocationSrc", "UpdateUser", "UpdateDate"); err != nil {
+				return nil, err
+			}
+			if err = stockMoveReassign(o, move, user); err != nil {
+				return nil, err
+			}
+			group.FirstUomQty -= takeFirstQty
+			group.SecondUomQty = math.Max(group.SecondUomQty-takeSecondQty, 0)
+			packMoves = append(packMoves, move)
+		}
+		if group.FirstUomQty <= stockQtyEpsilon && group.SecondUomQty <= stockQtyEpsilon {
+			continue
+		}
+		if planned != nil {
+			group.Name = planned.Name
+			group.Partner = planned.Partner
+			group.Origin = planned.Origin
+			group.WareHouse = planned.WareHouse
+			group.PriceUnit = planned.PriceUnit
+			group.SaleOrderLine = planned.SaleOrderLine
+			group.PurchaseOrderLine = planned.PurchaseOrderLine
+		}
+		sequence++
+		group.Sequence = sequence
+		if _, err = o.Insert(group); err != nil {
+			return nil, err
+		}
+		if err = stockMoveAssign(o, group, user); err != nil {
+			return nil, err
+		}
+		packMoves = append(packMoves, group)
+	}
+	for _, move := range packMoves {
+		if err = stockMoveAddQtyDone(o, move, move.FirstUomQty-move.FirstQtyDone, move.SecondUomQty-move.SecondQtyDone, user); err != nil {
+			return nil, err
+		}
+	}
+	if err = stockPickingPackageOperation(o, picking, pack, user); err != nil {
+		return nil, err
+	}
+	return packMoves, nil
+}
+
+// stockPickingBarcodeState 读取调拨单及其移动明细的关联数据，生成扫码界面的状态
+func stockPickingBarcodeState(o orm.Ormer, picking *StockPicking, message string, moveID int64) (state *StockPickingBarcodeState, err error) {
+	if err = o.Read(picking); err != nil {
+		return nil, err
+	}
+	if picking.LocationSrc != nil {
+		if err = o.Read(picking.LocationSrc); err != nil {
+			return nil, err
+		}
+	}
+	if picking.LocationDest != nil {
+		if err = o.Read(picking.LocationDest); err != nil {
+			return nil, err
+		}
+	}
+	state = &StockPickingBarcodeState{Picking: picking, Message: message, MoveID: moveID}
+	if state.Moves, err = stockPickingMoves(o, picking); err != nil {
+		return nil, err
+	}
+	for _, move := range state.Moves {
+		if _, err = o.LoadRelated(move, "Product"); err != nil {
+			return nil, err
+		}
+		if _, err = o.LoadRelated(move, "FirstUom"); err != nil {
+			return nil, err
+		}
+		if move.SecondUom != nil {
+			if _, err = o.LoadRelated(move, "SecondUom"); err != nil {
+				return nil, err
+			}
+		}
+		if move.Lot != nil {
+			if _, err = o.LoadRelated(move, "Lot"); err != nil {
+				return nil, err
+			}
+		}
+		if move.ResultPackage != nil {
+			if _, err = o.LoadRelated(move, "ResultPackage"); err != nil {
+				return nil, err
+			}
+		}
+		if move.LocationSrc != nil {
+			if _, err = o.LoadRelated(move, "LocationSrc"); err != nil {
+				return nil, err
+			}
+		}
+		if move.LocationDest != nil {
+			if _, err = o.LoadRelated(move, "LocationDest"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return state, nil
+}
+
+// GetStockPickingBarcodeState 获得调拨单扫码处理的当前状态
+func GetStockPickingBarcodeState(id int64) (*StockPickingBarcodeState, error) {
+	o := orm.NewOrm()
+	return stockPickingBarcodeState(o, &StockPicking{ID: id}, "", 0)
+}
+
+// ScanStockPicking 扫码处理调拨单，条码依次按库位、产品规格、产品款式、批次、包匹配。
+// 库位设置源库位或目标库位，产品和批次为匹配的移动增加完成数量，包整包移动，
+// 完成数量保存在移动中，调拨单完成时使用
+func ScanStockPicking(id int64, barcode string, user *User) (state *StockPickingBarcodeState, err error) {
+	barcode = strings.TrimSpace(barcode)
+	if barcode == "" {
+		return nil, errors.New("条码不能为空")
+	}
+	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return nil, errBegin
+	}
+	picking := &StockPicking{ID: id}
+	if err = stockPickingCheckOpen(o, picking); err != nil {
+		return nil, err
+	}
+	var allMoves, moves []*StockMove
+	if allMoves, err = stockPickingMoves(o, picking); err != nil {
+		return nil, err
+	}
+	productIDs := make([]int64, 0, len(allMoves))
+	for _, move := range allMoves {
+		if move.State != "done" && move.State != "cancel" {
+			moves = append(moves, move)
+			productIDs = append(productIDs, move.Product.ID)
+		}
+	}
+	var (
+		message string
+		move    *StockMove
+	)
+	location := new(StockLocation)
+	products := make([]*ProductProduct, 0, 1)
+	lot := new(StockProductionLot)
+	pack := new(StockQuantPackage)
+	if err = o.QueryTable(location).Filter("Barcode", barcode).Filter("Active", true).One(location); err == nil {
+		if message, err = stockPickingScanLocation(o, picking, moves, location, user); err != nil {
+			return nil, err
+		}
+	} else if err != orm.ErrNoRows {
+		return nil, err
+	} else {
+		if _, err = o.QueryTable(new(ProductProduct)).Filter("Barcode", barcode).All(&products, "Id"); err != nil {
+			return nil, err
+		}
+		if len(products) == 0 {
+			if _, err = o.QueryTable(new(ProductProduct)).Filter("ProductTemplate__Barcode", barcode).All(&products, "Id"); err != nil {
+				return nil, err
+			}
+		}
+		if len(products) > 0 {
+			ids := make([]int64, 0, len(products))
+			for _, product := range products {
+				ids = append(ids, product.ID)
+			}
+			if move, err = stockPickingScanProduct(o, moves, ids, user); err != nil {
+				return nil, err
+			}
+			message = fmt.Sprintf("产品:%s", move.Name)
+		} else if len(productIDs) > 0 && o.QueryTable(lot).Filter("Name", barcode).Filter("Product__Id__in", productIDs).OrderBy("Id").Limit(1).One(lot) == nil {
+			if move, err = stockPickingScanLot(o, moves, lot, user); err != nil {
+				return nil, err
+			}
+			message = fmt.Sprintf("批次:%s", lot.Name)
+		} else {
+			cond := orm.NewCondition().Or("Barcode", barcode).Or("Name", barcode)
+			if err = o.QueryTable(pack).SetCond(cond).One(pack); err != nil {
+				if err == orm.ErrNoRows {
+					err = fmt.Errorf("未找到条码[%s]对应的库位、产品、批次或包", barcode)
+				}
+				return nil, err
+			}
+			var packMoves []*StockMove
+			if packMoves, err = stockPickingScanPackage(o, picking, pack, user); err != nil {
+				return nil, err
+			}
+			message = fmt.Sprintf("包:%s,%d个移动", pack.Name, len(packMoves))
+		}
+	}
+	var moveID int64
+	if move != nil {
+		moveID = move.ID
+	}
+	if state, err = stockPickingBarcodeState(o, picking, message, moveID); err != nil {
+		return nil, err
+	}
+	return state, o.Commit()
+}
@@ -0,0 +1,270 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// StockReturnLine 退货明细，Move为原调拨单中已完成的移动
+type StockReturnLine struct {
+	Move         *StockMove //原移动
+	FirstUomQty  float64    //第一单位可退数量
+	SecondUomQty float64    //第二单位可退数量
+}
+
+// stockMoveReturnableQty 获得移动还可以退回的数量，已完成数量减去未取消的退货数量
+func stockMoveReturnableQty(o orm.Ormer, move *StockMove) (firstQty, secondQty float64, err error) {
+	if move.State != "done" {
+		return 0, 0, nil
+	}
+	firstQty = move.FirstUomQty
+	secondQty = move.SecondUomQty
+	var returns []*StockMove
+	if _, err = o.QueryTable(new(StockMove)).Filter("ReturnedMove__Id", move.ID).Exclude("State", "cancel").All(&returns, "FirstUomQty", "SecondUomQty"); err != nil {
+		return 0, 0, err
+	}
+	for _, ret := range returns {
+		firstQty -= ret.FirstUomQty
+		secondQty -= ret.SecondUomQty
+	}
+	return math.Max(firstQty, 0), math.Max(secondQty, 0), nil
+}
+
+// stockReturnLocation 获得公司的退货库位，公司没有时使用公共的退货库位
+func stockReturnLocation(o orm.Ormer, company *Company) (*StockLocation, error) {
+	var location StockLocation
+	qs := o.QueryTable(new(StockLocation)).Filter("ReturnLocation", true).Filter("Active", true)
+	if company != nil {
+		if err := qs.Filter("Company__Id", company.ID).OrderBy("Id").One(&location); err == nil {
+			return &location, nil
+		}
+	}
+	if err := qs.Filter("Company__isnull", true).OrderBy("Id").One(&location); err != nil {
+		return nil, err
+	}
+	return &location, nil
+}
+
+// stockPickingReturnLines 获得调拨单中还可以退回的移动及数量
+func stockPickingReturnLines(o orm.Ormer, picking *StockPicking) (lines []*StockReturnLine, err error) {
+	var moves []*StockMove
+	if moves, err = stockPickingMoves(o, picking); err != nil {
+		return nil, err
+	}
+	for _, move := range moves {
+		firstQty, secondQty, err := stockMoveReturnableQty(o, move)
+		if err != nil {
+			return nil, err
+		}
+		if firstQty <= stockQtyEpsilon && secondQty <= stockQtyEpsilon {
+			continue
+		}
+		lines = append(lines, &StockReturnLine{Move: move, FirstUomQty: firstQty, SecondUomQty: secondQty})
+	}
+	return lines, nil
+}
+
+// GetStockPickingReturnLines 获得已完成调拨单的可退货明细，用于预填退货单
+func GetStockPickingReturnLines(id int64) ([]*StockReturnLine, error) {
+	o := orm.NewOrm()
+	picking := &StockPicking{ID: id}
+	if err := o.Read(picking); err != nil {
+		return nil, err
+	}
+	if picking.State != "done" {
+		return nil, fmt.Errorf("调拨单[%s]未完成,不能退货", picking.Name)
+	}
+	lines, err := stockPickingReturnLines(o, picking)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		if line.Move.Product != nil {
+			o.Read(line.Move.Product)
+		}
+		if line.Move.FirstUom != nil {
+			o.Read(line.Move.FirstUom)
+		}
+		if line.Move.SecondUom != nil {
+			o.Read(line.Move.SecondUom)
+		}
+		if line.Move.Lot != nil {
+			o.Read(line.Move.Lot)
+		}
+	}
+	return lines, nil
+}
+
+// stockReturnPickingType 退货单使用原调拨单所在仓库的反向分拣类型，发货的退货为收货，收货的退货为发货，
+// 仓库没有反向分拣类型或为内部调拨时沿用原分拣类型
+func stockReturnPickingType(o orm.Ormer, pickingType *StockPickingType) (*StockPickingType, error) {
+	if pickingType == nil {
+		return nil, nil
+	}
+	original := &StockPickingType{ID: pickingType.ID}
+	if err := o.Read(original); err != nil {
+		return nil, err
+	}
+	var code string
+	switch original.Code {
+	case "outgoing":
+		code = "incoming"
+	case "incoming":
+		code = "outgoing"
+	default:
+		return original, nil
+	}
+	if original.WareHouse == nil {
+		return original, nil
+	}
+	if returnType, err := stockPickingTypeByWarehouse(o, original.WareHouse, code); err == nil {
+		return returnType, nil
+	}
+	return original, nil
+}
+
+// ReturnStockPicking 为已完成的调拨单创建反向的退货单，returnMoves的ID为原移动ID，
+// FirstUomQty、SecondUomQty为退货数量，为空时退回全部可退数量。
+// 客户退货进入退货库位，没有退货库位时退回原调拨单的源库位
+func ReturnStockPicking(id int64, returnMoves []StockMove, user *User) (returnID int64, err error) {
+	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return 0, errBegin
+	}
+	picking := &StockPicking{ID: id}
+	if err = o.Read(picking); err != nil {
+		return 0, err
+	}
+	if picking.State != "done" {
+		return 0, fmt.Errorf("调拨单[%s]未完成,不能退货", picking.Name)
+	}
+	var lines []*StockReturnLine
+	if lines, err = stockPickingReturnLines(o, picking); err != nil {
+		return 0, err
+	}
+	if len(returnMoves) > 0 {
+		var selected []*StockReturnLine
+		for _, returnMove := range returnMoves {
+			var line *StockReturnLine
+			for _, l := range lines {
+				if l.Move.ID == returnMove.ID {
+					line = l
+					break
+				}
+			}
+			if line == nil {
+				return 0, fmt.Errorf("移动[%d]不属于调拨单[%s]或已全部退回", returnMove.ID, picking.Name)
+			}
+			if returnMove.FirstUomQty < 0 || returnMove.SecondUomQty < 0 {
+				return 0, fmt.Errorf("移动[%s]的退货数量不能为负数", line.Move.Name)
+			}
+			if returnMove.FirstUomQty-line.FirstUomQty > stockQtyEpsilon || returnMove.SecondUomQty-line.SecondUomQty > stockQtyEpsilon {
+				return 0, fmt.Errorf("移动[%s]的退货数量超过可退数量,第一单位最多%v,第二单位最多%v", line.Move.Name, line.FirstUomQty, line.SecondUomQty)
+			}
+			if returnMove.FirstUomQty <= stockQtyEpsilon && returnMove.SecondUomQty <= stockQtyEpsilon {
+				continue
+			}
+			selected = append(selected, &StockReturnLine{Move: line.Move, FirstUomQty: returnMove.FirstUomQty, SecondUomQty: returnMove.SecondUomQty})
+		}
+		lines = selected
+	}
+	if len(lines) == 0 {
+		return 0, fmt.Errorf("调拨单[%s]没有可退货的明细", picking.Name)
+	}
+	src := &StockLocation{ID: picking.LocationDest.ID}
+	if err = o.Read(src); err != nil {
+		return 0, err
+	}
+	dest := &StockLocation{ID: picking.LocationSrc.ID}
+	if err = o.Read(dest); err != nil {
+		return 0, err
+	}
+	// 从内部库位发出的货物退回时进入退货库位
+	if locationNeedQuants(dest) {
+		if returnLocation, errLocation := stockReturnLocation(o, picking.Company); errLocation == nil {
+			dest = returnLocation
+		}
+	}
+	var returnType *StockPickingType
+	if returnType, err = stockReturnPickingType(o, picking.PickingType); err != nil {
+		return 0, err
+	}
+	var name string
+	if name, err = stockPickingNextName(o, returnType, picking.Company); err != nil {
+		return 0, err
+	}
+	returnPicking := &StockPicking{
+		Name:          name,
+		Origin:        picking.Name,
+		Note:          "退货:" + picking.Name,
+		MoveType:      picking.MoveType,
+		State:         "confirm",
+		Company:       picking.Company,
+		LocationSrc:   src,
+		LocationDest:  dest,
+		Partner:       picking.Partner,
+		Priority:      picking.Priority,
+		PickingType:   returnType,
+		SaleOrder:     picking.SaleOrder,
+		PurchaseOrder: picking.PurchaseOrder,
+		ReturnOf:      picking,
+		CreateUser:    user,
+		UpdateUser:    user,
+	}
+	if returnPicking.ID, err = o.Insert(returnPicking); err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	for i, line := range lines {
+		origin := line.Move
+		move := &StockMove{
+			Sequence:          int64(i + 1),
+			Name:              origin.Name,
+			Date:              now,
+			DateExpected:      now,
+			Product:           origin.Product,
+			FirstUomQty:       line.FirstUomQty,
+			SecondUomQty:      line.SecondUomQty,
+			FirstUom:          origin.FirstUom,
+			SecondUom:         origin.SecondUom,
+			ProductTemplate:   origin.ProductTemplate,
+			LocationSrc:       src,
+			LocationDest:      dest,
+			Partner:           origin.Partner,
+			Picking:           returnPicking,
+			State:             "confirm",
+			PriceUnit:         origin.PriceUnit,
+			Company:           origin.Company,
+			Origin:            picking.Name,
+			ProcureMethod:     "make_to_stock",
+			WareHouse:         origin.WareHouse,
+			SaleOrderLine:     origin.SaleOrderLine,
+			PurchaseOrderLine: origin.PurchaseOrderLine,
+			Lot:               origin.Lot,
+			ReturnedMove:      origin,
+			CreateUser:        user,
+			UpdateUser:        user,
+		}
+		if move.ID, err = o.Insert(move); err != nil {
+			return 0, err
+		}
+		if err = stockMoveAssign(o, move, user); err != nil {
+			return 0, err
+		}
+	}
+	if err = stockPickingUpdateState(o, returnPicking, user); err != nil {
+		return 0, err
+	}
+	return returnPicking.ID, o.Commit()
+}
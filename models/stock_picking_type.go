@@ -12,25 +12,31 @@ import (
 
 // StockPickingType 分拣类型决定分拣视图
 type StockPickingType struct {
-	ID         int64             `orm:"column(id);pk;auto" json:"id"`         //主键
-	CreateUser *User             `orm:"rel(fk);null" json:"-"`                //创建者
-	UpdateUser *User             `orm:"rel(fk);null" json:"-"`                //最后更新者
-	CreateDate time.Time         `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
-	UpdateDate time.Time         `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
-	Name       string            `orm:"unique" json:"Name"`                   //分拣类型名称
-	Active     bool              `orm:"default(true)" json:"Active"`          //是否有效
-	Code       string            `json:"Code"`                                //移库类型 incoming/outgoing/internal
-	WareHouse  *StockWarehouse   `orm:"rel(fk)"`                              //仓库
-	NextStep   *StockPickingType `orm:"null;rel(one)"`                        //下一步
-	PrevStep   *StockPickingType `orm:"null;rel(one)"`                        //上一步
-	IsStart    bool              `orm:"default(false)"`                       //流程开始
-	IsEnd      bool              `orm:"default(false)"`                       //流程结束
-
-	FormAction   string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
-	ActionFields []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
-	WareHouseID  int64    `orm:"-" json:"WareHouse"`
-	NextStepID   int64    `orm:"-" json:"NextStep"`
-	PrevStepID   int64    `orm:"-" json:"PrevStep"`
+	ID                  int64             `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser          *User             `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser          *User             `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate          time.Time         `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate          time.Time         `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	Name                string            `orm:"unique" json:"Name"`                   //分拣类型名称
+	Active              bool              `orm:"default(true)" json:"Active"`          //是否有效
+	Code                string            `json:"Code"`                                //移库类型 incoming/outgoing/internal
+	WareHouse           *StockWarehouse   `orm:"rel(fk)"`                              //仓库
+	NextStep            *StockPickingType `orm:"null;rel(one)"`                        //下一步
+	PrevStep            *StockPickingType `orm:"null;rel(one)"`                        //上一步
+	IsStart             bool              `orm:"default(false)"`                       //流程开始
+	IsEnd               bool              `orm:"default(false)"`                       //流程结束
+	DefaultLocationSrc  *StockLocation    `orm:"rel(fk);null"`                         //默认源库位
+	DefaultLocationDest *StockLocation    `orm:"rel(fk);null"`                         //默认目标库位
+	Sequence            *Sequence         `orm:"rel(fk);null"`                         //调拨单序号，为空时使用默认序号
+
+	FormAction            string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
+	ActionFields          []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
+	WareHouseID           int64    `orm:"-" json:"WareHouse"`
+	NextStepID            int64    `orm:"-" json:"NextStep"`
+	PrevStepID            int64    `orm:"-" json:"PrevStep"`
+	DefaultLocationSrcID  int64    `orm:"-" json:"DefaultLocationSrc"`
+	DefaultLocationDestID int64    `orm:"-" json:"DefaultLocationDest"`
+	SequenceID            int64    `orm:"-" json:"Sequence"`
 }
 
 func init() {
@@ -64,6 +70,15 @@ func AddStockPickingType(obj *StockPickingType, addUser *User) (id int64, err er
 	if obj.PrevStepID > 0 {
 		obj.PrevStep, _ = GetStockPickingTypeByID(obj.PrevStepID)
 	}
+	if obj.DefaultLocationSrcID > 0 {
+		obj.DefaultLocationSrc, _ = GetStockLocationByID(obj.DefaultLocationSrcID)
+	}
+	if obj.DefaultLocationDestID > 0 {
+		obj.DefaultLocationDest, _ = GetStockLocationByID(obj.DefaultLocationDestID)
+	}
+	if obj.SequenceID > 0 {
+		obj.Sequence, _ = GetSequenceByID(obj.SequenceID)
+	}
 	id, err = o.Insert(obj)
 	if err != nil {
 		return 0, err
@@ -91,6 +106,15 @@ func GetStockPickingTypeByID(id int64) (obj *StockPickingType, err error) {
 		if obj.PrevStep != nil {
 			o.Read(obj.PrevStep)
 		}
+		if obj.DefaultLocationSrc != nil {
+			o.Read(obj.DefaultLocationSrc)
+		}
+		if obj.DefaultLocationDest != nil {
+			o.Read(obj.DefaultLocationDest)
+		}
+		if obj.Sequence != nil {
+			o.Read(obj.Sequence)
+		}
 		return obj, nil
 	}
 	return nil, err
@@ -253,3 +277,31 @@ func DeleteStockPickingType(id int64) (err error) {
 	}
 	return
 }
+
+// stockPickingTypeByWarehouse 获得仓库中指定移库类型的分拣类型，优先使用流程开始的分拣类型
+func stockPickingTypeByWarehouse(o orm.Ormer, warehouse *StockWarehouse, code string) (*StockPickingType, error) {
+	var pickingType StockPickingType
+	qs := o.QueryTable(new(StockPickingType)).Filter("WareHouse__Id", warehouse.ID).Filter("Code", code).Filter("Active", true)
+	if err := qs.OrderBy("-IsStart", "Id").One(&pickingType); err != nil {
+		return nil, fmt.Errorf("仓库[%s]没有移库类型为%s的分拣类型", warehouse.Name, code)
+	}
+	return &pickingType, nil
+}
+
+// stockPickingTypeChainStart 获得分拣类型所在流程的第一步
+func stockPickingTypeChainStart(o orm.Ormer, pickingType *StockPickingType) (*StockPickingType, error) {
+	start := pickingType
+	visited := map[int64]bool{start.ID: true}
+	for start.PrevStep != nil && !start.IsStart {
+		prev := &StockPickingType{ID: start.PrevStep.ID}
+		if err := o.Read(prev); err != nil {
+			return nil, err
+		}
+		if visited[prev.ID] {
+			return nil, fmt.Errorf("分拣类型[%s]的流程存在循环", pickingType.Name)
+		}
+		visited[prev.ID] = true
+		start = prev
+	}
+	return start, nil
+}
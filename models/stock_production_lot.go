@@ -1,20 +1,263 @@
 package models
 
 import (
+	"errors"
+	"fmt"
+	"goERP/utils"
+	"strings"
 	"time"
 
 	"github.com/astaxie/beego/orm"
 )
 
-// StockProductionLot ..
+// StockProductionLot 批次、序列号
 type StockProductionLot struct {
-	ID         int64     `orm:"column(id);pk;auto" json:"id"`         //主键
-	CreateUser *User     `orm:"rel(fk);null" json:"-"`                //创建者
-	UpdateUser *User     `orm:"rel(fk);null" json:"-"`                //最后更新者
-	CreateDate time.Time `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
-	UpdateDate time.Time `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	ID          int64           `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser  *User           `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser  *User           `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate  time.Time       `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate  time.Time       `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	Name        string          `orm:"index" json:"Name"`                    //批次号、序列号，同一产品规格内唯一
+	Ref         string          `orm:"default()" json:"Ref"`                 //内部参考
+	Product     *ProductProduct `orm:"rel(fk)"`                              //产品规格
+	Company     *Company        `orm:"rel(fk);null"`                         //公司
+	ExpiryDate  time.Time       `orm:"type(datetime);null" json:"-"`         //过期时间
+	Certificate string          `orm:"default()" json:"Certificate"`         //证书编号
+	Note        string          `orm:"type(text);null" json:"Note"`          //备注
+	Quants      []*StockQuant   `orm:"reverse(many)"`                        //份
+
+	FormAction    string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
+	ActionFields  []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
+	ProductID     int64    `orm:"-" json:"Product"`
+	CompanyID     int64    `orm:"-" json:"Company"`
+	ExpiryDateStr string   `orm:"-" json:"ExpiryDate"` //过期时间form,格式2006-01-02
 }
 
 func init() {
 	orm.RegisterModel(new(StockProductionLot))
 }
+
+// AddStockProductionLot insert a new StockProductionLot into database and returns
+// last inserted ID on success.
+func AddStockProductionLot(obj *StockProductionLot, addUser *User) (id int64, err error) {
+	o := orm.NewOrm()
+	obj.CreateUser = addUser
+	obj.UpdateUser = addUser
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return 0, errBegin
+	}
+	if obj.ProductID > 0 {
+		obj.Product, _ = GetProductProductByID(obj.ProductID)
+	}
+	if obj.CompanyID > 0 {
+		obj.Company, _ = GetCompanyByID(obj.CompanyID)
+	}
+	if obj.Product == nil {
+		return 0, errors.New("批次必须指定产品规格")
+	}
+	if obj.ExpiryDateStr != "" {
+		if obj.ExpiryDate, err = time.ParseInLocation("2006-01-02", obj.ExpiryDateStr, time.Local); err != nil {
+			return 0, err
+		}
+	}
+	obj.Name = strings.TrimSpace(obj.Name)
+	if o.QueryTable(obj).Filter("Name", obj.Name).Filter("Product__Id", obj.Product.ID).Exist() {
+		return 0, fmt.Errorf("产品规格[%s]已存在批次[%s]", obj.Product.Name, obj.Name)
+	}
+	id, err = o.Insert(obj)
+	if err == nil {
+		errCommit := o.Commit()
+		if errCommit != nil {
+			return 0, errCommit
+		}
+	}
+	return id, err
+}
+
+// GetStockProductionLotByID retrieves StockProductionLot by ID. Returns error if
+// ID doesn't exist
+func GetStockProductionLotByID(id int64) (obj *StockProductionLot, err error) {
+	o := orm.NewOrm()
+	obj = &StockProductionLot{ID: id}
+	if err = o.Read(obj); err == nil {
+		if obj.Product != nil {
+			o.Read(obj.Product)
+		}
+		if obj.Company != nil {
+			o.Read(obj.Company)
+		}
+		return obj, nil
+	}
+	return nil, err
+}
+
+// GetStockProductionLotByName retrieves StockProductionLot by Name and product. Returns error if
+// Name doesn't exist
+func GetStockProductionLotByName(name string, productID int64) (obj *StockProductionLot, err error) {
+	o := orm.NewOrm()
+	obj = new(StockProductionLot)
+	if err = o.QueryTable(obj).Filter("Name", name).Filter("Product__Id", productID).One(obj); err == nil {
+		return obj, nil
+	}
+	return nil, err
+}
+
+// GetAllStockProductionLot retrieves all StockProductionLot matches certain condition. Returns empty list if
+// no records exist
+func GetAllStockProductionLot(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (utils.Paginator, []StockProductionLot, error) {
+	var (
+		objArrs   []StockProductionLot
+		paginator utils.Paginator
+		num       int64
+		err       error
+	)
+	if limit == 0 {
+		limit = 20
+	}
+	o := orm.NewOrm()
+	qs := o.QueryTable(new(StockProductionLot))
+	qs = qs.RelatedSel()
+
+	//cond k=v cond必须放到Filter和Exclude前面
+	cond := orm.NewCondition()
+	if _, ok := condMap["and"]; ok {
+		andMap := condMap["and"]
+		for k, v := range andMap {
+			k = strings.Replace(k, ".", "__", -1)
+			cond = cond.And(k, v)
+		}
+	}
+	if _, ok := condMap["or"]; ok {
+		orMap := condMap["or"]
+		for k, v := range orMap {
+			k = strings.Replace(k, ".", "__", -1)
+			cond = cond.Or(k, v)
+		}
+	}
+	qs = qs.SetCond(cond)
+	// query k=v
+	for k, v := range query {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		qs = qs.Filter(k, v)
+	}
+	//exclude k=v
+	for k, v := range exclude {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		qs = qs.Exclude(k, v)
+	}
+
+	// order by:
+	var sortFields []string
+	if len(sortby) != 0 {
+		if len(sortby) == len(order) {
+			// 1) for each sort field, there is an associated order
+			for i, v := range sortby {
+				orderby := ""
+				if order[i] == "desc" {
+					orderby = "-" + strings.Replace(v, ".", "__", -1)
+				} else if order[i] == "asc" {
+					orderby = strings.Replace(v, ".", "__", -1)
+				} else {
+					return paginator, nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+			qs = qs.OrderBy(sortFields...)
+		} else if len(sortby) != len(order) && len(order) == 1 {
+			// 2) there is exactly one order, all the sorted fields will be sorted by this order
+			for _, v := range sortby {
+				orderby := ""
+				if order[0] == "desc" {
+					orderby = "-" + strings.Replace(v, ".", "__", -1)
+				} else if order[0] == "asc" {
+					orderby = strings.Replace(v, ".", "__", -1)
+				} else {
+					return paginator, nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+		} else if len(sortby) != len(order) && len(order) != 1 {
+			return paginator, nil, errors.New("Error: 'sortby', 'order' sizes mismatch or 'order' size is not 1")
+		}
+	} else {
+		if len(order) != 0 {
+			return paginator, nil, errors.New("Error: unused 'order' fields")
+		}
+	}
+
+	qs = qs.OrderBy(sortFields...)
+	if cnt, err := qs.Count(); err == nil {
+		if cnt > 0 {
+			paginator = utils.GenPaginator(limit, offset, cnt)
+			if num, err = qs.Limit(limit, offset).All(&objArrs, fields...); err == nil {
+				paginator.CurrentPageSize = num
+			}
+		}
+	}
+	return paginator, objArrs, err
+}
+
+// UpdateStockProductionLotByID updates StockProductionLot by ID and returns error if
+// the record to be updated doesn't exist
+func UpdateStockProductionLotByID(m *StockProductionLot) (err error) {
+	o := orm.NewOrm()
+	v := StockProductionLot{ID: m.ID}
+	// ascertain id exists in the database
+	if err = o.Read(&v); err == nil {
+		var num int64
+		if num, err = o.Update(m); err == nil {
+			fmt.Println("Number of records updated in database:", num)
+		}
+	}
+	return
+}
+
+// DeleteStockProductionLot deletes StockProductionLot by ID and returns error if
+// the record to be deleted doesn't exist
+func DeleteStockProductionLot(id int64) (err error) {
+	o := orm.NewOrm()
+	v := StockProductionLot{ID: id}
+	// ascertain id exists in the database
+	if err = o.Read(&v); err == nil {
+		var num int64
+		if num, err = o.Delete(&StockProductionLot{ID: id}); err == nil {
+			fmt.Println("Number of records deleted in database:", num)
+		}
+	}
+	return
+}
+
+// GetStockProductionLotTrace 获得批次经过的所有已完成的库存移动，按日期排序
+func GetStockProductionLotTrace(id int64) (moves []*StockMove, err error) {
+	o := orm.NewOrm()
+	qs := o.QueryTable(new(StockMove)).Filter("Lot__Id", id).Filter("State", "done")
+	_, err = qs.RelatedSel("LocationSrc", "LocationDest", "Picking", "Partner").OrderBy("Date", "Id").All(&moves)
+	return moves, err
+}
+
+// productTracking 获得产品规格的追踪方式
+func productTracking(o orm.Ormer, product *ProductProduct) (string, error) {
+	if product.ProductTemplate == nil {
+		if err := o.Read(product); err != nil {
+			return "", err
+		}
+	}
+	template := &ProductTemplate{ID: product.ProductTemplate.ID}
+	if err := o.Read(template); err != nil {
+		return "", err
+	}
+	if template.Tracking == "" {
+		return "none", nil
+	}
+	return template.Tracking, nil
+}
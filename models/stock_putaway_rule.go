@@ -0,0 +1,257 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"goERP/utils"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// StockPutawayRule 入库规则，产品或产品类别入库到指定库位
+type StockPutawayRule struct {
+	ID         int64                 `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser *User                 `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser *User                 `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate time.Time             `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate time.Time             `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	Strategy   *StockPutawayStrategy `orm:"rel(fk)"`                              //入库策略
+	Sequence   int64                 `orm:"default(0)" json:"Sequence"`           //序号，越小越优先
+	Product    *ProductProduct       `orm:"rel(fk);null"`                         //产品规格
+	Category   *ProductCategory      `orm:"rel(fk);null"`                         //产品类别
+	Location   *StockLocation        `orm:"rel(fk)"`                              //入库库位
+
+	FormAction   string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
+	ActionFields []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
+	StrategyID   int64    `orm:"-" json:"Strategy"`
+	ProductID    int64    `orm:"-" json:"Product"`
+	CategoryID   int64    `orm:"-" json:"Category"`
+	LocationID   int64    `orm:"-" json:"Location"`
+}
+
+func init() {
+	orm.RegisterModel(new(StockPutawayRule))
+}
+
+// AddStockPutawayRule insert a new StockPutawayRule into database and returns
+// last inserted ID on success.
+func AddStockPutawayRule(obj *StockPutawayRule, addUser *User) (id int64, err error) {
+	o := orm.NewOrm()
+	obj.CreateUser = addUser
+	obj.UpdateUser = addUser
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return 0, errBegin
+	}
+	if obj.StrategyID > 0 {
+		obj.Strategy, _ = GetStockPutawayStrategyByID(obj.StrategyID)
+	}
+	if obj.ProductID > 0 {
+		obj.Product, _ = GetProductProductByID(obj.ProductID)
+	}
+	if obj.CategoryID > 0 {
+		obj.Category, _ = GetProductCategoryByID(obj.CategoryID)
+	}
+	if obj.LocationID > 0 {
+		obj.Location, _ = GetStockLocationByID(obj.LocationID)
+	}
+	if err = stockPutawayRuleCheck(obj); err != nil {
+		return 0, err
+	}
+	id, err = o.Insert(obj)
+	if err == nil {
+		errCommit := o.Commit()
+		if errCommit != nil {
+			return 0, errCommit
+		}
+	}
+	return id, err
+}
+
+// stockPutawayRuleCheck 检查入库规则，必须指定策略、库位以及产品规格或产品类别
+func stockPutawayRuleCheck(obj *StockPutawayRule) error {
+	if obj.Strategy == nil {
+		return errors.New("入库规则必须指定入库策略")
+	}
+	if obj.Location == nil {
+		return errors.New("入库规则必须指定入库库位")
+	}
+	if obj.Product == nil && obj.Category == nil {
+		return errors.New("入库规则必须指定产品规格或产品类别")
+	}
+	return nil
+}
+
+// GetStockPutawayRuleByID retrieves StockPutawayRule by ID. Returns error if
+// ID doesn't exist
+func GetStockPutawayRuleByID(id int64) (obj *StockPutawayRule, err error) {
+	o := orm.NewOrm()
+	obj = &StockPutawayRule{ID: id}
+	if err = o.Read(obj); err == nil {
+		if obj.Strategy != nil {
+			o.Read(obj.Strategy)
+		}
+		if obj.Product != nil {
+			o.Read(obj.Product)
+		}
+		if obj.Category != nil {
+			o.Read(obj.Category)
+		}
+		if obj.Location != nil {
+			o.Read(obj.Location)
+		}
+		return obj, nil
+	}
+	return nil, err
+}
+
+// GetAllStockPutawayRule retrieves all StockPutawayRule matches certain condition. Returns empty list if
+// no records exist
+func GetAllStockPutawayRule(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (utils.Paginator, []StockPutawayRule, error) {
+	var (
+		objArrs   []StockPutawayRule
+		paginator utils.Paginator
+		num       int64
+		err       error
+	)
+	if limit == 0 {
+		limit = 20
+	}
+	o := orm.NewOrm()
+	qs := o.QueryTable(new(StockPutawayRule))
+	qs = qs.RelatedSel()
+
+	//cond k=v cond必须放到Filter和Exclude前面
+	cond := orm.NewCondition()
+	if _, ok := condMap["and"]; ok {
+		andMap := condMap["and"]
+		for k, v := range andMap {
+			k = strings.Replace(k, ".", "__", -1)
+			cond = cond.And(k, v)
+		}
+	}
+	if _, ok := condMap["or"]; ok {
+		orMap := condMap["or"]
+		for k, v := range orMap {
+			k = strings.Replace(k, ".", "__", -1)
+			cond = cond.Or(k, v)
+		}
+	}
+	qs = qs.SetCond(cond)
+	// query k=v
+	for k, v := range query {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		qs = qs.Filter(k, v)
+	}
+	//exclude k=v
+	for k, v := range exclude {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		qs = qs.Exclude(k, v)
+	}
+
+	// order by:
+	var sortFields []string
+	if len(sortby) != 0 {
+		if len(sortby) == len(order) {
+			// 1) for each sort field, there is an associated order
+			for i, v := range sortby {
+				orderby := ""
+				if order[i] == "desc" {
+					orderby = "-" + strings.Replace(v, ".", "__", -1)
+				} else if order[i] == "asc" {
+					orderby = strings.Replace(v, ".", "__", -1)
+				} else {
+					return paginator, nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+			qs = qs.OrderBy(sortFields...)
+		} else if len(sortby) != len(order) && len(order) == 1 {
+			// 2) there is exactly one order, all the sorted fields will be sorted by this order
+			for _, v := range sortby {
+				orderby := ""
+				if order[0] == "desc" {
+					orderby = "-" + strings.Replace(v, ".", "__", -1)
+				} else if order[0] == "asc" {
+					orderby = strings.Replace(v, ".", "__", -1)
+				} else {
+					return paginator, nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+		} else if len(sortby) != len(order) && len(order) != 1 {
+			return paginator, nil, errors.New("Error: 'sortby', 'order' sizes mismatch or 'order' size is not 1")
+		}
+	} else {
+		if len(order) != 0 {
+			return paginator, nil, errors.New("Error: unused 'order' fields")
+		}
+	}
+
+	qs = qs.OrderBy(sortFields...)
+	if cnt, err := qs.Count(); err == nil {
+		if cnt > 0 {
+			paginator = utils.GenPaginator(limit, offset, cnt)
+			if num, err = qs.Limit(limit, offset).All(&objArrs, fields...); err == nil {
+				paginator.CurrentPageSize = num
+			}
+		}
+	}
+	return paginator, objArrs, err
+}
+
+// UpdateStockPutawayRuleByID updates StockPutawayRule by ID and returns error if
+// the record to be updated doesn't exist
+func UpdateStockPutawayRuleByID(m *StockPutawayRule) (err error) {
+	o := orm.NewOrm()
+	v := StockPutawayRule{ID: m.ID}
+	if m.StrategyID > 0 {
+		m.Strategy = &StockPutawayStrategy{ID: m.StrategyID}
+	}
+	if m.ProductID > 0 {
+		m.Product = &ProductProduct{ID: m.ProductID}
+	}
+	if m.CategoryID > 0 {
+		m.Category = &ProductCategory{ID: m.CategoryID}
+	}
+	if m.LocationID > 0 {
+		m.Location = &StockLocation{ID: m.LocationID}
+	}
+	if err = stockPutawayRuleCheck(m); err != nil {
+		return err
+	}
+	// ascertain id exists in the database
+	if err = o.Read(&v); err == nil {
+		var num int64
+		if num, err = o.Update(m); err == nil {
+			fmt.Println("Number of records updated in database:", num)
+		}
+	}
+	return
+}
+
+// DeleteStockPutawayRule deletes StockPutawayRule by ID and returns error if
+// the record to be deleted doesn't exist
+func DeleteStockPutawayRule(id int64) (err error) {
+	o := orm.NewOrm()
+	v := StockPutawayRule{ID: id}
+	// ascertain id exists in the database
+	if err = o.Read(&v); err == nil {
+		var num int64
+		if num, err = o.Delete(&StockPutawayRule{ID: id}); err == nil {
+			fmt.Println("Number of records deleted in database:", num)
+		}
+	}
+	return
+}
@@ -0,0 +1,288 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"goERP/utils"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// StockPutawayStrategy 入库策略，按产品或产品类别决定入库的子库位
+type StockPutawayStrategy struct {
+	ID         int64               `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser *User               `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser *User               `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate time.Time           `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate time.Time           `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	Name       string              `orm:"unique" json:"Name"`                   //策略名称
+	Company    *Company            `orm:"rel(fk);null"`                         //公司
+	Active     bool                `orm:"default(true)" json:"Active"`          //有效
+	Rules      []*StockPutawayRule `orm:"reverse(many)"`                        //入库规则
+
+	FormAction   string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
+	ActionFields []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
+	CompanyID    int64    `orm:"-" json:"Company"`
+}
+
+func init() {
+	orm.RegisterModel(new(StockPutawayStrategy))
+}
+
+// AddStockPutawayStrategy insert a new StockPutawayStrategy into database and returns
+// last inserted ID on success.
+func AddStockPutawayStrategy(obj *StockPutawayStrategy, addUser *User) (id int64, err error) {
+	o := orm.NewOrm()
+	obj.CreateUser = addUser
+	obj.UpdateUser = addUser
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return 0, errBegin
+	}
+	if obj.CompanyID > 0 {
+		obj.Company, _ = GetCompanyByID(obj.CompanyID)
+	}
+	id, err = o.Insert(obj)
+	if err == nil {
+		errCommit := o.Commit()
+		if errCommit != nil {
+			return 0, errCommit
+		}
+	}
+	return id, err
+}
+
+// GetStockPutawayStrategyByID retrieves StockPutawayStrategy by ID. Returns error if
+// ID doesn't exist
+func GetStockPutawayStrategyByID(id int64) (obj *StockPutawayStrategy, err error) {
+	o := orm.NewOrm()
+	obj = &StockPutawayStrategy{ID: id}
+	if err = o.Read(obj); err == nil {
+		if obj.Company != nil {
+			o.Read(obj.Company)
+		}
+		return obj, nil
+	}
+	return nil, err
+}
+
+// GetStockPutawayStrategyByName retrieves StockPutawayStrategy by Name. Returns error if
+// Name doesn't exist
+func GetStockPutawayStrategyByName(name string) (obj *StockPutawayStrategy, err error) {
+	o := orm.NewOrm()
+	obj = &StockPutawayStrategy{Name: name}
+	if err = o.Read(obj, "Name"); err == nil {
+		return obj, nil
+	}
+	return nil, err
+}
+
+// GetAllStockPutawayStrategy retrieves all StockPutawayStrategy matches certain condition. Returns empty list if
+// no records exist
+func GetAllStockPutawayStrategy(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (utils.Paginator, []StockPutawayStrategy, error) {
+	var (
+		objArrs   []StockPutawayStrategy
+		paginator utils.Paginator
+		num       int64
+		err       error
+	)
+	if limit == 0 {
+		limit = 20
+	}
+	o := orm.NewOrm()
+	qs := o.QueryTable(new(StockPutawayStrategy))
+	qs = qs.RelatedSel()
+
+	//cond k=v cond必须放到Filter和Exclude前面
+	cond := orm.NewCondition()
+	if _, ok := condMap["and"]; ok {
+		andMap := condMap["and"]
+		for k, v := range andMap {
+			k = strings.Replace(k, ".", "__", -1)
+			cond = cond.And(k, v)
+		}
+	}
+	if _, ok := condMap["or"]; ok {
+		orMap := condMap["or"]
+		for k, v := range orMap {
+			k = strings.Replace(k, ".", "__", -1)
+			cond = cond.Or(k, v)
+		}
+	}
+	qs = qs.SetCond(cond)
+	// query k=v
+	for k, v := range query {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		qs = qs.Filter(k, v)
+	}
+	//exclude k=v
+	for k, v := range exclude {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		qs = qs.Exclude(k, v)
+	}
+
+	// order by:
+	var sortFields []string
+	if len(sortby) != 0 {
+		if len(sortby) == len(order) {
+			// 1) for each sort field, there is an associated order
+			for i, v := range sortby {
+				orderby := ""
+				if order[i] == "desc" {
+					orderby = "-" + strings.Replace(v, ".", "__", -1)
+				} else if order[i] == "asc" {
+					orderby = strings.Replace(v, ".", "__", -1)
+				} else {
+					return paginator, nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+			qs = qs.OrderBy(sortFields...)
+		} else if len(sortby) != len(order) && len(order) == 1 {
+			// 2) there is exactly one order, all the sorted fields will be sorted by this order
+			for _, v := range sortby {
+				orderby := ""
+				if order[0] == "desc" {
+					orderby = "-" + strings.Replace(v, ".", "__", -1)
+				} else if order[0] == "asc" {
+					orderby = strings.Replace(v, ".", "__", -1)
+				} else {
+					return paginator, nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+		} else if len(sortby) != len(order) && len(order) != 1 {
+			return paginator, nil, errors.New("Error: 'sortby', 'order' sizes mismatch or 'order' size is not 1")
+		}
+	} else {
+		if len(order) != 0 {
+			return paginator, nil, errors.New("Error: unused 'order' fields")
+		}
+	}
+
+	qs = qs.OrderBy(sortFields...)
+	if cnt, err := qs.Count(); err == nil {
+		if cnt > 0 {
+			paginator = utils.GenPaginator(limit, offset, cnt)
+			if num, err = qs.Limit(limit, offset).All(&objArrs, fields...); err == nil {
+				paginator.CurrentPageSize = num
+			}
+		}
+	}
+	return paginator, objArrs, err
+}
+
+// UpdateStockPutawayStrategyByID updates StockPutawayStrategy by ID and returns error if
+// the record to be updated doesn't exist
+func UpdateStockPutawayStrategyByID(m *StockPutawayStrategy) (err error) {
+	o := orm.NewOrm()
+	v := StockPutawayStrategy{ID: m.ID}
+	// ascertain id exists in the database
+	if err = o.Read(&v); err == nil {
+		var num int64
+		if num, err = o.Update(m); err == nil {
+			fmt.Println("Number of records updated in database:", num)
+		}
+	}
+	return
+}
+
+// DeleteStockPutawayStrategy deletes StockPutawayStrategy by ID and returns error if
+// the record to be deleted doesn't exist
+func DeleteStockPutawayStrategy(id int64) (err error) {
+	o := orm.NewOrm()
+	v := StockPutawayStrategy{ID: id}
+	// ascertain id exists in the database
+	if err = o.Read(&v); err == nil {
+		var num int64
+		if num, err = o.Delete(&StockPutawayStrategy{ID: id}); err == nil {
+			fmt.Println("Number of records deleted in database:", num)
+		}
+	}
+	return
+}
+
+// stockLocationPutaway 根据目标库位的入库策略获得产品实际入库的子库位，
+// 产品规格的规则优先于产品类别的规则，下级类别优先于上级类别，没有匹配的规则时返回原库位
+func stockLocationPutaway(o orm.Ormer, location *StockLocation, product *ProductProduct) (*StockLocation, error) {
+	loc := &StockLocation{ID: location.ID}
+	if err := o.Read(loc); err != nil {
+		return nil, err
+	}
+	if loc.PutawayStrategy == nil {
+		return location, nil
+	}
+	strategy := &StockPutawayStrategy{ID: loc.PutawayStrategy.ID}
+	if err := o.Read(strategy); err != nil {
+		return nil, err
+	}
+	if !strategy.Active {
+		return location, nil
+	}
+	var rules []*StockPutawayRule
+	if _, err := o.QueryTable(new(StockPutawayRule)).Filter("Strategy__Id", strategy.ID).OrderBy("Sequence", "Id").All(&rules); err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return location, nil
+	}
+	for _, rule := range rules {
+		if rule.Product != nil && rule.Product.ID == product.ID {
+			return stockPutawayRuleLocation(o, rule, location)
+		}
+	}
+	if product.ProductTemplate == nil {
+		if err := o.Read(product); err != nil {
+			return nil, err
+		}
+	}
+	template := &ProductTemplate{ID: product.ProductTemplate.ID}
+	if err := o.Read(template); err != nil {
+		return nil, err
+	}
+	visited := make(map[int64]bool)
+	category := template.Category
+	for category != nil && !visited[category.ID] {
+		visited[category.ID] = true
+		for _, rule := range rules {
+			if rule.Product == nil && rule.Category != nil && rule.Category.ID == category.ID {
+				return stockPutawayRuleLocation(o, rule, location)
+			}
+		}
+		parent := &ProductCategory{ID: category.ID}
+		if err := o.Read(parent); err != nil {
+			return nil, err
+		}
+		category = parent.Parent
+	}
+	return location, nil
+}
+
+// stockPutawayRuleLocation 获得入库规则的目标库位，必须是原库位的下级库位
+func stockPutawayRuleLocation(o orm.Ormer, rule *StockPutawayRule, location *StockLocation) (*StockLocation, error) {
+	dest := &StockLocation{ID: rule.Location.ID}
+	if err := o.Read(dest); err != nil {
+		return nil, err
+	}
+	childIDs, err := stockLocationChildIDs(o, location)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range childIDs {
+		if id == dest.ID {
+			return dest, nil
+		}
+	}
+	return nil, fmt.Errorf("入库规则的库位[%s]不是库位[%s]的下级库位", dest.Name, location.Name)
+}
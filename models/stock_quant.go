@@ -1,11 +1,20 @@
 package models
 
 import (
+	"errors"
+	"fmt"
+	"goERP/utils"
+	"math"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/astaxie/beego/orm"
 )
 
+// stockQtyEpsilon 数量比较精度，小于该值的数量视为0
+const stockQtyEpsilon = 0.000001
+
 // StockQuant  	库存分析
 type StockQuant struct {
 	ID                   int64               `orm:"column(id);pk;auto" json:"id"`                  //主键
@@ -21,16 +30,17 @@ type StockQuant struct {
 	FirstUom             *ProductUom         `orm:"rel(fk)"`                                       //第一单位
 	SecondUom            *ProductUom         `orm:"rel(fk);null"`                                  //第二单位
 	Package              *StockQuantPackage  `orm:"rel(fk);null"`                                  //物理包装
-	PackagingType        *ProductPackaging   `orm:"rel(fk)"`                                       //包装
+	PackagingType        *ProductPackaging   `orm:"rel(fk);null"`                                  //包装
 	Reservation          *StockMove          `orm:"rel(fk);null"`                                  //调拨保留
-	Lot                  *StockProductionLot `orm:"rel(fk)"`                                       // 批次
+	Lot                  *StockProductionLot `orm:"rel(fk);null"`                                  // 批次
 	Cost                 float64             `orm:"default(0)"`                                    //成本
-	InDate               time.Time           `orm:"auto_now_add;type(datetime)"`                   //接收时间
+	InDate               time.Time           `orm:"type(datetime)"`                                //接收时间，拆分时保留原接收时间用于先进先出
 	Historys             []*StockMove        `orm:"reverse(many);rel_table(stock_quant_move_rel)"` //调拨
 	Company              *Company            `orm:"rel(fk)"`                                       //公司
-	PropagatedFrom       *StockQuant         `orm:"rel(fk);null"`                                  //连接份数
+	PropagatedFrom       *StockQuant         `orm:"rel(fk);null;on_delete(set_null)"`              //拆分来源的份，用于追溯
 	NegativeDestLocation *StockLocation      `orm:"rel(fk);null"`                                  //负值目标库位
 	NegativeMove         *StockMove          `orm:"rel(fk);null"`                                  //调拨负数分析
+	Owner                *Partner            `orm:"rel(fk);null"`                                  //货主，代售品归供应商所有，不计入库存价值
 
 	FormAction   string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
 	ActionFields []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
@@ -40,3 +50,652 @@ type StockQuant struct {
 func init() {
 	orm.RegisterModel(new(StockQuant))
 }
+
+// GetStockQuantByID retrieves StockQuant by ID. Returns error if
+// ID doesn't exist
+func GetStockQuantByID(id int64) (obj *StockQuant, err error) {
+	o := orm.NewOrm()
+	obj = &StockQuant{ID: id}
+	if err = o.Read(obj); err == nil {
+		return obj, nil
+	}
+	return nil, err
+}
+
+// GetAllStockQuant retrieves all StockQuant matches certain condition. Returns empty list if
+// no records exist
+func GetAllStockQuant(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (utils.Paginator, []StockQuant, error) {
+	var (
+		objArrs   []StockQuant
+		paginator utils.Paginator
+		num       int64
+		err       error
+	)
+	if limit == 0 {
+		limit = 20
+	}
+	o := orm.NewOrm()
+	qs := o.QueryTable(new(StockQuant))
+	qs = qs.RelatedSel()
+
+	//cond k=v cond必须放到Filter和Exclude前面
+	cond := orm.NewCondition()
+	if _, ok := condMap["and"]; ok {
+		andMap := condMap["and"]
+		for k, v := range andMap {
+			k = strings.Replace(k, ".", "__", -1)
+			cond = cond.And(k, v)
+		}
+	}
+	if _, ok := condMap["or"]; ok {
+		orMap := condMap["or"]
+		for k, v := range orMap {
+			k = strings.Replace(k, ".", "__", -1)
+			cond = cond.Or(k, v)
+		}
+	}
+	qs = qs.SetCond(cond)
+	// query k=v
+	for k, v := range query {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		qs = qs.Filter(k, v)
+	}
+	//exclude k=v
+	for k, v := range exclude {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		qs = qs.Exclude(k, v)
+	}
+
+	// order by:
+	var sortFields []string
+	if len(sortby) != 0 {
+		if len(sortby) == len(order) {
+			// 1) for each sort field, there is an associated order
+			for i, v := range sortby {
+				orderby := ""
+				if order[i] == "desc" {
+					orderby = "-" + strings.Replace(v, ".", "__", -1)
+				} else if order[i] == "asc" {
+					orderby = strings.Replace(v, ".", "__", -1)
+				} else {
+					return paginator, nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+			qs = qs.OrderBy(sortFields...)
+		} else if len(sortby) != len(order) && len(order) == 1 {
+			// 2) there is exactly one order, all the sorted fields will be sorted by this order
+			for _, v := range sortby {
+				orderby := ""
+				if order[0] == "desc" {
+					orderby = "-" + strings.Replace(v, ".", "__", -1)
+				} else if order[0] == "asc" {
+					orderby = strings.Replace(v, ".", "__", -1)
+				} else {
+					return paginator, nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+		} else if len(sortby) != len(order) && len(order) != 1 {
+			return paginator, nil, errors.New("Error: 'sortby', 'order' sizes mismatch or 'order' size is not 1")
+		}
+	} else {
+		if len(order) != 0 {
+			return paginator, nil, errors.New("Error: unused 'order' fields")
+		}
+	}
+
+	qs = qs.OrderBy(sortFields...)
+	if cnt, err := qs.Count(); err == nil {
+		if cnt > 0 {
+			paginator = utils.GenPaginator(limit, offset, cnt)
+			if num, err = qs.Limit(limit, offset).All(&objArrs, fields...); err == nil {
+				paginator.CurrentPageSize = num
+			}
+		}
+	}
+	return paginator, objArrs, err
+}
+
+// locationNeedQuants 内部库位和中转库位需要校验库存数量
+func locationNeedQuants(location *StockLocation) bool {
+	return location.Usage == "internal" || location.Usage == "transit"
+}
+
+// quantsFilterForMove 移动可使用的该产品数量为正的份的查询条件，分拣中的移动按出库策略从源库位及其下级库位中选取，
+// 盘点、报废等没有分拣的移动只使用源库位本身，没有批次或包时也只使用没有批次或包的份
+func quantsFilterForMove(o orm.Ormer, move *StockMove) (*orm.Condition, error) {
+	qtyCond := orm.NewCondition().Or("FirstUomQty__gt", 0).Or("SecondUomQty__gt", 0)
+	cond := orm.NewCondition()
+	cond = cond.And("Product__Id", move.Product.ID).AndCond(qtyCond)
+	if move.Picking != nil {
+		locationIDs, err := stockLocationChildIDs(o, move.LocationSrc)
+		if err != nil {
+			return nil, err
+		}
+		cond = cond.And("Location__Id__in", locationIDs)
+	} else {
+		cond = cond.And("Location__Id", move.LocationSrc.ID)
+		if move.Lot == nil {
+			cond = cond.And("Lot__isnull", true)
+		}
+		if move.Package == nil {
+			cond = cond.And("Package__isnull", true)
+		}
+	}
+	if move.Lot != nil {
+		cond = cond.And("Lot__Id", move.Lot.ID)
+	}
+	if move.Package != nil {
+		cond = cond.And("Package__Id", move.Package.ID)
+	}
+	return cond, nil
+}
+
+// quantsGetAvailable 获得移动源库位中未被保留的份，按源库位的出库策略排序。
+// 退回供应商时只使用该供应商代售的份或公司自有的份，并优先使用代售的份；其他移动优先使用公司自有的份
+func quantsGetAvailable(o orm.Ormer, move *StockMove) (quants []*StockQuant, err error) {
+	cond, err := quantsFilterForMove(o, move)
+	if err != nil {
+		return nil, err
+	}
+	cond = cond.And("Reservation__isnull", true)
+	var owner *Partner
+	if owner, err = quantsReturnOwner(o, move); err != nil {
+		return nil, err
+	}
+	if owner != nil {
+		ownerCond := orm.NewCondition().Or("Owner__isnull", true).Or("Owner__Id", owner.ID)
+		cond = cond.AndCond(ownerCond)
+	}
+	qs := o.QueryTable(new(StockQuant)).SetCond(cond)
+	if _, err = qs.OrderBy("InDate", "Id").All(&quants); err != nil {
+		return nil, err
+	}
+	quantsSortByRemoval(o, quants, stockLocationRemovalMethod(o, move.LocationSrc), move.LocationSrc)
+	sort.SliceStable(quants, func(i, j int) bool {
+		if owner != nil {
+			return quants[i].Owner != nil && quants[j].Owner == nil
+		}
+		return quants[i].Owner == nil && quants[j].Owner != nil
+	})
+	return quants, nil
+}
+
+// quantsReturnOwner 移动退回供应商时返回该供应商，其他移动返回空
+func quantsReturnOwner(o orm.Ormer, move *StockMove) (*Partner, error) {
+	if move.LocationDest == nil {
+		return nil, nil
+	}
+	dest := &StockLocation{ID: move.LocationDest.ID}
+	if err := o.Read(dest); err != nil {
+		return nil, err
+	}
+	if dest.Usage != "supplier" {
+		return nil, nil
+	}
+	if move.Partner != nil {
+		return move.Partner, nil
+	}
+	if move.Picking != nil {
+		picking := &StockPicking{ID: move.Picking.ID}
+		if err := o.Read(picking); err != nil {
+			return nil, err
+		}
+		return picking.Partner, nil
+	}
+	return nil, nil
+}
+
+// quantsGetForMove 获得移动可使用的份，优先使用已为该移动保留的份，其余按出库策略排序
+func quantsGetForMove(o orm.Ormer, move *StockMove) (quants []*StockQuant, err error) {
+	var reserved, available []*StockQuant
+	if move.ID > 0 {
+		var cond *orm.Condition
+		if cond, err = quantsFilterForMove(o, move); err != nil {
+			return nil, err
+		}
+		qs := o.QueryTable(new(StockQuant)).SetCond(cond.And("Reservation__Id", move.ID))
+		if _, err = qs.OrderBy("InDate", "Id").All(&reserved); err != nil {
+			return nil, err
+		}
+	}
+	if available, err = quantsGetAvailable(o, move); err != nil {
+		return nil, err
+	}
+	quants = append(reserved, available...)
+	return quants, nil
+}
+
+// quantSplit 拆分份，原份保留指定的数量，剩余数量生成新的份并继承调拨历史
+func quantSplit(o orm.Ormer, quant *StockQuant, firstQty, secondQty float64) (newQuant *StockQuant, err error) {
+	restFirstQty := quant.FirstUomQty - firstQty
+	restSecondQty := quant.SecondUomQty - secondQty
+	if restFirstQty <= stockQtyEpsilon && restSecondQty <= stockQtyEpsilon {
+		return nil, nil
+	}
+	newQuant = new(StockQuant)
+	*newQuant = *quant
+	newQuant.ID = 0
+	newQuant.Historys = nil
+	newQuant.PropagatedFrom = quant
+	newQuant.FirstUomQty = restFirstQty
+	newQuant.SecondUomQty = restSecondQty
+	if newQuant.ID, err = o.Insert(newQuant); err != nil {
+		return nil, err
+	}
+	quant.FirstUomQty = firstQty
+	quant.SecondUomQty = secondQty
+	if _, err = o.Update(quant, "FirstUomQty", "SecondUomQty", "UpdateDate"); err != nil {
+		return nil, err
+	}
+	var historys []*StockMove
+	if _, err = o.QueryTable(new(StockMove)).Filter("Quants__StockQuant__Id", quant.ID).All(&historys, "Id"); err != nil {
+		return nil, err
+	}
+	for _, history := range historys {
+		if _, err = o.QueryM2M(history, "Quants").Add(newQuant); err != nil {
+			return nil, err
+		}
+	}
+	return newQuant, nil
+}
+
+// quantCreate 根据移动在库位中创建新的份，数量为负时为负库存
+func quantCreate(o orm.Ormer, move *StockMove, location *StockLocation, firstQty, secondQty float64, user *User) (quant *StockQuant, err error) {
+	quant = &StockQuant{
+		CreateUser:    user,
+		UpdateUser:    user,
+		Product:       move.Product,
+		Location:      location,
+		FirstUomQty:   firstQty,
+		SecondUomQty:  secondQty,
+		FirstUom:      move.FirstUom,
+		SecondUom:     move.SecondUom,
+		PackagingType: move.ProductPackaging,
+		Lot:           move.Lot,
+		Package:       move.ResultPackage,
+		Cost:          stockMoveUnitCost(o, move),
+		InDate:        time.Now(),
+		Company:       move.Company,
+	}
+	if quant.ID, err = o.Insert(quant); err != nil {
+		return nil, err
+	}
+	if _, err = o.QueryM2M(move, "Quants").Add(quant); err != nil {
+		return nil, err
+	}
+	return quant, nil
+}
+
+// quantMove 将份移动到目标库位并记录调拨历史
+func quantMove(o orm.Ormer, quant *StockQuant, move *StockMove, dest *StockLocation, user *User) (err error) {
+	quant.Location = dest
+	quant.Reservation = nil
+	// 份离开原库位后不再属于原来的包，整包移动或装包时转入目标包
+	quant.Package = move.ResultPackage
+	quant.UpdateUser = user
+	fields := []string{"Location", "Reservation", "Package", "UpdateUser", "UpdateDate"}
+	// 公司间调拨的份到达调入公司后归属调入公司，成本为调拨价格
+	if move.Company != nil && quant.Company != nil && quant.Company.ID != move.Company.ID && locationNeedQuants(dest) {
+		quant.Company = move.Company
+		quant.Cost = move.PriceUnit
+		fields = append(fields, "Company", "Cost")
+	}
+	if _, err = o.Update(quant, fields...); err != nil {
+		return err
+	}
+	if _, err = o.QueryM2M(move, "Quants").Add(quant); err != nil {
+		return err
+	}
+	var remaining bool
+	if remaining, err = quantReconcileNegative(o, quant, user); err != nil || !remaining {
+		return err
+	}
+	return quantMerge(o, quant)
+}
+
+// quantReconcileLocationIDs 份所在库位及其上级库位，到达下级库位的库存也可以冲销上级库位的负库存
+func quantReconcileLocationIDs(o orm.Ormer, location *StockLocation) (ids []int64, err error) {
+	for location != nil && location.ID > 0 {
+		ids = append(ids, location.ID)
+		if location.Parent == nil || location.Parent.ID == 0 {
+			break
+		}
+		parent := &StockLocation{ID: location.Parent.ID}
+		if err = o.Read(parent); err != nil {
+			return nil, err
+		}
+		location = parent
+	}
+	return ids, nil
+}
+
+// quantReconcileNegative 用到达库位的正数份按接收时间冲销同一产品的负库存，
+// 负数份有批次时只冲销相同批次，冲销为0的份会被删除，返回正数份是否还有剩余数量
+func quantReconcileNegative(o orm.Ormer, quant *StockQuant, user *User) (remaining bool, err error) {
+	if quant.FirstUomQty <= stockQtyEpsilon && quant.SecondUomQty <= stockQtyEpsilon {
+		return true, nil
+	}
+	// 代售品不属于公司，不能冲销公司的负库存
+	if quant.Location == nil || !locationNeedQuants(quant.Location) || quant.Owner != nil {
+		return true, nil
+	}
+	locationIDs, err := quantReconcileLocationIDs(o, quant.Location)
+	if err != nil {
+		return false, err
+	}
+	qtyCond := orm.NewCondition().Or("FirstUomQty__lt", 0).Or("SecondUomQty__lt", 0)
+	cond := orm.NewCondition()
+	cond = cond.And("Product__Id", quant.Product.ID).And("Location__Id__in", locationIDs).AndCond(qtyCond)
+	lotCond := orm.NewCondition().Or("Lot__isnull", true)
+	if quant.Lot != nil {
+		lotCond = lotCond.Or("Lot__Id", quant.Lot.ID)
+	}
+	cond = cond.AndCond(lotCond)
+	var negatives []*StockQuant
+	if _, err = o.QueryTable(new(StockQuant)).SetCond(cond).OrderBy("InDate", "Id").All(&negatives); err != nil {
+		return false, err
+	}
+	for _, negative := range negatives {
+		offsetFirstQty := math.Min(math.Max(quant.FirstUomQty, 0), math.Max(-negative.FirstUomQty, 0))
+		offsetSecondQty := math.Min(math.Max(quant.SecondUomQty, 0), math.Max(-negative.SecondUomQty, 0))
+		if offsetFirstQty <= stockQtyEpsilon && offsetSecondQty <= stockQtyEpsilon {
+			continue
+		}
+		negative.FirstUomQty += offsetFirstQty
+		negative.SecondUomQty += offsetSecondQty
+		quant.FirstUomQty -= offsetFirstQty
+		quant.SecondUomQty -= offsetSecondQty
+		negativeDone := negative.FirstUomQty >= -stockQtyEpsilon && negative.SecondUomQty >= -stockQtyEpsilon
+		if negativeDone {
+			// 冲销完的负数份的调拨历史转到正数份，保留追溯关系
+			if err = quantMoveTrace(o, negative, quant); err != nil {
+				return false, err
+			}
+			if _, err = o.Delete(negative); err != nil {
+				return false, err
+			}
+		} else {
+			negative.UpdateUser = user
+			if _, err = o.Update(negative, "FirstUomQty", "SecondUomQty", "UpdateUser", "UpdateDate"); err != nil {
+				return false, err
+			}
+		}
+		if quant.FirstUomQty <= stockQtyEpsilon && quant.SecondUomQty <= stockQtyEpsilon {
+			if negativeDone {
+				// 正负数份同时冲销完时保留数量为0的正数份作为追溯记录
+				quant.FirstUomQty, quant.SecondUomQty = 0, 0
+				quant.UpdateUser = user
+				_, err = o.Update(quant, "FirstUomQty", "SecondUomQty", "UpdateUser", "UpdateDate")
+				return false, err
+			}
+			if err = quantMoveTrace(o, quant, negative); err != nil {
+				return false, err
+			}
+			_, err = o.Delete(quant)
+			return false, err
+		}
+	}
+	if len(negatives) > 0 {
+		quant.UpdateUser = user
+		if _, err = o.Update(quant, "FirstUomQty", "SecondUomQty", "UpdateUser", "UpdateDate"); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// quantMerge 将份合并到同一库位中产品、批次、包装、成本、货主都相同的份中，保留最早的接收时间
+func quantMerge(o orm.Ormer, quant *StockQuant) (err error) {
+	if quant.FirstUomQty < 0 || quant.SecondUomQty < 0 || quant.Reservation != nil {
+		return nil
+	}
+	var target StockQuant
+	cond := orm.NewCondition()
+	cond = cond.And("Product__Id", quant.Product.ID).And("Location__Id", quant.Location.ID)
+	cond = cond.And("Cost", quant.Cost).And("Reservation__isnull", true).And("NegativeMove__isnull", true)
+	cond = cond.And("FirstUomQty__gte", 0).And("SecondUomQty__gte", 0)
+	if quant.Lot != nil {
+		cond = cond.And("Lot__Id", quant.Lot.ID)
+	} else {
+		cond = cond.And("Lot__isnull", true)
+	}
+	if quant.Package != nil {
+		cond = cond.And("Package__Id", quant.Package.ID)
+	} else {
+		cond = cond.And("Package__isnull", true)
+	}
+	if quant.Owner != nil {
+		cond = cond.And("Owner__Id", quant.Owner.ID)
+	} else {
+		cond = cond.And("Owner__isnull", true)
+	}
+	qs := o.QueryTable(new(StockQuant)).SetCond(cond).Exclude("Id", quant.ID)
+	if err = qs.OrderBy("InDate", "Id").One(&target); err != nil {
+		if err == orm.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	target.FirstUomQty += quant.FirstUomQty
+	target.SecondUomQty += quant.SecondUomQty
+	if quant.InDate.Before(target.InDate) {
+		target.InDate = quant.InDate
+	}
+	if _, err = o.Update(&target, "FirstUomQty", "SecondUomQty", "InDate", "UpdateDate"); err != nil {
+		return err
+	}
+	if err = quantMoveTrace(o, quant, &target); err != nil {
+		return err
+	}
+	_, err = o.Delete(quant)
+	return err
+}
+
+// quantMoveTrace 删除份前将其调拨历史转到另一个份，由其拆分出的份改为指向该份，保留追溯关系
+func quantMoveTrace(o orm.Ormer, from, to *StockQuant) error {
+	var historys []*StockMove
+	if _, err := o.QueryTable(new(StockMove)).Filter("Quants__StockQuant__Id", from.ID).All(&historys, "Id"); err != nil {
+		return err
+	}
+	for _, history := range historys {
+		m2m := o.QueryM2M(history, "Quants")
+		if !m2m.Exist(to) {
+			if _, err := m2m.Add(to); err != nil {
+				return err
+			}
+		}
+		if _, err := m2m.Remove(from); err != nil {
+			return err
+		}
+	}
+	_, err := o.QueryTable(new(StockQuant)).Filter("PropagatedFrom__Id", from.ID).Update(orm.Params{"PropagatedFrom": to.ID})
+	return err
+}
+
+// quantsMoveForMove 按移动的数量将源库位的份转移到目标库位，两个单位分别计算，
+// 返回转移的公司自有的份按成本计算的金额，代售品的份记录代售明细
+func quantsMoveForMove(o orm.Ormer, move *StockMove, user *User) (movedCost float64, err error) {
+	src := move.LocationSrc
+	dest := move.LocationDest
+	if src.Usage == "view" || dest.Usage == "view" {
+		return 0, errors.New("视图库位不能存放产品")
+	}
+	// 从供应商接收时确定份的货主，代售品按结算单价计算成本
+	owner, ownerPrice, err := stockMoveConsignmentOwner(o, move)
+	if err != nil {
+		return 0, err
+	}
+	setOwner := func(quant *StockQuant) error {
+		if src.Usage != "supplier" {
+			return nil
+		}
+		quant.Owner = owner
+		if owner != nil {
+			quant.Cost = ownerPrice
+		}
+		_, err := o.Update(quant, "Owner", "Cost")
+		return err
+	}
+	addMoved := func(quant *StockQuant, firstQty, secondQty float64) error {
+		if quant.Owner != nil {
+			return stockConsignmentLineCreate(o, move, quant, firstQty, secondQty, user)
+		}
+		movedCost += quant.Cost * firstQty
+		return nil
+	}
+	// 按目标库位的入库策略放到子库位，整包移动时包内的份保持在同一库位
+	if locationNeedQuants(dest) && move.ResultPackage == nil {
+		if dest, err = stockLocationPutaway(o, dest, move.Product); err != nil {
+			return 0, err
+		}
+	}
+	firstQty := move.FirstUomQty
+	secondQty := move.SecondUomQty
+	quants, err := quantsGetForMove(o, move)
+	if err != nil {
+		return 0, err
+	}
+	for _, quant := range quants {
+		if firstQty <= stockQtyEpsilon && secondQty <= stockQtyEpsilon {
+			break
+		}
+		takeFirstQty := math.Min(math.Max(quant.FirstUomQty, 0), math.Max(firstQty, 0))
+		takeSecondQty := math.Min(math.Max(quant.SecondUomQty, 0), math.Max(secondQty, 0))
+		if takeFirstQty <= stockQtyEpsilon && takeSecondQty <= stockQtyEpsilon {
+			continue
+		}
+		if _, err = quantSplit(o, quant, takeFirstQty, takeSecondQty); err != nil {
+			return 0, err
+		}
+		if err = setOwner(quant); err != nil {
+			return 0, err
+		}
+		if err = quantMove(o, quant, move, dest, user); err != nil {
+			return 0, err
+		}
+		if err = addMoved(quant, takeFirstQty, takeSecondQty); err != nil {
+			return 0, err
+		}
+		firstQty -= takeFirstQty
+		secondQty -= takeSecondQty
+	}
+	firstQty = math.Max(firstQty, 0)
+	secondQty = math.Max(secondQty, 0)
+	if firstQty <= stockQtyEpsilon && secondQty <= stockQtyEpsilon {
+		return movedCost, nil
+	}
+	if locationNeedQuants(src) {
+		if !src.AllowNegative {
+			return 0, fmt.Errorf("库位[%s]库存不足,缺少第一单位数量%v,第二单位数量%v", src.Name, firstQty, secondQty)
+		}
+		// 负数份记录产生负库存的移动和目标库位，到货时冲销
+		var negative *StockQuant
+		if negative, err = quantCreate(o, move, src, -firstQty, -secondQty, user); err != nil {
+			return 0, err
+		}
+		negative.NegativeMove = move
+		negative.NegativeDestLocation = dest
+		if _, err = o.Update(negative, "NegativeMove", "NegativeDestLocation"); err != nil {
+			return 0, err
+		}
+	}
+	var quant *StockQuant
+	if quant, err = quantCreate(o, move, dest, firstQty, secondQty, user); err != nil {
+		return 0, err
+	}
+	if err = setOwner(quant); err != nil {
+		return 0, err
+	}
+	if err = addMoved(quant, firstQty, secondQty); err != nil {
+		return 0, err
+	}
+	var remaining bool
+	if remaining, err = quantReconcileNegative(o, quant, user); err != nil || !remaining {
+		return movedCost, err
+	}
+	return movedCost, quantMerge(o, quant)
+}
+
+// quantsReserve 按先进先出为移动保留份，返回本次保留的两个单位数量
+func quantsReserve(o orm.Ormer, move *StockMove, firstQty, secondQty float64, user *User) (reservedFirstQty, reservedSecondQty float64, err error) {
+	quants, err := quantsGetAvailable(o, move)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, quant := range quants {
+		if firstQty-reservedFirstQty <= stockQtyEpsilon && secondQty-reservedSecondQty <= stockQtyEpsilon {
+			break
+		}
+		takeFirstQty := math.Min(quant.FirstUomQty, math.Max(firstQty-reservedFirstQty, 0))
+		takeSecondQty := math.Min(quant.SecondUomQty, math.Max(secondQty-reservedSecondQty, 0))
+		if takeFirstQty <= stockQtyEpsilon && takeSecondQty <= stockQtyEpsilon {
+			continue
+		}
+		if _, err = quantSplit(o, quant, takeFirstQty, takeSecondQty); err != nil {
+			return 0, 0, err
+		}
+		quant.Reservation = move
+		quant.UpdateUser = user
+		if _, err = o.Update(quant, "Reservation", "UpdateUser", "UpdateDate"); err != nil {
+			return 0, 0, err
+		}
+		reservedFirstQty += takeFirstQty
+		reservedSecondQty += takeSecondQty
+	}
+	return reservedFirstQty, reservedSecondQty, nil
+}
+
+// quantsUnreserveExcess 释放为移动保留的超出指定数量的份，保留的数量按份拆分，剩余部分释放
+func quantsUnreserveExcess(o orm.Ormer, move *StockMove, keepFirstQty, keepSecondQty float64) (err error) {
+	var quants []*StockQuant
+	if _, err = o.QueryTable(new(StockQuant)).Filter("Reservation__Id", move.ID).OrderBy("Id").All(&quants); err != nil {
+		return err
+	}
+	for _, quant := range quants {
+		takeFirstQty := math.Min(quant.FirstUomQty, math.Max(keepFirstQty, 0))
+		takeSecondQty := math.Min(quant.SecondUomQty, math.Max(keepSecondQty, 0))
+		if takeFirstQty > stockQtyEpsilon || takeSecondQty > stockQtyEpsilon {
+			// 拆分出的份同样保留给该移动，继续由后面的循环释放
+			var rest *StockQuant
+			if rest, err = quantSplit(o, quant, takeFirstQty, takeSecondQty); err != nil {
+				return err
+			}
+			keepFirstQty -= takeFirstQty
+			keepSecondQty -= takeSecondQty
+			if rest == nil {
+				continue
+			}
+			quant = rest
+		}
+		quant.Reservation = nil
+		if _, err = o.Update(quant, "Reservation", "UpdateDate"); err != nil {
+			return err
+		}
+		if err = quantMerge(o, quant); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quantsUnreserve 释放为移动保留的份
+func quantsUnreserve(o orm.Ormer, move *StockMove) (err error) {
+	var quants []*StockQuant
+	if _, err = o.QueryTable(new(StockQuant)).Filter("Reservation__Id", move.ID).All(&quants); err != nil {
+		return err
+	}
+	for _, quant := range quants {
+		quant.Reservation = nil
+		if _, err = o.Update(quant, "Reservation", "UpdateDate"); err != nil {
+			return err
+		}
+		if err = quantMerge(o, quant); err != nil {
+			return err
+		}
+	}
+	return nil
+}
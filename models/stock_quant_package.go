@@ -1,6 +1,10 @@
 package models
 
 import (
+	"errors"
+	"fmt"
+	"goERP/utils"
+	"strings"
 	"time"
 
 	"github.com/astaxie/beego/orm"
@@ -8,16 +12,338 @@ import (
 
 // StockQuantPackage 物理包装
 type StockQuantPackage struct {
-	ID         int64     `orm:"column(id);pk;auto" json:"id"`         //主键
-	CreateUser *User     `orm:"rel(fk);null" json:"-"`                //创建者
-	UpdateUser *User     `orm:"rel(fk);null" json:"-"`                //最后更新者
-	CreateDate time.Time `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
-	UpdateDate time.Time `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	ID         int64                `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser *User                `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser *User                `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate time.Time            `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate time.Time            `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	Name       string               `orm:"unique" json:"Name"`                   //包名称
+	Barcode    string               `orm:"default()" json:"Barcode"`             //条码
+	Parent     *StockQuantPackage   `orm:"rel(fk);null"`                         //上级包
+	Childs     []*StockQuantPackage `orm:"reverse(many)"`                        //下级包
+	Location   *StockLocation       `orm:"rel(fk);null"`                         //所在库位
+	Company    *Company             `orm:"rel(fk);null"`                         //公司
+	Quants     []*StockQuant        `orm:"reverse(many)"`                        //包内的份
 
 	FormAction   string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
 	ActionFields []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
+	ParentID     int64    `orm:"-" json:"Parent"`
+	LocationID   int64    `orm:"-" json:"Location"`
+	CompanyID    int64    `orm:"-" json:"Company"`
 }
 
 func init() {
 	orm.RegisterModel(new(StockQuantPackage))
 }
+
+// AddStockQuantPackage insert a new StockQuantPackage into database and returns
+// last inserted ID on success.
+func AddStockQuantPackage(obj *StockQuantPackage, addUser *User) (id int64, err error) {
+	o := orm.NewOrm()
+	obj.CreateUser = addUser
+	obj.UpdateUser = addUser
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return 0, errBegin
+	}
+	if obj.ParentID > 0 {
+		obj.Parent, _ = GetStockQuantPackageByID(obj.ParentID)
+	}
+	if obj.LocationID > 0 {
+		obj.Location, _ = GetStockLocationByID(obj.LocationID)
+	}
+	if obj.CompanyID > 0 {
+		obj.Company, _ = GetCompanyByID(obj.CompanyID)
+	}
+	if err = stockQuantPackageSetName(obj); err != nil {
+		return 0, err
+	}
+	id, err = o.Insert(obj)
+	if err == nil {
+		errCommit := o.Commit()
+		if errCommit != nil {
+			return 0, errCommit
+		}
+	}
+	return id, err
+}
+
+// stockQuantPackageSetName 包没有名称时使用序号作为名称
+func stockQuantPackageSetName(obj *StockQuantPackage) (err error) {
+	obj.Name = strings.TrimSpace(obj.Name)
+	if obj.Name != "" {
+		return nil
+	}
+	var companyID int64
+	if obj.Company != nil {
+		companyID = obj.Company.ID
+	}
+	if obj.Name, err = GetNextSequece("StockQuantPackage", companyID); err != nil {
+		return fmt.Errorf("包序号获取失败:%s", err.Error())
+	}
+	return nil
+}
+
+// GetStockQuantPackageByID retrieves StockQuantPackage by ID. Returns error if
+// ID doesn't exist
+func GetStockQuantPackageByID(id int64) (obj *StockQuantPackage, err error) {
+	o := orm.NewOrm()
+	obj = &StockQuantPackage{ID: id}
+	if err = o.Read(obj); err == nil {
+		if obj.Parent != nil {
+			o.Read(obj.Parent)
+		}
+		if obj.Location != nil {
+			o.Read(obj.Location)
+		}
+		if obj.Company != nil {
+			o.Read(obj.Company)
+		}
+		return obj, nil
+	}
+	return nil, err
+}
+
+// GetStockQuantPackageByName retrieves StockQuantPackage by Name or Barcode. Returns error if
+// Name doesn't exist
+func GetStockQuantPackageByName(name string) (obj *StockQuantPackage, err error) {
+	o := orm.NewOrm()
+	obj = new(StockQuantPackage)
+	cond := orm.NewCondition().Or("Name", name).Or("Barcode", name)
+	if err = o.QueryTable(obj).SetCond(cond).One(obj); err == nil {
+		return obj, nil
+	}
+	return nil, err
+}
+
+// GetAllStockQuantPackage retrieves all StockQuantPackage matches certain condition. Returns empty list if
+// no records exist
+func GetAllStockQuantPackage(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (utils.Paginator, []StockQuantPackage, error) {
+	var (
+		objArrs   []StockQuantPackage
+		paginator utils.Paginator
+		num       int64
+		err       error
+	)
+	if limit == 0 {
+		limit = 20
+	}
+	o := orm.NewOrm()
+	qs := o.QueryTable(new(StockQuantPackage))
+	qs = qs.RelatedSel()
+
+	//cond k=v cond必须放到Filter和Exclude前面
+	cond := orm.NewCondition()
+	if _, ok := condMap["and"]; ok {
+		andMap := condMap["and"]
+		for k, v := range andMap {
+			k = strings.Replace(k, ".", "__", -1)
+			cond = cond.And(k, v)
+		}
+	}
+	if _, ok := condMap["or"]; ok {
+		orMap := condMap["or"]
+		for k, v := range orMap {
+			k = strings.Replace(k, ".", "__", -1)
+			cond = cond.Or(k, v)
+		}
+	}
+	qs = qs.SetCond(cond)
+	// query k=v
+	for k, v := range query {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		qs = qs.Filter(k, v)
+	}
+	//exclude k=v
+	for k, v := range exclude {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		qs = qs.Exclude(k, v)
+	}
+
+	// order by:
+	var sortFields []string
+	if len(sortby) != 0 {
+		if len(sortby) == len(order) {
+			// 1) for each sort field, there is an associated order
+			for i, v := range sortby {
+				orderby := ""
+				if order[i] == "desc" {
+					orderby = "-" + strings.Replace(v, ".", "__", -1)
+				} else if order[i] == "asc" {
+					orderby = strings.Replace(v, ".", "__", -1)
+				} else {
+					return paginator, nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+			qs = qs.OrderBy(sortFields...)
+		} else if len(sortby) != len(order) && len(order) == 1 {
+			// 2) there is exactly one order, all the sorted fields will be sorted by this order
+			for _, v := range sortby {
+				orderby := ""
+				if order[0] == "desc" {
+					orderby = "-" + strings.Replace(v, ".", "__", -1)
+				} else if order[0] == "asc" {
+					orderby = strings.Replace(v, ".", "__", -1)
+				} else {
+					return paginator, nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+		} else if len(sortby) != len(order) && len(order) != 1 {
+			return paginator, nil, errors.New("Error: 'sortby', 'order' sizes mismatch or 'order' size is not 1")
+		}
+	} else {
+		if len(order) != 0 {
+			return paginator, nil, errors.New("Error: unused 'order' fields")
+		}
+	}
+
+	qs = qs.OrderBy(sortFields...)
+	if cnt, err := qs.Count(); err == nil {
+		if cnt > 0 {
+			paginator = utils.GenPaginator(limit, offset, cnt)
+			if num, err = qs.Limit(limit, offset).All(&objArrs, fields...); err == nil {
+				paginator.CurrentPageSize = num
+			}
+		}
+	}
+	return paginator, objArrs, err
+}
+
+// UpdateStockQuantPackageByID updates StockQuantPackage by ID and returns error if
+// the record to be updated doesn't exist
+func UpdateStockQuantPackageByID(m *StockQuantPackage) (err error) {
+	o := orm.NewOrm()
+	v := StockQuantPackage{ID: m.ID}
+	// ascertain id exists in the database
+	if err = o.Read(&v); err == nil {
+		var num int64
+		if num, err = o.Update(m); err == nil {
+			fmt.Println("Number of records updated in database:", num)
+		}
+	}
+	return
+}
+
+// DeleteStockQuantPackage deletes StockQuantPackage by ID and returns error if
+// the record to be deleted doesn't exist
+func DeleteStockQuantPackage(id int64) (err error) {
+	o := orm.NewOrm()
+	v := StockQuantPackage{ID: id}
+	// ascertain id exists in the database
+	if err = o.Read(&v); err == nil {
+		var num int64
+		if num, err = o.Delete(&StockQuantPackage{ID: id}); err == nil {
+			fmt.Println("Number of records deleted in database:", num)
+		}
+	}
+	return
+}
+
+// stockQuantPackageChildIDs 获得包及其所有下级包的ID
+func stockQuantPackageChildIDs(o orm.Ormer, pack *StockQuantPackage) ([]int64, error) {
+	ids := []int64{pack.ID}
+	parents := []int64{pack.ID}
+	for len(parents) > 0 {
+		var childs []*StockQuantPackage
+		if _, err := o.QueryTable(new(StockQuantPackage)).Filter("Parent__Id__in", parents).All(&childs, "Id"); err != nil {
+			return nil, err
+		}
+		parents = parents[:0]
+		for _, child := range childs {
+			ids = append(ids, child.ID)
+			parents = append(parents, child.ID)
+		}
+	}
+	return ids, nil
+}
+
+// GetStockQuantPackageContent 获得包及下级包中的所有份
+func GetStockQuantPackageContent(id int64) (quants []*StockQuant, err error) {
+	o := orm.NewOrm()
+	var packageIDs []int64
+	if packageIDs, err = stockQuantPackageChildIDs(o, &StockQuantPackage{ID: id}); err != nil {
+		return nil, err
+	}
+	qs := o.QueryTable(new(StockQuant)).Filter("Package__Id__in", packageIDs)
+	_, err = qs.RelatedSel("Product", "Location", "Package", "FirstUom").OrderBy("Package__Id", "Product__Id", "Id").All(&quants)
+	return quants, err
+}
+
+// stockQuantPackageSetLocation 更新包及下级包的所在库位
+func stockQuantPackageSetLocation(o orm.Ormer, pack *StockQuantPackage, location *StockLocation, user *User) error {
+	packageIDs, err := stockQuantPackageChildIDs(o, pack)
+	if err != nil {
+		return err
+	}
+	_, err = o.QueryTable(new(StockQuantPackage)).Filter("Id__in", packageIDs).Update(orm.Params{
+		"Location":   location.ID,
+		"UpdateUser": user.ID,
+		"UpdateDate": time.Now(),
+	})
+	return err
+}
+
+// UnpackStockQuantPackage 拆包，包内的份和下级包转到上级包中，没有上级包时不再属于任何包
+func UnpackStockQuantPackage(id int64, user *User) (err error) {
+	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return errBegin
+	}
+	pack := &StockQuantPackage{ID: id}
+	if err = o.Read(pack); err != nil {
+		return err
+	}
+	if o.QueryTable(new(StockQuant)).Filter("Package__Id", pack.ID).Filter("Reservation__isnull", false).Exist() {
+		return fmt.Errorf("包[%s]中有已保留的份,不能拆包", pack.Name)
+	}
+	var quants []*StockQuant
+	if _, err = o.QueryTable(new(StockQuant)).Filter("Package__Id", pack.ID).All(&quants); err != nil {
+		return err
+	}
+	for _, quant := range quants {
+		quant.Package = pack.Parent
+		quant.UpdateUser = user
+		if _, err = o.Update(quant, "Package", "UpdateUser", "UpdateDate"); err != nil {
+			return err
+		}
+		if err = quantMerge(o, quant); err != nil {
+			return err
+		}
+	}
+	var childs []*StockQuantPackage
+	if _, err = o.QueryTable(new(StockQuantPackage)).Filter("Parent__Id", pack.ID).All(&childs); err != nil {
+		return err
+	}
+	for _, child := range childs {
+		child.Parent = pack.Parent
+		child.UpdateUser = user
+		if _, err = o.Update(child, "Parent", "UpdateUser", "UpdateDate"); err != nil {
+			return err
+		}
+	}
+	pack.Location = nil
+	pack.UpdateUser = user
+	if _, err = o.Update(pack, "Location", "UpdateUser", "UpdateDate"); err != nil {
+		return err
+	}
+	return o.Commit()
+}
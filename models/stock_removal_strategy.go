@@ -0,0 +1,332 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"goERP/utils"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// StockRemovalStrategy 出库策略，决定保留份时优先使用哪些份
+type StockRemovalStrategy struct {
+	ID         int64     `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser *User     `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser *User     `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate time.Time `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate time.Time `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	Name       string    `orm:"unique" json:"Name"`                   //策略名称
+	Method     string    `orm:"default(fifo)" json:"Method"`          //出库方法 fifo先进先出/lifo后进先出/fefo先到期先出/closest最近库位
+	Active     bool      `orm:"default(true)" json:"Active"`          //有效
+
+	FormAction   string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
+	ActionFields []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
+}
+
+func init() {
+	orm.RegisterModel(new(StockRemovalStrategy))
+}
+
+// AddStockRemovalStrategy insert a new StockRemovalStrategy into database and returns
+// last inserted ID on success.
+func AddStockRemovalStrategy(obj *StockRemovalStrategy, addUser *User) (id int64, err error) {
+	o := orm.NewOrm()
+	obj.CreateUser = addUser
+	obj.UpdateUser = addUser
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return 0, errBegin
+	}
+	if err = stockRemovalCheckMethod(obj.Method); err != nil {
+		return 0, err
+	}
+	id, err = o.Insert(obj)
+	if err == nil {
+		errCommit := o.Commit()
+		if errCommit != nil {
+			return 0, errCommit
+		}
+	}
+	return id, err
+}
+
+// GetStockRemovalStrategyByID retrieves StockRemovalStrategy by ID. Returns error if
+// ID doesn't exist
+func GetStockRemovalStrategyByID(id int64) (obj *StockRemovalStrategy, err error) {
+	o := orm.NewOrm()
+	obj = &StockRemovalStrategy{ID: id}
+	if err = o.Read(obj); err == nil {
+		return obj, nil
+	}
+	return nil, err
+}
+
+// GetStockRemovalStrategyByName retrieves StockRemovalStrategy by Name. Returns error if
+// Name doesn't exist
+func GetStockRemovalStrategyByName(name string) (obj *StockRemovalStrategy, err error) {
+	o := orm.NewOrm()
+	obj = &StockRemovalStrategy{Name: name}
+	if err = o.Read(obj, "Name"); err == nil {
+		return obj, nil
+	}
+	return nil, err
+}
+
+// GetAllStockRemovalStrategy retrieves all StockRemovalStrategy matches certain condition. Returns empty list if
+// no records exist
+func GetAllStockRemovalStrategy(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (utils.Paginator, []StockRemovalStrategy, error) {
+	var (
+		objArrs   []StockRemovalStrategy
+		paginator utils.Paginator
+		num       int64
+		err       error
+	)
+	if limit == 0 {
+		limit = 20
+	}
+	o := orm.NewOrm()
+	qs := o.QueryTable(new(StockRemovalStrategy))
+	qs = qs.RelatedSel()
+
+	//cond k=v cond必须放到Filter和Exclude前面
+	cond := orm.NewCondition()
+	if _, ok := condMap["and"]; ok {
+		andMap := condMap["and"]
+		for k, v := range andMap {
+			k = strings.Replace(k, ".", "__", -1)
+			cond = cond.And(k, v)
+		}
+	}
+	if _, ok := condMap["or"]; ok {
+		orMap := condMap["or"]
+		for k, v := range orMap {
+			k = strings.Replace(k, ".", "__", -1)
+			cond = cond.Or(k, v)
+		}
+	}
+	qs = qs.SetCond(cond)
+	// query k=v
+	for k, v := range query {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		qs = qs.Filter(k, v)
+	}
+	//exclude k=v
+	for k, v := range exclude {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		qs = qs.Exclude(k, v)
+	}
+
+	// order by:
+	var sortFields []string
+	if len(sortby) != 0 {
+		if len(sortby) == len(order) {
+			// 1) for each sort field, there is an associated order
+			for i, v := range sortby {
+				orderby := ""
+				if order[i] == "desc" {
+					orderby = "-" + strings.Replace(v, ".", "__", -1)
+				} else if order[i] == "asc" {
+					orderby = strings.Replace(v, ".", "__", -1)
+				} else {
+					return paginator, nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+			qs = qs.OrderBy(sortFields...)
+		} else if len(sortby) != len(order) && len(order) == 1 {
+			// 2) there is exactly one order, all the sorted fields will be sorted by this order
+			for _, v := range sortby {
+				orderby := ""
+				if order[0] == "desc" {
+					orderby = "-" + strings.Replace(v, ".", "__", -1)
+				} else if order[0] == "asc" {
+					orderby = strings.Replace(v, ".", "__", -1)
+				} else {
+					return paginator, nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+		} else if len(sortby) != len(order) && len(order) != 1 {
+			return paginator, nil, errors.New("Error: 'sortby', 'order' sizes mismatch or 'order' size is not 1")
+		}
+	} else {
+		if len(order) != 0 {
+			return paginator, nil, errors.New("Error: unused 'order' fields")
+		}
+	}
+
+	qs = qs.OrderBy(sortFields...)
+	if cnt, err := qs.Count(); err == nil {
+		if cnt > 0 {
+			paginator = utils.GenPaginator(limit, offset, cnt)
+			if num, err = qs.Limit(limit, offset).All(&objArrs, fields...); err == nil {
+				paginator.CurrentPageSize = num
+			}
+		}
+	}
+	return paginator, objArrs, err
+}
+
+// UpdateStockRemovalStrategyByID updates StockRemovalStrategy by ID and returns error if
+// the record to be updated doesn't exist
+func UpdateStockRemovalStrategyByID(m *StockRemovalStrategy) (err error) {
+	o := orm.NewOrm()
+	v := StockRemovalStrategy{ID: m.ID}
+	if err = stockRemovalCheckMethod(m.Method); err != nil {
+		return err
+	}
+	// ascertain id exists in the database
+	if err = o.Read(&v); err == nil {
+		var num int64
+		if num, err = o.Update(m); err == nil {
+			fmt.Println("Number of records updated in database:", num)
+		}
+	}
+	return
+}
+
+// DeleteStockRemovalStrategy deletes StockRemovalStrategy by ID and returns error if
+// the record to be deleted doesn't exist
+func DeleteStockRemovalStrategy(id int64) (err error) {
+	o := orm.NewOrm()
+	v := StockRemovalStrategy{ID: id}
+	// ascertain id exists in the database
+	if err = o.Read(&v); err == nil {
+		var num int64
+		if num, err = o.Delete(&StockRemovalStrategy{ID: id}); err == nil {
+			fmt.Println("Number of records deleted in database:", num)
+		}
+	}
+	return
+}
+
+// stockRemovalCheckMethod 检查出库方法是否有效
+func stockRemovalCheckMethod(method string) error {
+	switch method {
+	case "fifo", "lifo", "fefo", "closest":
+		return nil
+	}
+	return fmt.Errorf("无效的出库方法:%s", method)
+}
+
+// stockLocationRemovalMethod 获得库位的出库方法，库位未设置时使用上级库位的策略，都没有时先进先出
+func stockLocationRemovalMethod(o orm.Ormer, location *StockLocation) string {
+	visited := make(map[int64]bool)
+	current := location
+	for current != nil && !visited[current.ID] {
+		visited[current.ID] = true
+		loc := &StockLocation{ID: current.ID}
+		if err := o.Read(loc); err != nil {
+			break
+		}
+		if loc.RemovalStrategy != nil {
+			strategy := &StockRemovalStrategy{ID: loc.RemovalStrategy.ID}
+			if err := o.Read(strategy); err == nil && strategy.Active {
+				return strategy.Method
+			}
+		}
+		current = loc.Parent
+	}
+	return "fifo"
+}
+
+// quantsSortByRemoval 按出库方法对份排序，最近库位按份所在库位到出库库位的距离计算
+func quantsSortByRemoval(o orm.Ormer, quants []*StockQuant, method string, origin *StockLocation) {
+	switch method {
+	case "lifo":
+		sort.SliceStable(quants, func(i, j int) bool {
+			return quants[i].InDate.After(quants[j].InDate)
+		})
+	case "fefo":
+		// 没有批次或批次没有过期时间的份排在最后
+		expiry := make(map[int64]time.Time)
+		for _, quant := range quants {
+			if quant.Lot == nil {
+				continue
+			}
+			if _, ok := expiry[quant.Lot.ID]; !ok {
+				lot := &StockProductionLot{ID: quant.Lot.ID}
+				if err := o.Read(lot); err == nil {
+					expiry[lot.ID] = lot.ExpiryDate
+				}
+			}
+		}
+		expiryOf := func(quant *StockQuant) time.Time {
+			if quant.Lot != nil {
+				return expiry[quant.Lot.ID]
+			}
+			return time.Time{}
+		}
+		sort.SliceStable(quants, func(i, j int) bool {
+			ei, ej := expiryOf(quants[i]), expiryOf(quants[j])
+			if ei.IsZero() != ej.IsZero() {
+				return !ei.IsZero()
+			}
+			if !ei.Equal(ej) {
+				return ei.Before(ej)
+			}
+			return quants[i].InDate.Before(quants[j].InDate)
+		})
+	case "closest":
+		// 按通道、货架、层坐标计算到出库库位的直角距离，距离相同时先进先出
+		var ox, oy, oz int64
+		if origin != nil {
+			loc := &StockLocation{ID: origin.ID}
+			if err := o.Read(loc); err == nil {
+				ox, oy, oz = loc.Posx, loc.Posy, loc.Posz
+			}
+		}
+		distances := make(map[int64]int64)
+		for _, quant := range quants {
+			if quant.Location == nil {
+				continue
+			}
+			if _, ok := distances[quant.Location.ID]; !ok {
+				loc := &StockLocation{ID: quant.Location.ID}
+				if err := o.Read(loc); err == nil {
+					distances[loc.ID] = stockLocationAbs(loc.Posx-ox) + stockLocationAbs(loc.Posy-oy) + stockLocationAbs(loc.Posz-oz)
+				}
+			}
+		}
+		distanceOf := func(quant *StockQuant) int64 {
+			if quant.Location != nil {
+				if distance, ok := distances[quant.Location.ID]; ok {
+					return distance
+				}
+			}
+			return math.MaxInt64
+		}
+		sort.SliceStable(quants, func(i, j int) bool {
+			di, dj := distanceOf(quants[i]), distanceOf(quants[j])
+			if di != dj {
+				return di < dj
+			}
+			return quants[i].InDate.Before(quants[j].InDate)
+		})
+	default:
+		sort.SliceStable(quants, func(i, j int) bool {
+			return quants[i].InDate.Before(quants[j].InDate)
+		})
+	}
+}
+
+// stockLocationAbs 坐标差的绝对值
+func stockLocationAbs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
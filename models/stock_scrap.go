@@ -0,0 +1,437 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"goERP/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// StockScrap 报废单，将产品从库存库位移动到公司的废料库位
+type StockScrap struct {
+	ID            int64               `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser    *User               `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser    *User               `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate    time.Time           `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate    time.Time           `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	Name          string              `orm:"unique" json:"Name"`                   //报废单号
+	Origin        string              `orm:"default()" json:"Origin"`              //源单据
+	Product       *ProductProduct     `orm:"rel(fk)"`                              //产品规格
+	FirstUomQty   float64             `orm:"default(0)" json:"FirstUomQty"`        //第一单位数量
+	SecondUomQty  float64             `orm:"default(0)" json:"SecondUomQty"`       //第二单位数量
+	FirstUom      *ProductUom         `orm:"rel(fk)"`                              //第一单位
+	SecondUom     *ProductUom         `orm:"rel(fk);null"`                         //第二单位
+	Lot           *StockProductionLot `orm:"rel(fk);null"`                         //批次、序列号
+	Package       *StockQuantPackage  `orm:"rel(fk);null"`                         //包
+	LocationSrc   *StockLocation      `orm:"rel(fk)"`                              //源库位
+	ScrapLocation *StockLocation      `orm:"rel(fk);null"`                         //废料库位
+	Move          *StockMove          `orm:"rel(fk);null"`                         //报废生成的移动
+	Reason        string              `orm:"default()" json:"Reason"`              //报废原因
+	Note          string              `orm:"type(text);null" json:"Note"`          //备注
+	State         string              `orm:"default(draft)" json:"State"`          //状态:draft/done
+	DoneUser      *User               `orm:"rel(fk);null" json:"-"`                //报废人
+	DateDone      time.Time           `orm:"type(datetime);null" json:"-"`         //报废时间
+	Company       *Company            `orm:"rel(fk);null"`                         //公司
+
+	FormAction      string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
+	ActionFields    []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
+	ProductID       int64    `orm:"-" json:"Product"`
+	FirstUomID      int64    `orm:"-" json:"FirstUom"`
+	SecondUomID     int64    `orm:"-" json:"SecondUom"`
+	LotID           int64    `orm:"-" json:"Lot"`
+	PackageID       int64    `orm:"-" json:"Package"`
+	LocationSrcID   int64    `orm:"-" json:"LocationSrc"`
+	ScrapLocationID int64    `orm:"-" json:"ScrapLocation"`
+	CompanyID       int64    `orm:"-" json:"Company"`
+}
+
+func init() {
+	orm.RegisterModel(new(StockScrap))
+}
+
+// stockScrapLocation 获得公司的废料库位，公司没有时使用公共的废料库位
+func stockScrapLocation(o orm.Ormer, company *Company) (*StockLocation, error) {
+	var location StockLocation
+	qs := o.QueryTable(new(StockLocation)).Filter("ScrapLocation", true).Filter("Active", true)
+	if company != nil {
+		if err := qs.Filter("Company__Id", company.ID).OrderBy("Id").One(&location); err == nil {
+			return &location, nil
+		}
+	}
+	if err := qs.Filter("Company__isnull", true).OrderBy("Id").One(&location); err != nil {
+		return nil, errors.New("没有找到废料库位")
+	}
+	return &location, nil
+}
+
+// AddStockScrap insert a new StockScrap into database and returns
+// last inserted ID on success.
+func AddStockScrap(obj *StockScrap, addUser *User) (id int64, err error) {
+	if obj.CompanyID > 0 {
+		obj.Company, _ = GetCompanyByID(obj.CompanyID)
+	}
+	if obj.Company == nil && addUser != nil && addUser.Company != nil {
+		obj.Company = addUser.Company
+	}
+	if obj.Company != nil {
+		if obj.Name, err = GetNextSequece("StockScrap", obj.Company.ID); err != nil {
+			obj.Name = ""
+		}
+	}
+	// 没有单据序号时按记录ID编号，先用纳秒时间占位避免重名
+	autoName := obj.Name == ""
+	if autoName {
+		obj.Name = "SP" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	o := orm.NewOrm()
+	obj.CreateUser = addUser
+	obj.UpdateUser = addUser
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return 0, errBegin
+	}
+	if obj.ProductID > 0 {
+		obj.Product, _ = GetProductProductByID(obj.ProductID)
+	}
+	if obj.FirstUomID > 0 {
+		obj.FirstUom, _ = GetProductUomByID(obj.FirstUomID)
+	}
+	if obj.SecondUomID > 0 {
+		obj.SecondUom, _ = GetProductUomByID(obj.SecondUomID)
+	}
+	if obj.LotID > 0 {
+		obj.Lot, _ = GetStockProductionLotByID(obj.LotID)
+	}
+	if obj.PackageID > 0 {
+		obj.Package, _ = GetStockQuantPackageByID(obj.PackageID)
+	}
+	if obj.LocationSrcID > 0 {
+		obj.LocationSrc, _ = GetStockLocationByID(obj.LocationSrcID)
+	}
+	if obj.ScrapLocationID > 0 {
+		obj.ScrapLocation, _ = GetStockLocationByID(obj.ScrapLocationID)
+	}
+	if obj.Product == nil || obj.LocationSrc == nil {
+		return 0, errors.New("报废单必须指定产品规格和源库位")
+	}
+	if !locationNeedQuants(obj.LocationSrc) {
+		return 0, fmt.Errorf("库位[%s]不是内部库位,不能报废", obj.LocationSrc.Name)
+	}
+	if obj.FirstUomQty <= stockQtyEpsilon && obj.SecondUomQty <= stockQtyEpsilon {
+		return 0, errors.New("报废数量必须大于0")
+	}
+	var tracking string
+	if tracking, err = productTracking(o, obj.Product); err != nil {
+		return 0, err
+	}
+	if tracking != "none" && obj.Lot == nil {
+		return 0, fmt.Errorf("产品[%s]需要追踪批次或序列号,请指定报废的批次", obj.Product.Name)
+	}
+	if obj.FirstUom == nil {
+		obj.FirstUom = obj.Product.FirstSaleUom
+	}
+	if obj.SecondUom == nil {
+		obj.SecondUom = obj.Product.SecondSaleUom
+	}
+	if obj.FirstUom == nil {
+		return 0, errors.New("报废单必须指定第一单位")
+	}
+	if obj.ScrapLocation == nil {
+		if obj.ScrapLocation, err = stockScrapLocation(o, obj.Company); err != nil {
+			return 0, err
+		}
+	} else if !obj.ScrapLocation.ScrapLocation {
+		return 0, fmt.Errorf("库位[%s]不是废料库位", obj.ScrapLocation.Name)
+	}
+	obj.Reason = strings.TrimSpace(obj.Reason)
+	if obj.Reason == "" {
+		return 0, errors.New("报废原因不能为空")
+	}
+	obj.State = "draft"
+	id, err = o.Insert(obj)
+	if err == nil && autoName {
+		obj.Name = fmt.Sprintf("SP%06d", id)
+		_, err = o.Update(obj, "Name")
+	}
+	if err == nil {
+		errCommit := o.Commit()
+		if errCommit != nil {
+			return 0, errCommit
+		}
+	}
+	return id, err
+}
+
+// DoneStockScrap 确认报废，生成报废移动并将份转移到废料库位
+func DoneStockScrap(id int64, doneUser *User) (err error) {
+	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return errBegin
+	}
+	scrap := &StockScrap{ID: id}
+	if err = o.Read(scrap); err != nil {
+		return err
+	}
+	if scrap.State != "draft" {
+		return fmt.Errorf("报废单[%s]已完成", scrap.Name)
+	}
+	product := &ProductProduct{ID: scrap.Product.ID}
+	if err = o.Read(product); err != nil {
+		return err
+	}
+	company := scrap.Company
+	if company == nil && doneUser != nil {
+		company = doneUser.Company
+	}
+	if company == nil {
+		return fmt.Errorf("报废单[%s]没有所属公司", scrap.Name)
+	}
+	move := &StockMove{
+		Name:            "报废:" + product.Name,
+		Origin:          scrap.Name,
+		Date:            time.Now(),
+		DateExpected:    time.Now(),
+		Product:         product,
+		ProductTemplate: product.ProductTemplate,
+		FirstUomQty:     scrap.FirstUomQty,
+		SecondUomQty:    scrap.SecondUomQty,
+		FirstUom:        scrap.FirstUom,
+		SecondUom:       scrap.SecondUom,
+		LocationSrc:     scrap.LocationSrc,
+		LocationDest:    scrap.ScrapLocation,
+		Lot:             scrap.Lot,
+		Package:         scrap.Package,
+		Company:         company,
+		Scrapped:        true,
+		State:           "confirm",
+		Note:            scrap.Reason,
+		CreateUser:      doneUser,
+		UpdateUser:      doneUser,
+	}
+	if move.ID, err = o.Insert(move); err != nil {
+		return err
+	}
+	// 追踪批次或序列号的产品必须指定报废的批次，不能按出库策略任选
+	if err = stockMoveCheckLot(o, move); err != nil {
+		return err
+	}
+	if err = stockMoveDone(o, move, doneUser); err != nil {
+		return err
+	}
+	scrap.Move = move
+	scrap.State = "done"
+	scrap.DoneUser = doneUser
+	scrap.DateDone = time.Now()
+	scrap.UpdateUser = doneUser
+	if _, err = o.Update(scrap, "Move", "State", "DoneUser", "DateDone", "UpdateUser", "UpdateDate"); err != nil {
+		return err
+	}
+	return o.Commit()
+}
+
+// GetStockScrapByID retrieves StockScrap by ID. Returns error if
+// ID doesn't exist
+func GetStockScrapByID(id int64) (obj *StockScrap, err error) {
+	o := orm.NewOrm()
+	obj = &StockScrap{ID: id}
+	if err = o.Read(obj); err == nil {
+		if obj.Product != nil {
+			o.Read(obj.Product)
+		}
+		if obj.FirstUom != nil {
+			o.Read(obj.FirstUom)
+		}
+		if obj.SecondUom != nil {
+			o.Read(obj.SecondUom)
+		}
+		if obj.Lot != nil {
+			o.Read(obj.Lot)
+		}
+		if obj.Package != nil {
+			o.Read(obj.Package)
+		}
+		if obj.LocationSrc != nil {
+			o.Read(obj.LocationSrc)
+		}
+		if obj.ScrapLocation != nil {
+			o.Read(obj.ScrapLocation)
+		}
+		if obj.Move != nil {
+			o.Read(obj.Move)
+		}
+		if obj.DoneUser != nil {
+			o.Read(obj.DoneUser)
+		}
+		if obj.CreateUser != nil {
+			o.Read(obj.CreateUser)
+		}
+		if obj.Company != nil {
+			o.Read(obj.Company)
+		}
+		return obj, nil
+	}
+	return nil, err
+}
+
+// GetStockScrapByName retrieves StockScrap by Name. Returns error if
+// Name doesn't exist
+func GetStockScrapByName(name string) (obj *StockScrap, err error) {
+	o := orm.NewOrm()
+	obj = &StockScrap{Name: name}
+	if err = o.Read(obj, "Name"); err == nil {
+		return obj, nil
+	}
+	return nil, err
+}
+
+// GetAllStockScrap retrieves all StockScrap matches certain condition. Returns empty list if
+// no records exist
+func GetAllStockScrap(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (utils.Paginator, []StockScrap, error) {
+	var (
+		objArrs   []StockScrap
+		paginator utils.Paginator
+		num       int64
+		err       error
+	)
+	if limit == 0 {
+		limit = 20
+	}
+	o := orm.NewOrm()
+	qs := o.QueryTable(new(StockScrap))
+	qs = qs.RelatedSel()
+
+	//cond k=v cond必须放到Filter和Exclude前面
+	cond := orm.NewCondition()
+	if _, ok := condMap["and"]; ok {
+		andMap := condMap["and"]
+		for k, v := range andMap {
+			k = strings.Replace(k, ".", "__", -1)
+			cond = cond.And(k, v)
+		}
+	}
+	if _, ok := condMap["or"]; ok {
+		orMap := condMap["or"]
+		for k, v := range orMap {
+			k = strings.Replace(k, ".", "__", -1)
+			cond = cond.Or(k, v)
+		}
+	}
+	qs = qs.SetCond(cond)
+	// query k=v
+	for k, v := range query {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		qs = qs.Filter(k, v)
+	}
+	//exclude k=v
+	for k, v := range exclude {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		qs = qs.Exclude(k, v)
+	}
+
+	// order by:
+	var sortFields []string
+	if len(sortby) != 0 {
+		if len(sortby) == len(order) {
+			// 1) for each sort field, there is an associated order
+			for i, v := range sortby {
+				orderby := ""
+				if order[i] == "desc" {
+					orderby = "-" + strings.Replace(v, ".", "__", -1)
+				} else if order[i] == "asc" {
+					orderby = strings.Replace(v, ".", "__", -1)
+				} else {
+					return paginator, nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+			qs = qs.OrderBy(sortFields...)
+		} else if len(sortby) != len(order) && len(order) == 1 {
+			// 2) there is exactly one order, all the sorted fields will be sorted by this order
+			for _, v := range sortby {
+				orderby := ""
+				if order[0] == "desc" {
+					orderby = "-" + strings.Replace(v, ".", "__", -1)
+				} else if order[0] == "asc" {
+					orderby = strings.Replace(v, ".", "__", -1)
+				} else {
+					return paginator, nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+		} else if len(sortby) != len(order) && len(order) != 1 {
+			return paginator, nil, errors.New("Error: 'sortby', 'order' sizes mismatch or 'order' size is not 1")
+		}
+	} else {
+		if len(order) != 0 {
+			return paginator, nil, errors.New("Error: unused 'order' fields")
+		}
+	}
+
+	qs = qs.OrderBy(sortFields...)
+	if cnt, err := qs.Count(); err == nil {
+		if cnt > 0 {
+			paginator = utils.GenPaginator(limit, offset, cnt)
+			if num, err = qs.Limit(limit, offset).All(&objArrs, fields...); err == nil {
+				paginator.CurrentPageSize = num
+			}
+		}
+	}
+	return paginator, objArrs, err
+}
+
+// UpdateStockScrapByID updates StockScrap by ID and returns error if
+// the record to be updated doesn't exist
+func UpdateStockScrapByID(m *StockScrap) (err error) {
+	o := orm.NewOrm()
+	v := StockScrap{ID: m.ID}
+	// ascertain id exists in the database
+	if err = o.Read(&v); err == nil {
+		if v.State != "draft" {
+			return fmt.Errorf("报废单[%s]已完成,不能修改", v.Name)
+		}
+		var num int64
+		if num, err = o.Update(m); err == nil {
+			fmt.Println("Number of records updated in database:", num)
+		}
+	}
+	return
+}
+
+// DeleteStockScrap deletes StockScrap by ID and returns error if
+// the record to be deleted doesn't exist
+func DeleteStockScrap(id int64) (err error) {
+	o := orm.NewOrm()
+	v := StockScrap{ID: id}
+	// ascertain id exists in the database
+	if err = o.Read(&v); err == nil {
+		if v.State != "draft" {
+			return fmt.Errorf("报废单[%s]已完成,不能删除", v.Name)
+		}
+		var num int64
+		if num, err = o.Delete(&StockScrap{ID: id}); err == nil {
+			fmt.Println("Number of records deleted in database:", num)
+		}
+	}
+	return
+}
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// StockTraceLine 追溯明细，Direction为upstream上游来源、downstream下游去向或internal内部调拨
+type StockTraceLine struct {
+	Direction string      //方向
+	Move      *StockMove  //移动
+	Quant     *StockQuant //经过该移动的份
+}
+
+// stockTraceDirection 根据移动的源库位和目标库位判断追溯方向
+func stockTraceDirection(move *StockMove) string {
+	srcIn := move.LocationSrc != nil && locationNeedQuants(move.LocationSrc)
+	destIn := move.LocationDest != nil && locationNeedQuants(move.LocationDest)
+	switch {
+	case destIn && !srcIn:
+		return "upstream"
+	case srcIn && !destIn:
+		return "downstream"
+	}
+	return "internal"
+}
+
+// stockQuantHistorys 获得份的调拨历史
+func stockQuantHistorys(o orm.Ormer, quant *StockQuant) (moves []*StockMove, err error) {
+	qs := o.QueryTable(new(StockMove)).Filter("Quants__StockQuant__Id", quant.ID).Filter("State", "done")
+	_, err = qs.RelatedSel("LocationSrc", "LocationDest", "Picking", "Partner", "Lot").OrderBy("Date", "Id").Limit(-1).All(&moves)
+	return moves, err
+}
+
+// GetStockQuantTrace 追溯份:沿拆分来源向上获得份及其来源经过的移动，再逐层获得由该份拆分出的份经过的移动，
+// 与批次追溯相同，从外部进入内部库位为上游，从内部库位离开为下游
+func GetStockQuantTrace(quantID int64) (lines []*StockTraceLine, err error) {
+	o := orm.NewOrm()
+	quant := &StockQuant{ID: quantID}
+	if err = o.Read(quant); err != nil {
+		return nil, errors.New("份不存在")
+	}
+	seen := make(map[int64]bool)
+	// 上游:本份及拆分来源的调拨历史
+	for current, depth := quant, 0; current != nil && depth < 100; depth++ {
+		var moves []*StockMove
+		if moves, err = stockQuantHistorys(o, current); err != nil {
+			return nil, err
+		}
+		for _, move := range moves {
+			if !seen[move.ID] {
+				seen[move.ID] = true
+				lines = append(lines, &StockTraceLine{Direction: stockTraceDirection(move), Move: move, Quant: current})
+			}
+		}
+		if current.PropagatedFrom == nil {
+			break
+		}
+		parent := &StockQuant{ID: current.PropagatedFrom.ID}
+		if o.Read(parent) != nil {
+			break
+		}
+		current = parent
+	}
+	// 下游:逐层获得拆分出的份
+	parents := []int64{quant.ID}
+	for depth := 0; len(parents) > 0 && depth < 100; depth++ {
+		var childs []*StockQuant
+		if _, err = o.QueryTable(new(StockQuant)).Filter("PropagatedFrom__Id__in", parents).Limit(-1).All(&childs); err != nil {
+			return nil, err
+		}
+		parents = parents[:0]
+		for _, child := range childs {
+			parents = append(parents, child.ID)
+			var moves []*StockMove
+			if moves, err = stockQuantHistorys(o, child); err != nil {
+				return nil, err
+			}
+			for _, move := range moves {
+				if !seen[move.ID] {
+					seen[move.ID] = true
+					lines = append(lines, &StockTraceLine{Direction: stockTraceDirection(move), Move: move, Quant: child})
+				}
+			}
+		}
+	}
+	stockTraceLinesSort(lines)
+	return lines, nil
+}
+
+// GetStockLotTrace 追溯批次:批次的移动及批次的份经过的移动，
+// 从外部进入内部库位为上游，从内部库位离开为下游
+func GetStockLotTrace(lotID int64) (lines []*StockTraceLine, err error) {
+	o := orm.NewOrm()
+	lot := &StockProductionLot{ID: lotID}
+	if err = o.Read(lot); err != nil {
+		return nil, errors.New("批次不存在")
+	}
+	seen := make(map[int64]bool)
+	var moves []*StockMove
+	if moves, err = GetStockProductionLotTrace(lotID); err != nil {
+		return nil, err
+	}
+	for _, move := range moves {
+		seen[move.ID] = true
+		lines = append(lines, &StockTraceLine{Direction: stockTraceDirection(move), Move: move})
+	}
+	var quants []*StockQuant
+	if _, err = o.QueryTable(new(StockQuant)).Filter("Lot__Id", lotID).Limit(-1).All(&quants); err != nil {
+		return nil, err
+	}
+	for _, quant := range quants {
+		if moves, err = stockQuantHistorys(o, quant); err != nil {
+			return nil, err
+		}
+		for _, move := range moves {
+			if !seen[move.ID] {
+				seen[move.ID] = true
+				lines = append(lines, &StockTraceLine{Direction: stockTraceDirection(move), Move: move, Quant: quant})
+			}
+		}
+	}
+	stockTraceLinesSort(lines)
+	return lines, nil
+}
+
+// stockTraceLinesSort 追溯明细按移动日期排序
+func stockTraceLinesSort(lines []*StockTraceLine) {
+	sort.SliceStable(lines, func(i, j int) bool {
+		if lines[i].Move.Date.Equal(lines[j].Move.Date) {
+			return lines[i].Move.ID < lines[j].Move.ID
+		}
+		return lines[i].Move.Date.Before(lines[j].Move.Date)
+	})
+}
@@ -0,0 +1,655 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"goERP/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// StockTransfer 仓库间调拨单，经中转库位从调出仓库发货到调入仓库，
+// 两个仓库属于不同公司时同时生成公司间的销售订单和采购订单
+type StockTransfer struct {
+	ID              int64                `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser      *User                `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser      *User                `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate      time.Time            `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate      time.Time            `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	Name            string               `orm:"unique" json:"Name"`                   //调拨单号
+	Origin          string               `orm:"default()" json:"Origin"`              //源单据
+	WareHouseSrc    *StockWarehouse      `orm:"rel(fk)"`                              //调出仓库
+	WareHouseDest   *StockWarehouse      `orm:"rel(fk)"`                              //调入仓库
+	TransitLocation *StockLocation       `orm:"rel(fk);null"`                         //中转库位，为空时确认时自动选择
+	State           string               `orm:"default(draft)" json:"State"`          //状态:draft/confirm/cancel
+	PickingOut      *StockPicking        `orm:"rel(fk);null"`                         //调出仓库的出库单
+	PickingIn       *StockPicking        `orm:"rel(fk);null"`                         //调入仓库的入库单
+	SaleOrder       *SaleOrder           `orm:"rel(fk);null"`                         //公司间调拨的销售订单
+	PurchaseOrder   *PurchaseOrder       `orm:"rel(fk);null"`                         //公司间调拨的采购订单
+	Lines           []*StockTransferLine `orm:"reverse(many)"`                        //调拨明细
+	Note            string               `orm:"type(text);null" json:"Note"`          //备注
+	Company         *Company             `orm:"rel(fk);null"`                         //公司，与调出仓库相同
+
+	FormAction        string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
+	ActionFields      []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
+	WareHouseSrcID    int64    `orm:"-" json:"WareHouseSrc"`
+	WareHouseDestID   int64    `orm:"-" json:"WareHouseDest"`
+	TransitLocationID int64    `orm:"-" json:"TransitLocation"`
+}
+
+func init() {
+	orm.RegisterModel(new(StockTransfer))
+}
+
+// AddStockTransfer insert a new StockTransfer into database and returns
+// last inserted ID on success.
+func AddStockTransfer(obj *StockTransfer, addUser *User) (id int64, err error) {
+	if obj.WareHouseSrcID > 0 {
+		obj.WareHouseSrc, _ = GetStockWarehouseByID(obj.WareHouseSrcID)
+	}
+	if obj.WareHouseDestID > 0 {
+		obj.WareHouseDest, _ = GetStockWarehouseByID(obj.WareHouseDestID)
+	}
+	if obj.TransitLocationID > 0 {
+		obj.TransitLocation, _ = GetStockLocationByID(obj.TransitLocationID)
+	}
+	if obj.WareHouseSrc == nil || obj.WareHouseDest == nil {
+		return 0, errors.New("调拨单必须指定调出仓库和调入仓库")
+	}
+	if obj.WareHouseSrc.ID == obj.WareHouseDest.ID {
+		return 0, errors.New("调出仓库和调入仓库不能相同")
+	}
+	if obj.TransitLocation != nil && obj.TransitLocation.Usage != "transit" {
+		return 0, fmt.Errorf("库位[%s]不是中转库位", obj.TransitLocation.Name)
+	}
+	obj.Company = obj.WareHouseSrc.Company
+	if obj.Company != nil {
+		if obj.Name, err = GetNextSequece("StockTransfer", obj.Company.ID); err != nil {
+			obj.Name = ""
+		}
+	}
+	// 没有单据序号时按记录ID编号，先用纳秒时间占位避免重名
+	autoName := obj.Name == ""
+	if autoName {
+		obj.Name = "TR" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	o := orm.NewOrm()
+	obj.CreateUser = addUser
+	obj.UpdateUser = addUser
+	obj.State = "draft"
+	id, err = o.Insert(obj)
+	if err == nil && autoName {
+		obj.Name = fmt.Sprintf("TR%06d", id)
+		_, err = o.Update(obj, "Name")
+	}
+	return id, err
+}
+
+// GetStockTransferByID retrieves StockTransfer by ID. Returns error if
+// ID doesn't exist
+func GetStockTransferByID(id int64) (obj *StockTransfer, err error) {
+	o := orm.NewOrm()
+	obj = &StockTransfer{ID: id}
+	if err = o.Read(obj); err == nil {
+		if obj.WareHouseSrc != nil {
+			o.Read(obj.WareHouseSrc)
+		}
+		if obj.WareHouseDest != nil {
+			o.Read(obj.WareHouseDest)
+		}
+		if obj.TransitLocation != nil {
+			o.Read(obj.TransitLocation)
+		}
+		if obj.PickingOut != nil {
+			o.Read(obj.PickingOut)
+		}
+		if obj.PickingIn != nil {
+			o.Read(obj.PickingIn)
+		}
+		if obj.SaleOrder != nil {
+			o.Read(obj.SaleOrder)
+		}
+		if obj.PurchaseOrder != nil {
+			o.Read(obj.PurchaseOrder)
+		}
+		if obj.Company != nil {
+			o.Read(obj.Company)
+		}
+		if _, err = o.QueryTable(new(StockTransferLine)).Filter("Transfer__Id", obj.ID).RelatedSel("Product", "FirstUom").OrderBy("Id").All(&obj.Lines); err != nil {
+			return nil, err
+		}
+		for _, line := range obj.Lines {
+			if line.SecondUom != nil {
+				o.Read(line.SecondUom)
+			}
+			if line.Lot != nil {
+				o.Read(line.Lot)
+			}
+		}
+		return obj, nil
+	}
+	return nil, err
+}
+
+// GetStockTransferByName retrieves StockTransfer by Name. Returns error if
+// Name doesn't exist
+func GetStockTransferByName(name string) (obj *StockTransfer, err error) {
+	o := orm.NewOrm()
+	obj = &StockTransfer{Name: name}
+	if err = o.Read(obj, "Name"); err == nil {
+		return obj, nil
+	}
+	return nil, err
+}
+
+// GetAllStockTransfer retrieves all StockTransfer matches certain condition. Returns empty list if
+// no records exist
+func GetAllStockTransfer(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (utils.Paginator, []StockTransfer, error) {
+	var (
+		objArrs   []StockTransfer
+		paginator utils.Paginator
+		num       int64
+		err       error
+	)
+	if limit == 0 {
+		limit = 20
+	}
+	o := orm.NewOrm()
+	qs := o.QueryTable(new(StockTransfer))
+	qs = qs.RelatedSel()
+
+	//cond k=v cond必须放到Filter和Exclude前面
+	cond := orm.NewCondition()
+	if _, ok := condMap["and"]; ok {
+		andMap := condMap["and"]
+		for k, v := range andMap {
+			k = strings.Replace(k, ".", "__", -1)
+			cond = cond.And(k, v)
+		}
+	}
+	if _, ok := condMap["or"]; ok {
+		orMap := condMap["or"]
+		for k, v := range orMap {
+			k = strings.Replace(k, ".", "__", -1)
+			cond = cond.Or(k, v)
+		}
+	}
+	qs = qs.SetCond(cond)
+	// query k=v
+	for k, v := range query {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		qs = qs.Filter(k, v)
+	}
+	//exclude k=v
+	for k, v := range exclude {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		qs = qs.Exclude(k, v)
+	}
+
+	// order by:
+	var sortFields []string
+	if len(sortby) != 0 {
+		if len(sortby) == len(order) {
+			// 1) for each sort field, there is an associated order
+			for i, v := range sortby {
+				orderby := ""
+				if order[i] == "desc" {
+					orderby = "-" + strings.Replace(v, ".", "__", -1)
+				} else if order[i] == "asc" {
+					orderby = strings.Replace(v, ".", "__", -1)
+				} else {
+					return paginator, nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+			qs = qs.OrderBy(sortFields...)
+		} else if len(sortby) != len(order) && len(order) == 1 {
+			// 2) there is exactly one order, all the sorted fields will be sorted by this order
+			for _, v := range sortby {
+				orderby := ""
+				if order[0] == "desc" {
+					orderby = "-" + strings.Replace(v, ".", "__", -1)
+				} else if order[0] == "asc" {
+					orderby = strings.Replace(v, ".", "__", -1)
+				} else {
+					return paginator, nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+		} else if len(sortby) != len(order) && len(order) != 1 {
+			return paginator, nil, errors.New("Error: 'sortby', 'order' sizes mismatch or 'order' size is not 1")
+		}
+	} else {
+		if len(order) != 0 {
+			return paginator, nil, errors.New("Error: unused 'order' fields")
+		}
+	}
+
+	qs = qs.OrderBy(sortFields...)
+	if cnt, err := qs.Count(); err == nil {
+		if cnt > 0 {
+			paginator = utils.GenPaginator(limit, offset, cnt)
+			if num, err = qs.Limit(limit, offset).All(&objArrs, fields...); err == nil {
+				paginator.CurrentPageSize = num
+			}
+		}
+	}
+	return paginator, objArrs, err
+}
+
+// UpdateStockTransferByID updates StockTransfer by ID and returns error if
+// the record to be updated doesn't exist
+func UpdateStockTransferByID(m *StockTransfer) (err error) {
+	o := orm.NewOrm()
+	v := StockTransfer{ID: m.ID}
+	// ascertain id exists in the database
+	if err = o.Read(&v); err == nil {
+		if v.State != "draft" {
+			return fmt.Errorf("调拨单[%s]已确认,不能修改", v.Name)
+		}
+		if m.WareHouseSrc == nil || m.WareHouseDest == nil || m.WareHouseSrc.ID == m.WareHouseDest.ID {
+			return errors.New("调出仓库和调入仓库不能为空且不能相同")
+		}
+		var num int64
+		if num, err = o.Update(m); err == nil {
+			fmt.Println("Number of records updated in database:", num)
+		}
+	}
+	return
+}
+
+// DeleteStockTransfer deletes StockTransfer by ID and returns error if
+// the record to be deleted doesn't exist
+func DeleteStockTransfer(id int64) (err error) {
+	o := orm.NewOrm()
+	v := StockTransfer{ID: id}
+	// ascertain id exists in the database
+	if err = o.Read(&v); err == nil {
+		if v.State != "draft" {
+			return fmt.Errorf("调拨单[%s]已确认,不能删除", v.Name)
+		}
+		var num int64
+		if num, err = o.Delete(&StockTransfer{ID: id}); err == nil {
+			fmt.Println("Number of records deleted in database:", num)
+		}
+	}
+	return
+}
+
+// stockTransitLocation 获得公司的中转库位，company为空时为公司间共用的中转库位，没有时创建
+func stockTransitLocation(o orm.Ormer, company *Company, user *User) (*StockLocation, error) {
+	location := new(StockLocation)
+	qs := o.QueryTable(location).Filter("Usage", "transit").Filter("Active", true)
+	if company != nil {
+		qs = qs.Filter("Company__Id", company.ID)
+	} else {
+		qs = qs.Filter("Company__isnull", true)
+	}
+	err := qs.OrderBy("Id").One(location)
+	if err == nil {
+		return location, nil
+	}
+	if err != orm.ErrNoRows {
+		return nil, err
+	}
+	location = &StockLocation{
+		Name:       "公司间中转",
+		Company:    company,
+		Usage:      "transit",
+		Active:     true,
+		CreateUser: user,
+		UpdateUser: user,
+	}
+	if company != nil {
+		location.Name = company.Name + "/中转"
+	}
+	if location.ID, err = o.Insert(location); err != nil {
+		return nil, err
+	}
+	return location, nil
+}
+
+// stockTransferSaleOrder 为公司间调拨生成调出公司对调入公司的销售订单，订单处于仓库流程的初始状态
+func stockTransferSaleOrder(o orm.Ormer, transfer *StockTransfer, lines []*StockTransferLine, customer *Partner, user *User) (order *SaleOrder, orderLines []*SaleOrderLine, err error) {
+	warehouse := transfer.WareHouseSrc
+	var state *SaleOrderState
+	if state, err = GetSaleOrderStateByCompanyStock(warehouse.Company, warehouse, nil); err != nil || state == nil {
+		return nil, nil, fmt.Errorf("仓库[%s]没有唯一的销售订单初始状态", warehouse.Name)
+	}
+	var name string
+	if name, err = GetNextSequece("SaleOrder", warehouse.Company.ID); err != nil {
+		return nil, nil, fmt.Errorf("销售订单序号获取失败:%s", err.Error())
+	}
+	order = &SaleOrder{
+		Name:           name,
+		Partner:        customer,
+		SalesMan:       user,
+		Company:        warehouse.Company,
+		State:          state,
+		StockWarehouse: warehouse,
+		PickingPolicy:  "mult",
+		CreateUser:     user,
+		UpdateUser:     user,
+	}
+	if order.ID, err = o.Insert(order); err != nil {
+		return nil, nil, err
+	}
+	if err = saleOrderStateLogCreate(o, order, nil, state, "advance", user, "调拨单"+transfer.Name); err != nil {
+		return nil, nil, err
+	}
+	for _, line := range lines {
+		orderLine := &SaleOrderLine{
+			Name:          transfer.Name,
+			Company:       order.Company,
+			SaleOrder:     order,
+			Partner:       customer,
+			Product:       line.Product,
+			ProductName:   line.Product.Name,
+			ProductCode:   line.Product.DefaultCode,
+			FirstSaleUom:  line.FirstUom,
+			SecondSaleUom: line.SecondUom,
+			FirstSaleQty:  float32(line.FirstUomQty),
+			SecondSaleQty: float32(line.SecondUomQty),
+			PriceUnit:     float32(line.PriceUnit),
+			Total:         float32(line.PriceUnit * line.FirstUomQty),
+			State:         "confirm",
+			CreateUser:    user,
+			UpdateUser:    user,
+		}
+		if orderLine.ID, err = o.Insert(orderLine); err != nil {
+			return nil, nil, err
+		}
+		orderLines = append(orderLines, orderLine)
+	}
+	return order, orderLines, nil
+}
+
+// stockTransferPurchaseOrder 为公司间调拨生成调入公司向调出公司的采购订单，收货状态在生成入库移动后按移动更新
+func stockTransferPurchaseOrder(o orm.Ormer, transfer *StockTransfer, lines []*StockTransferLine, supplier *Partner, user *User) (order *PurchaseOrder, orderLines []*PurchaseOrderLine, err error) {
+	warehouse := transfer.WareHouseDest
+	var state *PurchaseOrderState
+	if state, err = purchaseOrderDraftState(o); err != nil {
+		return nil, nil, err
+	}
+	var name string
+	if name, err = GetNextSequece("PurchaseOrder", warehouse.Company.ID); err != nil {
+		return nil, nil, fmt.Errorf("采购订单序号获取失败:%s", err.Error())
+	}
+	order = &PurchaseOrder{
+		Name:           name,
+		Partner:        supplier,
+		PurchasesMan:   user,
+		Company:        warehouse.Company,
+		State:          state,
+		StockWarehouse: warehouse,
+		CreateUser:     user,
+		UpdateUser:     user,
+	}
+	if order.ID, err = o.Insert(order); err != nil {
+		return nil, nil, err
+	}
+	for _, line := range lines {
+		secondUom := line.SecondUom
+		if secondUom == nil {
+			secondUom = line.FirstUom
+		}
+		orderLine := &PurchaseOrderLine{
+			Name:              line.Product.Name,
+			Company:           order.Company,
+			PurchaseOrder:     order,
+			Partner:           supplier,
+			Product:           line.Product,
+			FirstPurchaseUom:  line.FirstUom,
+			SecondPurchaseUom: secondUom,
+			FirstPurchaseQty:  float32(line.FirstUomQty),
+			SecondPurchaseQty: float32(line.SecondUomQty),
+			PriceUnit:         float32(line.PriceUnit),
+			State:             "confirm",
+			CreateUser:        user,
+			UpdateUser:        user,
+		}
+		if orderLine.ID, err = o.Insert(orderLine); err != nil {
+			return nil, nil, err
+		}
+		orderLines = append(orderLines, orderLine)
+	}
+	return order, orderLines, nil
+}
+
+// ConfirmStockTransfer 确认调拨单，在调出仓库生成发往中转库位的出库单，在调入仓库生成从中转库位收货的入库单，
+// 出库和入库都从仓库流程的第一步开始，入库移动等待对应的出库移动完成后再保留中转库位的库存。调出和调入仓库属于不同公司时使用公司间共用的中转库位，
+// 并生成调出公司的销售订单和调入公司的采购订单，出入库移动关联订单明细以更新发货和收货数量
+func ConfirmStockTransfer(id int64, user *User) (err error) {
+	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return errBegin
+	}
+	transfer := &StockTransfer{ID: id}
+	if err = o.Read(transfer); err != nil {
+		return err
+	}
+	if transfer.State != "draft" {
+		return fmt.Errorf("调拨单[%s]已确认", transfer.Name)
+	}
+	var lines []*StockTransferLine
+	if _, err = o.QueryTable(new(StockTransferLine)).Filter("Transfer__Id", transfer.ID).RelatedSel("Product").OrderBy("Id").All(&lines); err != nil {
+		return err
+	}
+	if len(lines) == 0 {
+		return fmt.Errorf("调拨单[%s]没有调拨明细", transfer.Name)
+	}
+	whSrc, whDest := transfer.WareHouseSrc, transfer.WareHouseDest
+	if err = o.Read(whSrc); err != nil {
+		return err
+	}
+	if err = o.Read(whDest); err != nil {
+		return err
+	}
+	if whSrc.Location == nil || whDest.Location == nil {
+		return errors.New("调出仓库和调入仓库都必须设置库位")
+	}
+	companySrc, companyDest := whSrc.Company, whDest.Company
+	if err = o.Read(companySrc); err != nil {
+		return err
+	}
+	if err = o.Read(companyDest); err != nil {
+		return err
+	}
+	interCompany := companySrc.ID != companyDest.ID
+	var transit *StockLocation
+	if transfer.TransitLocation != nil {
+		transit = transfer.TransitLocation
+		if err = o.Read(transit); err != nil {
+			return err
+		}
+	} else if interCompany {
+		if transit, err = stockTransitLocation(o, nil, user); err != nil {
+			return err
+		}
+	} else if transit, err = stockTransitLocation(o, companySrc, user); err != nil {
+		return err
+	}
+	var partnerSrc, partnerDest *Partner
+	if partnerSrc, err = companyPartner(o, companySrc, user); err != nil {
+		return err
+	}
+	if partnerDest, err = companyPartner(o, companyDest, user); err != nil {
+		return err
+	}
+	var (
+		saleLines     []*SaleOrderLine
+		purchaseLines []*PurchaseOrderLine
+	)
+	if interCompany {
+		if transfer.SaleOrder, saleLines, err = stockTransferSaleOrder(o, transfer, lines, partnerDest, user); err != nil {
+			return err
+		}
+		if transfer.PurchaseOrder, purchaseLines, err = stockTransferPurchaseOrder(o, transfer, lines, partnerSrc, user); err != nil {
+			return err
+		}
+	}
+	var typeOut, typeIn *StockPickingType
+	if typeOut, err = stockPickingTypeByWarehouse(o, whSrc, "outgoing"); err != nil {
+		return err
+	}
+	if typeIn, err = stockPickingTypeByWarehouse(o, whDest, "incoming"); err != nil {
+		return err
+	}
+	// 多步出货时从流程的第一步开始，如拣货->发货，发货步骤完成时再发往中转库位
+	if typeOut, err = stockPickingTypeChainStart(o, typeOut); err != nil {
+		return err
+	}
+	locationOutSrc, locationOut := whSrc.Location, transit
+	if typeOut.DefaultLocationSrc != nil {
+		locationOutSrc = typeOut.DefaultLocationSrc
+	}
+	if typeOut.Code != "outgoing" {
+		if typeOut.DefaultLocationDest == nil {
+			return fmt.Errorf("分拣类型[%s]没有设置默认目标库位", typeOut.Name)
+		}
+		locationOut = typeOut.DefaultLocationDest
+	}
+	// 多步收货时先收到分拣类型的默认目标库位，完成后进入下一步
+	if typeIn, err = stockPickingTypeChainStart(o, typeIn); err != nil {
+		return err
+	}
+	locationIn := whDest.Location
+	if typeIn.DefaultLocationDest != nil {
+		locationIn = typeIn.DefaultLocationDest
+	}
+	newPicking := func(pickingType *StockPickingType, company *Company, partner *Partner, src, dest *StockLocation, saleOrder *SaleOrder, purchaseOrder *PurchaseOrder) (*StockPicking, error) {
+		name, errName := stockPickingNextName(o, pickingType, company)
+		if errName != nil {
+			return nil, errName
+		}
+		picking := &StockPicking{
+			Name:          name,
+			Origin:        transfer.Name,
+			MoveType:      "partial",
+			State:         "confirm",
+			Company:       company,
+			LocationSrc:   src,
+			LocationDest:  dest,
+			Partner:       partner,
+			PickingType:   pickingType,
+			SaleOrder:     saleOrder,
+			PurchaseOrder: purchaseOrder,
+			CreateUser:    user,
+			UpdateUser:    user,
+		}
+		var errInsert error
+		picking.ID, errInsert = o.Insert(picking)
+		return picking, errInsert
+	}
+	if transfer.PickingOut, err = newPicking(typeOut, companySrc, partnerDest, locationOutSrc, locationOut, transfer.SaleOrder, nil); err != nil {
+		return err
+	}
+	if transfer.PickingIn, err = newPicking(typeIn, companyDest, partnerSrc, transit, locationIn, nil, transfer.PurchaseOrder); err != nil {
+		return err
+	}
+	now := time.Now()
+	for i, line := range lines {
+		moveOut := &StockMove{
+			Sequence:        int64(i + 1),
+			Name:            line.Product.Name,
+			Date:            now,
+			DateExpected:    now,
+			Product:         line.Product,
+			ProductTemplate: line.Product.ProductTemplate,
+			FirstUomQty:     line.FirstUomQty,
+			SecondUomQty:    line.SecondUomQty,
+			FirstUom:        line.FirstUom,
+			SecondUom:       line.SecondUom,
+			LocationSrc:     locationOutSrc,
+			LocationDest:    locationOut,
+			Partner:         partnerDest,
+			Picking:         transfer.PickingOut,
+			State:           "confirm",
+			PriceUnit:       line.PriceUnit,
+			Company:         companySrc,
+			Origin:          transfer.Name,
+			ProcureMethod:   "make_to_stock",
+			WareHouse:       whSrc,
+			Lot:             line.Lot,
+			CreateUser:      user,
+			UpdateUser:      user,
+		}
+		if interCompany {
+			moveOut.SaleOrderLine = saleLines[i]
+		}
+		if moveOut.ID, err = o.Insert(moveOut); err != nil {
+			return err
+		}
+		if err = stockMoveAssign(o, moveOut, user); err != nil {
+			return err
+		}
+		moveIn := &StockMove{
+			Sequence:        int64(i + 1),
+			Name:            line.Product.Name,
+			Date:            now,
+			DateExpected:    now,
+			Product:         line.Product,
+			ProductTemplate: line.Product.ProductTemplate,
+			FirstUomQty:     line.FirstUomQty,
+			SecondUomQty:    line.SecondUomQty,
+			FirstUom:        line.FirstUom,
+			SecondUom:       line.SecondUom,
+			LocationSrc:     transit,
+			LocationDest:    locationIn,
+			Partner:         partnerSrc,
+			Picking:         transfer.PickingIn,
+			State:           "confirm",
+			PriceUnit:       line.PriceUnit,
+			Company:         companyDest,
+			Origin:          transfer.Name,
+			ProcureMethod:   "make_to_stock",
+			WareHouse:       whDest,
+			Lot:             line.Lot,
+			MoveOrigin:      moveOut,
+			CreateUser:      user,
+			UpdateUser:      user,
+		}
+		if interCompany {
+			moveIn.PurchaseOrderLine = purchaseLines[i]
+		}
+		if moveIn.ID, err = o.Insert(moveIn); err != nil {
+			return err
+		}
+		if err = stockMoveAssign(o, moveIn, user); err != nil {
+			return err
+		}
+	}
+	if err = stockPickingUpdateState(o, transfer.PickingOut, user); err != nil {
+		return err
+	}
+	if err = stockPickingUpdateState(o, transfer.PickingIn, user); err != nil {
+		return err
+	}
+	for _, line := range purchaseLines {
+		if err = purchaseOrderLineUpdateState(o, line, user); err != nil {
+			return err
+		}
+	}
+	transfer.TransitLocation = transit
+	transfer.Company = companySrc
+	transfer.State = "confirm"
+	transfer.UpdateUser = user
+	_, err = o.Update(transfer, "TransitLocation", "Company", "State", "PickingOut", "PickingIn", "SaleOrder", "PurchaseOrder", "UpdateUser", "UpdateDate")
+	if err != nil {
+		return err
+	}
+	return o.Commit()
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// StockTransferLine 仓库间调拨明细
+type StockTransferLine struct {
+	ID           int64               `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser   *User               `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser   *User               `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate   time.Time           `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate   time.Time           `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	Transfer     *StockTransfer      `orm:"rel(fk)"`                              //调拨单
+	Product      *ProductProduct     `orm:"rel(fk)"`                              //产品规格
+	FirstUomQty  float64             `orm:"default(0)" json:"FirstUomQty"`        //第一单位数量
+	SecondUomQty float64             `orm:"default(0)" json:"SecondUomQty"`       //第二单位数量
+	FirstUom     *ProductUom         `orm:"rel(fk)"`                              //第一单位
+	SecondUom    *ProductUom         `orm:"rel(fk);null"`                         //第二单位
+	Lot          *StockProductionLot `orm:"rel(fk);null"`                         //批次、序列号
+	PriceUnit    float64             `orm:"default(0)" json:"PriceUnit"`          //公司间调拨的结算单价
+
+	FormAction   string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
+	ActionFields []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
+	TransferID   int64    `orm:"-" json:"Transfer"`
+	ProductID    int64    `orm:"-" json:"Product"`
+	FirstUomID   int64    `orm:"-" json:"FirstUom"`
+	SecondUomID  int64    `orm:"-" json:"SecondUom"`
+	LotID        int64    `orm:"-" json:"Lot"`
+}
+
+func init() {
+	orm.RegisterModel(new(StockTransferLine))
+}
+
+// AddStockTransferLine insert a new StockTransferLine into database and returns
+// last inserted ID on success.
+func AddStockTransferLine(obj *StockTransferLine, addUser *User) (id int64, err error) {
+	o := orm.NewOrm()
+	obj.Transfer = &StockTransfer{ID: obj.TransferID}
+	if err = o.Read(obj.Transfer); err != nil {
+		return 0, err
+	}
+	if obj.Transfer.State != "draft" {
+		return 0, fmt.Errorf("调拨单[%s]已确认,不能添加明细", obj.Transfer.Name)
+	}
+	if obj.ProductID > 0 {
+		obj.Product, _ = GetProductProductByID(obj.ProductID)
+	}
+	if obj.FirstUomID > 0 {
+		obj.FirstUom, _ = GetProductUomByID(obj.FirstUomID)
+	}
+	if obj.SecondUomID > 0 {
+		obj.SecondUom, _ = GetProductUomByID(obj.SecondUomID)
+	}
+	if obj.LotID > 0 {
+		obj.Lot, _ = GetStockProductionLotByID(obj.LotID)
+	}
+	if obj.Product == nil {
+		return 0, errors.New("调拨明细必须指定产品规格")
+	}
+	if obj.FirstUomQty <= stockQtyEpsilon && obj.SecondUomQty <= stockQtyEpsilon {
+		return 0, errors.New("调拨数量必须大于0")
+	}
+	if obj.FirstUom == nil {
+		obj.FirstUom = obj.Product.FirstSaleUom
+	}
+	if obj.SecondUom == nil {
+		obj.SecondUom = obj.Product.SecondSaleUom
+	}
+	if obj.FirstUom == nil {
+		return 0, errors.New("调拨明细必须指定第一单位")
+	}
+	if obj.PriceUnit <= 0 {
+		obj.PriceUnit = obj.Product.StandardPrice
+	}
+	obj.CreateUser = addUser
+	obj.UpdateUser = addUser
+	return o.Insert(obj)
+}
+
+// GetStockTransferLineByID retrieves StockTransferLine by ID. Returns error if
+// ID doesn't exist
+func GetStockTransferLineByID(id int64) (obj *StockTransferLine, err error) {
+	o := orm.NewOrm()
+	obj = &StockTransferLine{ID: id}
+	if err = o.Read(obj); err == nil {
+		return obj, nil
+	}
+	return nil, err
+}
+
+// DeleteStockTransferLine deletes StockTransferLine by ID and returns error if
+// the record to be deleted doesn't exist
+func DeleteStockTransferLine(id int64) (err error) {
+	o := orm.NewOrm()
+	v := StockTransferLine{ID: id}
+	// ascertain id exists in the database
+	if err = o.Read(&v); err == nil {
+		transfer := &StockTransfer{ID: v.Transfer.ID}
+		if err = o.Read(transfer); err != nil {
+			return err
+		}
+		if transfer.State != "draft" {
+			return fmt.Errorf("调拨单[%s]已确认,不能删除明细", transfer.Name)
+		}
+		var num int64
+		if num, err = o.Delete(&StockTransferLine{ID: id}); err == nil {
+			fmt.Println("Number of records deleted in database:", num)
+		}
+	}
+	return
+}
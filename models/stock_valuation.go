@@ -0,0 +1,344 @@
+package models
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// StockValuationLine 库存估值报表明细，按库位和产品规格汇总
+type StockValuationLine struct {
+	Location     *StockLocation   //库位
+	Product      *ProductProduct  //产品规格
+	Category     *ProductCategory //产品类别
+	CostMethod   string           //成本方法
+	FirstUomQty  float64          //第一单位数量
+	SecondUomQty float64          //第二单位数量
+	UnitCost     float64          //单位成本
+	Value        float64          //库存价值
+}
+
+// productCostMethod 获得产品规格所在类别的成本方法，未设置时使用标准成本
+func productCostMethod(o orm.Ormer, product *ProductProduct) (string, error) {
+	if product.Category == nil {
+		if err := o.Read(product); err != nil {
+			return "", err
+		}
+	}
+	if product.Category == nil {
+		return "standard", nil
+	}
+	category := &ProductCategory{ID: product.Category.ID}
+	if err := o.Read(category); err != nil {
+		return "", err
+	}
+	switch category.CostMethod {
+	case "average", "fifo":
+		return category.CostMethod, nil
+	}
+	return "standard", nil
+}
+
+// stockMoveIsIncoming 移动从外部库位进入公司库存
+func stockMoveIsIncoming(move *StockMove) bool {
+	return !locationNeedQuants(move.LocationSrc) && locationNeedQuants(move.LocationDest)
+}
+
+// stockMoveIsOutgoing 移动从公司库存离开到外部库位
+func stockMoveIsOutgoing(move *StockMove) bool {
+	return locationNeedQuants(move.LocationSrc) && !locationNeedQuants(move.LocationDest)
+}
+
+// stockMoveUnitCost 移动的单位成本，已计算库存价值时按价值计算，否则使用产品规格的成本价格
+func stockMoveUnitCost(o orm.Ormer, move *StockMove) float64 {
+	if move.Value != 0 && move.FirstUomQty > stockQtyEpsilon {
+		return math.Abs(move.Value) / move.FirstUomQty
+	}
+	product := &ProductProduct{ID: move.Product.ID}
+	if err := o.Read(product); err != nil {
+		return 0
+	}
+	return product.StandardPrice
+}
+
+// productOnHandQty 获得所有公司内部库位中产品规格的第一单位数量，不含代售品。
+// 成本价格保存在产品规格上由各公司共用，移动平均按所有公司的库存数量计算
+func productOnHandQty(o orm.Ormer, product *ProductProduct) (float64, error) {
+	var quants []*StockQuant
+	qs := o.QueryTable(new(StockQuant)).Filter("Product__Id", product.ID).Filter("Location__Usage__in", "internal", "transit").Filter("Owner__isnull", true)
+	if _, err := qs.Limit(-1).All(&quants, "FirstUomQty"); err != nil {
+		return 0, err
+	}
+	var qty float64
+	for _, quant := range quants {
+		qty += quant.FirstUomQty
+	}
+	return qty, nil
+}
+
+// stockMoveValuationIn 入库移动计算库存价值，供应商入库按单价，其他入库按成本价格，
+// 移动平均的产品同时按所有公司的库存更新产品规格的成本价格，接收代售品不计算库存价值
+func stockMoveValuationIn(o orm.Ormer, move *StockMove, user *User) error {
+	if !stockMoveIsIncoming(move) {
+		return nil
+	}
+	owner, _, err := stockMoveConsignmentOwner(o, move)
+	if err != nil {
+		return err
+	}
+	if owner != nil {
+		move.Value = 0
+		return nil
+	}
+	product := &ProductProduct{ID: move.Product.ID}
+	if err := o.Read(product); err != nil {
+		return err
+	}
+	method, err := productCostMethod(o, product)
+	if err != nil {
+		return err
+	}
+	unitCost := product.StandardPrice
+	if method != "standard" && move.LocationSrc.Usage == "supplier" && move.PriceUnit > 0 {
+		unitCost = move.PriceUnit
+	}
+	if method == "average" {
+		onHand, err := productOnHandQty(o, product)
+		if err != nil {
+			return err
+		}
+		if onHand > stockQtyEpsilon && onHand+move.FirstUomQty > stockQtyEpsilon {
+			product.StandardPrice = (onHand*product.StandardPrice + move.FirstUomQty*unitCost) / (onHand + move.FirstUomQty)
+		} else {
+			product.StandardPrice = unitCost
+		}
+		product.UpdateUser = user
+		if _, err = o.Update(product, "StandardPrice", "UpdateUser", "UpdateDate"); err != nil {
+			return err
+		}
+	}
+	move.Value = unitCost * move.FirstUomQty
+	return nil
+}
+
+// stockMoveValuationConsignment 客户退回的代售品不计入库存价值，按代售品数量扣减入库移动的价值
+func stockMoveValuationConsignment(o orm.Ormer, move *StockMove) error {
+	if !stockMoveIsIncoming(move) || move.Value == 0 || move.FirstUomQty <= stockQtyEpsilon {
+		return nil
+	}
+	consignedQty, err := stockConsignmentMoveQty(o, move)
+	if err != nil {
+		return err
+	}
+	if consignedQty > stockQtyEpsilon {
+		move.Value = move.Value * math.Max(move.FirstUomQty-consignedQty, 0) / move.FirstUomQty
+	}
+	return nil
+}
+
+// stockMoveValuationOut 出库移动计算库存价值，先进先出按消耗的份的成本，其他按成本价格，
+// 代售品不计入库存价值
+func stockMoveValuationOut(o orm.Ormer, move *StockMove, movedCost float64) error {
+	if !stockMoveIsOutgoing(move) {
+		return nil
+	}
+	consignedQty, err := stockConsignmentMoveQty(o, move)
+	if err != nil {
+		return err
+	}
+	product := &ProductProduct{ID: move.Product.ID}
+	if err := o.Read(product); err != nil {
+		return err
+	}
+	method, err := productCostMethod(o, product)
+	if err != nil {
+		return err
+	}
+	if method == "fifo" {
+		move.Value = -movedCost
+	} else {
+		move.Value = -product.StandardPrice * math.Max(move.FirstUomQty-consignedQty, 0)
+	}
+	return nil
+}
+
+// GetStockValuation 获得指定日期的库存估值，date为空时为当前库存。
+// 当前库存按份汇总，先进先出按份的成本计价，标准成本和移动平均按产品规格的成本价格(各公司共用)计价；
+// 指定日期时从当前库存扣回该日期之后的出入库移动的数量和价值，单位成本为产品规格在该日期的库存价值除以库存数量，
+// locationID、categoryID大于0时只统计该库位、类别及其下级，不含代售品
+func GetStockValuation(date time.Time, locationID, categoryID int64) (lines []*StockValuationLine, err error) {
+	o := orm.NewOrm()
+	var categoryIDs []int64
+	if categoryID > 0 {
+		categoryIDs = []int64{categoryID}
+		if _, childs, errChild := GetAllChildCategorys(categoryID); errChild == nil {
+			for _, child := range childs {
+				categoryIDs = append(categoryIDs, child.ID)
+			}
+		}
+	}
+	var locationFilter map[int64]bool
+	if locationID > 0 {
+		var ids []int64
+		if ids, err = stockLocationChildIDs(o, &StockLocation{ID: locationID}); err != nil {
+			return nil, err
+		}
+		locationFilter = make(map[int64]bool)
+		for _, id := range ids {
+			locationFilter[id] = true
+		}
+	}
+	categories := make(map[int64]*ProductCategory)
+	readCategory := func(product *ProductProduct) (*ProductCategory, error) {
+		if product.Category == nil {
+			return nil, nil
+		}
+		category, ok := categories[product.Category.ID]
+		if !ok {
+			category = &ProductCategory{ID: product.Category.ID}
+			if err := o.Read(category); err != nil {
+				return nil, err
+			}
+			categories[category.ID] = category
+		}
+		return category, nil
+	}
+	type productTotal struct {
+		qty   float64
+		value float64
+	}
+	totals := make(map[int64]*productTotal)
+	productTotalOf := func(product *ProductProduct) *productTotal {
+		total, ok := totals[product.ID]
+		if !ok {
+			total = new(productTotal)
+			totals[product.ID] = total
+		}
+		return total
+	}
+	lineMap := make(map[[2]int64]*StockValuationLine)
+	addLine := func(location *StockLocation, product *ProductProduct, firstQty, secondQty, value float64) {
+		if locationFilter != nil && !locationFilter[location.ID] {
+			return
+		}
+		key := [2]int64{location.ID, product.ID}
+		line, ok := lineMap[key]
+		if !ok {
+			line = &StockValuationLine{Location: location, Product: product}
+			lineMap[key] = line
+		}
+		line.FirstUomQty += firstQty
+		line.SecondUomQty += secondQty
+		line.Value += value
+	}
+	// 当前库存
+	quantQs := o.QueryTable(new(StockQuant)).Filter("Location__Usage__in", "internal", "transit").Filter("Owner__isnull", true)
+	if categoryID > 0 {
+		quantQs = quantQs.Filter("Product__Category__Id__in", categoryIDs)
+	}
+	var quants []*StockQuant
+	if _, err = quantQs.RelatedSel("Location", "Product").Limit(-1).All(&quants); err != nil {
+		return nil, err
+	}
+	for _, quant := range quants {
+		if quant.Location == nil || quant.Product == nil {
+			continue
+		}
+		var category *ProductCategory
+		if category, err = readCategory(quant.Product); err != nil {
+			return nil, err
+		}
+		unitCost := quant.Product.StandardPrice
+		if category != nil && category.CostMethod == "fifo" {
+			unitCost = quant.Cost
+		}
+		value := unitCost * quant.FirstUomQty
+		total := productTotalOf(quant.Product)
+		total.qty += quant.FirstUomQty
+		total.value += value
+		addLine(quant.Location, quant.Product, quant.FirstUomQty, quant.SecondUomQty, value)
+	}
+	if !date.IsZero() {
+		// 扣回指定日期之后的出入库移动
+		moveQs := o.QueryTable(new(StockMove)).Filter("State", "done").Filter("Date__gt", date)
+		if categoryID > 0 {
+			moveQs = moveQs.Filter("Product__Category__Id__in", categoryIDs)
+		}
+		var moves []*StockMove
+		if _, err = moveQs.RelatedSel("LocationSrc", "LocationDest", "Product").Limit(-1).All(&moves); err != nil {
+			return nil, err
+		}
+		for _, move := range moves {
+			if move.LocationSrc == nil || move.LocationDest == nil || move.Product == nil {
+				continue
+			}
+			total := productTotalOf(move.Product)
+			total.value -= move.Value
+			if stockMoveIsIncoming(move) {
+				total.qty -= move.FirstUomQty
+			} else if stockMoveIsOutgoing(move) {
+				total.qty += move.FirstUomQty
+			}
+			if locationNeedQuants(move.LocationSrc) {
+				addLine(move.LocationSrc, move.Product, move.FirstUomQty, move.SecondUomQty, 0)
+			}
+			if locationNeedQuants(move.LocationDest) {
+				addLine(move.LocationDest, move.Product, -move.FirstUomQty, -move.SecondUomQty, 0)
+			}
+		}
+		// 代售品归货主所有，当前库存已不含代售品，扣回移动时补回代售明细的数量
+		consignmentQs := o.QueryTable(new(StockConsignmentLine)).Filter("Date__gt", date)
+		if categoryID > 0 {
+			consignmentQs = consignmentQs.Filter("Product__Category__Id__in", categoryIDs)
+		}
+		var consignments []*StockConsignmentLine
+		if _, err = consignmentQs.RelatedSel("LocationSrc", "LocationDest", "Product").Limit(-1).All(&consignments); err != nil {
+			return nil, err
+		}
+		for _, consignment := range consignments {
+			move := &StockMove{LocationSrc: consignment.LocationSrc, LocationDest: consignment.LocationDest}
+			total := productTotalOf(consignment.Product)
+			if stockMoveIsIncoming(move) {
+				total.qty += consignment.FirstUomQty
+			} else if stockMoveIsOutgoing(move) {
+				total.qty -= consignment.FirstUomQty
+			}
+			if locationNeedQuants(consignment.LocationSrc) {
+				addLine(consignment.LocationSrc, consignment.Product, -consignment.FirstUomQty, -consignment.SecondUomQty, 0)
+			}
+			if locationNeedQuants(consignment.LocationDest) {
+				addLine(consignment.LocationDest, consignment.Product, consignment.FirstUomQty, consignment.SecondUomQty, 0)
+			}
+		}
+	}
+	for _, line := range lineMap {
+		if math.Abs(line.FirstUomQty) <= stockQtyEpsilon && math.Abs(line.SecondUomQty) <= stockQtyEpsilon {
+			continue
+		}
+		if !date.IsZero() {
+			line.UnitCost = 0
+			if total := totals[line.Product.ID]; total != nil && math.Abs(total.qty) > stockQtyEpsilon {
+				line.UnitCost = total.value / total.qty
+			}
+			line.Value = line.UnitCost * line.FirstUomQty
+		} else if math.Abs(line.FirstUomQty) > stockQtyEpsilon {
+			line.UnitCost = line.Value / line.FirstUomQty
+		}
+		if line.Category, err = readCategory(line.Product); err != nil {
+			return nil, err
+		}
+		if line.Category != nil {
+			line.CostMethod = line.Category.CostMethod
+		}
+		lines = append(lines, line)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].Location.Name != lines[j].Location.Name {
+			return lines[i].Location.Name < lines[j].Location.Name
+		}
+		return lines[i].Product.Name < lines[j].Product.Name
+	})
+	return lines, nil
+}
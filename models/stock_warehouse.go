@@ -12,20 +12,22 @@ import (
 
 // StockWarehouse  仓库
 type StockWarehouse struct {
-	ID         int64            `orm:"column(id);pk;auto" json:"id"`         //主键
-	CreateUser *User            `orm:"rel(fk);null" json:"-"`                //创建者
-	UpdateUser *User            `orm:"rel(fk);null" json:"-"`                //最后更新者
-	CreateDate time.Time        `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
-	UpdateDate time.Time        `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
-	Name       string           `orm:"unique" json:"Name"`                   //仓库名称
-	Code       string           `orm:"unique" json:"Code"`                   //仓库编码
-	Company    *Company         `orm:"rel(fk)"`                              //所属公司
-	Country    *AddressCountry  `orm:"rel(fk);null" json:"-"`                //国家
-	Province   *AddressProvince `orm:"rel(fk);null" json:"-"`                //省份
-	City       *AddressCity     `orm:"rel(fk);null" json:"-"`                //城市
-	District   *AddressDistrict `orm:"rel(fk);null" json:"-"`                //区县
-	Street     string           `orm:"default()" json:"Street"`              //街道
-	Location   *StockLocation   `orm:"rel(fk);null"`                         //库存库位
+	ID             int64            `orm:"column(id);pk;auto" json:"id"`            //主键
+	CreateUser     *User            `orm:"rel(fk);null" json:"-"`                   //创建者
+	UpdateUser     *User            `orm:"rel(fk);null" json:"-"`                   //最后更新者
+	CreateDate     time.Time        `orm:"auto_now_add;type(datetime)" json:"-"`    //创建时间
+	UpdateDate     time.Time        `orm:"auto_now;type(datetime)" json:"-"`        //最后更新时间
+	Name           string           `orm:"unique" json:"Name"`                      //仓库名称
+	Code           string           `orm:"unique" json:"Code"`                      //仓库编码
+	Company        *Company         `orm:"rel(fk)"`                                 //所属公司
+	Country        *AddressCountry  `orm:"rel(fk);null" json:"-"`                   //国家
+	Province       *AddressProvince `orm:"rel(fk);null" json:"-"`                   //省份
+	City           *AddressCity     `orm:"rel(fk);null" json:"-"`                   //城市
+	District       *AddressDistrict `orm:"rel(fk);null" json:"-"`                   //区县
+	Street         string           `orm:"default()" json:"Street"`                 //街道
+	Location       *StockLocation   `orm:"rel(fk);null"`                            //库存库位
+	ReceptionSteps string           `orm:"default(one_step)" json:"ReceptionSteps"` //收货流程:one_step直接入库/two_steps收货后上架
+	DeliverySteps  string           `orm:"default(ship_only)" json:"DeliverySteps"` //发货流程:ship_only直接发货/pick_ship拣货后发货
 
 	FormAction   string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
 	ActionFields []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
@@ -76,15 +78,169 @@ func AddStockWarehouse(obj *StockWarehouse, addUser *User) (id int64, err error)
 	if obj.LocationID > 0 {
 		obj.Location, _ = GetStockLocationByID(obj.LocationID)
 	}
+	obj.Code = strings.TrimSpace(obj.Code)
+	if obj.Code == "" {
+		return 0, errors.New("仓库编码不能为空")
+	}
+	if obj.Company == nil {
+		return 0, errors.New("仓库没有设置公司")
+	}
+	if obj.ReceptionSteps == "" {
+		obj.ReceptionSteps = "one_step"
+	}
+	if obj.DeliverySteps == "" {
+		obj.DeliverySteps = "ship_only"
+	}
+	if obj.ReceptionSteps != "one_step" && obj.ReceptionSteps != "two_steps" {
+		return 0, fmt.Errorf("无效的收货流程:%s", obj.ReceptionSteps)
+	}
+	if obj.DeliverySteps != "ship_only" && obj.DeliverySteps != "pick_ship" {
+		return 0, fmt.Errorf("无效的发货流程:%s", obj.DeliverySteps)
+	}
+	if id, err = o.Insert(obj); err != nil {
+		return 0, err
+	}
+	if err = stockWarehouseCreateLayout(o, obj, addUser); err != nil {
+		return 0, err
+	}
+	errCommit := o.Commit()
+	if errCommit != nil {
+		return 0, errCommit
+	}
+	return id, err
+}
 
-	id, err = o.Insert(obj)
-	if err == nil {
-		errCommit := o.Commit()
-		if errCommit != nil {
-			return 0, errCommit
+// stockWarehouseCreateLayout 创建仓库的标准库位和分拣类型:
+// 视图库位下的库存、收货区、出货区、盘点损益、废料库位，内部调拨、收货->上架、拣货->发货的分拣类型，
+// 收货和上架、拣货和发货始终通过上一步、下一步关联，仓库的收货、发货流程设置只决定
+// 收货是否经收货区上架、发货是否先拣货到出货区，每个分拣类型使用以仓库编码为前缀的序号
+func stockWarehouseCreateLayout(o orm.Ormer, warehouse *StockWarehouse, user *User) (err error) {
+	newLocation := func(name, usage string, parent *StockLocation) (*StockLocation, error) {
+		location := &StockLocation{
+			Name:       warehouse.Code + name,
+			Company:    warehouse.Company,
+			Usage:      usage,
+			Active:     true,
+			Parent:     parent,
+			CreateUser: user,
+			UpdateUser: user,
 		}
+		var errInsert error
+		location.ID, errInsert = o.Insert(location)
+		return location, errInsert
 	}
-	return id, err
+	var viewLoc, stockLoc, inputLoc, outputLoc, scrapLoc *StockLocation
+	if viewLoc, err = newLocation("", "view", nil); err != nil {
+		return err
+	}
+	if warehouse.Location != nil {
+		// 指定了库存库位时挂到仓库视图库位下
+		stockLoc = warehouse.Location
+		stockLoc.Parent = viewLoc
+		stockLoc.UpdateUser = user
+		if _, err = o.Update(stockLoc, "Parent", "UpdateUser", "UpdateDate"); err != nil {
+			return err
+		}
+	} else if stockLoc, err = newLocation("/库存", "internal", viewLoc); err != nil {
+		return err
+	}
+	if inputLoc, err = newLocation("/收货区", "internal", viewLoc); err != nil {
+		return err
+	}
+	if outputLoc, err = newLocation("/出货区", "internal", viewLoc); err != nil {
+		return err
+	}
+	// 盘点差异记入盘点损益库位，报废记入废料库位
+	if _, err = newLocation("/盘点损益", "inventory", viewLoc); err != nil {
+		return err
+	}
+	if scrapLoc, err = newLocation("/废料", "inventory", viewLoc); err != nil {
+		return err
+	}
+	scrapLoc.ScrapLocation = true
+	if _, err = o.Update(scrapLoc, "ScrapLocation"); err != nil {
+		return err
+	}
+	warehouse.Location = stockLoc
+	warehouse.UpdateUser = user
+	if _, err = o.Update(warehouse, "Location", "UpdateUser", "UpdateDate"); err != nil {
+		return err
+	}
+	newPickingType := func(name, code, prefix string, src, dest *StockLocation, isStart, isEnd bool) (*StockPickingType, error) {
+		sequence := &Sequence{
+			Company:    warehouse.Company,
+			Name:       warehouse.Name + name,
+			Prefix:     warehouse.Code + "/" + prefix + "/",
+			Padding:    5,
+			StructName: "StockPicking",
+			Active:     true,
+			IsDefault:  false,
+			CreateUser: user,
+			UpdateUser: user,
+		}
+		var errInsert error
+		if sequence.ID, errInsert = o.Insert(sequence); errInsert != nil {
+			return nil, errInsert
+		}
+		pickingType := &StockPickingType{
+			Name:                warehouse.Name + name,
+			Active:              true,
+			Code:                code,
+			WareHouse:           warehouse,
+			IsStart:             isStart,
+			IsEnd:               isEnd,
+			DefaultLocationSrc:  src,
+			DefaultLocationDest: dest,
+			Sequence:            sequence,
+			CreateUser:          user,
+			UpdateUser:          user,
+		}
+		pickingType.ID, errInsert = o.Insert(pickingType)
+		return pickingType, errInsert
+	}
+	chain := func(prev, next *StockPickingType) error {
+		prev.NextStep = next
+		next.PrevStep = prev
+		if _, errUpdate := o.Update(prev, "NextStep"); errUpdate != nil {
+			return errUpdate
+		}
+		_, errUpdate := o.Update(next, "PrevStep")
+		return errUpdate
+	}
+	// 内部调拨最先创建，按移库类型查找内部分拣类型时优先使用
+	if _, err = newPickingType("内部调拨", "internal", "INT", stockLoc, stockLoc, true, true); err != nil {
+		return err
+	}
+	twoSteps := warehouse.ReceptionSteps == "two_steps"
+	receiptDest := stockLoc
+	if twoSteps {
+		receiptDest = inputLoc
+	}
+	var receipt, putaway, pick, delivery *StockPickingType
+	if receipt, err = newPickingType("收货", "incoming", "IN", nil, receiptDest, true, !twoSteps); err != nil {
+		return err
+	}
+	if putaway, err = newPickingType("上架", "internal", "PUT", inputLoc, stockLoc, false, true); err != nil {
+		return err
+	}
+	if err = chain(receipt, putaway); err != nil {
+		return err
+	}
+	pickShip := warehouse.DeliverySteps == "pick_ship"
+	deliverySrc := stockLoc
+	if pickShip {
+		deliverySrc = outputLoc
+	}
+	if pick, err = newPickingType("拣货", "internal", "PICK", stockLoc, outputLoc, true, false); err != nil {
+		return err
+	}
+	if delivery, err = newPickingType("发货", "outgoing", "OUT", deliverySrc, nil, !pickShip, true); err != nil {
+		return err
+	}
+	if err = chain(pick, delivery); err != nil {
+		return err
+	}
+	return nil
 }
 
 // GetStockWarehouseByID retrieves StockWarehouse by ID. Returns error if
@@ -0,0 +1,456 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"goERP/utils"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+)
+
+// StockWarehouseOrderpoint 补货规则，预测库存低于最小数量时补货到最大数量
+type StockWarehouseOrderpoint struct {
+	ID          int64           `orm:"column(id);pk;auto" json:"id"`         //主键
+	CreateUser  *User           `orm:"rel(fk);null" json:"-"`                //创建者
+	UpdateUser  *User           `orm:"rel(fk);null" json:"-"`                //最后更新者
+	CreateDate  time.Time       `orm:"auto_now_add;type(datetime)" json:"-"` //创建时间
+	UpdateDate  time.Time       `orm:"auto_now;type(datetime)" json:"-"`     //最后更新时间
+	Name        string          `orm:"default()" json:"Name"`                //规则名称
+	Active      bool            `orm:"default(true)" json:"Active"`          //有效
+	WareHouse   *StockWarehouse `orm:"rel(fk)"`                              //仓库
+	Location    *StockLocation  `orm:"rel(fk);null"`                         //库位，为空时使用仓库的库存库位
+	Product     *ProductProduct `orm:"rel(fk)"`                              //产品规格
+	FirstMinQty float64         `orm:"default(0)" json:"FirstMinQty"`        //第一单位最小数量
+	FirstMaxQty float64         `orm:"default(0)" json:"FirstMaxQty"`        //第一单位最大数量
+	QtyMultiple float64         `orm:"default(1)" json:"QtyMultiple"`        //补货数量的倍数
+	Company     *Company        `orm:"rel(fk);null"`                         //公司
+	LastRun     time.Time       `orm:"type(datetime);null" json:"-"`         //最后补货时间
+
+	FormAction   string   `orm:"-" json:"FormAction"`   //非数据库字段，用于表示记录的增加，修改
+	ActionFields []string `orm:"-" json:"ActionFields"` //需要操作的字段,用于update时
+	WareHouseID  int64    `orm:"-" json:"WareHouse"`
+	LocationID   int64    `orm:"-" json:"Location"`
+	ProductID    int64    `orm:"-" json:"Product"`
+	CompanyID    int64    `orm:"-" json:"Company"`
+}
+
+func init() {
+	orm.RegisterModel(new(StockWarehouseOrderpoint))
+}
+
+// AddStockWarehouseOrderpoint insert a new StockWarehouseOrderpoint into database and returns
+// last inserted ID on success.
+func AddStockWarehouseOrderpoint(obj *StockWarehouseOrderpoint, addUser *User) (id int64, err error) {
+	o := orm.NewOrm()
+	obj.CreateUser = addUser
+	obj.UpdateUser = addUser
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return 0, errBegin
+	}
+	if obj.WareHouseID > 0 {
+		obj.WareHouse, _ = GetStockWarehouseByID(obj.WareHouseID)
+	}
+	if obj.LocationID > 0 {
+		obj.Location, _ = GetStockLocationByID(obj.LocationID)
+	}
+	if obj.ProductID > 0 {
+		obj.Product, _ = GetProductProductByID(obj.ProductID)
+	}
+	if obj.CompanyID > 0 {
+		obj.Company, _ = GetCompanyByID(obj.CompanyID)
+	}
+	if obj.WareHouse == nil || obj.Product == nil {
+		return 0, errors.New("补货规则必须指定仓库和产品规格")
+	}
+	if obj.Company == nil {
+		obj.Company = obj.WareHouse.Company
+	}
+	if obj.FirstMaxQty < obj.FirstMinQty {
+		return 0, errors.New("最大数量不能小于最小数量")
+	}
+	if o.QueryTable(obj).Filter("WareHouse__Id", obj.WareHouse.ID).Filter("Product__Id", obj.Product.ID).Filter("Active", true).Exist() {
+		return 0, fmt.Errorf("仓库[%s]已存在产品[%s]的补货规则", obj.WareHouse.Name, obj.Product.Name)
+	}
+	if obj.Name == "" {
+		obj.Name = obj.WareHouse.Name + "/" + obj.Product.Name
+	}
+	id, err = o.Insert(obj)
+	if err == nil {
+		errCommit := o.Commit()
+		if errCommit != nil {
+			return 0, errCommit
+		}
+	}
+	return id, err
+}
+
+// GetStockWarehouseOrderpointByID retrieves StockWarehouseOrderpoint by ID. Returns error if
+// ID doesn't exist
+func GetStockWarehouseOrderpointByID(id int64) (obj *StockWarehouseOrderpoint, err error) {
+	o := orm.NewOrm()
+	obj = &StockWarehouseOrderpoint{ID: id}
+	if err = o.Read(obj); err == nil {
+		if obj.WareHouse != nil {
+			o.Read(obj.WareHouse)
+		}
+		if obj.Location != nil {
+			o.Read(obj.Location)
+		}
+		if obj.Product != nil {
+			o.Read(obj.Product)
+		}
+		if obj.Company != nil {
+			o.Read(obj.Company)
+		}
+		return obj, nil
+	}
+	return nil, err
+}
+
+// GetAllStockWarehouseOrderpoint retrieves all StockWarehouseOrderpoint matches certain condition. Returns empty list if
+// no records exist
+func GetAllStockWarehouseOrderpoint(query map[string]interface{}, exclude map[string]interface{}, condMap map[string]map[string]interface{}, fields []string, sortby []string, order []string, offset int64, limit int64) (utils.Paginator, []StockWarehouseOrderpoint, error) {
+	var (
+		objArrs   []StockWarehouseOrderpoint
+		paginator utils.Paginator
+		num       int64
+		err       error
+	)
+	if limit == 0 {
+		limit = 20
+	}
+	o := orm.NewOrm()
+	qs := o.QueryTable(new(StockWarehouseOrderpoint))
+	qs = qs.RelatedSel()
+
+	//cond k=v cond必须放到Filter和Exclude前面
+	cond := orm.NewCondition()
+	if _, ok := condMap["and"]; ok {
+		andMap := condMap["and"]
+		for k, v := range andMap {
+			k = strings.Replace(k, ".", "__", -1)
+			cond = cond.And(k, v)
+		}
+	}
+	if _, ok := condMap["or"]; ok {
+		orMap := condMap["or"]
+		for k, v := range orMap {
+			k = strings.Replace(k, ".", "__", -1)
+			cond = cond.Or(k, v)
+		}
+	}
+	qs = qs.SetCond(cond)
+	// query k=v
+	for k, v := range query {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		qs = qs.Filter(k, v)
+	}
+	//exclude k=v
+	for k, v := range exclude {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		qs = qs.Exclude(k, v)
+	}
+
+	// order by:
+	var sortFields []string
+	if len(sortby) != 0 {
+		if len(sortby) == len(order) {
+			// 1) for each sort field, there is an associated order
+			for i, v := range sortby {
+				orderby := ""
+				if order[i] == "desc" {
+					orderby = "-" + strings.Replace(v, ".", "__", -1)
+				} else if order[i] == "asc" {
+					orderby = strings.Replace(v, ".", "__", -1)
+				} else {
+					return paginator, nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+			qs = qs.OrderBy(sortFields...)
+		} else if len(sortby) != len(order) && len(order) == 1 {
+			// 2) there is exactly one order, all the sorted fields will be sorted by this order
+			for _, v := range sortby {
+				orderby := ""
+				if order[0] == "desc" {
+					orderby = "-" + strings.Replace(v, ".", "__", -1)
+				} else if order[0] == "asc" {
+					orderby = strings.Replace(v, ".", "__", -1)
+				} else {
+					return paginator, nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+		} else if len(sortby) != len(order) && len(order) != 1 {
+			return paginator, nil, errors.New("Error: 'sortby', 'order' sizes mismatch or 'order' size is not 1")
+		}
+	} else {
+		if len(order) != 0 {
+			return paginator, nil, errors.New("Error: unused 'order' fields")
+		}
+	}
+
+	qs = qs.OrderBy(sortFields...)
+	if cnt, err := qs.Count(); err == nil {
+		if cnt > 0 {
+			paginator = utils.GenPaginator(limit, offset, cnt)
+			if num, err = qs.Limit(limit, offset).All(&objArrs, fields...); err == nil {
+				paginator.CurrentPageSize = num
+			}
+		}
+	}
+	return paginator, objArrs, err
+}
+
+// UpdateStockWarehouseOrderpointByID updates StockWarehouseOrderpoint by ID and returns error if
+// the record to be updated doesn't exist
+func UpdateStockWarehouseOrderpointByID(m *StockWarehouseOrderpoint) (err error) {
+	o := orm.NewOrm()
+	v := StockWarehouseOrderpoint{ID: m.ID}
+	if m.FirstMaxQty < m.FirstMinQty {
+		return errors.New("最大数量不能小于最小数量")
+	}
+	// ascertain id exists in the database
+	if err = o.Read(&v); err == nil {
+		var num int64
+		if num, err = o.Update(m); err == nil {
+			fmt.Println("Number of records updated in database:", num)
+		}
+	}
+	return
+}
+
+// DeleteStockWarehouseOrderpoint deletes StockWarehouseOrderpoint by ID and returns error if
+// the record to be deleted doesn't exist
+func DeleteStockWarehouseOrderpoint(id int64) (err error) {
+	o := orm.NewOrm()
+	v := StockWarehouseOrderpoint{ID: id}
+	// ascertain id exists in the database
+	if err = o.Read(&v); err == nil {
+		var num int64
+		if num, err = o.Delete(&StockWarehouseOrderpoint{ID: id}); err == nil {
+			fmt.Println("Number of records deleted in database:", num)
+		}
+	}
+	return
+}
+
+// stockOrderpointForecastQty 获得补货规则库位的预测库存:在库数量减去已保留数量，
+// 加上未完成的入库移动和尚未确认的采购订单数量
+func stockOrderpointForecastQty(o orm.Ormer, point *StockWarehouseOrderpoint, location *StockLocation) (float64, error) {
+	locationIDs, err := stockLocationChildIDs(o, location)
+	if err != nil {
+		return 0, err
+	}
+	inLocation := make(map[int64]bool)
+	for _, id := range locationIDs {
+		inLocation[id] = true
+	}
+	var forecast float64
+	var quants []*StockQuant
+	if _, err = o.QueryTable(new(StockQuant)).Filter("Product__Id", point.Product.ID).Filter("Location__Id__in", locationIDs).Limit(-1).All(&quants); err != nil {
+		return 0, err
+	}
+	for _, quant := range quants {
+		if quant.Reservation == nil {
+			forecast += quant.FirstUomQty
+		}
+	}
+	var moves []*StockMove
+	qs := o.QueryTable(new(StockMove)).Filter("Product__Id", point.Product.ID).Filter("LocationDest__Id__in", locationIDs)
+	if _, err = qs.Filter("State__in", "confirm", "waiting", "assigned").Limit(-1).All(&moves); err != nil {
+		return 0, err
+	}
+	for _, move := range moves {
+		if move.LocationSrc != nil && inLocation[move.LocationSrc.ID] {
+			continue
+		}
+		forecast += move.FirstUomQty
+	}
+	var lines []*PurchaseOrderLine
+	lineQs := o.QueryTable(new(PurchaseOrderLine)).Filter("Product__Id", point.Product.ID).Filter("State", "draft")
+	lineQs = lineQs.Filter("PurchaseOrder__StockWarehouse__Id", point.WareHouse.ID).Filter("PurchaseOrder__ReceiptState", "draft")
+	if _, err = lineQs.Limit(-1).All(&lines); err != nil {
+		return 0, err
+	}
+	for _, line := range lines {
+		forecast += float64(line.FirstPurchaseQty)
+	}
+	return forecast, nil
+}
+
+// productPreferredSupplier 获得产品规格当前有效的首选供应商，规格供应商优先于款式供应商，序号越小越优先
+func productPreferredSupplier(o orm.Ormer, product *ProductProduct) (*ProductSupplier, error) {
+	now := time.Now()
+	cond := orm.NewCondition().Or("ProductProduct__Id", product.ID).Or("ProductTemplate__Id", product.ProductTemplate.ID)
+	var suppliers []*ProductSupplier
+	if _, err := o.QueryTable(new(ProductSupplier)).SetCond(cond).OrderBy("Sequence", "Id").All(&suppliers); err != nil {
+		return nil, err
+	}
+	var preferred *ProductSupplier
+	for _, supplier := range suppliers {
+		if !supplier.DateStart.IsZero() && supplier.DateStart.After(now) {
+			continue
+		}
+		if !supplier.DateEnd.IsZero() && supplier.DateEnd.Before(now) {
+			continue
+		}
+		if supplier.ProductProduct != nil && supplier.ProductProduct.ID == product.ID {
+			return supplier, nil
+		}
+		if preferred == nil && supplier.ProductProduct == nil {
+			preferred = supplier
+		}
+	}
+	if preferred == nil {
+		return nil, fmt.Errorf("产品[%s]没有有效的供应商", product.Name)
+	}
+	return preferred, nil
+}
+
+// RunStockReplenishment 执行补货，预测库存低于最小数量的规则按首选供应商合并生成询价单，
+// 返回新建的采购订单ID。user为空时由规则的创建者作为采购员，缺少库位、供应商或采购员的规则跳过并记录日志
+func RunStockReplenishment(user *User) (orderIDs []int64, err error) {
+	o := orm.NewOrm()
+	errBegin := o.Begin()
+	defer func() {
+		if err != nil {
+			if errRollback := o.Rollback(); errRollback != nil {
+				err = errRollback
+			}
+		}
+	}()
+	if errBegin != nil {
+		return nil, errBegin
+	}
+	var points []*StockWarehouseOrderpoint
+	if _, err = o.QueryTable(new(StockWarehouseOrderpoint)).Filter("Active", true).OrderBy("Id").Limit(-1).All(&points); err != nil {
+		return nil, err
+	}
+	var draftState *PurchaseOrderState
+	if draftState, err = purchaseOrderDraftState(o); err != nil {
+		return nil, err
+	}
+	// 规则本身的设置有问题时跳过该规则并记录日志，不影响其他规则的补货
+	skip := func(point *StockWarehouseOrderpoint, reason string) {
+		utils.LogOut("warning", fmt.Sprintf("补货规则[%s]已跳过:%s", point.Name, reason))
+	}
+	orders := make(map[string]*PurchaseOrder)
+	now := time.Now()
+	for _, point := range points {
+		warehouse := &StockWarehouse{ID: point.WareHouse.ID}
+		if err = o.Read(warehouse); err != nil {
+			return nil, err
+		}
+		location := warehouse.Location
+		if point.Location != nil {
+			location = point.Location
+		}
+		if location == nil {
+			skip(point, "没有库位")
+			continue
+		}
+		point.WareHouse = warehouse
+		var forecast float64
+		if forecast, err = stockOrderpointForecastQty(o, point, location); err != nil {
+			return nil, err
+		}
+		if forecast >= point.FirstMinQty {
+			continue
+		}
+		qty := point.FirstMaxQty - forecast
+		if point.QtyMultiple > stockQtyEpsilon {
+			qty = math.Ceil(qty/point.QtyMultiple-stockQtyEpsilon) * point.QtyMultiple
+		}
+		product := &ProductProduct{ID: point.Product.ID}
+		if err = o.Read(product); err != nil {
+			return nil, err
+		}
+		supplier, errSupplier := productPreferredSupplier(o, product)
+		if errSupplier != nil {
+			skip(point, errSupplier.Error())
+			continue
+		}
+		if qty < float64(supplier.FirstMinQty) {
+			qty = float64(supplier.FirstMinQty)
+		}
+		if qty <= stockQtyEpsilon {
+			continue
+		}
+		company := point.Company
+		if company == nil {
+			company = warehouse.Company
+		}
+		purchaser := user
+		if purchaser == nil {
+			purchaser = point.CreateUser
+		}
+		if purchaser == nil {
+			skip(point, "没有采购员")
+			continue
+		}
+		key := fmt.Sprintf("%d-%d-%d", supplier.Supplier.ID, warehouse.ID, company.ID)
+		order, ok := orders[key]
+		if !ok {
+			var name string
+			if name, err = GetNextSequece("PurchaseOrder", company.ID); err != nil {
+				return nil, fmt.Errorf("采购订单序号获取失败:%s", err.Error())
+			}
+			order = &PurchaseOrder{
+				Name:           name,
+				Partner:        supplier.Supplier,
+				PurchasesMan:   purchaser,
+				Company:        company,
+				State:          draftState,
+				StockWarehouse: warehouse,
+				ReceiptState:   "draft",
+				CreateUser:     purchaser,
+				UpdateUser:     purchaser,
+			}
+			if order.ID, err = o.Insert(order); err != nil {
+				return nil, err
+			}
+			orders[key] = order
+			orderIDs = append(orderIDs, order.ID)
+		}
+		secondUom := product.SecondPurchaseUom
+		if secondUom == nil {
+			secondUom = product.FirstPurchaseUom
+		}
+		line := &PurchaseOrderLine{
+			Name:              product.Name,
+			Company:           company,
+			PurchaseOrder:     order,
+			Partner:           supplier.Supplier,
+			Product:           product,
+			FirstPurchaseUom:  product.FirstPurchaseUom,
+			SecondPurchaseUom: secondUom,
+			FirstPurchaseQty:  float32(qty),
+			PriceUnit:         float32(supplier.FirstPrice),
+			State:             "draft",
+			CreateUser:        purchaser,
+			UpdateUser:        purchaser,
+		}
+		if _, err = o.Insert(line); err != nil {
+			return nil, err
+		}
+		point.LastRun = now
+		if _, err = o.Update(point, "LastRun", "UpdateDate"); err != nil {
+			return nil, err
+		}
+	}
+	return orderIDs, o.Commit()
+}
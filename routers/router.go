@@ -75,6 +75,10 @@ func init() {
 	beego.Router("/product/uom/?:id", &product.ProductUomController{})
 	//产品计量单位类别
 	beego.Router("/product/uomcateg/?:id", &product.ProductUomCategController{})
+	//价格表
+	beego.Router("/product/pricelist/?:id", &product.ProductPricelistController{})
+	//贵金属牌价
+	beego.Router("/product/metalrate/?:id", &product.ProductMetalRateController{})
 	//========================================合作伙伴管理===============================
 	//合作伙伴管理
 	beego.Router("/partner/?:id", &base.PartnerController{})
@@ -109,7 +113,27 @@ func init() {
 	beego.Router("/stock/picking/?:id", &stock.StockPickingController{})
 	// 库位管理
 	beego.Router("/stock/location/?:id", &stock.StockLocationController{})
+	// 入库、出库策略
+	beego.Router("/stock/putaway/rule/?:id", &stock.StockPutawayRuleController{})
+	beego.Router("/stock/putaway/?:id", &stock.StockPutawayStrategyController{})
+	beego.Router("/stock/removal/?:id", &stock.StockRemovalStrategyController{})
+	// 补货规则
+	beego.Router("/stock/orderpoint/?:id", &stock.StockWarehouseOrderpointController{})
+	// 报废单
+	beego.Router("/stock/scrap/?:id", &stock.StockScrapController{})
+	// 仓库调拨
+	beego.Router("/stock/transfer/?:id", &stock.StockTransferController{})
+	// 代售结算
+	beego.Router("/stock/consignment/?:id", &stock.StockConsignmentController{})
 	// 盘点管理
 	beego.Router("/stock/inventory/?:id", &stock.StockInventoryController{})
+	// 移动明细
+	beego.Router("/stock/move/?:id", &stock.StockMoveController{})
+	// 库存查询
+	beego.Router("/stock/quant/?:id", &stock.StockQuantController{})
+	beego.Router("/stock/lot/?:id", &stock.StockProductionLotController{})
+	beego.Router("/stock/package/?:id", &stock.StockQuantPackageController{})
+	// 库存报表
+	beego.Router("/stock/report/?:id", &stock.StockReportController{})
 
 }
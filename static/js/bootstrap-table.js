@@ -1481,6 +1481,22 @@ displayTable("#table-stock-location", '/stock/location/', [
             return html;
         }
     },
+    {
+        title: "允许负库存",
+        field: 'AllowNegative',
+        align: "center",
+        sortable: true,
+        order: "desc",
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.AllowNegative) {
+                html = '<i class="fa fa-check"></i><span style="display:none;">是<span>';
+            } else {
+                html = '<i class="fa fa-remove"></i><span style="display:none;">否<span>';
+            }
+            return html;
+        }
+    },
 
     { title: "通道(X)", field: 'Posx', align: "center", sortable: true, order: "desc" },
     { title: "货架(Y)", field: 'Posy', align: "center", sortable: true, order: "desc" },
@@ -1491,15 +1507,981 @@ displayTable("#table-stock-location", '/stock/location/', [
         align: "center",
         field: 'action',
         formatter: function cellStyle(value, row, index) {
-
+
+            var html = "";
+            var url = "/stock/location/";
+            html += "<a href='" + url + row.id + "?action=edit' class='table-action btn btn-xs btn-default'>编辑<i class='fa fa-pencil'></i></a>";
+            html += "<a href='" + url + row.id + "?action=detail' class='table-action btn btn-xs btn-default'>详情<i class='fa fa-external-link'></i></a>";
+            return html;
+        }
+    }
+]);
+displayTable("#table-stock-quant", '/stock/quant/', [
+    { title: "全选", field: 'ID', checkbox: true, align: "center", valign: "middle" },
+    {
+        title: "产品",
+        field: 'Product',
+        sortable: true,
+        order: "desc",
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Product) {
+                html = row.Product.name + "<a class='pull-right' href='/product/product/" + row.Product.id + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    {
+        title: "库位",
+        field: 'Location',
+        sortable: true,
+        order: "desc",
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Location) {
+                html = row.Location.name + "<a class='pull-right' href='/stock/location/" + row.Location.id + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    {
+        title: "批次",
+        field: 'Lot',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Lot) {
+                html = row.Lot.name + "<a class='pull-right' href='/stock/lot/" + row.Lot.id + "?action=trace'><i class='fa fa-random'></i></a>";
+            }
+            return html;
+        }
+    },
+    { title: "第一单位数量", field: 'FirstUomQty', align: "center", sortable: true, order: "desc" },
+    { title: "第一单位", field: 'FirstUom', align: "center" },
+    { title: "第二单位数量", field: 'SecondUomQty', align: "center", sortable: true, order: "desc" },
+    { title: "第二单位", field: 'SecondUom', align: "center" },
+    { title: "成本", field: 'Cost', align: "center", sortable: true, order: "desc" },
+    { title: "货主", field: 'Owner' },
+    { title: "接收时间", field: 'InDate', align: "center", sortable: true, order: "desc" },
+    {
+        title: "已保留",
+        field: 'Reserved',
+        align: "center",
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Reserved) {
+                html = '<i class="fa fa-check"></i><span style="display:none;">是<span>';
+            } else {
+                html = '<i class="fa fa-remove"></i><span style="display:none;">否<span>';
+            }
+            return html;
+        }
+    }
+]);
+displayTable("#table-stock-quant-negative", '/stock/quant/?negative=true', [
+    {
+        title: "产品",
+        field: 'Product',
+        sortable: true,
+        order: "desc",
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Product) {
+                html = row.Product.name + "<a class='pull-right' href='/product/product/" + row.Product.id + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    {
+        title: "库位",
+        field: 'Location',
+        sortable: true,
+        order: "desc",
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Location) {
+                html = row.Location.name + "<a class='pull-right' href='/stock/location/" + row.Location.id + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    {
+        title: "批次",
+        field: 'Lot',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Lot) {
+                html = row.Lot.name;
+            }
+            return html;
+        }
+    },
+    { title: "第一单位数量", field: 'FirstUomQty', align: "center", sortable: true, order: "asc" },
+    { title: "第一单位", field: 'FirstUom', align: "center" },
+    { title: "第二单位数量", field: 'SecondUomQty', align: "center", sortable: true, order: "asc" },
+    { title: "第二单位", field: 'SecondUom', align: "center" },
+    {
+        title: "产生的移动",
+        field: 'NegativeMove',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.NegativeMove) {
+                html = row.NegativeMove.name;
+                if (row.NegativeMove.origin) {
+                    html += "(" + row.NegativeMove.origin + ")";
+                }
+            }
+            return html;
+        }
+    },
+    {
+        title: "调拨单",
+        field: 'NegativeMove',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.NegativeMove && row.NegativeMove.pickingId) {
+                html = row.NegativeMove.picking + "<a class='pull-right' href='/stock/picking/" + row.NegativeMove.pickingId + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    {
+        title: "目标库位",
+        field: 'NegativeDestLocation',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.NegativeDestLocation) {
+                html = row.NegativeDestLocation.name;
+            }
+            return html;
+        }
+    },
+    { title: "发生时间", field: 'InDate', align: "center", sortable: true, order: "desc" }
+]);
+displayTable("#table-stock-lot", '/stock/lot/', [
+    { title: "全选", field: 'ID', checkbox: true, align: "center", valign: "middle" },
+    { title: "批次号", field: 'Name', sortable: true, order: "desc" },
+    {
+        title: "产品规格",
+        field: 'Product',
+        sortable: true,
+        order: "desc",
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Product) {
+                html = row.Product.name + "<a class='pull-right' href='/product/product/" + row.Product.id + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    { title: "内部参考", field: 'Ref', sortable: true, order: "desc" },
+    { title: "证书编号", field: 'Certificate', sortable: true, order: "desc" },
+    { title: "过期时间", field: 'ExpiryDate', align: "center", sortable: true, order: "desc" },
+    {
+        title: "所属公司",
+        field: 'Company',
+        sortable: true,
+        order: "desc",
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Company) {
+                html = row.Company.name;
+            }
+            return html;
+        }
+    },
+    {
+        title: "操作",
+        align: "center",
+        field: 'action',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            var url = "/stock/lot/";
+            html += "<a href='" + url + row.ID + "?action=edit' class='table-action btn btn-xs btn-default'>编辑&nbsp<i class='fa fa-pencil'></i></a>";
+            html += "<a href='" + url + row.ID + "?action=detail' class='table-action btn btn-xs btn-default'>详情&nbsp<i class='fa fa-external-link'></i></a>";
+            html += "<a href='" + url + row.ID + "?action=trace' class='table-action btn btn-xs btn-default'>追溯&nbsp<i class='fa fa-random'></i></a>";
+            return html;
+        }
+    }
+]);
+//批次追溯，表格数据提交到当前批次的地址
+displayTable("#table-stock-lot-trace", window.location.pathname, [
+    { title: "日期", field: 'Date', align: "center" },
+    { title: "移动", field: 'Name' },
+    { title: "源单据", field: 'Origin' },
+    {
+        title: "调拨单",
+        field: 'Picking',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Picking) {
+                html = row.Picking.name + "<a class='pull-right' href='/stock/picking/" + row.Picking.id + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    {
+        title: "源库位",
+        field: 'LocationSrc',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.LocationSrc) {
+                html = row.LocationSrc.name;
+            }
+            return html;
+        }
+    },
+    {
+        title: "目标库位",
+        field: 'LocationDest',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.LocationDest) {
+                html = row.LocationDest.name;
+            }
+            return html;
+        }
+    },
+    { title: "合作伙伴", field: 'Partner' },
+    { title: "第一单位数量", field: 'FirstUomQty', align: "center" },
+    { title: "第二单位数量", field: 'SecondUomQty', align: "center" }
+]);
+displayTable("#table-stock-package", '/stock/package/', [
+    { title: "全选", field: 'ID', checkbox: true, align: "center", valign: "middle" },
+    { title: "包名称", field: 'Name', sortable: true, order: "desc" },
+    { title: "条码", field: 'Barcode', sortable: true, order: "desc" },
+    {
+        title: "上级包",
+        field: 'Parent',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Parent) {
+                html = row.Parent.name + "<a class='pull-right' href='/stock/package/" + row.Parent.id + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    {
+        title: "所在库位",
+        field: 'Location',
+        sortable: true,
+        order: "desc",
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Location) {
+                html = row.Location.name;
+            }
+            return html;
+        }
+    },
+    {
+        title: "操作",
+        align: "center",
+        field: 'action',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            var url = "/stock/package/";
+            html += "<a href='" + url + row.ID + "?action=edit' class='table-action btn btn-xs btn-default'>编辑&nbsp<i class='fa fa-pencil'></i></a>";
+            html += "<a href='" + url + row.ID + "?action=detail' class='table-action btn btn-xs btn-default'>详情&nbsp<i class='fa fa-external-link'></i></a>";
+            html += "<a href='" + url + row.ID + "?action=content' class='table-action btn btn-xs btn-default'>内容&nbsp<i class='fa fa-cubes'></i></a>";
+            return html;
+        }
+    }
+]);
+//包内容，表格数据提交到当前包的地址
+displayTable("#table-stock-package-content", window.location.pathname, [
+    { title: "包", field: 'Package' },
+    {
+        title: "产品",
+        field: 'Product',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Product) {
+                html = row.Product.name + "<a class='pull-right' href='/product/product/" + row.Product.id + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    { title: "库位", field: 'Location' },
+    { title: "第一单位数量", field: 'FirstUomQty', align: "center" },
+    { title: "第一单位", field: 'FirstUom', align: "center" },
+    { title: "第二单位数量", field: 'SecondUomQty', align: "center" }
+]);
+displayTable("#table-stock-putaway", '/stock/putaway/', [
+    { title: "全选", field: 'ID', checkbox: true, align: "center", valign: "middle" },
+    { title: "策略名称", field: 'Name', sortable: true, order: "desc" },
+    {
+        title: "所属公司",
+        field: 'Company',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Company) {
+                html = row.Company.name;
+            }
+            return html;
+        }
+    },
+    {
+        title: "有效",
+        field: 'Active',
+        align: "center",
+        formatter: function cellStyle(value, row, index) {
+            return value ? "是" : "否";
+        }
+    },
+    {
+        title: "操作",
+        align: "center",
+        field: 'action',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            var url = "/stock/putaway/";
+            html += "<a href='" + url + row.ID + "?action=edit' class='table-action btn btn-xs btn-default'>编辑&nbsp<i class='fa fa-pencil'></i></a>";
+            html += "<a href='" + url + row.ID + "?action=detail' class='table-action btn btn-xs btn-default'>详情&nbsp<i class='fa fa-external-link'></i></a>";
+            html += "<a href='" + url + row.ID + "?action=rules' class='table-action btn btn-xs btn-default'>规则&nbsp<i class='fa fa-list'></i></a>";
+            return html;
+        }
+    }
+]);
+//入库规则，策略详情页中表格数据提交到当前策略的地址
+displayTable("#table-stock-putaway-rule", window.location.pathname, [
+    { title: "全选", field: 'ID', checkbox: true, align: "center", valign: "middle" },
+    { title: "序号", field: 'Sequence', sortable: true, order: "asc" },
+    {
+        title: "入库策略",
+        field: 'Strategy',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Strategy) {
+                html = row.Strategy.name + "<a class='pull-right' href='/stock/putaway/" + row.Strategy.id + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    {
+        title: "产品规格",
+        field: 'Product',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Product) {
+                html = row.Product.name + "<a class='pull-right' href='/product/product/" + row.Product.id + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    {
+        title: "产品类别",
+        field: 'Category',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Category) {
+                html = row.Category.name + "<a class='pull-right' href='/product/category/" + row.Category.id + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    {
+        title: "入库库位",
+        field: 'Location',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Location) {
+                html = row.Location.name + "<a class='pull-right' href='/stock/location/" + row.Location.id + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    {
+        title: "操作",
+        align: "center",
+        field: 'action',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            var url = "/stock/putaway/rule/";
+            html += "<a href='" + url + row.ID + "?action=edit' class='table-action btn btn-xs btn-default'>编辑&nbsp<i class='fa fa-pencil'></i></a>";
+            html += "<a href='" + url + row.ID + "?action=detail' class='table-action btn btn-xs btn-default'>详情&nbsp<i class='fa fa-external-link'></i></a>";
+            return html;
+        }
+    }
+]);
+displayTable("#table-stock-removal", '/stock/removal/', [
+    { title: "全选", field: 'ID', checkbox: true, align: "center", valign: "middle" },
+    { title: "策略名称", field: 'Name', sortable: true, order: "desc" },
+    {
+        title: "出库方法",
+        field: 'Method',
+        sortable: true,
+        order: "desc",
+        formatter: function cellStyle(value, row, index) {
+            var methods = { fifo: "先进先出", lifo: "后进先出", fefo: "先到期先出", closest: "最近库位" };
+            return methods[value] || value;
+        }
+    },
+    {
+        title: "有效",
+        field: 'Active',
+        align: "center",
+        formatter: function cellStyle(value, row, index) {
+            return value ? "是" : "否";
+        }
+    },
+    {
+        title: "操作",
+        align: "center",
+        field: 'action',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            var url = "/stock/removal/";
+            html += "<a href='" + url + row.ID + "?action=edit' class='table-action btn btn-xs btn-default'>编辑&nbsp<i class='fa fa-pencil'></i></a>";
+            html += "<a href='" + url + row.ID + "?action=detail' class='table-action btn btn-xs btn-default'>详情&nbsp<i class='fa fa-external-link'></i></a>";
+            return html;
+        }
+    }
+]);
+displayTable("#table-stock-valuation", '/stock/report/', [
+    {
+        title: "库位",
+        field: 'Location',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Location) {
+                html = row.Location.name + "<a class='pull-right' href='/stock/location/" + row.Location.id + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    { title: "产品类别", field: 'Category' },
+    {
+        title: "产品规格",
+        field: 'Product',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Product) {
+                html = row.Product.name + "<a class='pull-right' href='/product/product/" + row.Product.id + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    {
+        title: "成本方法",
+        field: 'CostMethod',
+        formatter: function cellStyle(value, row, index) {
+            var methods = { standard: "标准成本", average: "移动平均", fifo: "先进先出" };
+            return methods[value] || "标准成本";
+        }
+    },
+    { title: "第一单位数量", field: 'FirstUomQty', align: "center" },
+    { title: "第二单位数量", field: 'SecondUomQty', align: "center" },
+    {
+        title: "单位成本",
+        field: 'UnitCost',
+        align: "right",
+        formatter: function cellStyle(value, row, index) {
+            return (+value).toFixed(2);
+        }
+    },
+    {
+        title: "库存价值",
+        field: 'Value',
+        align: "right",
+        formatter: function cellStyle(value, row, index) {
+            return (+value).toFixed(2);
+        }
+    }
+]);
+displayTable("#table-stock-orderpoint", '/stock/orderpoint/', [
+    { title: "全选", field: 'ID', checkbox: true, align: "center", valign: "middle" },
+    { title: "规则名称", field: 'Name', sortable: true, order: "desc" },
+    {
+        title: "仓库",
+        field: 'WareHouse',
+        sortable: true,
+        order: "desc",
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.WareHouse) {
+                html = row.WareHouse.name;
+            }
+            return html;
+        }
+    },
+    {
+        title: "库位",
+        field: 'Location',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Location) {
+                html = row.Location.name;
+            }
+            return html;
+        }
+    },
+    {
+        title: "产品规格",
+        field: 'Product',
+        sortable: true,
+        order: "desc",
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Product) {
+                html = row.Product.name + "<a class='pull-right' href='/product/product/" + row.Product.id + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    { title: "最小数量", field: 'FirstMinQty', align: "right", sortable: true, order: "desc" },
+    { title: "最大数量", field: 'FirstMaxQty', align: "right", sortable: true, order: "desc" },
+    { title: "补货倍数", field: 'QtyMultiple', align: "right" },
+    { title: "最后补货", field: 'LastRun', align: "center", sortable: true, order: "desc" },
+    {
+        title: "有效",
+        field: 'Active',
+        align: "center",
+        formatter: function cellStyle(value, row, index) {
+            if (row.Active) {
+                return '<i class="fa fa-check"></i>';
+            }
+            return "";
+        }
+    },
+    {
+        title: "操作",
+        align: "center",
+        field: 'action',
+        formatter: function cellStyle(value, row, index) {
             var html = "";
-            var url = "/stock/location/";
-            html += "<a href='" + url + row.id + "?action=edit' class='table-action btn btn-xs btn-default'>编辑<i class='fa fa-pencil'></i></a>";
-            html += "<a href='" + url + row.id + "?action=detail' class='table-action btn btn-xs btn-default'>详情<i class='fa fa-external-link'></i></a>";
+            var url = "/stock/orderpoint/";
+            html += "<a href='" + url + row.ID + "?action=edit' class='table-action btn btn-xs btn-default'>编辑&nbsp<i class='fa fa-pencil'></i></a>";
+            html += "<a href='" + url + row.ID + "?action=detail' class='table-action btn btn-xs btn-default'>详情&nbsp<i class='fa fa-external-link'></i></a>";
+            return html;
+        }
+    }
+]);
+displayTable("#table-stock-scrap", '/stock/scrap/', [
+    { title: "全选", field: 'ID', checkbox: true, align: "center", valign: "middle" },
+    { title: "报废单号", field: 'Name', sortable: true, order: "desc" },
+    {
+        title: "产品规格",
+        field: 'Product',
+        sortable: true,
+        order: "desc",
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Product) {
+                html = row.Product.name + "<a class='pull-right' href='/product/product/" + row.Product.id + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    { title: "第一单位数量", field: 'FirstUomQty', align: "right" },
+    { title: "第二单位数量", field: 'SecondUomQty', align: "right" },
+    { title: "批次", field: 'Lot' },
+    {
+        title: "源库位",
+        field: 'LocationSrc',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.LocationSrc) {
+                html = row.LocationSrc.name;
+            }
+            return html;
+        }
+    },
+    {
+        title: "废料库位",
+        field: 'ScrapLocation',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.ScrapLocation) {
+                html = row.ScrapLocation.name;
+            }
+            return html;
+        }
+    },
+    { title: "报废原因", field: 'Reason' },
+    { title: "报废人", field: 'DoneUser' },
+    { title: "报废时间", field: 'DateDone', align: "center", sortable: true, order: "desc" },
+    {
+        title: "状态",
+        field: 'State',
+        align: "center",
+        formatter: function cellStyle(value, row, index) {
+            if (row.State == "done") {
+                return "已报废";
+            }
+            return "草稿";
+        }
+    },
+    {
+        title: "操作",
+        align: "center",
+        field: 'action',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            var url = "/stock/scrap/";
+            if (row.State == "draft") {
+                html += "<a href='" + url + row.ID + "?action=edit' class='table-action btn btn-xs btn-default'>编辑&nbsp<i class='fa fa-pencil'></i></a>";
+            }
+            html += "<a href='" + url + row.ID + "?action=detail' class='table-action btn btn-xs btn-default'>详情&nbsp<i class='fa fa-external-link'></i></a>";
+            return html;
+        }
+    }
+]);
+displayTable("#table-stock-transfer", '/stock/transfer/', [
+    { title: "全选", field: 'ID', checkbox: true, align: "center", valign: "middle" },
+    { title: "调拨单号", field: 'Name', sortable: true, order: "desc" },
+    { title: "源单据", field: 'Origin' },
+    {
+        title: "调出仓库",
+        field: 'WareHouseSrc',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.WareHouseSrc) {
+                html = row.WareHouseSrc.name;
+            }
+            return html;
+        }
+    },
+    {
+        title: "调入仓库",
+        field: 'WareHouseDest',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.WareHouseDest) {
+                html = row.WareHouseDest.name;
+            }
+            return html;
+        }
+    },
+    {
+        title: "出库单",
+        field: 'PickingOut',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.PickingOut) {
+                html = row.PickingOut.name + "<a class='pull-right' href='/stock/picking/" + row.PickingOut.id + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    {
+        title: "入库单",
+        field: 'PickingIn',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.PickingIn) {
+                html = row.PickingIn.name + "<a class='pull-right' href='/stock/picking/" + row.PickingIn.id + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    { title: "创建时间", field: 'CreateDate', align: "center", sortable: true, order: "desc" },
+    {
+        title: "状态",
+        field: 'State',
+        align: "center",
+        formatter: function cellStyle(value, row, index) {
+            if (row.State == "confirm") {
+                return "已确认";
+            } else if (row.State == "cancel") {
+                return "已取消";
+            }
+            return "草稿";
+        }
+    },
+    {
+        title: "操作",
+        align: "center",
+        field: 'action',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            var url = "/stock/transfer/";
+            if (row.State == "draft") {
+                html += "<a href='" + url + row.ID + "?action=edit' class='table-action btn btn-xs btn-default'>编辑&nbsp<i class='fa fa-pencil'></i></a>";
+            }
+            html += "<a href='" + url + row.ID + "?action=detail' class='table-action btn btn-xs btn-default'>详情&nbsp<i class='fa fa-external-link'></i></a>";
+            return html;
+        }
+    }
+]);
+//代售明细，销售和客户退回明细右键结算
+displayTable("#table-stock-consignment", '/stock/consignment/', [
+    { title: "全选", field: 'ID', checkbox: true, align: "center", valign: "middle" },
+    { title: "日期", field: 'Date', align: "center", sortable: true, order: "desc" },
+    {
+        title: "类型",
+        field: 'Type',
+        align: "center",
+        formatter: function cellStyle(value, row, index) {
+            var types = { receive: "接收", sale: "销售", refund: "客户退回", "return": "退回货主", internal: "内部调拨", other: "其他" };
+            return types[value] || value;
+        }
+    },
+    { title: "货主", field: 'Owner' },
+    {
+        title: "产品规格",
+        field: 'Product',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Product) {
+                html = row.Product.name + "<a class='pull-right' href='/product/product/" + row.Product.id + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    { title: "批次", field: 'Lot' },
+    { title: "移动", field: 'Move' },
+    { title: "源单据", field: 'Origin' },
+    { title: "第一单位数量", field: 'FirstUomQty', align: "center" },
+    { title: "第二单位数量", field: 'SecondUomQty', align: "center" },
+    { title: "结算单价", field: 'PriceUnit', align: "right" },
+    { title: "结算金额", field: 'Amount', align: "right" },
+    {
+        title: "结算状态",
+        field: 'State',
+        align: "center",
+        formatter: function cellStyle(value, row, index) {
+            if (row.Type != "sale" && row.Type != "refund") {
+                return "";
+            }
+            if (row.State == "settled") {
+                return "已结算";
+            }
+            return "未结算";
+        }
+    },
+    { title: "结算时间", field: 'DateSettled', align: "center" }
+]);
+//代售对账单
+displayTable("#table-stock-consignment-statement", '/stock/report/?report=consignment', [
+    {
+        title: "货主",
+        field: 'Owner',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Owner) {
+                html = row.Owner.name;
+            }
+            return html;
+        }
+    },
+    {
+        title: "产品规格",
+        field: 'Product',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Product) {
+                html = row.Product.name + "<a class='pull-right' href='/product/product/" + row.Product.id + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    { title: "接收数量", field: 'Received', align: "center" },
+    { title: "销售数量", field: 'Sold', align: "center" },
+    { title: "退回数量", field: 'Returned', align: "center" },
+    { title: "在库数量", field: 'OnHand', align: "center" },
+    { title: "销售金额", field: 'SaleAmount', align: "right" },
+    { title: "已结算金额", field: 'SettledAmount', align: "right" },
+    { title: "未结算金额", field: 'UnsettledAmount', align: "right" }
+]);
+//价格表
+displayTable("#table-product-pricelist", "/product/pricelist/", [
+    { title: "全选", field: 'ID', checkbox: true, align: "center", valign: "middle" },
+    { title: "价格表名称", field: 'Name', sortable: true, order: "desc" },
+    {
+        title: "公司",
+        field: 'Company',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Company) {
+                html = row.Company.name;
+            }
+            return html;
+        }
+    },
+    {
+        title: "有效",
+        field: 'Active',
+        align: "center",
+        formatter: function cellStyle(value, row, index) {
+            if (row.Active) {
+                return '<i class="fa fa-check"></i>';
+            }
+            return '<i class="fa fa-remove"></i>';
+        }
+    },
+    {
+        title: "操作",
+        align: "center",
+        field: 'action',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            var url = "/product/pricelist/";
+            html += "<a href='" + url + row.ID + "?action=edit' class='table-action btn btn-xs btn-default'>编辑&nbsp<i class='fa fa-pencil'></i></a>";
+            html += "<a href='" + url + row.ID + "?action=detail' class='table-action btn btn-xs btn-default'>详情&nbsp<i class='fa fa-external-link'></i></a>";
+            return html;
+        }
+    }
+]);
+//贵金属牌价
+displayTable("#table-product-metal-rate", "/product/metalrate/", [
+    { title: "全选", field: 'ID', checkbox: true, align: "center", valign: "middle" },
+    {
+        title: "金属",
+        field: 'Metal',
+        sortable: true,
+        formatter: function cellStyle(value, row, index) {
+            var names = { gold: "黄金", silver: "白银", platinum: "铂金", palladium: "钯金" };
+            return names[row.Metal] || row.Metal;
+        }
+    },
+    { title: "成色", field: 'Purity', sortable: true },
+    { title: "每克价格", field: 'PricePerGram', align: "right" },
+    { title: "生效时间", field: 'DateEffective', align: "center", sortable: true, order: "desc" },
+    {
+        title: "操作",
+        align: "center",
+        field: 'action',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            var url = "/product/metalrate/";
+            html += "<a href='" + url + row.ID + "?action=detail' class='table-action btn btn-xs btn-default'>详情&nbsp<i class='fa fa-external-link'></i></a>";
+            return html;
+        }
+    }
+]);
+displayTable("#table-sale-order-state", "/sale/order/state/", [
+    { title: "全选", field: 'ID', checkbox: true, align: "center", valign: "middle" },
+    { title: "状态名称", field: 'Name', sortable: true },
+    { title: "序号", field: 'Sequence', align: "center", sortable: true },
+    {
+        title: "公司",
+        field: 'Company',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Company) {
+                html = row.Company.name;
+            }
+            return html;
+        }
+    },
+    {
+        title: "仓库",
+        field: 'StockWarehouse',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.StockWarehouse) {
+                html = row.StockWarehouse.name;
+            }
+            return html;
+        }
+    },
+    {
+        title: "下一步",
+        field: 'NextStep',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.NextStep) {
+                html = row.NextStep.name;
+            }
+            return html;
+        }
+    },
+    { title: "进入条件", field: 'Conditions' },
+    {
+        title: "进入动作",
+        field: 'Action',
+        formatter: function cellStyle(value, row, index) {
+            var names = { reserve: "预留库存", picking: "生成出库分拣", cancel: "取消订单" };
+            return names[row.Action] || row.Action;
+        }
+    },
+    {
+        title: "有效",
+        field: 'Active',
+        align: "center",
+        formatter: function cellStyle(value, row, index) {
+            if (row.Active) {
+                return '<i class="fa fa-check"></i>';
+            }
+            return '<i class="fa fa-remove"></i>';
+        }
+    },
+    {
+        title: "操作",
+        align: "center",
+        field: 'action',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            var url = "/sale/order/state/";
+            html += "<a href='" + url + row.ID + "?action=edit' class='table-action btn btn-xs btn-default'>编辑&nbsp<i class='fa fa-pencil'></i></a>";
+            html += "<a href='" + url + row.ID + "?action=detail' class='table-action btn btn-xs btn-default'>详情&nbsp<i class='fa fa-external-link'></i></a>";
             return html;
         }
     }
 ]);
+//库存台账，第一行为期初结存
+displayTable("#table-stock-ledger", '/stock/report/?report=ledger', [
+    { title: "日期", field: 'Date', align: "center" },
+    { title: "移动", field: 'Name' },
+    { title: "源单据", field: 'Origin' },
+    {
+        title: "调拨单",
+        field: 'Picking',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Picking) {
+                html = row.Picking.name + "<a class='pull-right' href='/stock/picking/" + row.Picking.id + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    { title: "批次", field: 'Lot' },
+    { title: "源库位", field: 'LocationSrc' },
+    { title: "目标库位", field: 'LocationDest' },
+    { title: "第一单位入库", field: 'FirstIn', align: "right" },
+    { title: "第二单位入库", field: 'SecondIn', align: "right" },
+    { title: "第一单位出库", field: 'FirstOut', align: "right" },
+    { title: "第二单位出库", field: 'SecondOut', align: "right" },
+    { title: "第一单位结存", field: 'FirstBalance', align: "right" },
+    { title: "第二单位结存", field: 'SecondBalance', align: "right" }
+]);
+//份、批次追溯
+displayTable("#table-stock-trace", '/stock/report/?report=trace', [
+    {
+        title: "方向",
+        field: 'Direction',
+        align: "center",
+        formatter: function cellStyle(value, row, index) {
+            switch (row.Direction) {
+                case "upstream":
+                    return "上游";
+                case "downstream":
+                    return "下游";
+            }
+            return "内部";
+        }
+    },
+    { title: "日期", field: 'Date', align: "center" },
+    { title: "移动", field: 'Name' },
+    { title: "源单据", field: 'Origin' },
+    {
+        title: "调拨单",
+        field: 'Picking',
+        formatter: function cellStyle(value, row, index) {
+            var html = "";
+            if (row.Picking) {
+                html = row.Picking.name + "<a class='pull-right' href='/stock/picking/" + row.Picking.id + "?action=detail'><i class='fa fa-external-link'></i></a>";
+            }
+            return html;
+        }
+    },
+    { title: "批次", field: 'Lot' },
+    { title: "份", field: 'Quant', align: "center" },
+    { title: "源库位", field: 'LocationSrc' },
+    { title: "目标库位", field: 'LocationDest' },
+    { title: "第一单位数量", field: 'FirstUomQty', align: "right" },
+    { title: "第二单位数量", field: 'SecondUomQty', align: "right" }
+]);
 displayTable("#table-sale-order", "/sale/order", [
     { title: "全选", field: 'ID', checkbox: true, align: "center", valign: "middle" },
     { title: "订单号", field: 'Name', align: "left", sortable: true, order: "desc", valign: "middle" },
@@ -1567,7 +2549,7 @@ displayTable("#table-purchase-order", "/purchase/order", [
     { title: "供应商", field: 'Partner', align: "left", sortable: true, order: "desc", valign: "middle" },
     { title: "采购员", field: 'PurchasesMan', align: "left", sortable: true, order: "desc", valign: "middle" },
     { title: "所属公司", field: 'Company', align: "left", sortable: true, order: "desc", valign: "middle" },
-    { title: "发货仓库", field: 'StockWarehouse', align: "left", sortable: true, order: "desc", valign: "middle" },
+    { title: "收货仓库", field: 'StockWarehouse', align: "left", sortable: true, order: "desc", valign: "middle" },
     {
         title: "状态",
         field: 'State',
@@ -1579,11 +2561,13 @@ displayTable("#table-purchase-order", "/purchase/order", [
             var html = "-";
             if (row.State == "draft") {
                 html = "草稿";
-            } else if (row.state == 'confirm') {
+            } else if (row.State == 'confirm') {
                 html = "确认";
-            } else if (row.state == 'cancel') {
+            } else if (row.State == 'process') {
+                html = "部分收货";
+            } else if (row.State == 'cancel') {
                 html = "取消";
-            } else if (row.state == 'done') {
+            } else if (row.State == 'done') {
                 html = "完成";
             }
             return html;
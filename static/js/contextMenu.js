@@ -85,4 +85,110 @@ $(function() {
             };
         }
     });
+    // 'table-stock-consignment'
+    //结算选中的代售明细
+    $.contextMenu({
+        selector: '#table-stock-consignment tr.selected',
+        build: function($trigger, e) {
+            return {
+                callback: function(key, options) {
+                    var $selector = $("#table-stock-consignment");
+                    var selectedArr = $selector.bootstrapTable('getSelections');
+                    var selectedIds = [];
+                    for (var i = 0, len = selectedArr.length; i < len; i++) {
+                        selectedIds.push(selectedArr[i].id);
+                    }
+                    if (selectedIds.length == 0) {
+                        return;
+                    }
+                    var params = {
+                        action: 'settle',
+                        ids: selectedIds
+                    };
+                    var xsrf = $("input[name ='_xsrf']");
+                    if (xsrf != undefined) {
+                        params._xsrf = xsrf[0].value;
+                    }
+                    $.ajax({
+                        type: "POST",
+                        url: "/stock/consignment/",
+                        data: params,
+                        dataType: "json",
+                        success: function(response) {
+                            if (response.code == 'failed') {
+                                toastr.error(response.debug || "结算失败", "错误");
+                                return;
+                            }
+                            $selector.bootstrapTable('refresh');
+                            toastr.success("已结算" + response.num + "条明细", "结算成功");
+                        },
+                        error: function(XMLHttpRequest, textStatus, errorThrown) {
+                            toastr.error("请求失败，请刷新页面后再操作", "错误");
+                        }
+                    });
+                },
+                items: {
+                    "settle": { icon: "fa-check", name: "结算" }
+                }
+            };
+        }
+    });
+    $.contextMenu({
+        selector: '#table-product-metal-rate tbody tr',
+        build: function($trigger, e) {
+            return {
+                callback: function(key, options) {
+                    var $selector = $("#table-product-metal-rate");
+                    var params = {
+                        action: key
+                    };
+                    if (key == "delete") {
+                        var selectedArr = $selector.bootstrapTable('getSelections');
+                        var selectedIds = [];
+                        for (var i = 0, len = selectedArr.length; i < len; i++) {
+                            selectedIds.push(selectedArr[i].id);
+                        }
+                        if (selectedIds.length == 0) {
+                            toastr.warning("请先选择要删除的牌价", "提示");
+                            return;
+                        }
+                        params.ids = selectedIds;
+                    }
+                    var xsrf = $("input[name ='_xsrf']");
+                    if (xsrf != undefined) {
+                        params._xsrf = xsrf[0].value;
+                    }
+                    $.ajax({
+                        type: "POST",
+                        url: "/product/metalrate/",
+                        data: params,
+                        dataType: "json",
+                        success: function(response) {
+                            if (key == "delete") {
+                                $selector.bootstrapTable('refresh');
+                                if (response.code == 'failed') {
+                                    toastr.error(response.debug || "删除失败", "错误");
+                                    return;
+                                }
+                                toastr.success("已删除" + response.num + "条牌价并重新计价未确认订单", "删除成功");
+                                return;
+                            }
+                            if (response.code == 'failed') {
+                                toastr.error(response.debug || "重新计价失败", "错误");
+                                return;
+                            }
+                            toastr.success("已重新计价" + response.num + "条销售明细", "重新计价成功");
+                        },
+                        error: function(XMLHttpRequest, textStatus, errorThrown) {
+                            toastr.error("请求失败，请刷新页面后再操作", "错误");
+                        }
+                    });
+                },
+                items: {
+                    "reprice": { icon: "fa-refresh", name: "按当前牌价重新计价未确认订单" },
+                    "delete": { icon: "delete", name: "删除选中的牌价" }
+                }
+            };
+        }
+    });
 });
\ No newline at end of file
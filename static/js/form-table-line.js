@@ -1,3 +1,36 @@
+// 检查产品库存可用数量，数量不足时提示
+var checkProductAvailability = function(productId, firstQty, secondQty) {
+    if (!productId) {
+        return;
+    }
+    var params = {
+        action: "availability",
+        ProductID: productId,
+    };
+    var warehouse = $("select[name='StockWarehouse']").val();
+    if (warehouse) {
+        params.WareHouseID = warehouse;
+    }
+    var xsrf = $("input[name ='_xsrf']");
+    if (xsrf.length > 0) {
+        params._xsrf = xsrf[0].value;
+    }
+    $.ajax({
+        type: "POST",
+        url: "/product/product/",
+        dataType: "json",
+        data: params,
+        success: function(response) {
+            if (response.code != "success" || !response.data) {
+                return;
+            }
+            var data = response.data;
+            if (firstQty > data.FirstAvailable || secondQty > data.SecondAvailable) {
+                toastr.warning(data.Name + "可用库存不足:" + data.FirstAvailable + data.FirstUom + "/" + data.SecondAvailable + data.SecondUom + ",预测库存:" + data.FirstForecast + data.FirstUom, "库存提醒");
+            }
+        }
+    });
+};
 displayTable("#form-table-sale-order-line", "/sale/order/line", [
     { title: "全选", field: 'ID', checkbox: true, align: "center", valign: "middle" },
     { title: "订单明细号", field: 'Name', align: "left", sortable: true, order: "desc", valign: "middle" },
@@ -138,7 +171,10 @@ displayTable("#form-table-sale-order-line", "/sale/order/line", [
 ], {
     onPostBody: function() {
         select2AjaxData(".select-sale-order-product-product", '/product/product/', {
-            changeFunction: function(event) {},
+            changeFunction: function(event) {
+                var tr = $(this).closest("tr");
+                checkProductAvailability($(this).val(), parseFloat(tr.find("input[id^='FirstSaleQty-']").val()) || 0, parseFloat(tr.find("input[id^='SecondSaleQty-']").val()) || 0);
+            },
             formatRepo: function(repo) {
                 'use strict';
                 if (repo.loading) { return repo.text; }
@@ -180,6 +216,11 @@ displayTable("#form-table-sale-order-line", "/sale/order/line", [
         return params;
     }
 });
+// 修改销售数量时检查库存
+$("#form-table-sale-order-line").on("change", "input[id^='FirstSaleQty-'],input[id^='SecondSaleQty-']", function(e) {
+    var tr = $(this).closest("tr");
+    checkProductAvailability(tr.find("select.select-sale-order-product-product").val(), parseFloat(tr.find("input[id^='FirstSaleQty-']").val()) || 0, parseFloat(tr.find("input[id^='SecondSaleQty-']").val()) || 0);
+});
 // 增加一行销售订单明细
 $("#add-one-sale-order-line").on("click", function(e) {
     $("#form-table-sale-order-line").bootstrapTable('prepend', [{
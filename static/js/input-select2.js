@@ -128,10 +128,24 @@
  select2AjaxData(".select-product-attribute-value", '/product/attributevalue/?action=search'); // 选择属性值
  selectStaticData(".select-product-type", [{ id: "stock", name: '库存商品' }, { id: "consume", name: '消耗品' }, { id: "service", name: '服务' }]); // 产品类型
  select2AjaxData(".select-product-uom", "/product/uom/?action=search"); // 选择产品单位
+ select2AjaxData(".select-product-pricelist", "/product/pricelist/?action=search"); // 选择价格表
+ select2AjaxData(".select-sale-order-state", "/sale/order/state/?action=search"); // 选择订单状态
  select2AjaxData(".select-product-uom-category", "/product/uomcateg/?action=search"); //计量单位类别
  select2AjaxData(".select-stock-picking-type", '/stock/picking/type/?action=search'); //库位类型
  select2AjaxData(".select-stock-warehouse", '/stock/warehouse/?action=search'); //仓库
  select2AjaxData(".select-stock-location", '/stock/location/?action=search'); //库位
+ select2AjaxData(".select-stock-lot", '/stock/lot/?action=search'); //批次、序列号
+ select2AjaxData(".select-stock-package", '/stock/package/?action=search'); //包
+ select2AjaxData(".select-stock-putaway", '/stock/putaway/?action=search'); //入库策略
+ select2AjaxData(".select-stock-removal", '/stock/removal/?action=search'); //出库策略
+ select2AjaxData(".select-product-product", '/product/product/?action=search'); // 选择产品规格
+ selectStaticData(".select-stock-removal-method", [{ id: 'fifo', name: '先进先出' }, { id: 'lifo', name: '后进先出' }, { id: 'fefo', name: '先到期先出' }, { id: 'closest', name: '最近库位' }]); // 出库方法
+ selectStaticData(".select-product-cost-method", [{ id: 'standard', name: '标准成本' }, { id: 'average', name: '移动平均' }, { id: 'fifo', name: '先进先出' }]); // 成本方法
+ selectStaticData(".select-product-tracking", [{ id: 'none', name: '不追踪' }, { id: 'lot', name: '按批次' }, { id: 'serial', name: '按序列号' }]); // 追踪方式
+ selectStaticData(".select-product-pricing-mode", [{ id: 'fixed', name: '按价格表' }, { id: 'metal', name: '按克重和牌价' }]); // 定价方式
+ selectStaticData(".select-product-metal", [{ id: 'gold', name: '黄金' }, { id: 'silver', name: '白银' }, { id: 'platinum', name: '铂金' }, { id: 'palladium', name: '钯金' }]); // 贵金属
+ selectStaticData(".select-stock-reception-steps", [{ id: 'one_step', name: '直接入库' }, { id: 'two_steps', name: '收货后上架' }]); // 收货流程
+ selectStaticData(".select-stock-delivery-steps", [{ id: 'ship_only', name: '直接发货' }, { id: 'pick_ship', name: '拣货后发货' }]); // 发货流程
  selectStaticData(".select-stock-picking-type-code", [{ id: 'outgoing', name: '出库' }, { id: 'incoming', name: '入库' }, { id: 'internal', name: '内部调拨' }]); // 产品类型
  selectStaticData(".select-product-uom-category-type", [{ id: 1, name: '小于参考计量单位' }, { id: 2, name: '参考计量单位' }, { id: 3, name: '大于参考计量单位' }]); // 产品类型
  // 库位类型
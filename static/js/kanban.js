@@ -25,7 +25,7 @@ $(function() {
             innerHtml += '<div class="box-body">';
             innerHtml += '<div class="row">';
             innerHtml += '<div class="col-md-12">';
-            innerHtml += "等待添加内容";
+            innerHtml += '<ul class="list-unstyled counter-availability" data-counter-id="' + dataArr[i].id + '">等待添加内容</ul>';
             innerHtml += '</div>';
             innerHtml += '</div>';
             innerHtml += '</div>';
@@ -82,7 +82,7 @@ $(function() {
             innerHtml += '<div class="box-body">';
             innerHtml += '<div class="row">';
             innerHtml += '<div class="col-md-12">';
-            innerHtml += "等待添加内容";
+            innerHtml += '<ul class="list-unstyled counter-availability" data-counter-id="' + dataArr[i].id + '">等待添加内容</ul>';
             innerHtml += '</div>';
             innerHtml += '</div>';
             innerHtml += '</div>';
@@ -90,6 +90,38 @@ $(function() {
             innerHtml += '</div>';
         }
         el.append(innerHtml);
+        el.find(".counter-availability").each(function() {
+            displaySaleCounterAvailability($(this));
+        });
+    };
+    // 柜台产品的可用库存，可用数量不足时标红
+    var displaySaleCounterAvailability = function(el) {
+        var params = {
+            action: "availability",
+            CounterID: el.data("counter-id"),
+        };
+        var xsrf = $("input[name ='_xsrf']");
+        if (xsrf.length > 0) {
+            params._xsrf = xsrf[0].value;
+        }
+        $.ajax({
+            type: "POST",
+            url: "/product/product/",
+            dataType: "json",
+            data: params,
+            success: function(response) {
+                var data = response.data;
+                if (response.code != "success" || data == undefined || data.length == 0) {
+                    return;
+                }
+                var innerHtml = "";
+                for (var i = 0, len = data.length; i < len; i++) {
+                    var cls = data[i].FirstAvailable > 0 ? "text-success" : "text-danger";
+                    innerHtml += '<li>' + data[i].Name + '<span class="pull-right ' + cls + '">' + data[i].FirstAvailable + data[i].FirstUom + ' / ' + data[i].SecondAvailable + data[i].SecondUom + '</span></li>';
+                }
+                el.html(innerHtml);
+            }
+        });
     };
     var saleCounterKanban = $("#kanban-sale-counter");
     if (saleCounterKanban.length > 0) {
@@ -1437,6 +1437,299 @@ $(function() {
             },
         }
     });
+    // 批次管理
+    BootstrapValidator("#stockProductionLotForm", {
+        Name: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "批次号不能为空"
+                },
+                remote: {
+                    url: "/stock/lot/",
+                    message: "该产品规格已存在此批次号",
+                    dataType: "json",
+                    delay: 200,
+                    type: "POST",
+                    data: function() {
+                        var params = {
+                            action: "validator",
+                            Product: $("select[name='Product']").val() || 0,
+                        }
+                        var xsrf = $("input[name ='_xsrf']")
+                        if (xsrf.length > 0) {
+                            params._xsrf = xsrf[0].value;
+                        }
+                        var recordID = $("input[name ='recordID']");
+                        if (recordID.length > 0) {
+                            params.recordID = recordID[0].value;
+                        }
+                        return params
+                    },
+                },
+            },
+        },
+        Product: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "产品规格不能为空"
+                },
+            }
+        },
+    });
+    // 入库策略
+    BootstrapValidator("#stockPutawayStrategyForm", {
+        Name: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "策略名称不能为空"
+                },
+                remote: {
+                    url: "/stock/putaway/",
+                    message: "入库策略名称已经存在",
+                    dataType: "json",
+                    delay: 200,
+                    type: "POST",
+                    data: function() {
+                        var params = {
+                            action: "validator",
+                        }
+                        var xsrf = $("input[name ='_xsrf']")
+                        if (xsrf.length > 0) {
+                            params._xsrf = xsrf[0].value;
+                        }
+                        var recordID = $("input[name ='recordID']");
+                        if (recordID.length > 0) {
+                            params.recordID = recordID[0].value;
+                        }
+                        return params
+                    },
+                },
+            },
+        },
+    });
+    // 出库策略
+    BootstrapValidator("#stockRemovalStrategyForm", {
+        Name: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "策略名称不能为空"
+                },
+                remote: {
+                    url: "/stock/removal/",
+                    message: "出库策略名称已经存在",
+                    dataType: "json",
+                    delay: 200,
+                    type: "POST",
+                    data: function() {
+                        var params = {
+                            action: "validator",
+                        }
+                        var xsrf = $("input[name ='_xsrf']")
+                        if (xsrf.length > 0) {
+                            params._xsrf = xsrf[0].value;
+                        }
+                        var recordID = $("input[name ='recordID']");
+                        if (recordID.length > 0) {
+                            params.recordID = recordID[0].value;
+                        }
+                        return params
+                    },
+                },
+            },
+        },
+        Method: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "出库方法不能为空"
+                },
+            }
+        },
+    });
+    // 入库规则
+    BootstrapValidator("#stockPutawayRuleForm", {
+        Strategy: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "入库策略不能为空"
+                },
+            }
+        },
+        Location: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "入库库位不能为空"
+                },
+            }
+        },
+    });
+    // 补货规则
+    BootstrapValidator("#stockWarehouseOrderpointForm", {
+        WareHouse: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "仓库不能为空"
+                },
+            }
+        },
+        Product: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "产品规格不能为空"
+                },
+            }
+        },
+        FirstMinQty: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "最小数量不能为空"
+                },
+            }
+        },
+        FirstMaxQty: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "最大数量不能为空"
+                },
+            }
+        },
+    });
+    // 报废单
+    BootstrapValidator("#stockScrapForm", {
+        Product: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "产品规格不能为空"
+                },
+            }
+        },
+        FirstUomQty: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "报废数量不能为空"
+                },
+            }
+        },
+        LocationSrc: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "源库位不能为空"
+                },
+            }
+        },
+        Reason: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "报废原因不能为空"
+                },
+            }
+        },
+    });
+    BootstrapValidator("#stockTransferForm", {
+        WareHouseSrc: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "调出仓库不能为空"
+                },
+            }
+        },
+        WareHouseDest: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "调入仓库不能为空"
+                },
+            }
+        },
+    });
+    BootstrapValidator("#productPricelistForm", {
+        Name: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "价格表名称不能为空"
+                },
+            }
+        },
+    });
+    BootstrapValidator("#productMetalRateForm", {
+        Metal: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "金属不能为空"
+                },
+            }
+        },
+        Purity: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "成色不能为空"
+                },
+            }
+        },
+        PricePerGram: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "每克价格不能为空"
+                },
+            }
+        },
+    });
+    BootstrapValidator("#saleOrderStateForm", {
+        Name: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "状态名称不能为空"
+                },
+            }
+        },
+        Company: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "公司不能为空"
+                },
+            }
+        },
+        StockWarehouse: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "仓库不能为空"
+                },
+            }
+        },
+    });
+    // 采购订单
+    BootstrapValidator("#purchaseOrderForm", {
+        Partner: {
+            message: "该值无效",
+            validators: {
+                notEmpty: {
+                    message: "供应商不能为空"
+                },
+            }
+        },
+    });
     // 仓库管理
     BootstrapValidator("#stockWarehouseForm", {
         Name: {